@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"habit-tracker-bot/internal/config"
@@ -21,7 +22,7 @@ func main() {
 	}
 
 	ctx := context.Background()
-	repo, err := repository.NewPostgresRepository(ctx, cfg.DatabaseURL)
+	repo, err := repository.NewPostgresRepository(ctx, cfg.DatabaseURL, cfg.SubscriptionGraceDays)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -35,6 +36,18 @@ func main() {
 
 	tinkoffSvc := service.NewTinkoffService(repo, cfg.TinkoffTerminalKey, cfg.TinkoffPassword, cfg.TinkoffTestMode)
 	srv := server.NewServer(repo, tinkoffSvc, bot.GetHandlers(), cfg.Port)
+	// Клики по кнопкам рассылок и рекламы считаются через редирект на BASE_URL/go/...
+	srv.SetClickTracking(bot.GetClickTracker(), bot.GetBroadcastService(), bot.GetAdService())
+
+	// Веб-админка: вход через Telegram Login Widget, домен BASE_URL нужно привязать к боту в @BotFather (/setdomain)
+	if cfg.AdminPanelEnabled {
+		panel, err := server.NewAdminPanel(repo, bot.GetAdminService(), bot.GetBroadcastService(), bot.GetAdService(),
+			cfg.TelegramToken, bot.GetBotUsername(), strings.HasPrefix(cfg.BaseURL, "https://"))
+		if err != nil {
+			log.Fatalf("Failed to create admin panel: %v", err)
+		}
+		srv.SetAdminPanel(panel)
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -0,0 +1,160 @@
+package chart
+
+import (
+	"image/color"
+	"strconv"
+)
+
+// Series — именованный ряд значений для графика с легендой
+type Series struct {
+	Name   string
+	Values []int
+}
+
+// Bar — вертикальные столбцы: подписи снизу, значения над столбцами
+func Bar(title string, labels []string, values []int) ([]byte, error) {
+	c := newCanvas(800, 450)
+	c.title(title)
+
+	left, right, top, bottom := 70, c.width()-30, 80, c.height()-50
+	axisTop := c.valueAxis(left, right, top, bottom, maxOf(values))
+
+	slot := (right - left) / max(len(values), 1)
+	barW := slot * 3 / 5
+	for i, v := range values {
+		x := left + i*slot + (slot-barW)/2
+		h := v * (bottom - top) / axisTop
+		c.rect(x, bottom-h, x+barW, bottom, colorDone)
+		if v > 0 {
+			c.textCenter(x+barW/2, bottom-h-lineHeight-6, strconv.Itoa(v), textFont, colorText)
+		}
+		if i < len(labels) {
+			c.textCenter(x+barW/2, bottom+12, c.fitWidth(labels[i], slot-4, textFont), textFont, colorText)
+		}
+	}
+	return c.png()
+}
+
+// HBar — горизонтальные полосы (серии, проценты): подпись слева, значение справа от полосы
+func HBar(title string, labels []string, values []int) ([]byte, error) {
+	const rowH, barH, maxLabel = 44, 28, 14
+
+	c := newCanvas(800, 80+len(values)*rowH+50)
+	c.title(title)
+
+	names := make([]string, len(values))
+	labelW := 0
+	for i := range names {
+		if i < len(labels) {
+			names[i] = truncate(labels[i], maxLabel)
+		}
+		labelW = max(labelW, c.textWidth(names[i], textFont))
+	}
+
+	left, right, top := 20+labelW+15, c.width()-70, 80
+	bottom := top + len(values)*rowH
+	axisTop, step := axisMax(maxOf(values))
+
+	for v := 0; v <= axisTop; v += step {
+		x := left + v*(right-left)/axisTop
+		c.rect(x, top, x+1, bottom, colorGrid)
+		c.textCenter(x, bottom+12, strconv.Itoa(v), textFont, colorMuted)
+	}
+	c.rect(left, top, left+1, bottom, colorAxis)
+
+	for i, v := range values {
+		y := top + i*rowH + (rowH-barH)/2
+		w := v * (right - left) / axisTop
+		c.rect(left, y, left+w, y+barH, palette[i%len(palette)])
+		c.textRight(left-15, y+(barH-lineHeight)/2, names[i], textFont, colorText)
+		c.text(left+w+8, y+(barH-lineHeight)/2, strconv.Itoa(v), textFont, colorText)
+	}
+	return c.png()
+}
+
+// Trend — столбцы bars по правой оси и линии lines по левой с общей легендой.
+// Подписи по X прореживаются, чтобы не налезали друг на друга.
+func Trend(title string, labels []string, bars Series, lines ...Series) ([]byte, error) {
+	c := newCanvas(900, 480)
+	c.title(title)
+
+	barColor := blend(palette[2], colorBackground, 0.4)
+	x := 70
+	legendItem := func(name string, col color.Color) {
+		c.rect(x, 68, x+14, 82, col)
+		c.text(x+20, 68, name, textFont, colorText)
+		x += 20 + c.textWidth(name, textFont) + 30
+	}
+	for i, s := range lines {
+		legendItem(s.Name, palette[i%len(palette)])
+	}
+	legendItem(bars.Name, barColor)
+
+	left, right, top, bottom := 70, c.width()-80, 110, c.height()-50
+	linesMax := 0
+	for _, s := range lines {
+		linesMax = max(linesMax, maxOf(s.Values))
+	}
+	leftTop := c.valueAxis(left, right, top, bottom, linesMax)
+
+	rightTop, rightStep := axisMax(maxOf(bars.Values))
+	for v := 0; v <= rightTop; v += rightStep {
+		y := bottom - v*(bottom-top)/rightTop
+		c.text(right+10, y-lineHeight/2, strconv.Itoa(v), textFont, colorMuted)
+	}
+
+	n := max(len(labels), 1)
+	slot := float64(right-left) / float64(n)
+	center := func(i int) int { return left + int(slot*float64(i)+slot/2) }
+
+	barW := max(int(slot*3/5), 1)
+	for i, v := range bars.Values {
+		h := v * (bottom - top) / rightTop
+		c.rect(center(i)-barW/2, bottom-h, center(i)-barW/2+barW, bottom, barColor)
+	}
+
+	for li, s := range lines {
+		col := palette[li%len(palette)]
+		point := func(i int) (int, int) { return center(i), bottom - s.Values[i]*(bottom-top)/leftTop }
+		for i := range s.Values {
+			x1, y1 := point(i)
+			if i > 0 {
+				x0, y0 := point(i - 1)
+				c.line(x0, y0, x1, y1, 3, col)
+			}
+			c.rect(x1-3, y1-3, x1+4, y1+4, col)
+		}
+	}
+
+	labelW := 0
+	for _, l := range labels {
+		labelW = max(labelW, c.textWidth(l, textFont))
+	}
+	every := max(int(float64(labelW+10)/slot)+1, 1)
+	for i, l := range labels {
+		if i%every == 0 {
+			c.textCenter(center(i), bottom+12, l, textFont, colorText)
+		}
+	}
+	return c.png()
+}
+
+// valueAxis — сетка и подписи левой оси; возвращает верхнюю границу шкалы
+func (c *canvas) valueAxis(left, right, top, bottom, maxValue int) int {
+	axisTop, step := axisMax(maxValue)
+	for v := 0; v <= axisTop; v += step {
+		y := bottom - v*(bottom-top)/axisTop
+		c.rect(left, y, right, y+1, colorGrid)
+		c.textRight(left-10, y-lineHeight/2, strconv.Itoa(v), textFont, colorMuted)
+	}
+	c.rect(left, bottom, right, bottom+1, colorAxis)
+	return axisTop
+}
+
+func maxOf(values []int) int {
+	m := 0
+	for _, v := range values {
+		m = max(m, v)
+	}
+	return m
+}
@@ -0,0 +1,84 @@
+package chart
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Key — график пользователя за день: Kind различает виды графиков и привычки
+type Key struct {
+	UserID int64
+	Kind   string
+	Day    string
+}
+
+// NewKey — ключ графика пользователя на сегодня
+func NewKey(userID int64, kind string) Key {
+	return Key{UserID: userID, Kind: kind, Day: time.Now().Format("2006-01-02")}
+}
+
+type cacheEntry struct {
+	key         Key
+	fingerprint string
+	png         []byte
+}
+
+// Cache — готовые PNG по пользователю и дню. Отпечаток данных сравнивается при каждом
+// запросе: отметка привычки в течение дня перерисовывает график. Записи прошлых дней
+// удаляются при смене дня, а сверх maxEntries вытесняются давно не запрошенные.
+type Cache struct {
+	mu         sync.Mutex
+	day        string
+	maxEntries int
+	order      *list.List // от недавно запрошенных к давним, элементы — *cacheEntry
+	entries    map[Key]*list.Element
+}
+
+func NewCache(maxEntries int) *Cache {
+	return &Cache{maxEntries: maxEntries, order: list.New(), entries: make(map[Key]*list.Element)}
+}
+
+// Get — картинка из кэша или результат render, если данных нет или они изменились
+func (c *Cache) Get(key Key, fingerprint string, render func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if c.day != key.Day {
+		c.day = key.Day
+		c.order.Init()
+		c.entries = make(map[Key]*list.Element)
+	}
+	if el, ok := c.entries[key]; ok && el.Value.(*cacheEntry).fingerprint == fingerprint {
+		c.order.MoveToFront(el)
+		png := el.Value.(*cacheEntry).png
+		c.mu.Unlock()
+		return png, nil
+	}
+	c.mu.Unlock()
+
+	png, err := render()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.day == key.Day {
+		c.put(&cacheEntry{key: key, fingerprint: fingerprint, png: png})
+	}
+	c.mu.Unlock()
+	return png, nil
+}
+
+// put — сохраняет запись и вытесняет самые давние сверх лимита. Вызывается под mu.
+func (c *Cache) put(e *cacheEntry) {
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.order.PushFront(e)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,93 @@
+package chart
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCacheGet(t *testing.T) {
+	c := NewCache(10)
+	renders := 0
+	render := func() ([]byte, error) {
+		renders++
+		return []byte{byte(renders)}, nil
+	}
+
+	today := Key{UserID: 1, Kind: "weekly", Day: "2026-10-18"}
+	tomorrow := Key{UserID: 1, Kind: "weekly", Day: "2026-10-19"}
+
+	steps := []struct {
+		name        string
+		key         Key
+		fingerprint string
+		wantRenders int
+	}{
+		{"first request renders", today, "a", 1},
+		{"same data is cached", today, "a", 1},
+		{"changed data re-renders", today, "b", 2},
+		{"other kind renders", Key{UserID: 1, Kind: "streaks", Day: today.Day}, "b", 3},
+		{"other user renders", Key{UserID: 2, Kind: "weekly", Day: today.Day}, "b", 4},
+		{"new day renders", tomorrow, "b", 5},
+		{"previous day was dropped", today, "b", 6},
+	}
+	for _, s := range steps {
+		png, err := c.Get(s.key, s.fingerprint, render)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if renders != s.wantRenders {
+			t.Errorf("%s: renders = %d, want %d", s.name, renders, s.wantRenders)
+		}
+		if len(png) != 1 {
+			t.Errorf("%s: png = %v", s.name, png)
+		}
+	}
+}
+
+func TestCacheGetError(t *testing.T) {
+	c := NewCache(10)
+	key := Key{UserID: 1, Kind: "weekly", Day: "2026-10-18"}
+
+	if _, err := c.Get(key, "a", func() ([]byte, error) { return nil, errors.New("boom") }); err == nil {
+		t.Fatal("expected render error")
+	}
+
+	renders := 0
+	if _, err := c.Get(key, "a", func() ([]byte, error) { renders++; return []byte{1}, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if renders != 1 {
+		t.Errorf("failed render was cached")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(2)
+	renders := 0
+	render := func() ([]byte, error) {
+		renders++
+		return []byte{byte(renders)}, nil
+	}
+	key := func(userID int64) Key { return Key{UserID: userID, Kind: "weekly", Day: "2026-10-18"} }
+
+	steps := []struct {
+		name        string
+		userID      int64
+		wantRenders int
+	}{
+		{"first user renders", 1, 1},
+		{"second user renders", 2, 2},
+		{"first user is cached", 1, 2},
+		{"third user evicts the second", 3, 3},
+		{"first user is still cached", 1, 3},
+		{"second user renders again", 2, 4},
+	}
+	for _, s := range steps {
+		if _, err := c.Get(key(s.userID), "a", render); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if renders != s.wantRenders {
+			t.Errorf("%s: renders = %d, want %d", s.name, renders, s.wantRenders)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package chart
+
+import (
+	"strconv"
+	"time"
+)
+
+// weekdayLabels — дни недели с понедельника
+var weekdayLabels = []string{"Пн", "Вт", "Ср", "Чт", "Пт", "Сб", "Вс"}
+
+// Day — день календаря привычки
+type Day struct {
+	Date time.Time
+	Done bool
+}
+
+// Calendar — дни по неделям (строки) и дням недели (столбцы) с числом месяца в клетке:
+// выполненные — зелёные, пропущенные — красные. days — подряд идущие дни.
+func Calendar(title string, days []Day) ([]byte, error) {
+	const cell, gap, left, top = 64, 6, 30, 120
+
+	offset := 0
+	if len(days) > 0 {
+		offset = mondayIndex(days[0].Date)
+	}
+	rows := (offset + len(days) + 6) / 7
+
+	c := newCanvas(7*(cell+gap)-gap+2*left, top+rows*(cell+gap)+60)
+	c.title(title)
+
+	for i, label := range weekdayLabels {
+		c.textCenter(left+i*(cell+gap)+cell/2, top-lineHeight-12, label, textFont, colorMuted)
+	}
+
+	for i := 0; i < rows*7; i++ {
+		x, y := left+(i%7)*(cell+gap), top+(i/7)*(cell+gap)
+		d := i - offset
+		if d < 0 || d >= len(days) {
+			c.rect(x, y, x+cell, y+cell, colorEmpty)
+			continue
+		}
+
+		fill, textColor := colorMissed, colorText
+		if days[d].Done {
+			fill, textColor = colorDone, colorBackground
+		}
+		c.rect(x, y, x+cell, y+cell, fill)
+		c.textCenter(x+cell/2, y+(cell-lineHeight)/2, strconv.Itoa(days[d].Date.Day()), textFont, textColor)
+	}
+
+	legendY := top + rows*(cell+gap) + 16
+	c.rect(left, legendY, left+14, legendY+14, colorDone)
+	c.text(left+20, legendY, "выполнено", textFont, colorText)
+	x := left + 20 + c.textWidth("выполнено", textFont) + 30
+	c.rect(x, legendY, x+14, legendY+14, colorMissed)
+	c.text(x+20, legendY, "пропущено", textFont, colorText)
+
+	return c.png()
+}
+
+// mondayIndex — номер дня недели с понедельника (0) по воскресенье (6)
+func mondayIndex(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
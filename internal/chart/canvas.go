@@ -0,0 +1,125 @@
+// Package chart — графики в PNG без внешних сервисов: столбцы, полосы серий и календари.
+// Рисуется стандартной библиотекой и встроенными шрифтами, поэтому работает офлайн.
+package chart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	"golang.org/x/image/font"
+)
+
+var (
+	colorBackground = color.RGBA{255, 255, 255, 255}
+	colorText       = color.RGBA{51, 51, 51, 255}
+	colorMuted      = color.RGBA{130, 130, 130, 255}
+	colorGrid       = color.RGBA{230, 230, 230, 255}
+	colorAxis       = color.RGBA{180, 180, 180, 255}
+	colorDone       = color.RGBA{75, 192, 192, 255}
+	colorMissed     = color.RGBA{255, 177, 193, 255}
+	colorEmpty      = color.RGBA{240, 240, 240, 255}
+)
+
+// palette — цвета полос и линий по порядку
+var palette = []color.RGBA{
+	{255, 99, 132, 255},
+	{54, 162, 235, 255},
+	{255, 206, 86, 255},
+	{75, 192, 192, 255},
+	{153, 102, 255, 255},
+	{255, 159, 64, 255},
+}
+
+type canvas struct {
+	img   *image.RGBA
+	faces map[*textStyle]font.Face
+}
+
+func newCanvas(width, height int) *canvas {
+	c := &canvas{img: image.NewRGBA(image.Rect(0, 0, width, height))}
+	c.rect(0, 0, width, height, colorBackground)
+	return c
+}
+
+func (c *canvas) width() int  { return c.img.Bounds().Dx() }
+func (c *canvas) height() int { return c.img.Bounds().Dy() }
+
+// rect — закрашенный прямоугольник [x0, x1) × [y0, y1)
+func (c *canvas) rect(x0, y0, x1, y1 int, col color.Color) {
+	draw.Draw(c.img, image.Rect(x0, y0, x1, y1), &image.Uniform{C: col}, image.Point{}, draw.Src)
+}
+
+// line — отрезок толщиной width (алгоритм Брезенхэма)
+func (c *canvas) line(x0, y0, x1, y1, width int, col color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	half := width / 2
+	for e := dx + dy; ; {
+		c.rect(x0-half, y0-half, x0-half+width, y0-half+width, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// title — заголовок по центру сверху, обрезанный по ширине картинки
+func (c *canvas) title(s string) {
+	c.textCenter(c.width()/2, 20, c.fitWidth(s, c.width()-40, titleFont), titleFont, colorText)
+}
+
+func (c *canvas) png() ([]byte, error) {
+	for _, f := range c.faces {
+		f.Close()
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// axisMax — «круглая» верхняя граница шкалы и шаг делений (не больше пяти) для максимума v
+func axisMax(v int) (top, step int) {
+	if v < 1 {
+		v = 1
+	}
+	for magnitude := 1; ; magnitude *= 10 {
+		for _, base := range []int{1, 2, 5} {
+			step = base * magnitude
+			if v <= step*5 {
+				top = (v + step - 1) / step * step
+				return top, step
+			}
+		}
+	}
+}
+
+// blend — цвет между from и to, t от 0 до 1
+func blend(from, to color.RGBA, t float64) color.RGBA {
+	mix := func(a, b uint8) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*t) }
+	return color.RGBA{mix(from.R, to.R), mix(from.G, to.G), mix(from.B, to.B), 255}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,110 @@
+package chart
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func decodeSize(t *testing.T, data []byte, err error) (int, int) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	b := img.Bounds()
+	return b.Dx(), b.Dy()
+}
+
+func TestHeatmap(t *testing.T) {
+	tests := []struct {
+		name          string
+		year          int
+		ratios        map[string]float64
+		today         time.Time
+		width, height int
+	}{
+		{"year from monday", 2024, map[string]float64{"2024-03-01": 1, "2024-03-02": 0.3}, time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), 928, 262},
+		{"54 weeks", 2040, nil, time.Date(2040, 12, 31, 0, 0, 0, 0, time.UTC), 944, 262},
+		{"current year", 2026, map[string]float64{"2026-01-05": 0.5}, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), 928, 262},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Heatmap("Год", tt.year, tt.ratios, tt.today)
+			w, h := decodeSize(t, data, err)
+			if w != tt.width || h != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestHeatLevel(t *testing.T) {
+	tests := []struct {
+		ratio float64
+		want  int
+	}{
+		{0, 0}, {0.1, 1}, {0.25, 1}, {0.5, 2}, {0.75, 3}, {1, 4},
+	}
+	for _, tt := range tests {
+		if got := heatLevel(tt.ratio); got != tt.want {
+			t.Errorf("heatLevel(%v) = %d, want %d", tt.ratio, got, tt.want)
+		}
+	}
+}
+
+func TestHBar(t *testing.T) {
+	tests := []struct {
+		name          string
+		labels        []string
+		values        []int
+		width, height int
+	}{
+		{"empty", nil, nil, 800, 130},
+		{"one", []string{"Бег"}, []int{5}, 800, 174},
+		{"long labels", []string{"Очень длинное название привычки", "Йога 🧘", "Чтение"}, []int{12, 0, 3}, 800, 262},
+		{"fewer labels", []string{"Бег"}, []int{1, 2}, 800, 218},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := HBar("Серии", tt.labels, tt.values)
+			w, h := decodeSize(t, data, err)
+			if w != tt.width || h != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	days := func(from time.Time, n int) []Day {
+		var d []Day
+		for i := 0; i < n; i++ {
+			d = append(d, Day{Date: from.AddDate(0, 0, i), Done: i%2 == 0})
+		}
+		return d
+	}
+
+	tests := []struct {
+		name          string
+		days          []Day
+		width, height int
+	}{
+		{"from monday", days(time.Date(2026, 9, 7, 0, 0, 0, 0, time.UTC), 30), 544, 530},
+		{"from sunday", days(time.Date(2026, 9, 6, 0, 0, 0, 0, time.UTC), 30), 544, 600},
+		{"empty", nil, 544, 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Calendar("Бег — 30 дней", tt.days)
+			w, h := decodeSize(t, data, err)
+			if w != tt.width || h != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package chart
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Подписи рисуются встроенными TTF-шрифтами Go: латиница и кириллица в обоих регистрах.
+// Символы, которых в шрифте нет (эмодзи), пропускаются.
+
+// textStyle — шрифт и кегль подписи в пикселях
+type textStyle struct {
+	font *opentype.Font
+	size float64
+}
+
+var (
+	textFont  = &textStyle{font: mustParseFont(goregular.TTF), size: 15}
+	titleFont = &textStyle{font: mustParseFont(gobold.TTF), size: 22}
+)
+
+// lineHeight — высота строки обычной подписи
+var lineHeight = textFont.height()
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic("chart: parse font: " + err.Error())
+	}
+	return f
+}
+
+func (s *textStyle) newFace() font.Face {
+	face, err := opentype.NewFace(s.font, &opentype.FaceOptions{Size: s.size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		panic("chart: font face: " + err.Error())
+	}
+	return face
+}
+
+func (s *textStyle) height() int {
+	face := s.newFace()
+	defer face.Close()
+	m := face.Metrics()
+	return (m.Ascent + m.Descent).Ceil()
+}
+
+// face — начертание для холста. font.Face не потокобезопасен, поэтому у каждого холста свои.
+func (c *canvas) face(style *textStyle) font.Face {
+	if f, ok := c.faces[style]; ok {
+		return f
+	}
+	if c.faces == nil {
+		c.faces = make(map[*textStyle]font.Face)
+	}
+	f := style.newFace()
+	c.faces[style] = f
+	return f
+}
+
+// drawable — символы строки, которые есть в шрифте
+func drawable(face font.Face, s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if _, ok := face.GlyphAdvance(r); !ok && r != ' ' {
+			return -1
+		}
+		return r
+	}, s))
+}
+
+// textWidth — ширина строки в пикселях
+func (c *canvas) textWidth(s string, style *textStyle) int {
+	face := c.face(style)
+	return font.MeasureString(face, drawable(face, s)).Ceil()
+}
+
+// text — рисует строку, (x, y) — левый верхний угол
+func (c *canvas) text(x, y int, s string, style *textStyle, col color.Color) {
+	face := c.face(style)
+	d := font.Drawer{
+		Dst:  c.img,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.P(x, y+face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(drawable(face, s))
+}
+
+// textCenter — строка по центру относительно cx
+func (c *canvas) textCenter(cx, y int, s string, style *textStyle, col color.Color) {
+	c.text(cx-c.textWidth(s, style)/2, y, s, style, col)
+}
+
+// textRight — строка, прижатая правым краем к x
+func (c *canvas) textRight(x, y int, s string, style *textStyle, col color.Color) {
+	c.text(x-c.textWidth(s, style), y, s, style, col)
+}
+
+// truncate — обрезает строку до max символов с многоточием
+func truncate(s string, max int) string {
+	r := []rune(strings.TrimSpace(s))
+	if len(r) <= max {
+		return string(r)
+	}
+	return strings.TrimSpace(string(r[:max-1])) + "…"
+}
+
+// fitWidth — обрезает строку с многоточием, чтобы она помещалась в width пикселей
+func (c *canvas) fitWidth(s string, width int, style *textStyle) string {
+	s = strings.TrimSpace(s)
+	for n := len([]rune(s)); n > 1 && c.textWidth(s, style) > width; n-- {
+		s = truncate(s, n-1)
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package chart
+
+import (
+	"image/color"
+	"time"
+)
+
+var monthLabels = []string{"Янв", "Фев", "Мар", "Апр", "Май", "Июн", "Июл", "Авг", "Сен", "Окт", "Ноя", "Дек"}
+
+// heatLevels — цвета клеток от «ничего не выполнено» до «выполнено всё»
+var heatLevels = []color.RGBA{
+	{235, 237, 240, 255},
+	{155, 233, 168, 255},
+	{64, 196, 99, 255},
+	{48, 161, 78, 255},
+	{33, 110, 57, 255},
+}
+
+// Heatmap — год по неделям (столбцы) и дням недели (строки), как в профиле GitHub.
+// ratios — доля выполненного по датам "2006-01-02"; дни после today не рисуются.
+func Heatmap(title string, year int, ratios map[string]float64, today time.Time) ([]byte, error) {
+	const cell, gap, left, top = 13, 3, 50, 100
+	step := cell + gap
+
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC)
+	offset := mondayIndex(first)
+	weeks := (offset + last.YearDay() + 6) / 7
+
+	c := newCanvas(left+weeks*step+30, top+7*step+50)
+	c.title(title)
+
+	for _, row := range []int{0, 2, 4} {
+		c.textRight(left-8, top+row*step+(cell-lineHeight)/2, weekdayLabels[row], textFont, colorMuted)
+	}
+
+	end := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	for d := first; !d.After(last) && !d.After(end); d = d.AddDate(0, 0, 1) {
+		i := offset + d.YearDay() - 1
+		x, y := left+(i/7)*step, top+(i%7)*step
+		if d.Day() == 1 {
+			c.text(x, top-lineHeight-10, monthLabels[d.Month()-1], textFont, colorMuted)
+		}
+		c.rect(x, y, x+cell, y+cell, heatLevels[heatLevel(ratios[d.Format("2006-01-02")])])
+	}
+
+	legendY := top + 7*step + 20
+	x := c.width() - 30 - len(heatLevels)*step - c.textWidth("больше", textFont) - 8
+	c.textRight(x-8, legendY, "меньше", textFont, colorMuted)
+	for _, col := range heatLevels {
+		c.rect(x, legendY, x+cell, legendY+cell, col)
+		x += step
+	}
+	c.text(x+5, legendY, "больше", textFont, colorMuted)
+
+	return c.png()
+}
+
+// heatLevel — номер цвета клетки: 0 — ничего, далее по четвертям доли выполненного
+func heatLevel(ratio float64) int {
+	switch {
+	case ratio <= 0:
+		return 0
+	case ratio <= 0.25:
+		return 1
+	case ratio <= 0.5:
+		return 2
+	case ratio < 1:
+		return 3
+	default:
+		return 4
+	}
+}
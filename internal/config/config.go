@@ -23,10 +23,15 @@ type Config struct {
 	TinkoffTestMode    bool
 
 	// App
-	SubscriptionPrice int64
-	Environment       string
-	BaseURL           string
-	Port              string
+	SubscriptionPrice     int64
+	FamilyPrice           int64
+	SubscriptionGraceDays int
+	FamilyMaxMembers      int
+	AdFrequency           int
+	Environment           string
+	BaseURL               string
+	Port                  string
+	AdminPanelEnabled     bool
 }
 
 func Load() (*Config, error) {
@@ -42,6 +47,7 @@ func Load() (*Config, error) {
 		Environment:        getEnv("ENVIRONMENT", "development"),
 		BaseURL:            os.Getenv("BASE_URL"),
 		Port:               getEnv("PORT", "8080"),
+		AdminPanelEnabled:  getEnv("ADMIN_PANEL_ENABLED", "true") == "true",
 	}
 
 	if adminID := os.Getenv("ADMIN_TELEGRAM_ID"); adminID != "" {
@@ -54,6 +60,30 @@ func Load() (*Config, error) {
 	}
 	cfg.SubscriptionPrice = price
 
+	familyPrice, err := strconv.ParseInt(getEnv("FAMILY_PRICE", "39900"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid family price: %w", err)
+	}
+	cfg.FamilyPrice = familyPrice
+
+	graceDays, err := strconv.Atoi(getEnv("SUBSCRIPTION_GRACE_DAYS", "0"))
+	if err != nil || graceDays < 0 {
+		return nil, fmt.Errorf("invalid subscription grace days: %s", os.Getenv("SUBSCRIPTION_GRACE_DAYS"))
+	}
+	cfg.SubscriptionGraceDays = graceDays
+
+	familyMax, err := strconv.Atoi(getEnv("FAMILY_MAX_MEMBERS", "4"))
+	if err != nil || familyMax < 1 {
+		return nil, fmt.Errorf("invalid family max members: %s", os.Getenv("FAMILY_MAX_MEMBERS"))
+	}
+	cfg.FamilyMaxMembers = familyMax
+
+	adFrequency, err := strconv.Atoi(getEnv("AD_FREQUENCY", "5"))
+	if err != nil || adFrequency < 1 {
+		return nil, fmt.Errorf("invalid ad frequency: %s", os.Getenv("AD_FREQUENCY"))
+	}
+	cfg.AdFrequency = adFrequency
+
 	if cfg.TelegramToken == "" {
 		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN is required")
 	}
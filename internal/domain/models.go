@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"sort"
 	"strings"
 	"time"
@@ -20,20 +21,62 @@ type User struct {
 	SubscriptionEnd        *time.Time
 	IsPremium              bool
 	Timezone               string
+	LanguageCode           string
 	ReferralCode           string
 	ReferredBy             *int64
 	DiscountPercent        int
 	ActionCount            int
 	SubscribedToBroadcasts bool
+	IsBanned               bool
+	IsBlocked              bool       // заблокировал бота или удалил аккаунт
+	FamilySubscriptionEnd  *time.Time // окончание подписки владельца семейной группы, если пользователь в ней состоит
+	FamilyPlanUntil        *time.Time // до какого момента оплачен семейный тариф
+	GraceDays              int        // льготный период после окончания подписки, дней (из конфига)
 	CreatedAt              time.Time
 	UpdatedAt              time.Time
 }
 
 func (u *User) HasActiveSubscription() bool {
+	return u.HasOwnSubscription() || u.HasFamilyPremium()
+}
+
+// HasOwnSubscription — Premium оплачен самим пользователем (без учёта семьи)
+func (u *User) HasOwnSubscription() bool {
+	until := u.PremiumUntil()
+	if until == nil {
+		return false
+	}
+	return time.Now().Before(*until)
+}
+
+// HasFamilyPremium — Premium достаётся от владельца семейной группы
+func (u *User) HasFamilyPremium() bool {
+	if u.FamilySubscriptionEnd == nil {
+		return false
+	}
+	return time.Now().Before(u.FamilySubscriptionEnd.AddDate(0, 0, u.GraceDays))
+}
+
+// HasFamilyPlan — оплачен семейный тариф, можно делиться Premium с группой
+func (u *User) HasFamilyPlan() bool {
+	return u.FamilyPlanUntil != nil && time.Now().Before(*u.FamilyPlanUntil) && u.HasOwnSubscription()
+}
+
+// PremiumUntil — момент, до которого работают Premium-функции (с учётом льготного периода)
+func (u *User) PremiumUntil() *time.Time {
 	if u.SubscriptionEnd == nil {
+		return nil
+	}
+	until := u.SubscriptionEnd.AddDate(0, 0, u.GraceDays)
+	return &until
+}
+
+// InGracePeriod — подписка формально закончилась, но льготный период ещё идёт
+func (u *User) InGracePeriod() bool {
+	if u.SubscriptionEnd == nil || u.GraceDays <= 0 {
 		return false
 	}
-	return time.Now().Before(*u.SubscriptionEnd)
+	return !time.Now().Before(*u.SubscriptionEnd) && u.HasOwnSubscription()
 }
 
 func GenerateReferralCode() string {
@@ -52,6 +95,7 @@ type Habit struct {
 	Frequency    Frequency
 	ReminderTime *string
 	IsActive     bool
+	IsLocked     bool // заблокирована после окончания Premium (только просмотр)
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 	ReminderDays []int // [1,2,3,4,5] = пн-пт, [1,2,3,4,5,6,7] = все дни
@@ -104,11 +148,22 @@ type Payment struct {
 	Status          PaymentStatus
 	PaymentURL      string
 	Description     string
+	Purpose         PaymentPurpose
+	Days            int // сколько дней Premium даёт платёж
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 	PaidAt          *time.Time
 }
 
+type PaymentPurpose string
+
+const (
+	PaymentPurposeSubscription PaymentPurpose = "subscription"
+	PaymentPurposeGift         PaymentPurpose = "gift"
+	PaymentPurposeAd           PaymentPurpose = "ad"     // пакет показов рекламодателя
+	PaymentPurposeFamily       PaymentPurpose = "family" // семейный тариф
+)
+
 type PaymentStatus string
 
 const (
@@ -118,8 +173,103 @@ const (
 	PaymentStatusCanceled  PaymentStatus = "CANCELED"
 	PaymentStatusRejected  PaymentStatus = "REJECTED"
 	PaymentStatusRefunded  PaymentStatus = "REFUNDED"
+	PaymentStatusExpired   PaymentStatus = "DEADLINE_EXPIRED"
+	PaymentStatusAuthFail  PaymentStatus = "AUTH_FAIL"
 )
 
+// IsFailed — платёж уже не будет оплачен
+func (s PaymentStatus) IsFailed() bool {
+	switch s {
+	case PaymentStatusCanceled, PaymentStatusRejected, PaymentStatusExpired, PaymentStatusAuthFail:
+		return true
+	}
+	return false
+}
+
+// ==================== PLANS ====================
+
+type Plan struct {
+	Code            string
+	Title           string
+	Days            int
+	Months          int
+	DiscountPercent int  // скидка за длительный период
+	Family          bool // семейный тариф: Premium для владельца и его семейной группы
+}
+
+// DefaultPlanCode — тариф обычной месячной подписки
+const DefaultPlanCode = "1m"
+
+var Plans = []Plan{
+	{"1m", "1 месяц", 30, 1, 0, false},
+	{"3m", "3 месяца", 90, 3, 10, false},
+	{"12m", "12 месяцев", 365, 12, 25, false},
+}
+
+// FamilyPlans — семейные тарифы, оплачиваются по отдельной цене и не дарятся
+var FamilyPlans = []Plan{
+	{"f1m", "Семейный, 1 месяц", 30, 1, 0, true},
+	{"f12m", "Семейный, 12 месяцев", 365, 12, 25, true},
+}
+
+func GetPlan(code string) *Plan {
+	for _, plan := range Plans {
+		if plan.Code == code {
+			return &plan
+		}
+	}
+	return nil
+}
+
+func GetFamilyPlan(code string) *Plan {
+	for _, plan := range FamilyPlans {
+		if plan.Code == code {
+			return &plan
+		}
+	}
+	return nil
+}
+
+// ==================== GIFTS ====================
+
+type Gift struct {
+	ID          int64
+	Code        string
+	BuyerID     int64
+	RecipientID *int64
+	PlanCode    string
+	Days        int
+	OrderID     string
+	Status      GiftStatus
+	CreatedAt   time.Time
+	PaidAt      *time.Time
+	RedeemedAt  *time.Time
+}
+
+type GiftStatus string
+
+const (
+	GiftPending  GiftStatus = "pending"
+	GiftPaid     GiftStatus = "paid"
+	GiftRedeemed GiftStatus = "redeemed"
+)
+
+func GenerateGiftCode() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return strings.ToUpper(hex.EncodeToString(bytes))
+}
+
+// ==================== FAMILY ====================
+
+// FamilyGroup — семейная подписка: Premium владельца распространяется на участников
+type FamilyGroup struct {
+	ID         int64
+	OwnerID    int64
+	InviteCode string
+	CreatedAt  time.Time
+}
+
 // ==================== REFERRAL ====================
 
 type Referral struct {
@@ -212,6 +362,184 @@ type Ad struct {
 	StartDate   *time.Time
 	EndDate     *time.Time
 	CreatedAt   time.Time
+	Audience    AdAudience
+	MaxPerUser  int       // сколько раз показать одному пользователю, 0 — без лимита
+	MediaType   MediaType // вложение из MediaFileID; без него — картинка ImageURL, если задана
+	MediaFileID string
+
+	AdvertiserID     *int64 // пользователь, купивший размещение; nil — реклама админов
+	Status           AdStatus
+	ImpressionsLimit int // оплаченные показы, 0 — без лимита
+	OrderID          *string
+	RejectReason     string
+}
+
+// AdStatus — этап жизни рекламы. Реклама админов сразу активна,
+// реклама рекламодателей проходит оплату и модерацию.
+type AdStatus string
+
+const (
+	AdStatusPendingPayment AdStatus = "pending_payment"
+	AdStatusModeration     AdStatus = "moderation"
+	AdStatusActive         AdStatus = "active"
+	AdStatusRejected       AdStatus = "rejected"
+	AdStatusCompleted      AdStatus = "completed" // оплаченные показы закончились
+)
+
+func (s AdStatus) Title() string {
+	switch s {
+	case AdStatusPendingPayment:
+		return "ждёт оплаты"
+	case AdStatusModeration:
+		return "на модерации"
+	case AdStatusActive:
+		return "показывается"
+	case AdStatusRejected:
+		return "отклонена"
+	case AdStatusCompleted:
+		return "показы закончились"
+	}
+	return string(s)
+}
+
+// ImpressionsLeft — сколько оплаченных показов осталось, -1 — без лимита
+func (a *Ad) ImpressionsLeft() int {
+	if a.ImpressionsLimit == 0 {
+		return -1
+	}
+	if a.ViewsCount >= a.ImpressionsLimit {
+		return 0
+	}
+	return a.ImpressionsLimit - a.ViewsCount
+}
+
+// AdPackage — пакет показов, который покупает рекламодатель
+type AdPackage struct {
+	Code        string
+	Impressions int
+	Price       int64 // в копейках
+}
+
+var AdPackages = []AdPackage{
+	{"1k", 1000, 50000},
+	{"5k", 5000, 200000},
+	{"20k", 20000, 600000},
+}
+
+func GetAdPackage(code string) *AdPackage {
+	for _, pkg := range AdPackages {
+		if pkg.Code == code {
+			return &pkg
+		}
+	}
+	return nil
+}
+
+func (a *Ad) HasMedia() bool {
+	return a.MediaFileID != "" || (a.ImageURL != nil && *a.ImageURL != "")
+}
+
+// CTR — доля показов с кликом, в процентах
+func (a *Ad) CTR() float64 {
+	if a.ViewsCount == 0 {
+		return 0
+	}
+	return float64(a.ClicksCount) / float64(a.ViewsCount) * 100
+}
+
+// AdDailyStat — показы и клики рекламы за день
+type AdDailyStat struct {
+	Date   time.Time
+	Views  int
+	Clicks int
+}
+
+func (s *AdDailyStat) CTR() float64 {
+	if s.Views == 0 {
+		return 0
+	}
+	return float64(s.Clicks) / float64(s.Views) * 100
+}
+
+// Targeting — аудитория, лимит и период показа человекочитаемо, для админки
+func (a *Ad) Targeting() string {
+	parts := []string{a.Audience.Describe()}
+	if a.MaxPerUser > 0 {
+		parts = append(parts, fmt.Sprintf("до %d показов на человека", a.MaxPerUser))
+	}
+	if a.StartDate != nil {
+		parts = append(parts, "с "+a.StartDate.Format("02.01.2006"))
+	}
+	if a.EndDate != nil {
+		parts = append(parts, "по "+a.EndDate.AddDate(0, 0, -1).Format("02.01.2006"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// AdAudience — кому показывать рекламу. Пустые поля не ограничивают показ.
+type AdAudience struct {
+	NewDays      int      `json:"new_days,omitempty"`      // зарегистрировались не раньше N дней назад
+	InactiveDays int      `json:"inactive_days,omitempty"` // не отмечали привычки N дней
+	Emojis       []string `json:"emojis,omitempty"`        // есть активная привычка с одним из эмодзи
+}
+
+func (a *AdAudience) IsEmpty() bool {
+	return a.NewDays == 0 && a.InactiveDays == 0 && len(a.Emojis) == 0
+}
+
+// Describe — аудитория человекочитаемо, для админки
+func (a *AdAudience) Describe() string {
+	if a.IsEmpty() {
+		return "все бесплатные"
+	}
+
+	var parts []string
+	if a.NewDays > 0 {
+		parts = append(parts, fmt.Sprintf("новые за %d дн.", a.NewDays))
+	}
+	if a.InactiveDays > 0 {
+		parts = append(parts, fmt.Sprintf("неактивны %d дн.", a.InactiveDays))
+	}
+	if len(a.Emojis) > 0 {
+		parts = append(parts, "привычки "+strings.Join(a.Emojis, ""))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Matches — подходит ли пользователь под аудиторию
+func (a *AdAudience) Matches(v *AdViewer, now time.Time) bool {
+	if a.NewDays > 0 && v.RegisteredAt.Before(now.AddDate(0, 0, -a.NewDays)) {
+		return false
+	}
+	if a.InactiveDays > 0 && v.LastCompletedAt != nil && v.LastCompletedAt.After(now.AddDate(0, 0, -a.InactiveDays)) {
+		return false
+	}
+	if len(a.Emojis) > 0 {
+		for _, emoji := range a.Emojis {
+			for _, own := range v.Emojis {
+				if emoji == own {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// AdViewer — данные пользователя для выбора рекламы
+type AdViewer struct {
+	UserID          int64
+	RegisteredAt    time.Time
+	LastCompletedAt *time.Time // последняя отмеченная привычка, nil — ни разу
+	Emojis          []string   // эмодзи активных привычек
+	Impressions     map[int64]AdImpression
+}
+
+// AdImpression — показы одной рекламы одному пользователю
+type AdImpression struct {
+	Views   int
+	Clicked bool
 }
 
 // ==================== BROADCASTS ====================
@@ -228,31 +556,626 @@ type Broadcast struct {
 	SentCount   int
 	FailedCount int
 	LastUserID  int64
+	Segment     BroadcastSegment
 	CreatedAt   time.Time
 	StartedAt   *time.Time
 	CompletedAt *time.Time
+
+	// ScheduledAt — время отложенного запуска. При PerUserLocal это показание часов,
+	// записанное как UTC: каждый пользователь получает рассылку в это время в своём поясе.
+	ScheduledAt   *time.Time
+	PerUserLocal  bool
+	WaveTimezones []string // пояса текущей волны локальной рассылки
+	DoneTimezones []string // пояса, которым локальная рассылка уже ушла
+
+	Variants []BroadcastVariant // варианты B, C, ... для A/B-теста; загружаются в GetBroadcastByID
+
+	MediaType   MediaType
+	MediaFileID string              // file_id загруженного в Telegram файла
+	Buttons     [][]BroadcastButton // ряды кнопок; если пусто — ButtonText/ButtonURL
+}
+
+// MediaType — вложение рассылки или рекламы
+type MediaType string
+
+const (
+	MediaNone      MediaType = ""
+	MediaPhoto     MediaType = "photo"
+	MediaVideo     MediaType = "video"
+	MediaDocument  MediaType = "document"
+	MediaAnimation MediaType = "animation" // GIF или видео без звука
+)
+
+// Ограничения Telegram на длину текста сообщения и подписи к медиа
+const (
+	MaxMessageLength = 4096
+	MaxCaptionLength = 1024
+)
+
+// BroadcastButton — кнопка-ссылка рассылки
+type BroadcastButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// BaseVariantLabel — вариант A: текст и кнопка самой рассылки
+const BaseVariantLabel = "A"
+
+// MaxBroadcastVariants — сколько вариантов (включая A) можно сравнивать в одной рассылке
+const MaxBroadcastVariants = 4
+
+// BroadcastVariant — вариант рассылки для A/B-теста
+type BroadcastVariant struct {
+	Label       string
+	Text        string
+	ImageURL    *string
+	ButtonText  *string
+	ButtonURL   *string
+	MediaType   MediaType
+	MediaFileID string
+	Buttons     [][]BroadcastButton
+}
+
+// Keyboard — ряды кнопок варианта. Старые рассылки с одной кнопкой дают один ряд.
+func (v *BroadcastVariant) Keyboard() [][]BroadcastButton {
+	if len(v.Buttons) > 0 {
+		return v.Buttons
+	}
+	if v.ButtonText != nil && v.ButtonURL != nil && *v.ButtonText != "" && *v.ButtonURL != "" {
+		return [][]BroadcastButton{{{Text: *v.ButtonText, URL: *v.ButtonURL}}}
+	}
+	return nil
+}
+
+// HasMedia — у варианта есть вложение или картинка по ссылке
+func (v *BroadcastVariant) HasMedia() bool {
+	return v.MediaFileID != "" || (v.ImageURL != nil && *v.ImageURL != "")
+}
+
+// Button — кнопка по порядковому номеру (слева направо, сверху вниз), nil если нет
+func (v *BroadcastVariant) Button(index int) *BroadcastButton {
+	for _, row := range v.Keyboard() {
+		if index < len(row) {
+			return &row[index]
+		}
+		index -= len(row)
+	}
+	return nil
+}
+
+// AllVariants — вариант A и дополнительные варианты
+func (b *Broadcast) AllVariants() []BroadcastVariant {
+	base := BroadcastVariant{
+		Label:       BaseVariantLabel,
+		Text:        b.Text,
+		ImageURL:    b.ImageURL,
+		ButtonText:  b.ButtonText,
+		ButtonURL:   b.ButtonURL,
+		MediaType:   b.MediaType,
+		MediaFileID: b.MediaFileID,
+		Buttons:     b.Buttons,
+	}
+	return append([]BroadcastVariant{base}, b.Variants...)
+}
+
+// Variant — вариант по метке, nil если такого нет
+func (b *Broadcast) Variant(label string) *BroadcastVariant {
+	for _, v := range b.AllVariants() {
+		if v.Label == label {
+			return &v
+		}
+	}
+	return nil
+}
+
+// VariantFor — вариант для пользователя. Разбиение псевдослучайное, но стабильное:
+// при продолжении и повторной отправке пользователь получает тот же вариант.
+func (b *Broadcast) VariantFor(userID int64) BroadcastVariant {
+	variants := b.AllVariants()
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d:%d", b.ID, userID)
+	return variants[h.Sum32()%uint32(len(variants))]
+}
+
+// NextVariantLabel — первая свободная метка для нового варианта: B, C, ...
+func (b *Broadcast) NextVariantLabel() string {
+	for label := 'B'; ; label++ {
+		if b.Variant(string(label)) == nil {
+			return string(label)
+		}
+	}
+}
+
+// VariantResult — итоги варианта рассылки: доставлено и уникальные клики
+type VariantResult struct {
+	Label     string
+	Delivered int
+	Clickers  int
+	Clicks    int
+}
+
+// CTR — доля доставленных, кто нажал на кнопку, в процентах
+func (r *VariantResult) CTR() float64 {
+	if r.Delivered == 0 {
+		return 0
+	}
+	return float64(r.Clickers) / float64(r.Delivered) * 100
+}
+
+// ScheduleLabel — время запуска для админки, пустая строка если рассылка не запланирована
+func (b *Broadcast) ScheduleLabel() string {
+	if b.ScheduledAt == nil {
+		return ""
+	}
+	if b.PerUserLocal {
+		return b.ScheduledAt.UTC().Format("02.01.2006 15:04") + " по времени пользователя"
+	}
+	return b.ScheduledAt.Local().Format("02.01.2006 15:04")
 }
 
 type BroadcastStatus string
 
 const (
 	BroadcastDraft     BroadcastStatus = "draft"
+	BroadcastScheduled BroadcastStatus = "scheduled"
 	BroadcastRunning   BroadcastStatus = "running"
 	BroadcastPaused    BroadcastStatus = "paused"
 	BroadcastCompleted BroadcastStatus = "completed"
 )
 
+// CanLaunch — рассылку можно запустить: черновик, запланированная или прерванная
+// (приостановленная или оставшаяся в running после перезапуска). Завершённая не запускается.
+func (s BroadcastStatus) CanLaunch() bool {
+	switch s {
+	case BroadcastDraft, BroadcastScheduled, BroadcastRunning, BroadcastPaused:
+		return true
+	}
+	return false
+}
+
+// DeliveryStatus — результат отправки рассылки одному получателю
+type DeliveryStatus string
+
+const (
+	DeliverySent    DeliveryStatus = "sent"
+	DeliveryFailed  DeliveryStatus = "failed"  // можно повторить
+	DeliveryBlocked DeliveryStatus = "blocked" // бот заблокирован или чат не существует
+)
+
+// BroadcastDelivery — запись журнала доставки рассылки
+type BroadcastDelivery struct {
+	ID          int64
+	BroadcastID int64
+	UserID      int64
+	TelegramID  int64
+	Username    string
+	Status      DeliveryStatus
+	Error       string
+	Attempts    int
+	UpdatedAt   time.Time
+}
+
+// BroadcastRecipient — получатель рассылки
+type BroadcastRecipient struct {
+	UserID     int64
+	TelegramID int64
+}
+
+// BroadcastSegment — фильтр аудитории рассылки. Пустые поля не ограничивают выборку.
+type BroadcastSegment struct {
+	Premium        *bool      `json:"premium,omitempty"`       // true — только Premium, false — только бесплатные
+	InactiveDays   int        `json:"inactive_days,omitempty"` // не отмечали привычки N дней
+	RegisteredFrom *time.Time `json:"registered_from,omitempty"`
+	RegisteredTo   *time.Time `json:"registered_to,omitempty"` // не включительно
+	HasReferrals   bool       `json:"has_referrals,omitempty"`
+	Timezones      []string   `json:"timezones,omitempty"`
+	Languages      []string   `json:"languages,omitempty"`
+}
+
+func (s *BroadcastSegment) IsEmpty() bool {
+	return s.Premium == nil && s.InactiveDays == 0 && s.RegisteredFrom == nil && s.RegisteredTo == nil &&
+		!s.HasReferrals && len(s.Timezones) == 0 && len(s.Languages) == 0
+}
+
+// Describe — сегмент человекочитаемо, для админки
+func (s *BroadcastSegment) Describe() string {
+	if s.IsEmpty() {
+		return "все подписанные"
+	}
+
+	var parts []string
+	if s.Premium != nil {
+		if *s.Premium {
+			parts = append(parts, "Premium")
+		} else {
+			parts = append(parts, "бесплатные")
+		}
+	}
+	if s.InactiveDays > 0 {
+		parts = append(parts, fmt.Sprintf("неактивны %d дн.", s.InactiveDays))
+	}
+	if s.RegisteredFrom != nil {
+		parts = append(parts, "регистрация с "+s.RegisteredFrom.Format("02.01.2006"))
+	}
+	if s.RegisteredTo != nil {
+		parts = append(parts, "регистрация по "+s.RegisteredTo.AddDate(0, 0, -1).Format("02.01.2006"))
+	}
+	if s.HasReferrals {
+		parts = append(parts, "есть рефералы")
+	}
+	if len(s.Timezones) > 0 {
+		parts = append(parts, "пояс "+strings.Join(s.Timezones, ","))
+	}
+	if len(s.Languages) > 0 {
+		parts = append(parts, "язык "+strings.Join(s.Languages, ","))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ==================== SUBSCRIPTION NOTICES ====================
+
+type SubscriptionNotice string
+
+const (
+	SubscriptionNoticeExpiring3d SubscriptionNotice = "expiring_3d"
+	SubscriptionNoticeExpiring1d SubscriptionNotice = "expiring_1d"
+	SubscriptionNoticeGraceStart SubscriptionNotice = "grace_started"
+	SubscriptionNoticeExpired    SubscriptionNotice = "expired"
+)
+
 // ==================== PROMOCODE ====================
 type Promocode struct {
+	ID               int64
+	Code             string
+	DiscountPercent  int
+	DiscountAmount   int64 // фиксированная скидка в копейках
+	FreeDays         int   // дни Premium без оплаты
+	MaxUses          *int
+	UsedCount        int
+	IsActive         bool
+	StartsAt         *time.Time
+	EndsAt           *time.Time
+	PlanCodes        []string // пусто — действует на все тарифы
+	FirstPaymentOnly bool
+	OwnerUserID      *int64 // персональный промокод, действует только для этого пользователя
+	Campaign         PromoCampaign
+	CreatedAt        time.Time
+}
+
+// ==================== PROMO CAMPAIGNS ====================
+
+type PromoCampaign string
+
+const (
+	PromoCampaignFirst  PromoCampaign = "first"
+	PromoCampaignWeekly PromoCampaign = "weekly"
+)
+
+// CampaignStats — эффективность автоматической промо-кампании
+type CampaignStats struct {
+	Campaign  PromoCampaign
+	Sent      int
+	Converted int
+	Revenue   int64 // в копейках
+}
+
+func (s *CampaignStats) ConversionRate() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Converted) / float64(s.Sent) * 100
+}
+
+func GeneratePromoCode(prefix string) string {
+	bytes := make([]byte, 3)
+	rand.Read(bytes)
+	return prefix + strings.ToUpper(hex.EncodeToString(bytes))
+}
+
+// IsFreeDays — промокод сразу даёт Premium, без оплаты
+func (p *Promocode) IsFreeDays() bool {
+	return p.FreeDays > 0
+}
+
+// IsValidAt — промокод активен и попадает в период действия
+func (p *Promocode) IsValidAt(t time.Time) bool {
+	if !p.IsActive {
+		return false
+	}
+	if p.StartsAt != nil && t.Before(*p.StartsAt) {
+		return false
+	}
+	if p.EndsAt != nil && !t.Before(*p.EndsAt) {
+		return false
+	}
+	return true
+}
+
+func (p *Promocode) IsExhausted() bool {
+	return p.MaxUses != nil && p.UsedCount >= *p.MaxUses
+}
+
+func (p *Promocode) AppliesToPlan(planCode string) bool {
+	if len(p.PlanCodes) == 0 {
+		return true
+	}
+	for _, code := range p.PlanCodes {
+		if code == planCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply — цена после скидки по промокоду. Минимум 100 копеек (1 рубль).
+func (p *Promocode) Apply(amount int64) int64 {
+	final := amount
+	switch {
+	case p.DiscountAmount > 0:
+		final = amount - p.DiscountAmount
+	case p.DiscountPercent > 0:
+		final = amount * int64(100-p.DiscountPercent) / 100
+	}
+	if final < 100 {
+		final = 100
+	}
+	return final
+}
+
+// DiscountText — описание выгоды для пользователя
+func (p *Promocode) DiscountText() string {
+	switch {
+	case p.FreeDays > 0:
+		return fmt.Sprintf("%d дней Premium", p.FreeDays)
+	case p.DiscountAmount > 0:
+		return fmt.Sprintf("%d₽", p.DiscountAmount/100)
+	default:
+		return fmt.Sprintf("%d%%", p.DiscountPercent)
+	}
+}
+
+// ==================== ADMIN STATS ====================
+
+// AdminStats — бизнес-метрики за период [From, To)
+type AdminStats struct {
+	From time.Time
+	To   time.Time
+
+	TotalUsers   int
+	BlockedUsers int // заблокировали бота
+	NewUsers     int
+	DAU          int // активные за последний день периода
+	WAU          int // за последние 7 дней периода
+	MAU          int // за последние 30 дней периода
+
+	PremiumUsers     int // активная подписка сейчас
+	NewPayingUsers   int // новые пользователи периода, оплатившие подписку
+	PaymentsCount    int
+	Revenue          int64 // в копейках
+	ChurnedUsers     int   // подписка закончилась в периоде и не продлена
+	AvgHabitsPerUser float64
+
+	ReferralsTotal  int
+	ReferralsStage1 int
+	ReferralsStage2 int
+
+	Daily []DailyMetric
+}
+
+// ConversionRate — доля новых пользователей периода, которые оплатили подписку
+func (s *AdminStats) ConversionRate() float64 {
+	if s.NewUsers == 0 {
+		return 0
+	}
+	return float64(s.NewPayingUsers) / float64(s.NewUsers) * 100
+}
+
+type DailyMetric struct {
+	Date        time.Time
+	NewUsers    int
+	ActiveUsers int
+	Revenue     int64
+}
+
+// ==================== ADMIN ROLES ====================
+
+type AdminRole string
+
+const (
+	RoleOwner    AdminRole = "owner"
+	RoleAdmin    AdminRole = "admin"
+	RoleSupport  AdminRole = "support"
+	RoleMarketer AdminRole = "marketer"
+)
+
+type AdminPermission string
+
+const (
+	PermStats       AdminPermission = "stats"        // метрики и конверсия кампаний
+	PermUsersView   AdminPermission = "users_view"   // карточка пользователя
+	PermUsersManage AdminPermission = "users_manage" // бан, сброс скидки и состояния
+	PermUsersBill   AdminPermission = "users_bill"   // начисление и списание Premium
+	PermPromos      AdminPermission = "promos"
+	PermAds         AdminPermission = "ads"
+	PermBroadcasts  AdminPermission = "broadcasts"
+	PermAudit       AdminPermission = "audit"
+	PermAdmins      AdminPermission = "admins" // назначение и снятие администраторов
+)
+
+var rolePermissions = map[AdminRole][]AdminPermission{
+	RoleOwner:    {PermStats, PermUsersView, PermUsersManage, PermUsersBill, PermPromos, PermAds, PermBroadcasts, PermAudit, PermAdmins},
+	RoleAdmin:    {PermStats, PermUsersView, PermUsersManage, PermUsersBill, PermPromos, PermAds, PermBroadcasts, PermAudit},
+	RoleSupport:  {PermUsersView, PermUsersManage},
+	RoleMarketer: {PermStats, PermPromos, PermAds, PermBroadcasts},
+}
+
+func (r AdminRole) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r AdminRole) Can(p AdminPermission) bool {
+	for _, perm := range rolePermissions[r] {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (r AdminRole) Title() string {
+	switch r {
+	case RoleOwner:
+		return "Владелец"
+	case RoleAdmin:
+		return "Администратор"
+	case RoleSupport:
+		return "Поддержка"
+	case RoleMarketer:
+		return "Маркетолог"
+	}
+	return string(r)
+}
+
+type Admin struct {
+	TelegramID int64
+	Role       AdminRole
+	CreatedAt  time.Time
+}
+
+// ==================== ADMIN AUDIT ====================
+
+type AuditAction string
+
+const (
+	AuditGrantDays     AuditAction = "grant_days"
+	AuditRevokeDays    AuditAction = "revoke_days"
+	AuditResetDiscount AuditAction = "reset_discount"
+	AuditBan           AuditAction = "ban"
+	AuditUnban         AuditAction = "unban"
+	AuditResetState    AuditAction = "reset_state"
+
+	AuditAdCreate            AuditAction = "ad_create"
+	AuditAdDelete            AuditAction = "ad_delete"
+	AuditAdToggle            AuditAction = "ad_toggle"
+	AuditAdTarget            AuditAction = "ad_target"
+	AuditAdApprove           AuditAction = "ad_approve"
+	AuditAdReject            AuditAction = "ad_reject"
+	AuditBroadcastCreate     AuditAction = "broadcast_create"
+	AuditBroadcastStart      AuditAction = "broadcast_start"
+	AuditBroadcastSegment    AuditAction = "broadcast_segment"
+	AuditBroadcastSchedule   AuditAction = "broadcast_schedule"
+	AuditBroadcastUnschedule AuditAction = "broadcast_unschedule"
+	AuditBroadcastStop       AuditAction = "broadcast_stop"
+	AuditBroadcastVariant    AuditAction = "broadcast_variant"
+	AuditBroadcastEdit       AuditAction = "broadcast_edit"
+	AuditBroadcastRetry      AuditAction = "broadcast_retry"
+	AuditBroadcastResume     AuditAction = "broadcast_resume"
+	AuditPromoCreate         AuditAction = "promo_create"
+	AuditPromoDelete         AuditAction = "promo_delete"
+	AuditPromoToggle         AuditAction = "promo_toggle"
+	AuditAdminAdd            AuditAction = "admin_add"
+	AuditAdminRemove         AuditAction = "admin_remove"
+)
+
+// AuditEntry — запись журнала действий администратора
+type AuditEntry struct {
 	ID              int64
-	Code            string
-	DiscountPercent int
-	MaxUses         *int
-	UsedCount       int
-	IsActive        bool
+	AdminTelegramID int64
+	Action          AuditAction
+	TargetUserID    *int64
+	Details         string
 	CreatedAt       time.Time
 }
 
+// ==================== PROGRESS REPORTS ====================
+
+// ReportPeriod — период автоматического отчёта о прогрессе
+type ReportPeriod string
+
+const (
+	ReportWeekly  ReportPeriod = "weekly"
+	ReportMonthly ReportPeriod = "monthly"
+)
+
+// ReportHour — с какого часа по местному времени пользователя уходит отчёт:
+// еженедельный — в воскресенье, ежемесячный — в последний день месяца
+const ReportHour = 19
+
+// Title — «неделю» / «месяц» для подписей «за ...»
+func (p ReportPeriod) Title() string {
+	if p == ReportMonthly {
+		return "месяц"
+	}
+	return "неделю"
+}
+
+// ReportSettings — на какие отчёты подписан пользователь
+type ReportSettings struct {
+	Weekly  bool
+	Monthly bool
+}
+
+// ReportSubscriber — подписчик отчётов и начала периодов, за которые отчёты уже ушли
+type ReportSubscriber struct {
+	UserID      int64
+	TelegramID  int64
+	Timezone    string
+	Settings    ReportSettings
+	LastWeekly  *time.Time
+	LastMonthly *time.Time
+}
+
+// HabitPeriodStat — выполнения привычки за период
+type HabitPeriodStat struct {
+	HabitID   int64
+	Name      string
+	Frequency Frequency
+	CreatedAt time.Time
+	Done      int
+}
+
+// Expected — сколько выполнений ждём за days дней: ежедневная — каждый день,
+// еженедельная — раз в неделю, ежемесячная — раз в месяц
+func (s *HabitPeriodStat) Expected(days int) int {
+	if days <= 0 {
+		return 0
+	}
+	switch s.Frequency {
+	case FrequencyWeekly:
+		return (days + 6) / 7
+	case FrequencyMonthly:
+		return (days + 29) / 30
+	default:
+		return days
+	}
+}
+
+// HabitReport — строка отчёта по привычке; проценты выполнения от 0 до 100
+type HabitReport struct {
+	Name     string
+	Rate     float64
+	PrevRate float64
+	HasPrev  bool // привычка была и в прошлом периоде
+	Streak   int
+}
+
+// ProgressReport — итоги недели или месяца
+type ProgressReport struct {
+	Period          ReportPeriod
+	From            time.Time
+	To              time.Time // не включительно
+	Habits          []HabitReport
+	Rate            float64
+	PrevRate        float64
+	HasPrev         bool
+	Best            *HabitReport
+	Worst           *HabitReport // nil, если все привычки выполнены одинаково
+	OverallStreak   int
+	NextAchievement *AchievementConfig
+	DaysLeft        int
+}
+
 // ==================== TINKOFF ====================
 
 type TinkoffInitRequest struct {
@@ -361,5 +1284,11 @@ const (
 	ReferralDiscountPerRef = 25
 	MaxReferralDiscount    = 50
 	SubscriptionDays       = 30
-	AdFrequency            = 5
+
+	FirstPromoDelayDays = 3  // через сколько дней после регистрации первое предложение
+	FirstPromoDiscount  = 30 // скидка первого предложения, %
+	WeeklyPromoDiscount = 20 // скидка еженедельного предложения, %
+	WeeklyPromoInterval = 7  // дней между еженедельными предложениями
+	PromoOfferValidDays = 3  // сколько дней действует персональный промокод
+	PromoCampaignBatch  = 500
 )
@@ -0,0 +1,127 @@
+// Package format — HTML-разметка сообщений Telegram. Пользовательские данные (названия
+// привычек, имена) экранируются, тексты админов проверяются перед сохранением.
+package format
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// ParseMode — режим разметки всех сообщений бота
+const ParseMode = "HTML"
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Escape — текст без разметки, безопасный для подстановки в сообщение
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// allowedTags — теги Telegram и их допустимые атрибуты
+var allowedTags = map[string][]string{
+	"b": nil, "strong": nil,
+	"i": nil, "em": nil,
+	"u": nil, "ins": nil,
+	"s": nil, "strike": nil, "del": nil,
+	"code":       {"class"},
+	"pre":        nil,
+	"a":          {"href"},
+	"tg-spoiler": nil,
+	"span":       {"class"},
+	"blockquote": {"expandable"},
+}
+
+var (
+	tagRe    = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[a-zA-Z-]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+))?)*)\s*>`)
+	attrRe   = regexp.MustCompile(`([a-zA-Z-]+)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+	entityRe = regexp.MustCompile(`^&(?:lt|gt|amp|quot|#[0-9]{1,7}|#x[0-9a-fA-F]{1,6});`)
+)
+
+// Validate — проверяет текст админа: только теги Telegram, все теги закрыты,
+// символы <, > и & записаны сущностями
+func Validate(text string) error {
+	var open []string
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '<':
+			m := tagRe.FindStringSubmatch(text[i:])
+			if m == nil {
+				return fmt.Errorf("символ < нужно писать как &lt;")
+			}
+			closing, name := m[1] == "/", strings.ToLower(m[2])
+			attrs, ok := allowedTags[name]
+			if !ok {
+				return fmt.Errorf("тег <%s> не поддерживается Telegram", name)
+			}
+
+			if closing {
+				if len(open) == 0 {
+					return fmt.Errorf("лишний закрывающий тег </%s>", name)
+				}
+				if last := open[len(open)-1]; last != name {
+					return fmt.Errorf("тег <%s> закрыт раньше </%s>", last, name)
+				}
+				open = open[:len(open)-1]
+			} else {
+				for _, attr := range attrRe.FindAllStringSubmatch(m[3], -1) {
+					if !contains(attrs, strings.ToLower(attr[1])) {
+						return fmt.Errorf("у тега <%s> нет атрибута %s", name, attr[1])
+					}
+				}
+				if name == "a" && !strings.Contains(strings.ToLower(m[3]), "href") {
+					return fmt.Errorf("у ссылки <a> нет адреса href")
+				}
+				open = append(open, name)
+			}
+			i += len(m[0])
+		case '>':
+			return fmt.Errorf("символ > нужно писать как &gt;")
+		case '&':
+			m := entityRe.FindString(text[i:])
+			if m == "" {
+				return fmt.Errorf("символ & нужно писать как &amp;")
+			}
+			i += len(m)
+		default:
+			i++
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("не закрыт тег <%s>", open[len(open)-1])
+	}
+	return nil
+}
+
+// Strip — текст, который увидит пользователь: без тегов и с раскрытыми сущностями
+func Strip(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		if text[i] == '<' {
+			if m := tagRe.FindString(text[i:]); m != "" {
+				i += len(m)
+				continue
+			}
+		}
+		sb.WriteByte(text[i])
+		i++
+	}
+	return html.UnescapeString(sb.String())
+}
+
+// Length — длина текста по правилам лимитов Telegram: разметка не считается,
+// символы считаются в UTF-16, поэтому большинство эмодзи занимают два
+func Length(text string) int {
+	return len(utf16.Encode([]rune(Strip(text))))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package format
+
+import "testing"
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Бег", "Бег"},
+		{"a < b > c", "a &lt; b &gt; c"},
+		{"Tom & Jerry", "Tom &amp; Jerry"},
+		{"<b>жирный</b>", "&lt;b&gt;жирный&lt;/b&gt;"},
+		{"&amp;", "&amp;amp;"},
+		{`"кавычки" и 'апострофы'`, `"кавычки" и 'апострофы'`},
+	}
+	for _, tt := range tests {
+		if got := Escape(tt.in); got != tt.want {
+			t.Errorf("Escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{"plain", "Привет!", false},
+		{"tags", "<b>жирный</b> <i>курсив</i> <u>подчёркнутый</u>", false},
+		{"nested", "<b><i>оба</i></b>", false},
+		{"link", `<a href="https://example.com">ссылка</a>`, false},
+		{"code class", `<pre><code class="language-go">x</code></pre>`, false},
+		{"spoiler", "<tg-spoiler>секрет</tg-spoiler>", false},
+		{"expandable quote", "<blockquote expandable>цитата</blockquote>", false},
+		{"entities", "a &lt; b &amp;&amp; c &gt; d &#128512; &#x1F600;", false},
+		{"upper case tag", "<B>жирный</B>", false},
+		{"raw less than", "a < b", true},
+		{"raw greater than", "a > b", true},
+		{"raw ampersand", "Tom & Jerry", true},
+		{"unknown entity", "&nbsp;", true},
+		{"unsupported tag", "<div>блок</div>", true},
+		{"unclosed", "<b>жирный", true},
+		{"extra closing", "жирный</b>", true},
+		{"crossed", "<b><i>оба</b></i>", true},
+		{"bad attribute", `<b class="x">жирный</b>`, true},
+		{"link without href", "<a>ссылка</a>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"Привет", 6},
+		{"<b>Привет</b>", 6},
+		{"a &lt; b", 5},
+		{"🔥", 2},
+		{"<i>🔥 3</i>", 4},
+	}
+	for _, tt := range tests {
+		if got := Length(tt.text); got != tt.want {
+			t.Errorf("Length(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
@@ -2,24 +2,35 @@ package repository
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"habit-tracker-bot/internal/domain"
 )
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+)
+
+// isUniqueViolation — ошибка нарушения уникального индекса (SQLSTATE 23505)
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
 
 type PostgresRepository struct {
-	db *pgxpool.Pool
+	db        *pgxpool.Pool
+	graceDays int // льготный период после окончания подписки, дней
 }
 
-func NewPostgresRepository(ctx context.Context, databaseURL string) (*PostgresRepository, error) {
+func NewPostgresRepository(ctx context.Context, databaseURL string, graceDays int) (*PostgresRepository, error) {
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
@@ -36,7 +47,7 @@ func NewPostgresRepository(ctx context.Context, databaseURL string) (*PostgresRe
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
 
-	return &PostgresRepository{db: pool}, nil
+	return &PostgresRepository{db: pool, graceDays: graceDays}, nil
 }
 
 func (r *PostgresRepository) Close() {
@@ -51,37 +62,48 @@ func (r *PostgresRepository) CreateUser(ctx context.Context, user *domain.User)
 	}
 
 	query := `
-    INSERT INTO users (telegram_id, username, first_name, timezone, referral_code, referred_by, discount_percent, subscribed_to_broadcasts, created_at, updated_at)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, true, $8, $8)
+    INSERT INTO users (telegram_id, username, first_name, timezone, language_code, referral_code, referred_by, discount_percent, subscribed_to_broadcasts, created_at, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, true, $9, $9)
     ON CONFLICT (telegram_id) DO UPDATE SET
       username = EXCLUDED.username,
       first_name = EXCLUDED.first_name,
+      language_code = COALESCE(NULLIF(EXCLUDED.language_code, ''), users.language_code),
+      is_blocked = false,
       updated_at = EXCLUDED.updated_at
     RETURNING id, referral_code, discount_percent`
 
 	return r.db.QueryRow(ctx, query,
-		user.TelegramID, user.Username, user.FirstName, user.Timezone,
+		user.TelegramID, user.Username, user.FirstName, user.Timezone, user.LanguageCode,
 		user.ReferralCode, user.ReferredBy, user.DiscountPercent, time.Now(),
 	).Scan(&user.ID, &user.ReferralCode, &user.DiscountPercent)
 }
 
-func (r *PostgresRepository) GetUserByTelegramID(ctx context.Context, telegramID int64) (*domain.User, error) {
-	query := `
-    SELECT id, telegram_id, username, first_name, subscription_end, timezone, 
-           referral_code, referred_by, discount_percent, action_count, 
-           subscribed_to_broadcasts, created_at, updated_at
-    FROM users WHERE telegram_id = $1`
+// familyPremiumEnd — до какого момента пользователь alias получает Premium от владельца
+// семейной группы: пока у владельца есть и подписка, и оплаченный семейный тариф.
+// NULL, если пользователь не в семье
+func familyPremiumEnd(alias string) string {
+	return `(SELECT LEAST(o.subscription_end, o.family_until) FROM family_members fm
+	    JOIN family_groups fg ON fg.id = fm.group_id
+	    JOIN users o ON o.id = fg.owner_id
+	    WHERE fm.user_id = ` + alias + `.id)`
+}
+
+// userColumns — поля таблицы users в порядке scanUser
+var userColumns = `id, telegram_id, username, first_name, subscription_end, timezone, language_code,
+           referral_code, referred_by, discount_percent, action_count,
+           subscribed_to_broadcasts, is_banned, is_blocked, created_at, updated_at, family_until,
+           ` + familyPremiumEnd("users") + ` AS family_subscription_end`
 
-	user := &domain.User{}
-	err := r.db.QueryRow(ctx, query, telegramID).Scan(
+// scanUser — пользователь из строки с полями userColumns
+func (r *PostgresRepository) scanUser(row pgx.Row) (*domain.User, error) {
+	user := &domain.User{GraceDays: r.graceDays}
+	err := row.Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.SubscriptionEnd, &user.Timezone, &user.ReferralCode,
+		&user.SubscriptionEnd, &user.Timezone, &user.LanguageCode, &user.ReferralCode,
 		&user.ReferredBy, &user.DiscountPercent, &user.ActionCount,
-		&user.SubscribedToBroadcasts, &user.CreatedAt, &user.UpdatedAt,
+		&user.SubscribedToBroadcasts, &user.IsBanned, &user.IsBlocked, &user.CreatedAt, &user.UpdatedAt,
+		&user.FamilyPlanUntil, &user.FamilySubscriptionEnd,
 	)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, ErrNotFound
-	}
 	if err != nil {
 		return nil, err
 	}
@@ -89,51 +111,76 @@ func (r *PostgresRepository) GetUserByTelegramID(ctx context.Context, telegramID
 	return user, nil
 }
 
+func (r *PostgresRepository) GetUserByTelegramID(ctx context.Context, telegramID int64) (*domain.User, error) {
+	query := `
+    SELECT ` + userColumns + `
+    FROM users WHERE telegram_id = $1`
+
+	user, err := r.scanUser(r.db.QueryRow(ctx, query, telegramID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
 func (r *PostgresRepository) GetUserByID(ctx context.Context, id int64) (*domain.User, error) {
 	query := `
-    SELECT id, telegram_id, username, first_name, subscription_end, timezone, 
-           referral_code, referred_by, discount_percent, action_count,
-           subscribed_to_broadcasts, created_at, updated_at
+    SELECT ` + userColumns + `
     FROM users WHERE id = $1`
 
-	user := &domain.User{}
-	err := r.db.QueryRow(ctx, query, id).Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.SubscriptionEnd, &user.Timezone, &user.ReferralCode,
-		&user.ReferredBy, &user.DiscountPercent, &user.ActionCount,
-		&user.SubscribedToBroadcasts, &user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := r.scanUser(r.db.QueryRow(ctx, query, id))
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrNotFound
 	}
-	if err != nil {
-		return nil, err
-	}
-	user.IsPremium = user.HasActiveSubscription()
-	return user, nil
+	return user, err
 }
 
 func (r *PostgresRepository) GetUserByReferralCode(ctx context.Context, code string) (*domain.User, error) {
 	query := `
-    SELECT id, telegram_id, username, first_name, subscription_end, timezone, 
-           referral_code, referred_by, discount_percent, action_count,
-           subscribed_to_broadcasts, created_at, updated_at
+    SELECT ` + userColumns + `
     FROM users WHERE referral_code = $1`
-	user := &domain.User{}
-	err := r.db.QueryRow(ctx, query, code).Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.SubscriptionEnd, &user.Timezone, &user.ReferralCode,
-		&user.ReferredBy, &user.DiscountPercent, &user.ActionCount,
-		&user.SubscribedToBroadcasts, &user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := r.scanUser(r.db.QueryRow(ctx, query, code))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+// GetUserByUsername — поиск по @username без учёта регистра
+func (r *PostgresRepository) GetUserByUsername(ctx context.Context, username string) (*domain.User, error) {
+	query := `
+    SELECT ` + userColumns + `
+    FROM users WHERE LOWER(username) = LOWER($1)
+    ORDER BY updated_at DESC LIMIT 1`
+	user, err := r.scanUser(r.db.QueryRow(ctx, query, username))
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrNotFound
 	}
+	return user, err
+}
+
+// GetRecentUsers — последние зарегистрированные пользователи (для веб-админки)
+func (r *PostgresRepository) GetRecentUsers(ctx context.Context, limit int) ([]*domain.User, error) {
+	query := `
+    SELECT id, telegram_id, username, first_name, subscription_end, is_banned, is_blocked, created_at
+    FROM users ORDER BY created_at DESC LIMIT $1`
+
+	rows, err := r.db.Query(ctx, query, limit)
 	if err != nil {
 		return nil, err
 	}
-	user.IsPremium = user.HasActiveSubscription()
-	return user, nil
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		u := &domain.User{GraceDays: r.graceDays}
+		if err := rows.Scan(&u.ID, &u.TelegramID, &u.Username, &u.FirstName, &u.SubscriptionEnd, &u.IsBanned, &u.IsBlocked, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		u.IsPremium = u.HasOwnSubscription()
+		users = append(users, u)
+	}
+	return users, nil
 }
 
 func (r *PostgresRepository) UpdateUser(ctx context.Context, user *domain.User) error {
@@ -148,8 +195,8 @@ func (r *PostgresRepository) UpdateSubscription(ctx context.Context, userID int6
 	return err
 }
 
-func (r *PostgresRepository) AddSubscriptionDays(ctx context.Context, userID int64, days int) error {
-	query := `
+// addSubscriptionDaysQuery — продлевает подписку $1 на $2 дней: от текущего окончания или от сейчас, если истекла
+const addSubscriptionDaysQuery = `
     UPDATE users SET 
       subscription_end = CASE 
         WHEN subscription_end IS NULL OR subscription_end < NOW() 
@@ -158,6 +205,34 @@ func (r *PostgresRepository) AddSubscriptionDays(ctx context.Context, userID int
       END,
       updated_at = $3
     WHERE id = $1`
+
+// addFamilyPlanDaysQuery — продление семейного тарифа: вместе с подпиской продлевается
+// и family_until, чтобы семейный доступ заканчивался не раньше самой подписки
+const addFamilyPlanDaysQuery = `
+    UPDATE users SET
+      subscription_end = CASE
+        WHEN subscription_end IS NULL OR subscription_end < NOW()
+        THEN NOW() + INTERVAL '1 day' * $2
+        ELSE subscription_end + INTERVAL '1 day' * $2
+      END,
+      family_until = CASE
+        WHEN family_until IS NULL OR family_until < NOW()
+        THEN GREATEST(NOW(), COALESCE(subscription_end, NOW())) + INTERVAL '1 day' * $2
+        ELSE family_until + INTERVAL '1 day' * $2
+      END,
+      updated_at = $3
+    WHERE id = $1`
+
+func (r *PostgresRepository) AddSubscriptionDays(ctx context.Context, userID int64, days int) error {
+	_, err := r.db.Exec(ctx, addSubscriptionDaysQuery, userID, days, time.Now())
+	return err
+}
+
+// RevokeSubscriptionDays — сокращает подписку на days дней (может сделать её истёкшей)
+func (r *PostgresRepository) RevokeSubscriptionDays(ctx context.Context, userID int64, days int) error {
+	query := `
+    UPDATE users SET subscription_end = subscription_end - INTERVAL '1 day' * $2, updated_at = $3
+    WHERE id = $1 AND subscription_end IS NOT NULL`
 	_, err := r.db.Exec(ctx, query, userID, days, time.Now())
 	return err
 }
@@ -168,6 +243,18 @@ func (r *PostgresRepository) AddDiscount(ctx context.Context, userID int64, perc
 	return err
 }
 
+func (r *PostgresRepository) ResetDiscount(ctx context.Context, userID int64) error {
+	query := `UPDATE users SET discount_percent = 0, updated_at = $2 WHERE id = $1`
+	_, err := r.db.Exec(ctx, query, userID, time.Now())
+	return err
+}
+
+func (r *PostgresRepository) SetUserBanned(ctx context.Context, userID int64, banned bool) error {
+	query := `UPDATE users SET is_banned = $2, updated_at = $3 WHERE id = $1`
+	_, err := r.db.Exec(ctx, query, userID, banned, time.Now())
+	return err
+}
+
 func (r *PostgresRepository) IncrementActionCount(ctx context.Context, userID int64) (int, error) {
 	query := `UPDATE users SET action_count = action_count + 1, updated_at = $2 WHERE id = $1 RETURNING action_count`
 	var count int
@@ -181,37 +268,172 @@ func (r *PostgresRepository) ResetActionCount(ctx context.Context, userID int64)
 	return err
 }
 
+// MarkUserBlocked — пользователь заблокировал бота: исключается из рассылок, напоминаний и активных
+func (r *PostgresRepository) MarkUserBlocked(ctx context.Context, userID int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE users SET is_blocked = true, blocked_at = $2 WHERE id = $1 AND is_blocked = false`, userID, time.Now())
+	return err
+}
+
 func (r *PostgresRepository) GetTotalUsersCount(ctx context.Context) (int, error) {
 	var count int
-	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE subscribed_to_broadcasts = true`).Scan(&count)
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE subscribed_to_broadcasts = true AND is_banned = false AND is_blocked = false`).Scan(&count)
 	return count, err
 }
 
-func (r *PostgresRepository) GetUsersForBroadcast(ctx context.Context, lastUserID int64, limit int) ([]int64, int64, error) {
-	query := `
-    SELECT id, telegram_id FROM users 
-    WHERE id > $1 AND subscribed_to_broadcasts = true
-    ORDER BY id ASC LIMIT $2`
+// GetAdminStats — бизнес-метрики за период [from, to)
+func (r *PostgresRepository) GetAdminStats(ctx context.Context, from, to time.Time) (*domain.AdminStats, error) {
+	st := &domain.AdminStats{From: from, To: to}
+
+	err := r.db.QueryRow(ctx, `
+	  SELECT
+	    (SELECT COUNT(*) FROM users),
+	    (SELECT COUNT(*) FROM users WHERE is_blocked = true),
+	    (SELECT COUNT(*) FROM users WHERE created_at >= $1 AND created_at < $2),
+	    (SELECT COUNT(DISTINCT user_id) FROM habit_logs WHERE completed = true AND date >= $2::date - 1 AND date < $2::date),
+	    (SELECT COUNT(DISTINCT user_id) FROM habit_logs WHERE completed = true AND date >= $2::date - 7 AND date < $2::date),
+	    (SELECT COUNT(DISTINCT user_id) FROM habit_logs WHERE completed = true AND date >= $2::date - 30 AND date < $2::date),
+	    (SELECT COUNT(*) FROM users u WHERE ` + premiumCondition("$3") + `),
+	    (SELECT COUNT(DISTINCT u.id) FROM users u JOIN payments p ON p.user_id = u.id
+	       WHERE u.created_at >= $1 AND u.created_at < $2 AND p.status = 'CONFIRMED'),
+	    (SELECT COUNT(*) FROM payments WHERE status = 'CONFIRMED' AND paid_at >= $1 AND paid_at < $2),
+	    (SELECT COALESCE(SUM(amount), 0) FROM payments WHERE status = 'CONFIRMED' AND paid_at >= $1 AND paid_at < $2),
+	    (SELECT COUNT(*) FROM users WHERE subscription_end >= $1 AND subscription_end < $2 AND subscription_end < NOW()),
+	    (SELECT COALESCE(COUNT(*)::float / NULLIF((SELECT COUNT(*) FROM users), 0), 0) FROM habits WHERE is_active = true),
+	    (SELECT COUNT(*) FROM referrals WHERE created_at >= $1 AND created_at < $2),
+	    (SELECT COUNT(*) FROM referrals WHERE created_at >= $1 AND created_at < $2 AND stage1_applied = true),
+	    (SELECT COUNT(*) FROM referrals WHERE created_at >= $1 AND created_at < $2 AND stage2_applied = true)`,
+		from, to, r.graceDays).Scan(
+		&st.TotalUsers, &st.BlockedUsers, &st.NewUsers, &st.DAU, &st.WAU, &st.MAU,
+		&st.PremiumUsers, &st.NewPayingUsers, &st.PaymentsCount, &st.Revenue,
+		&st.ChurnedUsers, &st.AvgHabitsPerUser,
+		&st.ReferralsTotal, &st.ReferralsStage1, &st.ReferralsStage2,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(ctx, `
+	  SELECT d::date,
+	    (SELECT COUNT(*) FROM users WHERE created_at >= d AND created_at < d + INTERVAL '1 day'),
+	    (SELECT COUNT(DISTINCT user_id) FROM habit_logs WHERE completed = true AND date = d::date),
+	    (SELECT COALESCE(SUM(amount), 0) FROM payments WHERE status = 'CONFIRMED' AND paid_at >= d AND paid_at < d + INTERVAL '1 day')
+	  FROM generate_series($1::date, $2::date - 1, INTERVAL '1 day') AS d
+	  ORDER BY d`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m domain.DailyMetric
+		if err := rows.Scan(&m.Date, &m.NewUsers, &m.ActiveUsers, &m.Revenue); err != nil {
+			return nil, err
+		}
+		st.Daily = append(st.Daily, m)
+	}
+	return st, nil
+}
+
+// premiumCondition — SQL-условие «у пользователя u работает Premium», как в User.HasActiveSubscription:
+// своя подписка или подписка владельца семьи с учётом льготного периода grace (плейсхолдер).
+// Без подписки даёт false, а не NULL, поэтому его можно отрицать.
+func premiumCondition(grace string) string {
+	return `(COALESCE(u.subscription_end + INTERVAL '1 day' * ` + grace + ` > NOW(), false) OR
+	    COALESCE(` + familyPremiumEnd("u") + ` + INTERVAL '1 day' * ` + grace + ` > NOW(), false))`
+}
+
+// segmentFilter — условия WHERE для сегмента рассылки по таблице users u.
+// Параметры дописываются в args, плейсхолдеры продолжают нумерацию.
+func (r *PostgresRepository) segmentFilter(seg *domain.BroadcastSegment, args []any) (string, []any) {
+	var sb strings.Builder
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if seg.Premium != nil {
+		premium := premiumCondition(arg(r.graceDays))
+		if *seg.Premium {
+			sb.WriteString(" AND " + premium)
+		} else {
+			sb.WriteString(" AND NOT " + premium)
+		}
+	}
+	if seg.InactiveDays > 0 {
+		sb.WriteString(` AND NOT EXISTS (SELECT 1 FROM habit_logs l WHERE l.user_id = u.id AND l.completed = true AND l.date > CURRENT_DATE - ` + arg(seg.InactiveDays) + `::int)`)
+	}
+	if seg.RegisteredFrom != nil {
+		sb.WriteString(" AND u.created_at >= " + arg(*seg.RegisteredFrom))
+	}
+	if seg.RegisteredTo != nil {
+		sb.WriteString(" AND u.created_at < " + arg(*seg.RegisteredTo))
+	}
+	if seg.HasReferrals {
+		sb.WriteString(" AND EXISTS (SELECT 1 FROM referrals rf WHERE rf.referrer_id = u.id)")
+	}
+	if len(seg.Timezones) > 0 {
+		sb.WriteString(" AND u.timezone = ANY(" + arg(seg.Timezones) + ")")
+	}
+	if len(seg.Languages) > 0 {
+		sb.WriteString(" AND u.language_code = ANY(" + arg(seg.Languages) + ")")
+	}
+	return sb.String(), args
+}
+
+// CountBroadcastAudience — сколько пользователей получит рассылку с этим сегментом
+func (r *PostgresRepository) CountBroadcastAudience(ctx context.Context, seg *domain.BroadcastSegment) (int, error) {
+	filter, args := r.segmentFilter(seg, nil)
+	query := `SELECT COUNT(*) FROM users u WHERE u.subscribed_to_broadcasts = true AND u.is_banned = false AND u.is_blocked = false` + filter
+
+	var count int
+	err := r.db.QueryRow(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// GetBroadcastAudienceTimezones — часовые пояса, в которых есть получатели сегмента
+func (r *PostgresRepository) GetBroadcastAudienceTimezones(ctx context.Context, seg *domain.BroadcastSegment) ([]string, error) {
+	filter, args := r.segmentFilter(seg, nil)
+	query := `SELECT DISTINCT u.timezone FROM users u WHERE u.subscribed_to_broadcasts = true AND u.is_banned = false AND u.is_blocked = false` + filter
 
-	rows, err := r.db.Query(ctx, query, lastUserID, limit)
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
-		return nil, lastUserID, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var telegramIDs []int64
-	var maxID int64 = lastUserID
+	var zones []string
 	for rows.Next() {
-		var id, telegramID int64
-		if err := rows.Scan(&id, &telegramID); err != nil {
-			return nil, lastUserID, err
+		var tz string
+		if err := rows.Scan(&tz); err != nil {
+			return nil, err
 		}
-		telegramIDs = append(telegramIDs, telegramID)
-		if id > maxID {
-			maxID = id
+		zones = append(zones, tz)
+	}
+	return zones, nil
+}
+
+func (r *PostgresRepository) GetUsersForBroadcast(ctx context.Context, seg *domain.BroadcastSegment, lastUserID int64, limit int) ([]domain.BroadcastRecipient, error) {
+	filter, args := r.segmentFilter(seg, []any{lastUserID, limit})
+	query := `
+    SELECT u.id, u.telegram_id FROM users u
+    WHERE u.id > $1 AND u.subscribed_to_broadcasts = true AND u.is_banned = false AND u.is_blocked = false` + filter + `
+    ORDER BY u.id ASC LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []domain.BroadcastRecipient
+	for rows.Next() {
+		var rc domain.BroadcastRecipient
+		if err := rows.Scan(&rc.UserID, &rc.TelegramID); err != nil {
+			return nil, err
 		}
+		recipients = append(recipients, rc)
 	}
-	return telegramIDs, maxID, nil
+	return recipients, nil
 }
 
 func (r *PostgresRepository) GetUserIDByTelegramID(ctx context.Context, telegramID int64) (int64, error) {
@@ -220,6 +442,58 @@ func (r *PostgresRepository) GetUserIDByTelegramID(ctx context.Context, telegram
 	return id, err
 }
 
+// GetUsersBySubscriptionEnd — пользователи, у которых подписка заканчивается в интервале (from, to]
+func (r *PostgresRepository) GetUsersBySubscriptionEnd(ctx context.Context, from, to time.Time) ([]*domain.User, error) {
+	query := `
+    SELECT ` + userColumns + `
+    FROM users WHERE subscription_end > $1 AND subscription_end <= $2
+    ORDER BY id ASC`
+
+	rows, err := r.db.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		user, err := r.scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// ==================== SUBSCRIPTION NOTICES ====================
+
+// MarkSubscriptionNotice — отмечает уведомление как отправленное.
+// Возвращает false, если такое уведомление для этой даты окончания уже было.
+func (r *PostgresRepository) MarkSubscriptionNotice(ctx context.Context, userID int64, kind domain.SubscriptionNotice, subscriptionEnd time.Time) (bool, error) {
+	query := `
+    INSERT INTO subscription_notices (user_id, kind, subscription_end, sent_at)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (user_id, kind, subscription_end) DO NOTHING RETURNING id`
+
+	var id int64
+	err := r.db.QueryRow(ctx, query, userID, kind, subscriptionEnd, time.Now()).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ClearSubscriptionNotice — снимает отметку, если уведомление не удалось доставить,
+// чтобы следующая проверка отправила его снова
+func (r *PostgresRepository) ClearSubscriptionNotice(ctx context.Context, userID int64, kind domain.SubscriptionNotice, subscriptionEnd time.Time) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM subscription_notices WHERE user_id = $1 AND kind = $2 AND subscription_end = $3`, userID, kind, subscriptionEnd)
+	return err
+}
+
 // ==================== HABITS ====================
 func (r *PostgresRepository) CreateHabit(ctx context.Context, habit *domain.Habit) error {
 	return r.db.QueryRow(ctx, `
@@ -232,14 +506,14 @@ func (r *PostgresRepository) CreateHabit(ctx context.Context, habit *domain.Habi
 
 func (r *PostgresRepository) GetHabitByID(ctx context.Context, id int64) (*domain.Habit, error) {
 	query := `
-	  SELECT id, user_id, name, description, frequency, emoji, reminder_time, is_active, created_at, updated_at
+	  SELECT id, user_id, name, description, frequency, emoji, reminder_time, is_active, is_locked, created_at, updated_at
 	  FROM habits WHERE id = $1`
 
 	habit := &domain.Habit{}
 	err := r.db.QueryRow(ctx, query, id).Scan(
 		&habit.ID, &habit.UserID, &habit.Name, &habit.Description,
 		&habit.Frequency, &habit.Emoji, &habit.ReminderTime,
-		&habit.IsActive, &habit.CreatedAt, &habit.UpdatedAt,
+		&habit.IsActive, &habit.IsLocked, &habit.CreatedAt, &habit.UpdatedAt,
 	)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrNotFound
@@ -249,7 +523,7 @@ func (r *PostgresRepository) GetHabitByID(ctx context.Context, id int64) (*domai
 
 func (r *PostgresRepository) GetActiveHabits(ctx context.Context, userID int64) ([]*domain.Habit, error) {
 	query := `
-	  SELECT id, user_id, name, description, frequency, emoji, reminder_time, is_active, created_at, updated_at
+	  SELECT id, user_id, name, description, frequency, emoji, reminder_time, is_active, is_locked, created_at, updated_at
 	  FROM habits WHERE user_id = $1 AND is_active = true ORDER BY created_at DESC`
 
 	rows, err := r.db.Query(ctx, query, userID)
@@ -261,7 +535,7 @@ func (r *PostgresRepository) GetActiveHabits(ctx context.Context, userID int64)
 	var habits []*domain.Habit
 	for rows.Next() {
 		h := &domain.Habit{}
-		if err := rows.Scan(&h.ID, &h.UserID, &h.Name, &h.Description, &h.Frequency, &h.Emoji, &h.ReminderTime, &h.IsActive, &h.CreatedAt, &h.UpdatedAt); err != nil {
+		if err := rows.Scan(&h.ID, &h.UserID, &h.Name, &h.Description, &h.Frequency, &h.Emoji, &h.ReminderTime, &h.IsActive, &h.IsLocked, &h.CreatedAt, &h.UpdatedAt); err != nil {
 			return nil, err
 		}
 		habits = append(habits, h)
@@ -282,7 +556,7 @@ func (r *PostgresRepository) DeleteHabit(ctx context.Context, id int64) error {
 
 func (r *PostgresRepository) CountUserHabits(ctx context.Context, userID int64) (int, error) {
 	var count int
-	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM habits WHERE user_id = $1 AND is_active = true`, userID).Scan(&count)
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM habits WHERE user_id = $1 AND is_active = true AND is_locked = false`, userID).Scan(&count)
 	return count, err
 }
 
@@ -291,6 +565,33 @@ func (r *PostgresRepository) ClearReminders(ctx context.Context, userID int64) e
 	return err
 }
 
+// LockHabitsExcept — блокирует все активные привычки, кроме keep самых старых. Возвращает число заблокированных.
+func (r *PostgresRepository) LockHabitsExcept(ctx context.Context, userID int64, keep int) (int, error) {
+	query := `
+	  UPDATE habits SET is_locked = true, updated_at = $3
+	  WHERE user_id = $1 AND is_active = true AND is_locked = false
+	  AND id NOT IN (
+		SELECT id FROM habits
+		WHERE user_id = $1 AND is_active = true AND is_locked = false
+		ORDER BY created_at ASC, id ASC LIMIT $2
+	  )`
+	tag, err := r.db.Exec(ctx, query, userID, keep, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *PostgresRepository) UnlockUserHabits(ctx context.Context, userID int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE habits SET is_locked = false, updated_at = $2 WHERE user_id = $1 AND is_locked = true`, userID, time.Now())
+	return err
+}
+
+func (r *PostgresRepository) SetHabitLocked(ctx context.Context, habitID int64, locked bool) error {
+	_, err := r.db.Exec(ctx, `UPDATE habits SET is_locked = $2, updated_at = $3 WHERE id = $1`, habitID, locked, time.Now())
+	return err
+}
+
 // ==================== HABIT LOGS ====================
 
 func (r *PostgresRepository) LogHabit(ctx context.Context, log *domain.HabitLog) error {
@@ -427,7 +728,7 @@ func (r *PostgresRepository) GetUserStats(ctx context.Context, userID int64) ([]
 }
 func (r *PostgresRepository) GetUserOverallStreak(ctx context.Context, userID int64) (int, error) {
 	query := `
-	  WITH user_habits AS (SELECT id FROM habits WHERE user_id = $1 AND is_active = true),
+	  WITH user_habits AS (SELECT id FROM habits WHERE user_id = $1 AND is_active = true AND is_locked = false),
 	  habit_count AS (SELECT COUNT(*) as total FROM user_habits),
 	  daily_completions AS (
 		SELECT hl.date, COUNT(DISTINCT hl.habit_id) as completed_count
@@ -459,10 +760,11 @@ func (r *PostgresRepository) GetHabitsForReminder(ctx context.Context, timeStr s
 	query := `
 	  SELECT h.id, h.user_id, h.name, h.description, h.frequency, h.reminder_time, h.is_active, h.created_at, h.updated_at
 	  FROM habits h JOIN users u ON u.id = h.user_id
-	  WHERE h.reminder_time = $1 AND h.is_active = true AND u.subscription_end > NOW()
+	  WHERE h.reminder_time = $1 AND h.is_active = true AND h.is_locked = false AND u.is_banned = false AND u.is_blocked = false
+	  AND ` + premiumCondition("$2") + `
 	  AND NOT EXISTS (SELECT 1 FROM habit_logs WHERE habit_id = h.id AND date = CURRENT_DATE AND completed = true)`
 
-	rows, err := r.db.Query(ctx, query, timeStr)
+	rows, err := r.db.Query(ctx, query, timeStr, r.graceDays)
 	if err != nil {
 		return nil, err
 	}
@@ -489,18 +791,18 @@ func (r *PostgresRepository) GetUserTelegramIDByHabitID(ctx context.Context, hab
 
 func (r *PostgresRepository) CreatePayment(ctx context.Context, p *domain.Payment) error {
 	query := `
-	  INSERT INTO payments (user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, created_at, updated_at)
-	  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) RETURNING id`
-	return r.db.QueryRow(ctx, query, p.UserID, p.TinkoffID, p.OrderID, p.Amount, p.OriginalAmount, p.DiscountPercent, p.Status, p.PaymentURL, p.Description, time.Now()).Scan(&p.ID)
+	  INSERT INTO payments (user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, purpose, days, created_at, updated_at)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12) RETURNING id`
+	return r.db.QueryRow(ctx, query, p.UserID, p.TinkoffID, p.OrderID, p.Amount, p.OriginalAmount, p.DiscountPercent, p.Status, p.PaymentURL, p.Description, p.Purpose, p.Days, time.Now()).Scan(&p.ID)
 }
 
 func (r *PostgresRepository) GetPaymentByOrderID(ctx context.Context, orderID string) (*domain.Payment, error) {
 	query := `
-	  SELECT id, user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, created_at, updated_at, paid_at
+	  SELECT id, user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, purpose, days, created_at, updated_at, paid_at
 	  FROM payments WHERE order_id = $1`
 
 	p := &domain.Payment{}
-	err := r.db.QueryRow(ctx, query, orderID).Scan(&p.ID, &p.UserID, &p.TinkoffID, &p.OrderID, &p.Amount, &p.OriginalAmount, &p.DiscountPercent, &p.Status, &p.PaymentURL, &p.Description, &p.CreatedAt, &p.UpdatedAt, &p.PaidAt)
+	err := r.db.QueryRow(ctx, query, orderID).Scan(&p.ID, &p.UserID, &p.TinkoffID, &p.OrderID, &p.Amount, &p.OriginalAmount, &p.DiscountPercent, &p.Status, &p.PaymentURL, &p.Description, &p.Purpose, &p.Days, &p.CreatedAt, &p.UpdatedAt, &p.PaidAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -511,114 +813,424 @@ func (r *PostgresRepository) UpdatePaymentStatus(ctx context.Context, orderID st
 	_, err := r.db.Exec(ctx, `UPDATE payments SET status=$2, tinkoff_id=$3, updated_at=$4 WHERE order_id=$1`, orderID, status, tinkoffID, time.Now())
 	return err
 }
-func (r *PostgresRepository) UpdatePaymentPaid(ctx context.Context, orderID string, paidAt time.Time) error {
-	_, err := r.db.Exec(ctx, `UPDATE payments SET status=$2, paid_at=$3, updated_at=$4 WHERE order_id=$1`, orderID, domain.PaymentStatusConfirmed, paidAt, time.Now())
-	return err
-}
+// ApplyPaidPayment — в одной транзакции отмечает платёж оплаченным, списывает зарезервированный
+// промокод и начисляет оплаченное: дни подписки, подарок или рекламу на модерацию.
+// false, если платёж уже был обработан раньше. При ошибке ничего не сохраняется,
+// и повторное уведомление Тинькофф применит платёж заново.
+func (r *PostgresRepository) ApplyPaidPayment(ctx context.Context, p *domain.Payment, paidAt time.Time) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
 
-func (r *PostgresRepository) GetUserPendingPayment(ctx context.Context, userID int64) (*domain.Payment, error) {
-	query := `
-	  SELECT id, user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, created_at, updated_at, paid_at
-	  FROM payments WHERE user_id = $1 AND status IN ('NEW', 'PENDING') ORDER BY created_at DESC LIMIT 1`
+	tag, err := tx.Exec(ctx, `UPDATE payments SET status=$2, paid_at=$3, updated_at=$4 WHERE order_id=$1 AND paid_at IS NULL`, p.OrderID, domain.PaymentStatusConfirmed, paidAt, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("mark payment paid: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
 
-	p := &domain.Payment{}
-	err := r.db.QueryRow(ctx, query, userID).Scan(&p.ID, &p.UserID, &p.TinkoffID, &p.OrderID, &p.Amount, &p.OriginalAmount, &p.DiscountPercent, &p.Status, &p.PaymentURL, &p.Description, &p.CreatedAt, &p.UpdatedAt, &p.PaidAt)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, ErrNotFound
+	if err := commitPromocodeReservation(ctx, tx, p.OrderID); err != nil {
+		return false, fmt.Errorf("commit promocode: %w", err)
 	}
-	return p, err
-}
 
-// ==================== REFERRALS ====================
+	days := p.Days
+	if days <= 0 {
+		days = domain.SubscriptionDays
+	}
 
-func (r *PostgresRepository) CreateReferral(ctx context.Context, ref *domain.Referral) error {
-	query := `
-	  INSERT INTO referrals (referrer_id, referred_id, referral_code, stage1_applied, stage1_bonus_days, stage2_applied, stage2_bonus_days, gave_discount, created_at, updated_at)
-	  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
-	  ON CONFLICT (referred_id) DO NOTHING RETURNING id`
-	err := r.db.QueryRow(ctx, query, ref.ReferrerID, ref.ReferredID, ref.ReferralCode, ref.Stage1Applied, ref.Stage1BonusDays, ref.Stage2Applied, ref.Stage2BonusDays, ref.GaveDiscount, time.Now()).Scan(&ref.ID)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil
+	switch p.Purpose {
+	case domain.PaymentPurposeFamily:
+		_, err = tx.Exec(ctx, addFamilyPlanDaysQuery, p.UserID, days, time.Now())
+	case domain.PaymentPurposeGift:
+		_, err = tx.Exec(ctx, `UPDATE gifts SET status = 'paid', paid_at = $2 WHERE order_id = $1 AND status = 'pending'`, p.OrderID, paidAt)
+	case domain.PaymentPurposeAd:
+		_, err = tx.Exec(ctx, `UPDATE ads SET status='moderation', updated_at=NOW() WHERE order_id=$1 AND status='pending_payment'`, p.OrderID)
+	default:
+		_, err = tx.Exec(ctx, addSubscriptionDaysQuery, p.UserID, days, time.Now())
 	}
-	return err
+	if err != nil {
+		return false, fmt.Errorf("credit %s payment: %w", p.Purpose, err)
+	}
+	return true, tx.Commit(ctx)
 }
 
-func (r *PostgresRepository) GetReferralByReferredID(ctx context.Context, referredID int64) (*domain.Referral, error) {
+func (r *PostgresRepository) GetUserPendingPayment(ctx context.Context, userID int64) (*domain.Payment, error) {
 	query := `
-	  SELECT id, referrer_id, referred_id, referral_code, stage1_applied, stage1_bonus_days, stage2_applied, stage2_bonus_days, gave_discount, created_at, updated_at
-	  FROM referrals WHERE referred_id = $1`
+	  SELECT id, user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, purpose, days, created_at, updated_at, paid_at
+	  FROM payments WHERE user_id = $1 AND purpose IN ('subscription', 'family') AND status IN ('NEW', 'PENDING') ORDER BY created_at DESC LIMIT 1`
 
-	ref := &domain.Referral{}
-	err := r.db.QueryRow(ctx, query, referredID).Scan(&ref.ID, &ref.ReferrerID, &ref.ReferredID, &ref.ReferralCode, &ref.Stage1Applied, &ref.Stage1BonusDays, &ref.Stage2Applied, &ref.Stage2BonusDays, &ref.GaveDiscount, &ref.CreatedAt, &ref.UpdatedAt)
+	p := &domain.Payment{}
+	err := r.db.QueryRow(ctx, query, userID).Scan(&p.ID, &p.UserID, &p.TinkoffID, &p.OrderID, &p.Amount, &p.OriginalAmount, &p.DiscountPercent, &p.Status, &p.PaymentURL, &p.Description, &p.Purpose, &p.Days, &p.CreatedAt, &p.UpdatedAt, &p.PaidAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrNotFound
 	}
-	return ref, err
+	return p, err
 }
 
-func (r *PostgresRepository) GetReferralsByReferrerID(ctx context.Context, referrerID int64) ([]*domain.Referral, error) {
+// GetUserPayments — последние платежи пользователя (подписки и подарки)
+func (r *PostgresRepository) GetUserPayments(ctx context.Context, userID int64, limit int) ([]*domain.Payment, error) {
 	query := `
-	  SELECT id, referrer_id, referred_id, referral_code, stage1_applied, stage1_bonus_days, stage2_applied, stage2_bonus_days, gave_discount, created_at, updated_at
-	  FROM referrals WHERE referrer_id = $1 ORDER BY created_at DESC`
+	  SELECT id, user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, purpose, days, created_at, updated_at, paid_at
+	  FROM payments WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2`
 
-	rows, err := r.db.Query(ctx, query, referrerID)
+	rows, err := r.db.Query(ctx, query, userID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var refs []*domain.Referral
+	var payments []*domain.Payment
 	for rows.Next() {
-		ref := &domain.Referral{}
-		if err := rows.Scan(&ref.ID, &ref.ReferrerID, &ref.ReferredID, &ref.ReferralCode, &ref.Stage1Applied, &ref.Stage1BonusDays, &ref.Stage2Applied, &ref.Stage2BonusDays, &ref.GaveDiscount, &ref.CreatedAt, &ref.UpdatedAt); err != nil {
+		p := &domain.Payment{}
+		if err := rows.Scan(&p.ID, &p.UserID, &p.TinkoffID, &p.OrderID, &p.Amount, &p.OriginalAmount, &p.DiscountPercent, &p.Status, &p.PaymentURL, &p.Description, &p.Purpose, &p.Days, &p.CreatedAt, &p.UpdatedAt, &p.PaidAt); err != nil {
 			return nil, err
 		}
-		refs = append(refs, ref)
+		payments = append(payments, p)
 	}
-	return refs, nil
+	return payments, nil
 }
 
-func (r *PostgresRepository) GetReferralStats(ctx context.Context, userID int64) (*domain.ReferralStats, error) {
-	stats := &domain.ReferralStats{}
-
+// GetRecentPayments — последние платежи всех пользователей
+func (r *PostgresRepository) GetRecentPayments(ctx context.Context, limit int) ([]*domain.Payment, error) {
 	query := `
-	  SELECT COUNT(*), COUNT(*) FILTER (WHERE gave_discount = false), COUNT(*) FILTER (WHERE gave_discount = true),
-			 COUNT(*) FILTER (WHERE stage1_applied = true), COUNT(*) FILTER (WHERE stage2_applied = true),
-			 COALESCE(SUM(stage1_bonus_days + stage2_bonus_days), 0)
-	  FROM referrals WHERE referrer_id = $1`
+	  SELECT id, user_id, tinkoff_id, order_id, amount, original_amount, discount_percent, status, payment_url, description, purpose, days, created_at, updated_at, paid_at
+	  FROM payments ORDER BY created_at DESC LIMIT $1`
 
-	r.db.QueryRow(ctx, query, userID).Scan(&stats.TotalReferrals, &stats.BonusReferrals, &stats.DiscountReferrals, &stats.Stage1Completed, &stats.Stage2Completed, &stats.TotalBonusDays)
-	user, _ := r.GetUserByID(ctx, userID)
-	if user != nil {
-		stats.AccumulatedDiscount = user.DiscountPercent
+	rows, err := r.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	streak, _ := r.GetUserOverallStreak(ctx, userID)
-	stats.CurrentStreak = streak
-	stats.CanInvite = streak >= domain.ReferralUnlockStreak
-	if !stats.CanInvite {
-		stats.DaysUntilUnlock = domain.ReferralUnlockStreak - streak
+	var payments []*domain.Payment
+	for rows.Next() {
+		p := &domain.Payment{}
+		if err := rows.Scan(&p.ID, &p.UserID, &p.TinkoffID, &p.OrderID, &p.Amount, &p.OriginalAmount, &p.DiscountPercent, &p.Status, &p.PaymentURL, &p.Description, &p.Purpose, &p.Days, &p.CreatedAt, &p.UpdatedAt, &p.PaidAt); err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
 	}
-	return stats, nil
+	return payments, nil
 }
 
-func (r *PostgresRepository) UpdateReferralStage1(ctx context.Context, referralID int64, bonusDays int) error {
-	_, err := r.db.Exec(ctx, `UPDATE referrals SET stage1_applied=true, stage1_bonus_days=$2, updated_at=$3 WHERE id=$1`, referralID, bonusDays, time.Now())
-	return err
+// HasConfirmedPayments — была ли у пользователя хотя бы одна оплаченная подписка
+func (r *PostgresRepository) HasConfirmedPayments(ctx context.Context, userID int64) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM payments WHERE user_id = $1 AND purpose IN ('subscription', 'family') AND status = 'CONFIRMED')`,
+		userID).Scan(&exists)
+	return exists, err
 }
 
-func (r *PostgresRepository) UpdateReferralStage2(ctx context.Context, referralID int64, bonusDays int) error {
-	_, err := r.db.Exec(ctx, `UPDATE referrals SET stage2_applied=true, stage2_bonus_days=$2, updated_at=$3 WHERE id=$1`, referralID, bonusDays, time.Now())
-	return err
+// ==================== FAMILY ====================
+
+func (r *PostgresRepository) CreateFamilyGroup(ctx context.Context, g *domain.FamilyGroup) error {
+	query := `INSERT INTO family_groups (owner_id, invite_code, created_at) VALUES ($1, $2, $3) RETURNING id, created_at`
+	return r.db.QueryRow(ctx, query, g.OwnerID, g.InviteCode, time.Now()).Scan(&g.ID, &g.CreatedAt)
 }
 
-func (r *PostgresRepository) UpdateReferralDiscount(ctx context.Context, referralID int64) error {
-	_, err := r.db.Exec(ctx, `UPDATE referrals SET gave_discount=true, updated_at=$2 WHERE id=$1`, referralID, time.Now())
-	return err
+func (r *PostgresRepository) GetFamilyGroupByOwner(ctx context.Context, ownerID int64) (*domain.FamilyGroup, error) {
+	g := &domain.FamilyGroup{}
+	err := r.db.QueryRow(ctx, `SELECT id, owner_id, invite_code, created_at FROM family_groups WHERE owner_id = $1`, ownerID).
+		Scan(&g.ID, &g.OwnerID, &g.InviteCode, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return g, err
 }
 
-func (r *PostgresRepository) GetPendingStage2Referrals(ctx context.Context, referredID int64) (*domain.Referral, error) {
-	query := `
+func (r *PostgresRepository) GetFamilyGroupByInviteCode(ctx context.Context, code string) (*domain.FamilyGroup, error) {
+	g := &domain.FamilyGroup{}
+	err := r.db.QueryRow(ctx, `SELECT id, owner_id, invite_code, created_at FROM family_groups WHERE invite_code = $1`, code).
+		Scan(&g.ID, &g.OwnerID, &g.InviteCode, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return g, err
+}
+
+// GetFamilyGroupByMember — группа, в которой пользователь состоит участником
+func (r *PostgresRepository) GetFamilyGroupByMember(ctx context.Context, userID int64) (*domain.FamilyGroup, error) {
+	query := `
+	  SELECT fg.id, fg.owner_id, fg.invite_code, fg.created_at
+	  FROM family_groups fg JOIN family_members fm ON fm.group_id = fg.id
+	  WHERE fm.user_id = $1`
+
+	g := &domain.FamilyGroup{}
+	err := r.db.QueryRow(ctx, query, userID).Scan(&g.ID, &g.OwnerID, &g.InviteCode, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return g, err
+}
+
+func (r *PostgresRepository) GetFamilyMembers(ctx context.Context, groupID int64) ([]*domain.User, error) {
+	query := `
+	  SELECT u.id, u.telegram_id, u.username, u.first_name
+	  FROM family_members fm JOIN users u ON u.id = fm.user_id
+	  WHERE fm.group_id = $1 ORDER BY fm.joined_at ASC`
+
+	rows, err := r.db.Query(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		u := &domain.User{}
+		if err := rows.Scan(&u.ID, &u.TelegramID, &u.Username, &u.FirstName); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// AddFamilyMember — добавляет участника, если в группе есть место. false, если мест нет;
+// ErrAlreadyExists, если пользователь уже состоит в группе.
+func (r *PostgresRepository) AddFamilyMember(ctx context.Context, groupID, userID int64, maxMembers int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Блокировка группы: параллельные вступления считают участников по очереди
+	var id int64
+	err = tx.QueryRow(ctx, `SELECT id FROM family_groups WHERE id = $1 FOR UPDATE`, groupID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM family_members WHERE group_id = $1`, groupID).Scan(&count); err != nil {
+		return false, err
+	}
+	if count >= maxMembers {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO family_members (group_id, user_id, joined_at) VALUES ($1, $2, $3)`, groupID, userID, time.Now())
+	if isUniqueViolation(err) {
+		return false, ErrAlreadyExists
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+func (r *PostgresRepository) RemoveFamilyMember(ctx context.Context, groupID, userID int64) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM family_members WHERE group_id = $1 AND user_id = $2`, groupID, userID)
+	return err
+}
+
+func (r *PostgresRepository) UpdateFamilyInviteCode(ctx context.Context, groupID int64, code string) error {
+	_, err := r.db.Exec(ctx, `UPDATE family_groups SET invite_code = $2 WHERE id = $1`, groupID, code)
+	return err
+}
+
+func (r *PostgresRepository) DeleteFamilyGroup(ctx context.Context, groupID int64) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM family_groups WHERE id = $1`, groupID)
+	return err
+}
+
+// ==================== GIFTS ====================
+
+func (r *PostgresRepository) CreateGift(ctx context.Context, g *domain.Gift) error {
+	query := `
+	  INSERT INTO gifts (code, buyer_id, plan_code, days, order_id, status, created_at)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	return r.db.QueryRow(ctx, query, g.Code, g.BuyerID, g.PlanCode, g.Days, g.OrderID, g.Status, time.Now()).Scan(&g.ID, &g.CreatedAt)
+}
+
+func (r *PostgresRepository) GetGiftByID(ctx context.Context, id int64) (*domain.Gift, error) {
+	query := `
+	  SELECT id, code, buyer_id, recipient_id, plan_code, days, order_id, status, created_at, paid_at, redeemed_at
+	  FROM gifts WHERE id = $1`
+
+	g := &domain.Gift{}
+	err := r.db.QueryRow(ctx, query, id).Scan(&g.ID, &g.Code, &g.BuyerID, &g.RecipientID, &g.PlanCode, &g.Days, &g.OrderID, &g.Status, &g.CreatedAt, &g.PaidAt, &g.RedeemedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return g, err
+}
+
+func (r *PostgresRepository) GetGiftByCode(ctx context.Context, code string) (*domain.Gift, error) {
+	query := `
+	  SELECT id, code, buyer_id, recipient_id, plan_code, days, order_id, status, created_at, paid_at, redeemed_at
+	  FROM gifts WHERE code = $1`
+
+	g := &domain.Gift{}
+	err := r.db.QueryRow(ctx, query, code).Scan(&g.ID, &g.Code, &g.BuyerID, &g.RecipientID, &g.PlanCode, &g.Days, &g.OrderID, &g.Status, &g.CreatedAt, &g.PaidAt, &g.RedeemedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return g, err
+}
+
+func (r *PostgresRepository) GetGiftByOrderID(ctx context.Context, orderID string) (*domain.Gift, error) {
+	query := `
+	  SELECT id, code, buyer_id, recipient_id, plan_code, days, order_id, status, created_at, paid_at, redeemed_at
+	  FROM gifts WHERE order_id = $1`
+
+	g := &domain.Gift{}
+	err := r.db.QueryRow(ctx, query, orderID).Scan(&g.ID, &g.Code, &g.BuyerID, &g.RecipientID, &g.PlanCode, &g.Days, &g.OrderID, &g.Status, &g.CreatedAt, &g.PaidAt, &g.RedeemedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return g, err
+}
+
+// GetUserGifts — подарки, которые пользователь купил или получил
+func (r *PostgresRepository) GetUserGifts(ctx context.Context, userID int64) ([]*domain.Gift, error) {
+	query := `
+	  SELECT id, code, buyer_id, recipient_id, plan_code, days, order_id, status, created_at, paid_at, redeemed_at
+	  FROM gifts WHERE (buyer_id = $1 AND status <> 'pending') OR recipient_id = $1
+	  ORDER BY created_at DESC LIMIT 20`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gifts []*domain.Gift
+	for rows.Next() {
+		g := &domain.Gift{}
+		if err := rows.Scan(&g.ID, &g.Code, &g.BuyerID, &g.RecipientID, &g.PlanCode, &g.Days, &g.OrderID, &g.Status, &g.CreatedAt, &g.PaidAt, &g.RedeemedAt); err != nil {
+			return nil, err
+		}
+		gifts = append(gifts, g)
+	}
+	return gifts, nil
+}
+
+// RedeemGift — атомарно активирует подарок и в той же транзакции начисляет получателю его дни.
+// false, если подарок уже активировали.
+func (r *PostgresRepository) RedeemGift(ctx context.Context, giftID, recipientID int64) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	var days int
+	err = tx.QueryRow(ctx,
+		`UPDATE gifts SET status = 'redeemed', recipient_id = $2, redeemed_at = $3 WHERE id = $1 AND status = 'paid' RETURNING days`,
+		giftID, recipientID, now).Scan(&days)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(ctx, addSubscriptionDaysQuery, recipientID, days, now); err != nil {
+		return false, fmt.Errorf("add subscription: %w", err)
+	}
+	return true, tx.Commit(ctx)
+}
+
+// ==================== REFERRALS ====================
+
+func (r *PostgresRepository) CreateReferral(ctx context.Context, ref *domain.Referral) error {
+	query := `
+	  INSERT INTO referrals (referrer_id, referred_id, referral_code, stage1_applied, stage1_bonus_days, stage2_applied, stage2_bonus_days, gave_discount, created_at, updated_at)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+	  ON CONFLICT (referred_id) DO NOTHING RETURNING id`
+	err := r.db.QueryRow(ctx, query, ref.ReferrerID, ref.ReferredID, ref.ReferralCode, ref.Stage1Applied, ref.Stage1BonusDays, ref.Stage2Applied, ref.Stage2BonusDays, ref.GaveDiscount, time.Now()).Scan(&ref.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+func (r *PostgresRepository) GetReferralByReferredID(ctx context.Context, referredID int64) (*domain.Referral, error) {
+	query := `
+	  SELECT id, referrer_id, referred_id, referral_code, stage1_applied, stage1_bonus_days, stage2_applied, stage2_bonus_days, gave_discount, created_at, updated_at
+	  FROM referrals WHERE referred_id = $1`
+
+	ref := &domain.Referral{}
+	err := r.db.QueryRow(ctx, query, referredID).Scan(&ref.ID, &ref.ReferrerID, &ref.ReferredID, &ref.ReferralCode, &ref.Stage1Applied, &ref.Stage1BonusDays, &ref.Stage2Applied, &ref.Stage2BonusDays, &ref.GaveDiscount, &ref.CreatedAt, &ref.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return ref, err
+}
+
+func (r *PostgresRepository) GetReferralsByReferrerID(ctx context.Context, referrerID int64) ([]*domain.Referral, error) {
+	query := `
+	  SELECT id, referrer_id, referred_id, referral_code, stage1_applied, stage1_bonus_days, stage2_applied, stage2_bonus_days, gave_discount, created_at, updated_at
+	  FROM referrals WHERE referrer_id = $1 ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, referrerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []*domain.Referral
+	for rows.Next() {
+		ref := &domain.Referral{}
+		if err := rows.Scan(&ref.ID, &ref.ReferrerID, &ref.ReferredID, &ref.ReferralCode, &ref.Stage1Applied, &ref.Stage1BonusDays, &ref.Stage2Applied, &ref.Stage2BonusDays, &ref.GaveDiscount, &ref.CreatedAt, &ref.UpdatedAt); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func (r *PostgresRepository) GetReferralStats(ctx context.Context, userID int64) (*domain.ReferralStats, error) {
+	stats := &domain.ReferralStats{}
+
+	query := `
+	  SELECT COUNT(*), COUNT(*) FILTER (WHERE gave_discount = false), COUNT(*) FILTER (WHERE gave_discount = true),
+			 COUNT(*) FILTER (WHERE stage1_applied = true), COUNT(*) FILTER (WHERE stage2_applied = true),
+			 COALESCE(SUM(stage1_bonus_days + stage2_bonus_days), 0)
+	  FROM referrals WHERE referrer_id = $1`
+
+	r.db.QueryRow(ctx, query, userID).Scan(&stats.TotalReferrals, &stats.BonusReferrals, &stats.DiscountReferrals, &stats.Stage1Completed, &stats.Stage2Completed, &stats.TotalBonusDays)
+	user, _ := r.GetUserByID(ctx, userID)
+	if user != nil {
+		stats.AccumulatedDiscount = user.DiscountPercent
+	}
+
+	streak, _ := r.GetUserOverallStreak(ctx, userID)
+	stats.CurrentStreak = streak
+	stats.CanInvite = streak >= domain.ReferralUnlockStreak
+	if !stats.CanInvite {
+		stats.DaysUntilUnlock = domain.ReferralUnlockStreak - streak
+	}
+	return stats, nil
+}
+
+func (r *PostgresRepository) UpdateReferralStage1(ctx context.Context, referralID int64, bonusDays int) error {
+	_, err := r.db.Exec(ctx, `UPDATE referrals SET stage1_applied=true, stage1_bonus_days=$2, updated_at=$3 WHERE id=$1`, referralID, bonusDays, time.Now())
+	return err
+}
+
+func (r *PostgresRepository) UpdateReferralStage2(ctx context.Context, referralID int64, bonusDays int) error {
+	_, err := r.db.Exec(ctx, `UPDATE referrals SET stage2_applied=true, stage2_bonus_days=$2, updated_at=$3 WHERE id=$1`, referralID, bonusDays, time.Now())
+	return err
+}
+
+func (r *PostgresRepository) UpdateReferralDiscount(ctx context.Context, referralID int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE referrals SET gave_discount=true, updated_at=$2 WHERE id=$1`, referralID, time.Now())
+	return err
+}
+
+func (r *PostgresRepository) GetPendingStage2Referrals(ctx context.Context, referredID int64) (*domain.Referral, error) {
+	query := `
 	  SELECT id, referrer_id, referred_id, referral_code, stage1_applied, stage1_bonus_days, stage2_applied, stage2_bonus_days, gave_discount, created_at, updated_at
 	  FROM referrals WHERE referred_id = $1 AND stage1_applied = true AND stage2_applied = false AND gave_discount = false`
 
@@ -673,13 +1285,16 @@ func (r *PostgresRepository) HasAchievement(ctx context.Context, userID int64, t
 
 // ==================== ADS ====================
 func (r *PostgresRepository) CreateAd(ctx context.Context, ad *domain.Ad) error {
-	query := `INSERT INTO ads (name, text, image_url, button_text, button_url, is_active, priority, start_date, end_date, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$10) RETURNING id`
-	return r.db.QueryRow(ctx, query, ad.Name, ad.Text, ad.ImageURL, ad.ButtonText, ad.ButtonURL, ad.IsActive, ad.Priority, ad.StartDate, ad.EndDate, time.Now()).Scan(&ad.ID)
+	if ad.Status == "" {
+		ad.Status = domain.AdStatusActive
+	}
+	query := `INSERT INTO ads (name, text, image_url, button_text, button_url, is_active, priority, start_date, end_date, created_at, updated_at, audience, max_per_user, media_type, media_file_id, advertiser_id, status, impressions_limit, order_id) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$10,$11,$12,$13,$14,$15,$16,$17,$18) RETURNING id`
+	return r.db.QueryRow(ctx, query, ad.Name, ad.Text, ad.ImageURL, ad.ButtonText, ad.ButtonURL, ad.IsActive, ad.Priority, ad.StartDate, ad.EndDate, time.Now(), ad.Audience, ad.MaxPerUser, ad.MediaType, ad.MediaFileID, ad.AdvertiserID, ad.Status, ad.ImpressionsLimit, ad.OrderID).Scan(&ad.ID)
 }
 
 func (r *PostgresRepository) GetActiveAds(ctx context.Context) ([]*domain.Ad, error) {
-	query := `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at
-	  FROM ads WHERE is_active = true AND (start_date IS NULL OR start_date <= NOW()) AND (end_date IS NULL OR end_date >= NOW()) ORDER BY priority DESC`
+	query := `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at, audience, max_per_user, media_type, media_file_id, advertiser_id, status, impressions_limit, order_id, reject_reason
+	  FROM ads WHERE is_active = true AND status = 'active' AND (impressions_limit = 0 OR views_count < impressions_limit) AND (start_date IS NULL OR start_date <= NOW()) AND (end_date IS NULL OR end_date >= NOW()) ORDER BY priority DESC`
 
 	rows, err := r.db.Query(ctx, query)
 	if err != nil {
@@ -690,137 +1305,694 @@ func (r *PostgresRepository) GetActiveAds(ctx context.Context) ([]*domain.Ad, er
 	var ads []*domain.Ad
 	for rows.Next() {
 		a := &domain.Ad{}
-		if err := rows.Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt); err != nil {
+		if err := rows.Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt, &a.Audience, &a.MaxPerUser, &a.MediaType, &a.MediaFileID, &a.AdvertiserID, &a.Status, &a.ImpressionsLimit, &a.OrderID, &a.RejectReason); err != nil {
+			return nil, err
+		}
+		ads = append(ads, a)
+	}
+	return ads, nil
+}
+
+func (r *PostgresRepository) GetAdByID(ctx context.Context, id int64) (*domain.Ad, error) {
+	query := `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at, audience, max_per_user, media_type, media_file_id, advertiser_id, status, impressions_limit, order_id, reject_reason FROM ads WHERE id = $1`
+	a := &domain.Ad{}
+	err := r.db.QueryRow(ctx, query, id).Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt, &a.Audience, &a.MaxPerUser, &a.MediaType, &a.MediaFileID, &a.AdvertiserID, &a.Status, &a.ImpressionsLimit, &a.OrderID, &a.RejectReason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return a, err
+}
+
+func (r *PostgresRepository) GetAllAds(ctx context.Context) ([]*domain.Ad, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at, audience, max_per_user, media_type, media_file_id, advertiser_id, status, impressions_limit, order_id, reject_reason FROM ads ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ads []*domain.Ad
+	for rows.Next() {
+		a := &domain.Ad{}
+		if err := rows.Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt, &a.Audience, &a.MaxPerUser, &a.MediaType, &a.MediaFileID, &a.AdvertiserID, &a.Status, &a.ImpressionsLimit, &a.OrderID, &a.RejectReason); err != nil {
+			return nil, err
+		}
+		ads = append(ads, a)
+	}
+	return ads, nil
+}
+
+func (r *PostgresRepository) UpdateAd(ctx context.Context, ad *domain.Ad) error {
+	query := `UPDATE ads SET name=$2, text=$3, image_url=$4, button_text=$5, button_url=$6, is_active=$7, priority=$8, start_date=$9, end_date=$10, updated_at=$11, audience=$12, max_per_user=$13, media_type=$14, media_file_id=$15 WHERE id=$1`
+	_, err := r.db.Exec(ctx, query, ad.ID, ad.Name, ad.Text, ad.ImageURL, ad.ButtonText, ad.ButtonURL, ad.IsActive, ad.Priority, ad.StartDate, ad.EndDate, time.Now(), ad.Audience, ad.MaxPerUser, ad.MediaType, ad.MediaFileID)
+	return err
+}
+
+func (r *PostgresRepository) DeleteAd(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM ads WHERE id = $1`, id)
+	return err
+}
+
+// SetAdOrderID — привязывает объявление рекламодателя к созданному платежу
+func (r *PostgresRepository) SetAdOrderID(ctx context.Context, id int64, orderID string) error {
+	_, err := r.db.Exec(ctx, `UPDATE ads SET order_id=$2, updated_at=NOW() WHERE id=$1 AND status='pending_payment'`, id, orderID)
+	return err
+}
+
+// IncrementAdViews — засчитывает показ, пока оплаченные показы не исчерпаны.
+// true, если оплаченных показов больше не осталось.
+func (r *PostgresRepository) IncrementAdViews(ctx context.Context, adID int64) (bool, error) {
+	var exhausted bool
+	err := r.db.QueryRow(ctx, `
+	  WITH counted AS (
+	    UPDATE ads SET views_count = views_count + 1
+	    WHERE id = $1 AND (impressions_limit = 0 OR views_count < impressions_limit)
+	    RETURNING impressions_limit > 0 AND views_count >= impressions_limit AS exhausted
+	  ), daily AS (
+	    INSERT INTO ad_daily_stats (ad_id, date, views) SELECT $1, CURRENT_DATE, 1 FROM counted
+	    ON CONFLICT (ad_id, date) DO UPDATE SET views = ad_daily_stats.views + 1
+	  )
+	  SELECT exhausted FROM counted
+	`, adID).Scan(&exhausted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return exhausted, err
+}
+
+func (r *PostgresRepository) IncrementAdClicks(ctx context.Context, adID int64) error {
+	_, err := r.db.Exec(ctx, `
+	  WITH daily AS (
+	    INSERT INTO ad_daily_stats (ad_id, date, clicks) VALUES ($1, CURRENT_DATE, 1)
+	    ON CONFLICT (ad_id, date) DO UPDATE SET clicks = ad_daily_stats.clicks + 1
+	  )
+	  UPDATE ads SET clicks_count = clicks_count + 1 WHERE id = $1
+	`, adID)
+	return err
+}
+
+// GetAdDailyStats — показы и клики рекламы за последние days дней, новые сверху
+func (r *PostgresRepository) GetAdDailyStats(ctx context.Context, adID int64, days int) ([]domain.AdDailyStat, error) {
+	rows, err := r.db.Query(ctx, `
+	  SELECT date, views, clicks FROM ad_daily_stats
+	  WHERE ad_id = $1 AND date > CURRENT_DATE - $2::int
+	  ORDER BY date DESC
+	`, adID, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []domain.AdDailyStat
+	for rows.Next() {
+		var st domain.AdDailyStat
+		if err := rows.Scan(&st.Date, &st.Views, &st.Clicks); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+func (r *PostgresRepository) GetAdByOrderID(ctx context.Context, orderID string) (*domain.Ad, error) {
+	query := `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at, audience, max_per_user, media_type, media_file_id, advertiser_id, status, impressions_limit, order_id, reject_reason FROM ads WHERE order_id = $1`
+	a := &domain.Ad{}
+	err := r.db.QueryRow(ctx, query, orderID).Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt, &a.Audience, &a.MaxPerUser, &a.MediaType, &a.MediaFileID, &a.AdvertiserID, &a.Status, &a.ImpressionsLimit, &a.OrderID, &a.RejectReason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return a, err
+}
+
+// GetAdsByStatus — реклама на одном этапе, старые сверху (очередь модерации)
+func (r *PostgresRepository) GetAdsByStatus(ctx context.Context, status domain.AdStatus) ([]*domain.Ad, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at, audience, max_per_user, media_type, media_file_id, advertiser_id, status, impressions_limit, order_id, reject_reason FROM ads WHERE status = $1 ORDER BY created_at`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ads []*domain.Ad
+	for rows.Next() {
+		a := &domain.Ad{}
+		if err := rows.Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt, &a.Audience, &a.MaxPerUser, &a.MediaType, &a.MediaFileID, &a.AdvertiserID, &a.Status, &a.ImpressionsLimit, &a.OrderID, &a.RejectReason); err != nil {
+			return nil, err
+		}
+		ads = append(ads, a)
+	}
+	return ads, rows.Err()
+}
+
+// GetAdvertiserAds — оплаченная реклама пользователя, новые сверху
+func (r *PostgresRepository) GetAdvertiserAds(ctx context.Context, userID int64) ([]*domain.Ad, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at, audience, max_per_user, media_type, media_file_id, advertiser_id, status, impressions_limit, order_id, reject_reason FROM ads WHERE advertiser_id = $1 AND status <> 'pending_payment' ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ads []*domain.Ad
+	for rows.Next() {
+		a := &domain.Ad{}
+		if err := rows.Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt, &a.Audience, &a.MaxPerUser, &a.MediaType, &a.MediaFileID, &a.AdvertiserID, &a.Status, &a.ImpressionsLimit, &a.OrderID, &a.RejectReason); err != nil {
+			return nil, err
+		}
+		ads = append(ads, a)
+	}
+	return ads, rows.Err()
+}
+
+// SetAdStatus — переводит рекламу из статуса from в to; показывается только active.
+// false, если реклама уже в другом статусе.
+func (r *PostgresRepository) SetAdStatus(ctx context.Context, id int64, from, to domain.AdStatus, reason string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE ads SET status=$3, is_active=($3='active'), reject_reason=$4, updated_at=NOW() WHERE id=$1 AND status=$2`, id, from, to, reason)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetAdViewer — всё, что нужно для выбора рекламы пользователю: дата регистрации,
+// последняя отметка, эмодзи привычек и его показы по каждой рекламе
+func (r *PostgresRepository) GetAdViewer(ctx context.Context, userID int64) (*domain.AdViewer, error) {
+	v := &domain.AdViewer{UserID: userID, Impressions: make(map[int64]domain.AdImpression)}
+	err := r.db.QueryRow(ctx, `
+	  SELECT u.created_at,
+	    (SELECT MAX(l.date) FROM habit_logs l WHERE l.user_id = u.id AND l.completed = true),
+	    ARRAY(SELECT DISTINCT h.emoji FROM habits h WHERE h.user_id = u.id AND h.is_active = true AND h.emoji <> '')
+	  FROM users u WHERE u.id = $1
+	`, userID).Scan(&v.RegisteredAt, &v.LastCompletedAt, &v.Emojis)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(ctx, `SELECT ad_id, views, clicked_at IS NOT NULL FROM ad_impressions WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var adID int64
+		var imp domain.AdImpression
+		if err := rows.Scan(&adID, &imp.Views, &imp.Clicked); err != nil {
+			return nil, err
+		}
+		v.Impressions[adID] = imp
+	}
+	return v, rows.Err()
+}
+
+// RecordAdView — засчитывает показ рекламы пользователю
+func (r *PostgresRepository) RecordAdView(ctx context.Context, adID, userID int64) error {
+	_, err := r.db.Exec(ctx, `
+	  INSERT INTO ad_impressions (ad_id, user_id, views, last_view_at) VALUES ($1, $2, 1, NOW())
+	  ON CONFLICT (ad_id, user_id) DO UPDATE SET views = ad_impressions.views + 1, last_view_at = NOW()
+	`, adID, userID)
+	return err
+}
+
+// RecordAdClick — отмечает, что пользователь кликнул по рекламе. false — клик уже был.
+func (r *PostgresRepository) RecordAdClick(ctx context.Context, adID, userID int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+	  INSERT INTO ad_impressions (ad_id, user_id, clicked_at) VALUES ($1, $2, NOW())
+	  ON CONFLICT (ad_id, user_id) DO UPDATE SET clicked_at = NOW() WHERE ad_impressions.clicked_at IS NULL
+	`, adID, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ==================== BROADCASTS ====================
+
+func (r *PostgresRepository) CreateBroadcast(ctx context.Context, b *domain.Broadcast) error {
+	query := `INSERT INTO broadcasts (name, text, image_url, button_text, button_url, status, segment, media_type, media_file_id, buttons, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,COALESCE($10::jsonb, '[]'),$11) RETURNING id`
+	return r.db.QueryRow(ctx, query, b.Name, b.Text, b.ImageURL, b.ButtonText, b.ButtonURL, b.Status, b.Segment, b.MediaType, b.MediaFileID, b.Buttons, time.Now()).Scan(&b.ID)
+}
+
+// UpdateBroadcastContent — меняет название, текст, вложение и кнопки черновика.
+// false, если рассылка уже не черновик.
+func (r *PostgresRepository) UpdateBroadcastContent(ctx context.Context, b *domain.Broadcast) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE broadcasts SET name=$2, text=$3, image_url=$4, button_text=$5, button_url=$6,
+            media_type=$7, media_file_id=$8, buttons=COALESCE($9::jsonb, '[]')
+        WHERE id=$1 AND status='draft'`,
+		b.ID, b.Name, b.Text, b.ImageURL, b.ButtonText, b.ButtonURL, b.MediaType, b.MediaFileID, b.Buttons)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+func (r *PostgresRepository) GetBroadcastByID(ctx context.Context, id int64) (*domain.Broadcast, error) {
+	query := `SELECT id, name, text, image_url, button_text, button_url, status, total_users, sent_count, failed_count, last_user_id, segment, created_at, started_at, completed_at, scheduled_at, per_user_local, wave_timezones, done_timezones, media_type, media_file_id, buttons FROM broadcasts WHERE id = $1`
+	b := &domain.Broadcast{}
+	err := r.db.QueryRow(ctx, query, id).Scan(&b.ID, &b.Name, &b.Text, &b.ImageURL, &b.ButtonText, &b.ButtonURL, &b.Status, &b.TotalUsers, &b.SentCount, &b.FailedCount, &b.LastUserID, &b.Segment, &b.CreatedAt, &b.StartedAt, &b.CompletedAt, &b.ScheduledAt, &b.PerUserLocal, &b.WaveTimezones, &b.DoneTimezones, &b.MediaType, &b.MediaFileID, &b.Buttons)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.Variants, err = r.getBroadcastVariants(ctx, id)
+	return b, err
+}
+
+func (r *PostgresRepository) getBroadcastVariants(ctx context.Context, broadcastID int64) ([]domain.BroadcastVariant, error) {
+	rows, err := r.db.Query(ctx, `SELECT label, text, image_url, button_text, button_url, media_type, media_file_id, buttons FROM broadcast_variants WHERE broadcast_id = $1 ORDER BY label`, broadcastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []domain.BroadcastVariant
+	for rows.Next() {
+		var v domain.BroadcastVariant
+		if err := rows.Scan(&v.Label, &v.Text, &v.ImageURL, &v.ButtonText, &v.ButtonURL, &v.MediaType, &v.MediaFileID, &v.Buttons); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// AddBroadcastVariant — добавляет вариант к черновику. false, если рассылка уже не черновик.
+func (r *PostgresRepository) AddBroadcastVariant(ctx context.Context, broadcastID int64, v *domain.BroadcastVariant) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        INSERT INTO broadcast_variants (broadcast_id, label, text, image_url, button_text, button_url, media_type, media_file_id, buttons, created_at)
+        SELECT id, $2, $3, $4, $5, $6, $7, $8, COALESCE($9::jsonb, '[]'), $10 FROM broadcasts WHERE id = $1 AND status = 'draft'`,
+		broadcastID, v.Label, v.Text, v.ImageURL, v.ButtonText, v.ButtonURL, v.MediaType, v.MediaFileID, v.Buttons, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteBroadcastVariant — удаляет вариант черновика
+func (r *PostgresRepository) DeleteBroadcastVariant(ctx context.Context, broadcastID int64, label string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        DELETE FROM broadcast_variants
+        WHERE broadcast_id = $1 AND label = $2
+          AND EXISTS (SELECT 1 FROM broadcasts WHERE id = $1 AND status = 'draft')`, broadcastID, label)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordBroadcastClick — клик по кнопке рассылки; повторные клики пользователя суммируются
+func (r *PostgresRepository) RecordBroadcastClick(ctx context.Context, broadcastID int64, variant string, userID int64) error {
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO broadcast_clicks (broadcast_id, variant, user_id, first_clicked_at, last_clicked_at)
+        VALUES ($1, $2, $3, $4, $4)
+        ON CONFLICT (broadcast_id, user_id) DO UPDATE SET
+            clicks = broadcast_clicks.clicks + 1,
+            last_clicked_at = EXCLUDED.last_clicked_at`,
+		broadcastID, variant, userID, time.Now())
+	return err
+}
+
+// GetBroadcastResults — доставки и клики по вариантам рассылки
+func (r *PostgresRepository) GetBroadcastResults(ctx context.Context, broadcastID int64) ([]domain.VariantResult, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT v.label,
+            (SELECT COUNT(*) FROM broadcast_deliveries d WHERE d.broadcast_id = $1 AND d.variant = v.label AND d.status = 'sent'),
+            (SELECT COUNT(*) FROM broadcast_clicks c WHERE c.broadcast_id = $1 AND c.variant = v.label),
+            (SELECT COALESCE(SUM(c.clicks), 0) FROM broadcast_clicks c WHERE c.broadcast_id = $1 AND c.variant = v.label)
+        FROM (SELECT $2::varchar AS label UNION SELECT label FROM broadcast_variants WHERE broadcast_id = $1) v
+        ORDER BY v.label`, broadcastID, domain.BaseVariantLabel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []domain.VariantResult
+	for rows.Next() {
+		var res domain.VariantResult
+		if err := rows.Scan(&res.Label, &res.Delivered, &res.Clickers, &res.Clicks); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (r *PostgresRepository) GetAllBroadcasts(ctx context.Context) ([]*domain.Broadcast, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, name, text, image_url, button_text, button_url, status, total_users, sent_count, failed_count, last_user_id, segment, created_at, started_at, completed_at, scheduled_at, per_user_local, wave_timezones, done_timezones, media_type, media_file_id, buttons FROM broadcasts ORDER BY created_at DESC LIMIT 20`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var broadcasts []*domain.Broadcast
+	for rows.Next() {
+		b := &domain.Broadcast{}
+		if err := rows.Scan(&b.ID, &b.Name, &b.Text, &b.ImageURL, &b.ButtonText, &b.ButtonURL, &b.Status, &b.TotalUsers, &b.SentCount, &b.FailedCount, &b.LastUserID, &b.Segment, &b.CreatedAt, &b.StartedAt, &b.CompletedAt, &b.ScheduledAt, &b.PerUserLocal, &b.WaveTimezones, &b.DoneTimezones, &b.MediaType, &b.MediaFileID, &b.Buttons); err != nil {
+			return nil, err
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	return broadcasts, nil
+}
+
+func (r *PostgresRepository) GetRunningBroadcast(ctx context.Context) (*domain.Broadcast, error) {
+	b := &domain.Broadcast{}
+	err := r.db.QueryRow(ctx, `SELECT id, name, text, image_url, button_text, button_url, status, total_users, sent_count, failed_count, last_user_id, segment, created_at, started_at, completed_at, scheduled_at, per_user_local, wave_timezones, done_timezones, media_type, media_file_id, buttons FROM broadcasts WHERE status = 'running' LIMIT 1`).Scan(&b.ID, &b.Name, &b.Text, &b.ImageURL, &b.ButtonText, &b.ButtonURL, &b.Status, &b.TotalUsers, &b.SentCount, &b.FailedCount, &b.LastUserID, &b.Segment, &b.CreatedAt, &b.StartedAt, &b.CompletedAt, &b.ScheduledAt, &b.PerUserLocal, &b.WaveTimezones, &b.DoneTimezones, &b.MediaType, &b.MediaFileID, &b.Buttons)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+func (r *PostgresRepository) UpdateBroadcastStatus(ctx context.Context, id int64, status domain.BroadcastStatus) error {
+	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET status=$2 WHERE id=$1`, id, status)
+	return err
+}
+
+func (r *PostgresRepository) UpdateBroadcastProgress(ctx context.Context, id int64, sent, failed int, lastUserID int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET sent_count=$2, failed_count=$3, last_user_id=$4 WHERE id=$1`, id, sent, failed, lastUserID)
+	return err
+}
+
+// launchableBroadcast — условие на статус рассылки, которую можно запустить (BroadcastStatus.CanLaunch)
+const launchableBroadcast = `status IN ('draft', 'scheduled', 'running', 'paused')`
+
+// StartBroadcast — переводит рассылку в running. false, если её уже нельзя запустить.
+func (r *PostgresRepository) StartBroadcast(ctx context.Context, id int64, totalUsers int) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE broadcasts SET status='running', total_users=$2, started_at=$3 WHERE id=$1 AND `+launchableBroadcast, id, totalUsers, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ResumeBroadcastWave — продолжает начатую волну локальной рассылки. false, если её уже нельзя запустить.
+func (r *PostgresRepository) ResumeBroadcastWave(ctx context.Context, id int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE broadcasts SET status='running' WHERE id=$1 AND `+launchableBroadcast, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UpdateBroadcastSegment — меняет аудиторию черновика. false, если рассылка уже запускалась.
+func (r *PostgresRepository) UpdateBroadcastSegment(ctx context.Context, id int64, segment domain.BroadcastSegment) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE broadcasts SET segment=$2 WHERE id=$1 AND status='draft'`, id, segment)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PostgresRepository) CompleteBroadcast(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET status='completed', completed_at=$2 WHERE id=$1`, id, time.Now())
+	return err
+}
+
+// ScheduleBroadcast — планирует черновик или переносит запланированную рассылку.
+// false, если рассылка уже запускалась (в том числе частично отправленная локальная).
+func (r *PostgresRepository) ScheduleBroadcast(ctx context.Context, id int64, at time.Time, perUserLocal bool) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE broadcasts SET status='scheduled', scheduled_at=$2, per_user_local=$3
+        WHERE id=$1 AND status IN ('draft', 'scheduled') AND cardinality(done_timezones) = 0`,
+		id, at, perUserLocal)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CancelBroadcastSchedule — снимает рассылку с расписания и возвращает в черновики.
+// Локальная рассылка, часть поясов которой уже получила сообщение, завершается.
+func (r *PostgresRepository) CancelBroadcastSchedule(ctx context.Context, id int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE broadcasts SET
+            status = CASE WHEN cardinality(done_timezones) > 0 THEN 'completed' ELSE 'draft' END,
+            completed_at = CASE WHEN cardinality(done_timezones) > 0 THEN $2::timestamptz END,
+            scheduled_at = CASE WHEN cardinality(done_timezones) > 0 THEN scheduled_at END,
+            per_user_local = cardinality(done_timezones) > 0 AND per_user_local
+        WHERE id=$1 AND status='scheduled'`, id, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetScheduledBroadcasts — запланированные рассылки со временем запуска не позже before
+func (r *PostgresRepository) GetScheduledBroadcasts(ctx context.Context, before time.Time) ([]*domain.Broadcast, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, name, text, image_url, button_text, button_url, status, total_users, sent_count, failed_count, last_user_id, segment, created_at, started_at, completed_at, scheduled_at, per_user_local, wave_timezones, done_timezones, media_type, media_file_id, buttons FROM broadcasts WHERE status = 'scheduled' AND scheduled_at <= $1 ORDER BY scheduled_at`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var broadcasts []*domain.Broadcast
+	for rows.Next() {
+		b := &domain.Broadcast{}
+		if err := rows.Scan(&b.ID, &b.Name, &b.Text, &b.ImageURL, &b.ButtonText, &b.ButtonURL, &b.Status, &b.TotalUsers, &b.SentCount, &b.FailedCount, &b.LastUserID, &b.Segment, &b.CreatedAt, &b.StartedAt, &b.CompletedAt, &b.ScheduledAt, &b.PerUserLocal, &b.WaveTimezones, &b.DoneTimezones, &b.MediaType, &b.MediaFileID, &b.Buttons); err != nil {
+			return nil, err
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	return broadcasts, nil
+}
+
+// StartBroadcastWave — запускает волну локальной рассылки по поясам zones с начала списка пользователей.
+// false, если рассылку уже нельзя запустить.
+func (r *PostgresRepository) StartBroadcastWave(ctx context.Context, id int64, zones []string, totalUsers int) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE broadcasts SET status='running', total_users=$3, wave_timezones=$2::text[],
+            done_timezones = done_timezones || $2::text[], last_user_id=0, started_at=COALESCE(started_at, $4)
+        WHERE id=$1 AND `+launchableBroadcast, id, zones, totalUsers, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordBroadcastDelivery — результат отправки получателю; повторная попытка обновляет запись
+func (r *PostgresRepository) RecordBroadcastDelivery(ctx context.Context, broadcastID, userID int64, variant string, status domain.DeliveryStatus, errText string) error {
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO broadcast_deliveries (broadcast_id, user_id, variant, status, error, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $6)
+        ON CONFLICT (broadcast_id, user_id) DO UPDATE SET
+            variant = EXCLUDED.variant,
+            status = EXCLUDED.status,
+            error = EXCLUDED.error,
+            attempts = broadcast_deliveries.attempts + 1,
+            updated_at = EXCLUDED.updated_at`,
+		broadcastID, userID, variant, status, errText, time.Now())
+	return err
+}
+
+// GetFailedDeliveries — получатели с ошибкой доставки, которым ещё можно отправить
+func (r *PostgresRepository) GetFailedDeliveries(ctx context.Context, broadcastID, lastUserID int64, limit int) ([]domain.BroadcastRecipient, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT u.id, u.telegram_id FROM broadcast_deliveries d
+        JOIN users u ON u.id = d.user_id
+        WHERE d.broadcast_id = $1 AND d.status = 'failed' AND u.id > $2
+          AND u.subscribed_to_broadcasts = true AND u.is_banned = false AND u.is_blocked = false
+        ORDER BY u.id ASC LIMIT $3`, broadcastID, lastUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []domain.BroadcastRecipient
+	for rows.Next() {
+		var rc domain.BroadcastRecipient
+		if err := rows.Scan(&rc.UserID, &rc.TelegramID); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rc)
+	}
+	return recipients, nil
+}
+
+// GetBroadcastDeliveryStats — число получателей по статусам доставки
+func (r *PostgresRepository) GetBroadcastDeliveryStats(ctx context.Context, broadcastID int64) (map[domain.DeliveryStatus]int, error) {
+	rows, err := r.db.Query(ctx, `SELECT status, COUNT(*) FROM broadcast_deliveries WHERE broadcast_id = $1 GROUP BY status`, broadcastID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[domain.DeliveryStatus]int)
+	for rows.Next() {
+		var status domain.DeliveryStatus
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
 			return nil, err
 		}
-		ads = append(ads, a)
-	}
-	return ads, nil
-}
-
-func (r *PostgresRepository) GetAdByID(ctx context.Context, id int64) (*domain.Ad, error) {
-	query := `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at FROM ads WHERE id = $1`
-	a := &domain.Ad{}
-	err := r.db.QueryRow(ctx, query, id).Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, ErrNotFound
+		stats[status] = count
 	}
-	return a, err
+	return stats, nil
 }
 
-func (r *PostgresRepository) GetAllAds(ctx context.Context) ([]*domain.Ad, error) {
-	rows, err := r.db.Query(ctx, `SELECT id, name, text, image_url, button_text, button_url, is_active, priority, views_count, clicks_count, start_date, end_date, created_at FROM ads ORDER BY created_at DESC`)
+// GetBroadcastFailures — последние неудачные доставки с ошибками
+func (r *PostgresRepository) GetBroadcastFailures(ctx context.Context, broadcastID int64, limit int) ([]*domain.BroadcastDelivery, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT d.id, d.broadcast_id, d.user_id, u.telegram_id, COALESCE(u.username, ''), d.status, d.error, d.attempts, d.updated_at
+        FROM broadcast_deliveries d JOIN users u ON u.id = d.user_id
+        WHERE d.broadcast_id = $1 AND d.status <> 'sent'
+        ORDER BY d.updated_at DESC LIMIT $2`, broadcastID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var ads []*domain.Ad
+	var deliveries []*domain.BroadcastDelivery
 	for rows.Next() {
-		a := &domain.Ad{}
-		if err := rows.Scan(&a.ID, &a.Name, &a.Text, &a.ImageURL, &a.ButtonText, &a.ButtonURL, &a.IsActive, &a.Priority, &a.ViewsCount, &a.ClicksCount, &a.StartDate, &a.EndDate, &a.CreatedAt); err != nil {
+		d := &domain.BroadcastDelivery{}
+		if err := rows.Scan(&d.ID, &d.BroadcastID, &d.UserID, &d.TelegramID, &d.Username, &d.Status, &d.Error, &d.Attempts, &d.UpdatedAt); err != nil {
 			return nil, err
 		}
-		ads = append(ads, a)
+		deliveries = append(deliveries, d)
 	}
-	return ads, nil
+	return deliveries, nil
 }
 
-func (r *PostgresRepository) UpdateAd(ctx context.Context, ad *domain.Ad) error {
-	query := `UPDATE ads SET name=$2, text=$3, image_url=$4, button_text=$5, button_url=$6, is_active=$7, priority=$8, start_date=$9, end_date=$10, updated_at=$11 WHERE id=$1`
-	_, err := r.db.Exec(ctx, query, ad.ID, ad.Name, ad.Text, ad.ImageURL, ad.ButtonText, ad.ButtonURL, ad.IsActive, ad.Priority, ad.StartDate, ad.EndDate, time.Now())
+// AddBroadcastRetried — повторная отправка дошла до delivered получателей из числа неудачных
+func (r *PostgresRepository) AddBroadcastRetried(ctx context.Context, id int64, delivered int) error {
+	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET sent_count = sent_count + $2, failed_count = GREATEST(failed_count - $2, 0) WHERE id=$1`, id, delivered)
 	return err
 }
 
-func (r *PostgresRepository) DeleteAd(ctx context.Context, id int64) error {
-	_, err := r.db.Exec(ctx, `DELETE FROM ads WHERE id = $1`, id)
-	return err
+// AcquireBroadcastLease — берёт или продлевает аренду рассылки. false, если её держит
+// другой экземпляр и аренда ещё не истекла.
+func (r *PostgresRepository) AcquireBroadcastLease(ctx context.Context, id int64, owner string, ttl time.Duration) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE broadcasts SET lease_owner=$2, lease_until=$3
+        WHERE id=$1 AND (lease_owner IS NULL OR lease_owner=$2 OR lease_until < $4)`,
+		id, owner, time.Now().Add(ttl), time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
 }
 
-func (r *PostgresRepository) IncrementAdViews(ctx context.Context, adID int64) error {
-	_, err := r.db.Exec(ctx, `UPDATE ads SET views_count = views_count + 1 WHERE id = $1`, adID)
+func (r *PostgresRepository) ReleaseBroadcastLease(ctx context.Context, id int64, owner string) error {
+	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET lease_owner=NULL, lease_until=NULL WHERE id=$1 AND lease_owner=$2`, id, owner)
 	return err
 }
 
-func (r *PostgresRepository) IncrementAdClicks(ctx context.Context, adID int64) error {
-	_, err := r.db.Exec(ctx, `UPDATE ads SET clicks_count = clicks_count + 1 WHERE id = $1`, adID)
+// FinishBroadcastWave — волна отправлена, рассылка ждёт следующих поясов
+func (r *PostgresRepository) FinishBroadcastWave(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET status='scheduled', wave_timezones='{}', last_user_id=0 WHERE id=$1`, id)
 	return err
 }
 
-// ==================== BROADCASTS ====================
+// ==================== ADMINS ====================
 
-func (r *PostgresRepository) CreateBroadcast(ctx context.Context, b *domain.Broadcast) error {
-	query := `INSERT INTO broadcasts (name, text, image_url, button_text, button_url, status, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id`
-	return r.db.QueryRow(ctx, query, b.Name, b.Text, b.ImageURL, b.ButtonText, b.ButtonURL, b.Status, time.Now()).Scan(&b.ID)
-}
-func (r *PostgresRepository) GetBroadcastByID(ctx context.Context, id int64) (*domain.Broadcast, error) {
-	query := `SELECT id, name, text, image_url, button_text, button_url, status, total_users, sent_count, failed_count, last_user_id, created_at, started_at, completed_at FROM broadcasts WHERE id = $1`
-	b := &domain.Broadcast{}
-	err := r.db.QueryRow(ctx, query, id).Scan(&b.ID, &b.Name, &b.Text, &b.ImageURL, &b.ButtonText, &b.ButtonURL, &b.Status, &b.TotalUsers, &b.SentCount, &b.FailedCount, &b.LastUserID, &b.CreatedAt, &b.StartedAt, &b.CompletedAt)
+func (r *PostgresRepository) GetAdminRole(ctx context.Context, telegramID int64) (domain.AdminRole, error) {
+	var role domain.AdminRole
+	err := r.db.QueryRow(ctx, `SELECT role FROM admins WHERE telegram_id = $1`, telegramID).Scan(&role)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, ErrNotFound
+		return "", ErrNotFound
 	}
-	return b, err
+	return role, err
 }
 
-func (r *PostgresRepository) GetAllBroadcasts(ctx context.Context) ([]*domain.Broadcast, error) {
-	rows, err := r.db.Query(ctx, `SELECT id, name, text, image_url, button_text, button_url, status, total_users, sent_count, failed_count, last_user_id, created_at, started_at, completed_at FROM broadcasts ORDER BY created_at DESC LIMIT 20`)
+// AddAdmin — назначает администратора или меняет роль существующего
+func (r *PostgresRepository) AddAdmin(ctx context.Context, telegramID int64, role domain.AdminRole) error {
+	query := `INSERT INTO admins (telegram_id, role) VALUES ($1, $2) ON CONFLICT (telegram_id) DO UPDATE SET role = EXCLUDED.role`
+	_, err := r.db.Exec(ctx, query, telegramID, role)
+	return err
+}
+
+// BootstrapOwner — делает пользователя владельцем, только если владельца ещё нет
+func (r *PostgresRepository) BootstrapOwner(ctx context.Context, telegramID int64) error {
+	query := `
+	  INSERT INTO admins (telegram_id, role)
+	  SELECT $1, 'owner' WHERE NOT EXISTS (SELECT 1 FROM admins WHERE role = 'owner')
+	  ON CONFLICT (telegram_id) DO UPDATE SET role = 'owner'`
+	_, err := r.db.Exec(ctx, query, telegramID)
+	return err
+}
+
+func (r *PostgresRepository) RemoveAdmin(ctx context.Context, telegramID int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM admins WHERE telegram_id = $1`, telegramID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PostgresRepository) GetAllAdmins(ctx context.Context) ([]*domain.Admin, error) {
+	rows, err := r.db.Query(ctx, `SELECT telegram_id, role, created_at FROM admins ORDER BY created_at ASC`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var broadcasts []*domain.Broadcast
+	var admins []*domain.Admin
 	for rows.Next() {
-		b := &domain.Broadcast{}
-		if err := rows.Scan(&b.ID, &b.Name, &b.Text, &b.ImageURL, &b.ButtonText, &b.ButtonURL, &b.Status, &b.TotalUsers, &b.SentCount, &b.FailedCount, &b.LastUserID, &b.CreatedAt, &b.StartedAt, &b.CompletedAt); err != nil {
+		a := &domain.Admin{}
+		if err := rows.Scan(&a.TelegramID, &a.Role, &a.CreatedAt); err != nil {
 			return nil, err
 		}
-		broadcasts = append(broadcasts, b)
+		admins = append(admins, a)
 	}
-	return broadcasts, nil
+	return admins, nil
 }
 
-func (r *PostgresRepository) GetRunningBroadcast(ctx context.Context) (*domain.Broadcast, error) {
-	b := &domain.Broadcast{}
-	err := r.db.QueryRow(ctx, `SELECT id, name, text, image_url, button_text, button_url, status, total_users, sent_count, failed_count, last_user_id, created_at, started_at, completed_at FROM broadcasts WHERE status = 'running' LIMIT 1`).Scan(&b.ID, &b.Name, &b.Text, &b.ImageURL, &b.ButtonText, &b.ButtonURL, &b.Status, &b.TotalUsers, &b.SentCount, &b.FailedCount, &b.LastUserID, &b.CreatedAt, &b.StartedAt, &b.CompletedAt)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, ErrNotFound
-	}
-	return b, err
-}
+// ==================== ADMIN AUDIT ====================
 
-func (r *PostgresRepository) UpdateBroadcastStatus(ctx context.Context, id int64, status domain.BroadcastStatus) error {
-	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET status=$2 WHERE id=$1, id, status`)
-	return err
+func (r *PostgresRepository) CreateAuditEntry(ctx context.Context, e *domain.AuditEntry) error {
+	query := `
+	  INSERT INTO admin_audit_log (admin_telegram_id, action, target_user_id, details, created_at)
+	  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	e.CreatedAt = time.Now()
+	return r.db.QueryRow(ctx, query, e.AdminTelegramID, e.Action, e.TargetUserID, e.Details, e.CreatedAt).Scan(&e.ID)
 }
 
-func (r *PostgresRepository) UpdateBroadcastProgress(ctx context.Context, id int64, sent, failed int, lastUserID int64) error {
-	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET sent_count=$2, failed_count=$3, last_user_id=$4 WHERE id=$1`, id, sent, failed, lastUserID)
-	return err
-}
+// GetAuditEntries — последние действия всех администраторов
+func (r *PostgresRepository) GetAuditEntries(ctx context.Context, limit int) ([]*domain.AuditEntry, error) {
+	query := `
+	  SELECT id, admin_telegram_id, action, target_user_id, details, created_at
+	  FROM admin_audit_log ORDER BY created_at DESC LIMIT $1`
 
-func (r *PostgresRepository) StartBroadcast(ctx context.Context, id int64, totalUsers int) error {
-	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET status='running', total_users=$2, started_at=$3 WHERE id=$1`, id, totalUsers, time.Now())
-	return err
-}
+	rows, err := r.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func (r *PostgresRepository) CompleteBroadcast(ctx context.Context, id int64) error {
-	_, err := r.db.Exec(ctx, `UPDATE broadcasts SET status='completed', completed_at=$2 WHERE id=$1`, id, time.Now())
-	return err
+	var entries []*domain.AuditEntry
+	for rows.Next() {
+		e := &domain.AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.AdminTelegramID, &e.Action, &e.TargetUserID, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
 }
 
-// ==================== ADMINS ====================
+// GetUserAuditEntries — последние действия администраторов над пользователем
+func (r *PostgresRepository) GetUserAuditEntries(ctx context.Context, userID int64, limit int) ([]*domain.AuditEntry, error) {
+	query := `
+	  SELECT id, admin_telegram_id, action, target_user_id, details, created_at
+	  FROM admin_audit_log WHERE target_user_id = $1 ORDER BY created_at DESC LIMIT $2`
 
-func (r *PostgresRepository) IsAdmin(ctx context.Context, telegramID int64) (bool, error) {
-	var exists bool
-	err := r.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM admins WHERE telegram_id = $1)`, telegramID).Scan(&exists)
-	return exists, err
-}
+	rows, err := r.db.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func (r *PostgresRepository) AddAdmin(ctx context.Context, telegramID int64) error {
-	_, err := r.db.Exec(ctx, `INSERT INTO admins (telegram_id) VALUES ($1) ON CONFLICT DO NOTHING`, telegramID)
-	return err
+	var entries []*domain.AuditEntry
+	for rows.Next() {
+		e := &domain.AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.AdminTelegramID, &e.Action, &e.TargetUserID, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
 }
 
 // ==================== EXPORT ====================
@@ -839,21 +2011,22 @@ func (r *PostgresRepository) GetAllUserData(ctx context.Context, userID int64) (
 
 // ===== PROMOCODES =====
 
-func (r *PostgresRepository) CreatePromocode(ctx context.Context, code string, discount int, maxUses int) error {
-	var maxUsesPtr *int
-	if maxUses > 0 {
-		maxUsesPtr = &maxUses
+func (r *PostgresRepository) CreatePromocode(ctx context.Context, p *domain.Promocode) error {
+	if p.PlanCodes == nil {
+		p.PlanCodes = []string{}
 	}
-	_, err := r.db.Exec(ctx,
-		`INSERT INTO promocodes (code, discount_percent, max_uses) VALUES ($1, $2, $3)`,
-		code, discount, maxUsesPtr)
-	return err
+	return r.db.QueryRow(ctx,
+		`INSERT INTO promocodes (code, discount_percent, discount_amount, free_days, max_uses, starts_at, ends_at, plan_codes, first_payment_only, owner_user_id, campaign)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, is_active, created_at`,
+		p.Code, p.DiscountPercent, p.DiscountAmount, p.FreeDays, p.MaxUses, p.StartsAt, p.EndsAt, p.PlanCodes, p.FirstPaymentOnly, p.OwnerUserID, p.Campaign).
+		Scan(&p.ID, &p.IsActive, &p.CreatedAt)
 }
 
 func (r *PostgresRepository) GetAllPromocodes(ctx context.Context) ([]*domain.Promocode, error) {
 	rows, err := r.db.Query(ctx,
-		`SELECT id, code, discount_percent, max_uses, used_count, is_active, created_at 
-         FROM promocodes ORDER BY created_at DESC`)
+		`SELECT id, code, discount_percent, discount_amount, free_days, max_uses, used_count, is_active,
+                starts_at, ends_at, plan_codes, first_payment_only, owner_user_id, campaign, created_at
+         FROM promocodes WHERE owner_user_id IS NULL ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -862,7 +2035,8 @@ func (r *PostgresRepository) GetAllPromocodes(ctx context.Context) ([]*domain.Pr
 	var promos []*domain.Promocode
 	for rows.Next() {
 		p := &domain.Promocode{}
-		err := rows.Scan(&p.ID, &p.Code, &p.DiscountPercent, &p.MaxUses, &p.UsedCount, &p.IsActive, &p.CreatedAt)
+		err := rows.Scan(&p.ID, &p.Code, &p.DiscountPercent, &p.DiscountAmount, &p.FreeDays, &p.MaxUses, &p.UsedCount, &p.IsActive,
+			&p.StartsAt, &p.EndsAt, &p.PlanCodes, &p.FirstPaymentOnly, &p.OwnerUserID, &p.Campaign, &p.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -874,10 +2048,12 @@ func (r *PostgresRepository) GetAllPromocodes(ctx context.Context) ([]*domain.Pr
 func (r *PostgresRepository) GetPromocodeByCode(ctx context.Context, code string) (*domain.Promocode, error) {
 	p := &domain.Promocode{}
 	err := r.db.QueryRow(ctx,
-		`SELECT id, code, discount_percent, max_uses, used_count, is_active, created_at 
+		`SELECT id, code, discount_percent, discount_amount, free_days, max_uses, used_count, is_active,
+                starts_at, ends_at, plan_codes, first_payment_only, owner_user_id, campaign, created_at
          FROM promocodes WHERE code = $1`, code).
-		Scan(&p.ID, &p.Code, &p.DiscountPercent, &p.MaxUses, &p.UsedCount, &p.IsActive, &p.CreatedAt)
-	if err == sql.ErrNoRows {
+		Scan(&p.ID, &p.Code, &p.DiscountPercent, &p.DiscountAmount, &p.FreeDays, &p.MaxUses, &p.UsedCount, &p.IsActive,
+			&p.StartsAt, &p.EndsAt, &p.PlanCodes, &p.FirstPaymentOnly, &p.OwnerUserID, &p.Campaign, &p.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, nil
 	}
 	return p, err
@@ -897,27 +2073,34 @@ func (r *PostgresRepository) TogglePromocode(ctx context.Context, code string) e
 func (r *PostgresRepository) HasUserUsedPromocode(ctx context.Context, userID int64, promocodeID int64) (bool, error) {
 	var exists bool
 	err := r.db.QueryRow(ctx,
-		`SELECT EXISTS(SELECT 1 FROM promocode_usages WHERE user_id = $1 AND promocode_id = $2)`,
+		`SELECT EXISTS(SELECT 1 FROM promocode_usages WHERE user_id = $1 AND promocode_id = $2 AND status = 'committed')`,
 		userID, promocodeID).Scan(&exists)
 	return exists, err
 }
 
 func (r *PostgresRepository) SetUserActivePromocode(ctx context.Context, userID int64, promocodeID int64) error {
 	_, err := r.db.Exec(ctx,
-		`UPDATE users SET active_promocode_id = $1 WHERE telegram_id = $2`,
+		`UPDATE users SET active_promocode_id = $1 WHERE id = $2`,
 		promocodeID, userID)
 	return err
 }
 
+// GetUserActivePromocode — введённый пользователем промокод, если он ещё действует:
+// истёкшие, исчерпанные и уже использованные им не возвращаются
 func (r *PostgresRepository) GetUserActivePromocode(ctx context.Context, userID int64) (*domain.Promocode, error) {
 	p := &domain.Promocode{}
 	err := r.db.QueryRow(ctx,
-		`SELECT p.id, p.code, p.discount_percent, p.max_uses, p.used_count, p.is_active, p.created_at
+		`SELECT p.id, p.code, p.discount_percent, p.discount_amount, p.free_days, p.max_uses, p.used_count, p.is_active,
+                p.starts_at, p.ends_at, p.plan_codes, p.first_payment_only, p.owner_user_id, p.campaign, p.created_at
          FROM promocodes p
          JOIN users u ON u.active_promocode_id = p.id
-         WHERE u.telegram_id = $1 AND p.is_active = true`, userID).
-		Scan(&p.ID, &p.Code, &p.DiscountPercent, &p.MaxUses, &p.UsedCount, &p.IsActive, &p.CreatedAt)
-	if err == sql.ErrNoRows {
+         WHERE u.id = $1 AND p.is_active = true
+           AND (p.ends_at IS NULL OR p.ends_at > NOW())
+           AND (p.max_uses IS NULL OR p.used_count < p.max_uses)
+           AND NOT EXISTS (SELECT 1 FROM promocode_usages pu WHERE pu.promocode_id = p.id AND pu.user_id = u.id AND pu.status = 'committed')`, userID).
+		Scan(&p.ID, &p.Code, &p.DiscountPercent, &p.DiscountAmount, &p.FreeDays, &p.MaxUses, &p.UsedCount, &p.IsActive,
+			&p.StartsAt, &p.EndsAt, &p.PlanCodes, &p.FirstPaymentOnly, &p.OwnerUserID, &p.Campaign, &p.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, nil
 	}
 	if err != nil {
@@ -928,31 +2111,266 @@ func (r *PostgresRepository) GetUserActivePromocode(ctx context.Context, userID
 
 func (r *PostgresRepository) ClearUserActivePromocode(ctx context.Context, userID int64) error {
 	_, err := r.db.Exec(ctx,
-		`UPDATE users SET active_promocode_id = NULL WHERE telegram_id = $1`, userID)
+		`UPDATE users SET active_promocode_id = NULL WHERE id = $1`, userID)
 	return err
 }
 
-func (r *PostgresRepository) IncrementPromocodeUsage(ctx context.Context, promocodeID int64, userID int64) error {
+// RedeemFreeDaysPromocode — засчитывает использование промокода и начисляет бесплатные дни
+// одной транзакцией. false, если лимит использований исчерпан; ErrAlreadyExists, если
+// пользователь его уже использовал.
+func (r *PostgresRepository) RedeemFreeDaysPromocode(ctx context.Context, promocodeID int64, userID int64, days int) (bool, error) {
 	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer tx.Rollback(ctx)
 
+	tag, err := tx.Exec(ctx,
+		`UPDATE promocodes SET used_count = used_count + 1 WHERE id = $1 AND (max_uses IS NULL OR used_count < max_uses)`,
+		promocodeID)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
 	_, err = tx.Exec(ctx,
 		`INSERT INTO promocode_usages (promocode_id, user_id) VALUES ($1, $2)`,
 		promocodeID, userID)
+	if isUniqueViolation(err) {
+		return false, ErrAlreadyExists
+	}
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	if _, err := tx.Exec(ctx, addSubscriptionDaysQuery, userID, days, time.Now()); err != nil {
+		return false, fmt.Errorf("add subscription days: %w", err)
+	}
+	return true, tx.Commit(ctx)
+}
+
+// ReservePromocode — резервирует промокод за платежом orderID. Резервы других пользователей
+// занимают места в лимите, пока их платежи не оплачены или не отменены; несколько
+// ссылок на оплату одного пользователя занимают одно место. false, если мест нет.
+func (r *PostgresRepository) ReservePromocode(ctx context.Context, promocodeID int64, userID int64, orderID string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Блокировка промокода: резервы и списания считают места по очереди
+	var maxUses *int
+	var usedCount int
+	err = tx.QueryRow(ctx, `SELECT max_uses, used_count FROM promocodes WHERE id = $1 FOR UPDATE`, promocodeID).Scan(&maxUses, &usedCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if maxUses != nil {
+		var reserved int
+		err = tx.QueryRow(ctx,
+			`SELECT COUNT(DISTINCT user_id) FROM promocode_usages WHERE promocode_id = $1 AND status = 'reserved' AND user_id <> $2`,
+			promocodeID, userID).Scan(&reserved)
+		if err != nil {
+			return false, err
+		}
+		if usedCount+reserved >= *maxUses {
+			return false, nil
+		}
 	}
 
 	_, err = tx.Exec(ctx,
-		`UPDATE promocodes SET used_count = used_count + 1 WHERE id = $1`,
-		promocodeID)
+		`INSERT INTO promocode_usages (promocode_id, user_id, status, order_id, used_at) VALUES ($1, $2, 'reserved', $3, $4)`,
+		promocodeID, userID, orderID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// commitPromocodeReservation — промокод по оплаченному платежу считается использованным.
+// Выполняется в транзакции отметки оплаты.
+func commitPromocodeReservation(ctx context.Context, tx pgx.Tx, orderID string) error {
+	var promocodeID, userID int64
+	err := tx.QueryRow(ctx,
+		`SELECT promocode_id, user_id FROM promocode_usages WHERE order_id = $1 AND status = 'reserved'`,
+		orderID).Scan(&promocodeID, &userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM promocodes WHERE id = $1 FOR UPDATE`, promocodeID); err != nil {
+		return err
+	}
+
+	// Пользователь мог оплатить две ссылки с одним промокодом: засчитываем его один раз
+	tag, err := tx.Exec(ctx,
+		`UPDATE promocode_usages SET status = 'committed', used_at = $2
+         WHERE order_id = $1 AND status = 'reserved' AND NOT EXISTS (
+           SELECT 1 FROM promocode_usages c WHERE c.promocode_id = $3 AND c.user_id = $4 AND c.status = 'committed')`,
+		orderID, time.Now(), promocodeID, userID)
 	if err != nil {
 		return err
 	}
-	return tx.Commit(ctx)
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM promocode_usages WHERE promocode_id = $1 AND user_id = $2 AND status = 'reserved'`,
+		promocodeID, userID); err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	// Место в лимите держал резерв; условие не даёт счётчику превысить лимит, если его уменьшили
+	if _, err := tx.Exec(ctx,
+		`UPDATE promocodes SET used_count = used_count + 1 WHERE id = $1 AND (max_uses IS NULL OR used_count < max_uses)`,
+		promocodeID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET active_promocode_id = NULL WHERE id = $1 AND active_promocode_id = $2`,
+		userID, promocodeID); err != nil {
+		return err
+	}
+	// Конверсия промо-кампании, если промокод был из неё
+	if _, err := tx.Exec(ctx,
+		`UPDATE promo_campaign_sends SET converted_at = $3, order_id = $4
+         WHERE promocode_id = $1 AND user_id = $2 AND converted_at IS NULL`,
+		promocodeID, userID, time.Now(), orderID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *PostgresRepository) ReleasePromocodeReservation(ctx context.Context, orderID string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM promocode_usages WHERE order_id = $1 AND status = 'reserved'`, orderID)
+	return err
+}
+
+// ReleaseStalePromocodeReservations — снимает резервы по неоплаченным платежам, созданным раньше before
+func (r *PostgresRepository) ReleaseStalePromocodeReservations(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+	  DELETE FROM promocode_usages pu USING payments p
+	  WHERE pu.order_id = p.order_id AND pu.status = 'reserved'
+	    AND p.paid_at IS NULL AND p.created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ===== PROMO CAMPAIGNS =====
+
+// GetUsersForFirstPromo — бесплатные пользователи, зарегистрированные не позже registeredBefore,
+// которые ни разу не платили и ещё не получали первое предложение
+func (r *PostgresRepository) GetUsersForFirstPromo(ctx context.Context, registeredBefore time.Time, limit int) ([]*domain.User, error) {
+	query := `
+	  SELECT u.id, u.telegram_id, u.username, u.first_name, u.discount_percent
+	  FROM users u
+	  WHERE u.subscribed_to_broadcasts = true AND u.is_banned = false AND u.is_blocked = false AND u.created_at <= $1
+	    AND (u.subscription_end IS NULL OR u.subscription_end + INTERVAL '1 day' * $2 < NOW())
+	    AND NOT EXISTS (SELECT 1 FROM family_members fm WHERE fm.user_id = u.id)
+	    AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.user_id = u.id AND p.status = 'CONFIRMED')
+	    AND NOT EXISTS (SELECT 1 FROM user_promo_status ps WHERE ps.user_id = u.id AND ps.first_promo_sent = true)
+	  ORDER BY u.id ASC LIMIT $3`
+
+	return r.queryCampaignUsers(ctx, query, registeredBefore, r.graceDays, limit)
+}
+
+// GetUsersForWeeklyPromo — бесплатные пользователи, которым последнее предложение ушло раньше lastBefore
+func (r *PostgresRepository) GetUsersForWeeklyPromo(ctx context.Context, lastBefore time.Time, limit int) ([]*domain.User, error) {
+	query := `
+	  SELECT u.id, u.telegram_id, u.username, u.first_name, u.discount_percent
+	  FROM users u
+	  JOIN user_promo_status ps ON ps.user_id = u.id AND ps.first_promo_sent = true
+	  WHERE u.subscribed_to_broadcasts = true AND u.is_banned = false AND u.is_blocked = false
+	    AND (u.subscription_end IS NULL OR u.subscription_end + INTERVAL '1 day' * $2 < NOW())
+	    AND NOT EXISTS (SELECT 1 FROM family_members fm WHERE fm.user_id = u.id)
+	    AND COALESCE(ps.last_weekly_promo, ps.first_promo_sent_at, '-infinity'::timestamp) < $1
+	  ORDER BY u.id ASC LIMIT $3`
+
+	return r.queryCampaignUsers(ctx, query, lastBefore, r.graceDays, limit)
+}
+
+func (r *PostgresRepository) queryCampaignUsers(ctx context.Context, query string, args ...interface{}) ([]*domain.User, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		u := &domain.User{}
+		if err := rows.Scan(&u.ID, &u.TelegramID, &u.Username, &u.FirstName, &u.DiscountPercent); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (r *PostgresRepository) MarkFirstPromoSent(ctx context.Context, userID int64) error {
+	_, err := r.db.Exec(ctx, `
+	  INSERT INTO user_promo_status (user_id, first_promo_sent, first_promo_sent_at) VALUES ($1, true, $2)
+	  ON CONFLICT (user_id) DO UPDATE SET first_promo_sent = true, first_promo_sent_at = EXCLUDED.first_promo_sent_at`,
+		userID, time.Now())
+	return err
+}
+
+func (r *PostgresRepository) MarkWeeklyPromoSent(ctx context.Context, userID int64) error {
+	_, err := r.db.Exec(ctx, `
+	  INSERT INTO user_promo_status (user_id, first_promo_sent, last_weekly_promo) VALUES ($1, true, $2)
+	  ON CONFLICT (user_id) DO UPDATE SET last_weekly_promo = EXCLUDED.last_weekly_promo`,
+		userID, time.Now())
+	return err
+}
+
+func (r *PostgresRepository) CreateCampaignSend(ctx context.Context, userID int64, campaign domain.PromoCampaign, promocodeID int64) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO promo_campaign_sends (user_id, campaign, promocode_id, sent_at) VALUES ($1, $2, $3, $4)`,
+		userID, campaign, promocodeID, time.Now())
+	return err
+}
+
+// GetCampaignStats — отправлено, оплачено и выручка по кампаниям с момента since
+func (r *PostgresRepository) GetCampaignStats(ctx context.Context, since time.Time) ([]*domain.CampaignStats, error) {
+	query := `
+	  SELECT s.campaign, COUNT(*), COUNT(s.converted_at), COALESCE(SUM(p.amount), 0)
+	  FROM promo_campaign_sends s
+	  LEFT JOIN payments p ON p.order_id = s.order_id
+	  WHERE s.sent_at >= $1
+	  GROUP BY s.campaign ORDER BY s.campaign`
+
+	rows, err := r.db.Query(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*domain.CampaignStats
+	for rows.Next() {
+		st := &domain.CampaignStats{}
+		if err := rows.Scan(&st.Campaign, &st.Sent, &st.Converted, &st.Revenue); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+func (r *PostgresRepository) SetBroadcastSubscription(ctx context.Context, userID int64, subscribed bool) error {
+	_, err := r.db.Exec(ctx, `UPDATE users SET subscribed_to_broadcasts = $2, updated_at = $3 WHERE id = $1`, userID, subscribed, time.Now())
+	return err
 }
 
 
@@ -1021,6 +2439,37 @@ func (r *PostgresRepository) GetHabitCompletionDays(ctx context.Context, habitID
 	return result, nil
 }
 
+// GetCompletionRatios — доля выполненных привычек по дням [from, to): habitID = 0 — все
+// активные привычки пользователя, иначе одна. Дни до создания привычки не учитываются.
+func (r *PostgresRepository) GetCompletionRatios(ctx context.Context, userID, habitID int64, from, to time.Time) (map[string]float64, error) {
+	rows, err := r.db.Query(ctx, `
+	  SELECT d::date, COUNT(h.id), COUNT(l.id)
+	  FROM generate_series($3::date, $4::date - 1, INTERVAL '1 day') AS d
+	  JOIN habits h ON h.user_id = $1 AND h.is_active = true
+		AND ($2 = 0 OR h.id = $2)
+		AND h.created_at::date <= d::date
+	  LEFT JOIN habit_logs l ON l.habit_id = h.id AND l.date = d::date AND l.completed = true
+	  GROUP BY d
+	`, userID, habitID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]float64)
+	for rows.Next() {
+		var date time.Time
+		var total, done int
+		if err := rows.Scan(&date, &total, &done); err != nil {
+			return nil, err
+		}
+		if total > 0 {
+			result[date.Format("2006-01-02")] = float64(done) / float64(total)
+		}
+	}
+	return result, rows.Err()
+}
+
 // GetHabitsStreaks — серии всех привычек пользователя
 func (r *PostgresRepository) GetHabitsStreaks(ctx context.Context, userID int64) ([]HabitStreak, error) {
 	rows, err := r.db.Query(ctx, `
@@ -1103,3 +2552,84 @@ func (r *PostgresRepository) UpdateHabitEmoji(ctx context.Context, habitID int64
 	_, err := r.db.Exec(ctx, `UPDATE habits SET emoji = $1 WHERE id = $2`, emoji, habitID)
 	return err
 }
+
+// ===== PROGRESS REPORTS =====
+
+// GetReportSettings — подписка пользователя на отчёты; без записи — все выключены
+func (r *PostgresRepository) GetReportSettings(ctx context.Context, userID int64) (*domain.ReportSettings, error) {
+	settings := &domain.ReportSettings{}
+	err := r.db.QueryRow(ctx, `SELECT weekly, monthly FROM report_settings WHERE user_id = $1`, userID).
+		Scan(&settings.Weekly, &settings.Monthly)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (r *PostgresRepository) SetReportSettings(ctx context.Context, userID int64, settings domain.ReportSettings) error {
+	_, err := r.db.Exec(ctx, `
+	  INSERT INTO report_settings (user_id, weekly, monthly, updated_at) VALUES ($1, $2, $3, $4)
+	  ON CONFLICT (user_id) DO UPDATE SET weekly = EXCLUDED.weekly, monthly = EXCLUDED.monthly, updated_at = EXCLUDED.updated_at`,
+		userID, settings.Weekly, settings.Monthly, time.Now())
+	return err
+}
+
+// GetReportSubscribers — доступные пользователи, включившие хотя бы один отчёт
+func (r *PostgresRepository) GetReportSubscribers(ctx context.Context) ([]*domain.ReportSubscriber, error) {
+	rows, err := r.db.Query(ctx, `
+	  SELECT u.id, u.telegram_id, COALESCE(u.timezone, ''), rs.weekly, rs.monthly, rs.last_weekly_from, rs.last_monthly_from
+	  FROM report_settings rs JOIN users u ON u.id = rs.user_id
+	  WHERE (rs.weekly OR rs.monthly) AND u.is_banned = false AND u.is_blocked = false
+	  ORDER BY u.id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []*domain.ReportSubscriber
+	for rows.Next() {
+		s := &domain.ReportSubscriber{}
+		if err := rows.Scan(&s.UserID, &s.TelegramID, &s.Timezone, &s.Settings.Weekly, &s.Settings.Monthly, &s.LastWeekly, &s.LastMonthly); err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, s)
+	}
+	return subscribers, rows.Err()
+}
+
+// MarkReportSent — запоминает начало периода, за который ушёл отчёт
+func (r *PostgresRepository) MarkReportSent(ctx context.Context, userID int64, period domain.ReportPeriod, from time.Time) error {
+	column := "last_weekly_from"
+	if period == domain.ReportMonthly {
+		column = "last_monthly_from"
+	}
+	_, err := r.db.Exec(ctx, `UPDATE report_settings SET `+column+` = $2::date WHERE user_id = $1`, userID, from)
+	return err
+}
+
+// GetHabitPeriodStats — выполнения активных привычек пользователя за даты [from, to)
+func (r *PostgresRepository) GetHabitPeriodStats(ctx context.Context, userID int64, from, to time.Time) ([]*domain.HabitPeriodStat, error) {
+	rows, err := r.db.Query(ctx, `
+	  SELECT h.id, h.name, h.frequency, h.created_at, COUNT(hl.id)
+	  FROM habits h
+	  LEFT JOIN habit_logs hl ON hl.habit_id = h.id AND hl.completed = true
+		AND hl.date >= $2::date AND hl.date < $3::date
+	  WHERE h.user_id = $1 AND h.is_active = true AND h.is_locked = false
+	  GROUP BY h.id, h.name, h.frequency, h.created_at
+	  ORDER BY h.id ASC
+	`, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*domain.HabitPeriodStat
+	for rows.Next() {
+		s := &domain.HabitPeriodStat{}
+		if err := rows.Scan(&s.HabitID, &s.Name, &s.Frequency, &s.CreatedAt, &s.Done); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
@@ -27,15 +27,29 @@ type Repository interface {
 	GetUserByTelegramID(ctx context.Context, telegramID int64) (*domain.User, error)
 	GetUserByID(ctx context.Context, id int64) (*domain.User, error)
 	GetUserByReferralCode(ctx context.Context, code string) (*domain.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*domain.User, error)
 	UpdateUser(ctx context.Context, user *domain.User) error
 	UpdateSubscription(ctx context.Context, userID int64, endDate time.Time) error
 	AddSubscriptionDays(ctx context.Context, userID int64, days int) error
+	RevokeSubscriptionDays(ctx context.Context, userID int64, days int) error
 	AddDiscount(ctx context.Context, userID int64, percent int) error
+	ResetDiscount(ctx context.Context, userID int64) error
+	SetUserBanned(ctx context.Context, userID int64, banned bool) error
 	IncrementActionCount(ctx context.Context, userID int64) (int, error)
 	ResetActionCount(ctx context.Context, userID int64) error
 	GetTotalUsersCount(ctx context.Context) (int, error)
-	GetUsersForBroadcast(ctx context.Context, lastUserID int64, limit int) ([]int64, int64, error)
+	MarkUserBlocked(ctx context.Context, userID int64) error
+	GetRecentUsers(ctx context.Context, limit int) ([]*domain.User, error)
+	GetAdminStats(ctx context.Context, from, to time.Time) (*domain.AdminStats, error)
+	GetUsersForBroadcast(ctx context.Context, seg *domain.BroadcastSegment, lastUserID int64, limit int) ([]domain.BroadcastRecipient, error)
+	CountBroadcastAudience(ctx context.Context, seg *domain.BroadcastSegment) (int, error)
+	GetBroadcastAudienceTimezones(ctx context.Context, seg *domain.BroadcastSegment) ([]string, error)
 	GetUserIDByTelegramID(ctx context.Context, telegramID int64) (int64, error)
+	GetUsersBySubscriptionEnd(ctx context.Context, from, to time.Time) ([]*domain.User, error)
+
+	// Subscription notices
+	MarkSubscriptionNotice(ctx context.Context, userID int64, kind domain.SubscriptionNotice, subscriptionEnd time.Time) (bool, error)
+	ClearSubscriptionNotice(ctx context.Context, userID int64, kind domain.SubscriptionNotice, subscriptionEnd time.Time) error
 
 	// Habits
 	CreateHabit(ctx context.Context, habit *domain.Habit) error
@@ -45,6 +59,9 @@ type Repository interface {
 	DeleteHabit(ctx context.Context, id int64) error
 	CountUserHabits(ctx context.Context, userID int64) (int, error)
 	ClearReminders(ctx context.Context, userID int64) error
+	LockHabitsExcept(ctx context.Context, userID int64, keep int) (int, error)
+	UnlockUserHabits(ctx context.Context, userID int64) error
+	SetHabitLocked(ctx context.Context, habitID int64, locked bool) error
 
 	// Habit Logs
 	LogHabit(ctx context.Context, log *domain.HabitLog) error
@@ -64,8 +81,30 @@ type Repository interface {
 	CreatePayment(ctx context.Context, payment *domain.Payment) error
 	GetPaymentByOrderID(ctx context.Context, orderID string) (*domain.Payment, error)
 	UpdatePaymentStatus(ctx context.Context, orderID string, status domain.PaymentStatus, tinkoffID string) error
-	UpdatePaymentPaid(ctx context.Context, orderID string, paidAt time.Time) error
+	ApplyPaidPayment(ctx context.Context, p *domain.Payment, paidAt time.Time) (bool, error)
 	GetUserPendingPayment(ctx context.Context, userID int64) (*domain.Payment, error)
+	GetUserPayments(ctx context.Context, userID int64, limit int) ([]*domain.Payment, error)
+	GetRecentPayments(ctx context.Context, limit int) ([]*domain.Payment, error)
+	HasConfirmedPayments(ctx context.Context, userID int64) (bool, error)
+
+	// Family
+	CreateFamilyGroup(ctx context.Context, group *domain.FamilyGroup) error
+	GetFamilyGroupByOwner(ctx context.Context, ownerID int64) (*domain.FamilyGroup, error)
+	GetFamilyGroupByInviteCode(ctx context.Context, code string) (*domain.FamilyGroup, error)
+	GetFamilyGroupByMember(ctx context.Context, userID int64) (*domain.FamilyGroup, error)
+	GetFamilyMembers(ctx context.Context, groupID int64) ([]*domain.User, error)
+	AddFamilyMember(ctx context.Context, groupID, userID int64, maxMembers int) (bool, error)
+	RemoveFamilyMember(ctx context.Context, groupID, userID int64) error
+	UpdateFamilyInviteCode(ctx context.Context, groupID int64, code string) error
+	DeleteFamilyGroup(ctx context.Context, groupID int64) error
+
+	// Gifts
+	CreateGift(ctx context.Context, gift *domain.Gift) error
+	GetGiftByID(ctx context.Context, id int64) (*domain.Gift, error)
+	GetGiftByCode(ctx context.Context, code string) (*domain.Gift, error)
+	GetGiftByOrderID(ctx context.Context, orderID string) (*domain.Gift, error)
+	GetUserGifts(ctx context.Context, userID int64) ([]*domain.Gift, error)
+	RedeemGift(ctx context.Context, giftID, recipientID int64) (bool, error)
 
 	// Referrals
 	CreateReferral(ctx context.Context, referral *domain.Referral) error
@@ -89,8 +128,17 @@ type Repository interface {
 	GetAllAds(ctx context.Context) ([]*domain.Ad, error)
 	UpdateAd(ctx context.Context, ad *domain.Ad) error
 	DeleteAd(ctx context.Context, id int64) error
-	IncrementAdViews(ctx context.Context, adID int64) error
+	SetAdOrderID(ctx context.Context, id int64, orderID string) error
+	IncrementAdViews(ctx context.Context, adID int64) (bool, error)
 	IncrementAdClicks(ctx context.Context, adID int64) error
+	GetAdDailyStats(ctx context.Context, adID int64, days int) ([]domain.AdDailyStat, error)
+	GetAdByOrderID(ctx context.Context, orderID string) (*domain.Ad, error)
+	GetAdsByStatus(ctx context.Context, status domain.AdStatus) ([]*domain.Ad, error)
+	GetAdvertiserAds(ctx context.Context, userID int64) ([]*domain.Ad, error)
+	SetAdStatus(ctx context.Context, id int64, from, to domain.AdStatus, reason string) (bool, error)
+	GetAdViewer(ctx context.Context, userID int64) (*domain.AdViewer, error)
+	RecordAdView(ctx context.Context, adID, userID int64) error
+	RecordAdClick(ctx context.Context, adID, userID int64) (bool, error)
 
 	// Broadcasts
 	CreateBroadcast(ctx context.Context, b *domain.Broadcast) error
@@ -99,18 +147,45 @@ type Repository interface {
 	GetRunningBroadcast(ctx context.Context) (*domain.Broadcast, error)
 	UpdateBroadcastStatus(ctx context.Context, id int64, status domain.BroadcastStatus) error
 	UpdateBroadcastProgress(ctx context.Context, id int64, sent, failed int, lastUserID int64) error
-	StartBroadcast(ctx context.Context, id int64, totalUsers int) error
+	StartBroadcast(ctx context.Context, id int64, totalUsers int) (bool, error)
+	ResumeBroadcastWave(ctx context.Context, id int64) (bool, error)
+	UpdateBroadcastSegment(ctx context.Context, id int64, segment domain.BroadcastSegment) (bool, error)
 	CompleteBroadcast(ctx context.Context, id int64) error
+	ScheduleBroadcast(ctx context.Context, id int64, at time.Time, perUserLocal bool) (bool, error)
+	CancelBroadcastSchedule(ctx context.Context, id int64) (bool, error)
+	GetScheduledBroadcasts(ctx context.Context, before time.Time) ([]*domain.Broadcast, error)
+	StartBroadcastWave(ctx context.Context, id int64, zones []string, totalUsers int) (bool, error)
+	FinishBroadcastWave(ctx context.Context, id int64) error
+	AcquireBroadcastLease(ctx context.Context, id int64, owner string, ttl time.Duration) (bool, error)
+	ReleaseBroadcastLease(ctx context.Context, id int64, owner string) error
+	RecordBroadcastDelivery(ctx context.Context, broadcastID, userID int64, variant string, status domain.DeliveryStatus, errText string) error
+	UpdateBroadcastContent(ctx context.Context, b *domain.Broadcast) (bool, error)
+	AddBroadcastVariant(ctx context.Context, broadcastID int64, v *domain.BroadcastVariant) (bool, error)
+	DeleteBroadcastVariant(ctx context.Context, broadcastID int64, label string) (bool, error)
+	RecordBroadcastClick(ctx context.Context, broadcastID int64, variant string, userID int64) error
+	GetBroadcastResults(ctx context.Context, broadcastID int64) ([]domain.VariantResult, error)
+	GetFailedDeliveries(ctx context.Context, broadcastID, lastUserID int64, limit int) ([]domain.BroadcastRecipient, error)
+	GetBroadcastDeliveryStats(ctx context.Context, broadcastID int64) (map[domain.DeliveryStatus]int, error)
+	GetBroadcastFailures(ctx context.Context, broadcastID int64, limit int) ([]*domain.BroadcastDelivery, error)
+	AddBroadcastRetried(ctx context.Context, id int64, delivered int) error
 
 	// Admins
-	IsAdmin(ctx context.Context, telegramID int64) (bool, error)
-	AddAdmin(ctx context.Context, telegramID int64) error
+	GetAdminRole(ctx context.Context, telegramID int64) (domain.AdminRole, error)
+	AddAdmin(ctx context.Context, telegramID int64, role domain.AdminRole) error
+	BootstrapOwner(ctx context.Context, telegramID int64) error
+	RemoveAdmin(ctx context.Context, telegramID int64) (bool, error)
+	GetAllAdmins(ctx context.Context) ([]*domain.Admin, error)
+
+	// Admin audit
+	CreateAuditEntry(ctx context.Context, entry *domain.AuditEntry) error
+	GetAuditEntries(ctx context.Context, limit int) ([]*domain.AuditEntry, error)
+	GetUserAuditEntries(ctx context.Context, userID int64, limit int) ([]*domain.AuditEntry, error)
 
 	// Export
 	GetAllUserData(ctx context.Context, userID int64) (*UserExportData, error)
 
 	// Promocodes
-	CreatePromocode(ctx context.Context, code string, discount int, maxUses int) error
+	CreatePromocode(ctx context.Context, promo *domain.Promocode) error
 	GetAllPromocodes(ctx context.Context) ([]*domain.Promocode, error)
 	GetPromocodeByCode(ctx context.Context, code string) (*domain.Promocode, error)
 	DeletePromocode(ctx context.Context, code string) error
@@ -119,7 +194,19 @@ type Repository interface {
 	SetUserActivePromocode(ctx context.Context, userID int64, promocodeID int64) error
 	GetUserActivePromocode(ctx context.Context, userID int64) (*domain.Promocode, error)
 	ClearUserActivePromocode(ctx context.Context, userID int64) error
-	IncrementPromocodeUsage(ctx context.Context, promocodeID int64, userID int64) error
+	RedeemFreeDaysPromocode(ctx context.Context, promocodeID int64, userID int64, days int) (bool, error)
+	ReservePromocode(ctx context.Context, promocodeID int64, userID int64, orderID string) (bool, error)
+	ReleasePromocodeReservation(ctx context.Context, orderID string) error
+	ReleaseStalePromocodeReservations(ctx context.Context, before time.Time) (int64, error)
+
+	// Promo campaigns
+	GetUsersForFirstPromo(ctx context.Context, registeredBefore time.Time, limit int) ([]*domain.User, error)
+	GetUsersForWeeklyPromo(ctx context.Context, lastBefore time.Time, limit int) ([]*domain.User, error)
+	MarkFirstPromoSent(ctx context.Context, userID int64) error
+	MarkWeeklyPromoSent(ctx context.Context, userID int64) error
+	CreateCampaignSend(ctx context.Context, userID int64, campaign domain.PromoCampaign, promocodeID int64) error
+	GetCampaignStats(ctx context.Context, since time.Time) ([]*domain.CampaignStats, error)
+	SetBroadcastSubscription(ctx context.Context, userID int64, subscribed bool) error
 
 	UpdateHabitReminder(ctx context.Context, habitID int64, reminderTime *string, reminderDays []int) error
 
@@ -127,6 +214,14 @@ type Repository interface {
 	GetWeeklyCompletionStats(ctx context.Context, userID int64) (map[string]int, error)
 	GetHabitCompletionDays(ctx context.Context, habitID int64, days int) (map[string]bool, error)
 	GetHabitsStreaks(ctx context.Context, userID int64) ([]HabitStreak, error)
+	GetCompletionRatios(ctx context.Context, userID, habitID int64, from, to time.Time) (map[string]float64, error)
+
+	// Progress reports
+	GetReportSettings(ctx context.Context, userID int64) (*domain.ReportSettings, error)
+	SetReportSettings(ctx context.Context, userID int64, settings domain.ReportSettings) error
+	GetReportSubscribers(ctx context.Context) ([]*domain.ReportSubscriber, error)
+	MarkReportSent(ctx context.Context, userID int64, period domain.ReportPeriod, from time.Time) error
+	GetHabitPeriodStats(ctx context.Context, userID int64, from, to time.Time) ([]*domain.HabitPeriodStat, error)
 
 	// Edit
 	UpdateHabitName(ctx context.Context, habitID int64, name string) error
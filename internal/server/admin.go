@@ -0,0 +1,638 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+	"habit-tracker-bot/internal/service"
+	"habit-tracker-bot/internal/telegram"
+)
+
+//go:embed templates/*.html
+var adminTemplates embed.FS
+
+var adminPages = []string{"login.html", "error.html", "dashboard.html", "users.html", "user.html", "payments.html", "promos.html", "ads.html", "broadcasts.html", "audit.html"}
+
+// AdminPanel — веб-админка поверх тех же сервисов, что использует бот.
+// Вход через Telegram Login Widget, доступ по ролям из таблицы admins.
+type AdminPanel struct {
+	repo          repository.Repository
+	adminSvc      *service.AdminService
+	broadcastSvc  *service.BroadcastService
+	adSvc         *service.AdService
+	botToken      string
+	botUsername   string
+	secureCookies bool
+	signer        *sessionSigner
+	pages         map[string]*template.Template
+}
+
+func NewAdminPanel(
+	repo repository.Repository,
+	adminSvc *service.AdminService,
+	broadcastSvc *service.BroadcastService,
+	adSvc *service.AdService,
+	botToken, botUsername string,
+	secureCookies bool,
+) (*AdminPanel, error) {
+	funcs := template.FuncMap{
+		"rub":  func(kopecks int64) string { return fmt.Sprintf("%.0f₽", float64(kopecks)/100) },
+		"date": func(t time.Time) string { return t.Format("02.01.2006 15:04") },
+		"dateptr": func(t *time.Time) string {
+			if t == nil {
+				return "—"
+			}
+			return t.Format("02.01.2006 15:04")
+		},
+		"str": func(s *string) string {
+			if s == nil {
+				return ""
+			}
+			return *s
+		},
+	}
+
+	pages := make(map[string]*template.Template, len(adminPages))
+	for _, name := range adminPages {
+		tmpl, err := template.New(name).Funcs(funcs).ParseFS(adminTemplates, "templates/layout.html", "templates/"+name)
+		if err != nil {
+			return nil, fmt.Errorf("parse admin template %s: %w", name, err)
+		}
+		pages[name] = tmpl
+	}
+
+	return &AdminPanel{
+		repo:          repo,
+		adminSvc:      adminSvc,
+		broadcastSvc:  broadcastSvc,
+		adSvc:         adSvc,
+		botToken:      botToken,
+		botUsername:   botUsername,
+		secureCookies: secureCookies,
+		signer:        newSessionSigner(botToken),
+		pages:         pages,
+	}, nil
+}
+
+func (p *AdminPanel) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/login", p.loginPage)
+	mux.HandleFunc("/admin/auth", p.authHandler)
+	mux.HandleFunc("/admin/logout", p.require("", p.logoutHandler))
+	mux.HandleFunc("/admin/", p.require("", p.dashboardPage))
+	mux.HandleFunc("/admin/users", p.require(domain.PermUsersView, p.usersPage))
+	mux.HandleFunc("/admin/users/action", p.require(domain.PermUsersView, p.userAction))
+	mux.HandleFunc("/admin/payments", p.require(domain.PermStats, p.paymentsPage))
+	mux.HandleFunc("/admin/promos", p.require(domain.PermPromos, p.promosPage))
+	mux.HandleFunc("/admin/promos/action", p.require(domain.PermPromos, p.promoAction))
+	mux.HandleFunc("/admin/ads", p.require(domain.PermAds, p.adsPage))
+	mux.HandleFunc("/admin/ads/action", p.require(domain.PermAds, p.adAction))
+	mux.HandleFunc("/admin/broadcasts", p.require(domain.PermBroadcasts, p.broadcastsPage))
+	mux.HandleFunc("/admin/broadcasts/action", p.require(domain.PermBroadcasts, p.broadcastAction))
+	mux.HandleFunc("/admin/audit", p.require(domain.PermAudit, p.auditPage))
+}
+
+type adminSession struct {
+	TelegramID int64
+	Role       domain.AdminRole
+	CSRF       string
+}
+
+type pageData struct {
+	Title       string
+	Session     *adminSession
+	BotUsername string
+	Notice      string
+	Error       string
+	Data        any
+}
+
+type adminHandlerFunc func(w http.ResponseWriter, r *http.Request, sess *adminSession)
+
+// require — проверяет сессию, актуальную роль в базе, право perm и CSRF-токен для POST
+func (p *AdminPanel) require(perm domain.AdminPermission, next adminHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil {
+			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+			return
+		}
+		telegramID, ok := p.signer.parse(cookie.Value, time.Now())
+		if !ok {
+			p.setSession(w, "", -1)
+			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+			return
+		}
+
+		// Роль читаем при каждом запросе: снятый администратор теряет доступ сразу
+		role, err := p.repo.GetAdminRole(r.Context(), telegramID)
+		if err != nil {
+			p.setSession(w, "", -1)
+			http.Redirect(w, r, "/admin/login?err="+url.QueryEscape("Нет доступа к админке"), http.StatusSeeOther)
+			return
+		}
+
+		sess := &adminSession{TelegramID: telegramID, Role: role, CSRF: p.signer.csrfToken(cookie.Value)}
+		if perm != "" && !role.Can(perm) {
+			p.render(w, r, http.StatusForbidden, "error.html", "Нет доступа", sess, "Недостаточно прав для этого раздела")
+			return
+		}
+		if r.Method == http.MethodPost && !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(sess.CSRF)) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		next(w, r, sess)
+	}
+}
+
+func (p *AdminPanel) render(w http.ResponseWriter, r *http.Request, status int, page, title string, sess *adminSession, data any) {
+	pd := pageData{
+		Title:       title,
+		Session:     sess,
+		BotUsername: p.botUsername,
+		Notice:      r.URL.Query().Get("ok"),
+		Error:       r.URL.Query().Get("err"),
+		Data:        data,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := p.pages[page].ExecuteTemplate(w, "layout", pd); err != nil {
+		log.Printf("Error rendering admin page %s: %v", page, err)
+	}
+}
+
+// back — редирект после действия с сообщением об успехе или ошибке
+func back(w http.ResponseWriter, r *http.Request, path string, err error, notice string) {
+	q := url.Values{}
+	if err != nil {
+		q.Set("err", err.Error())
+	} else if notice != "" {
+		q.Set("ok", notice)
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, path+sep+q.Encode(), http.StatusSeeOther)
+}
+
+func postOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// ==================== AUTH ====================
+
+func (p *AdminPanel) loginPage(w http.ResponseWriter, r *http.Request) {
+	p.render(w, r, http.StatusOK, "login.html", "Вход", nil, nil)
+}
+
+func (p *AdminPanel) authHandler(w http.ResponseWriter, r *http.Request) {
+	telegramID, err := verifyTelegramLogin(r.URL.Query(), p.botToken, time.Now())
+	if err != nil {
+		http.Redirect(w, r, "/admin/login?err="+url.QueryEscape("Не удалось проверить вход через Telegram"), http.StatusSeeOther)
+		return
+	}
+
+	if _, err := p.repo.GetAdminRole(r.Context(), telegramID); err != nil {
+		log.Printf("Admin panel login denied for %d", telegramID)
+		http.Redirect(w, r, "/admin/login?err="+url.QueryEscape("Нет доступа к админке"), http.StatusSeeOther)
+		return
+	}
+
+	p.setSession(w, p.signer.issue(telegramID, time.Now()), int(sessionTTL.Seconds()))
+	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+}
+
+func (p *AdminPanel) logoutHandler(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	if !postOnly(w, r) {
+		return
+	}
+	p.setSession(w, "", -1)
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+// ==================== DASHBOARD ====================
+
+type dashboardData struct {
+	Stats    *domain.AdminStats
+	ChartURL template.URL // PNG графика, встроенный data:-ссылкой
+}
+
+func (p *AdminPanel) dashboardPage(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	if r.URL.Path != "/admin/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := dashboardData{}
+	if sess.Role.Can(domain.PermStats) {
+		now := time.Now()
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+		stats, err := p.repo.GetAdminStats(r.Context(), to.AddDate(0, 0, -30), to)
+		if err != nil {
+			log.Printf("Error loading admin stats: %v", err)
+		} else {
+			data.Stats = stats
+			if png, err := telegram.GenerateAdminStatsChart(stats.Daily); err != nil {
+				log.Printf("Error rendering stats chart: %v", err)
+			} else if png != nil {
+				data.ChartURL = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+			}
+		}
+	}
+
+	p.render(w, r, http.StatusOK, "dashboard.html", "Обзор", sess, data)
+}
+
+// ==================== USERS ====================
+
+type userCardData struct {
+	User         *domain.User
+	Habits       []*domain.Habit
+	Payments     []*domain.Payment
+	Referrals    *domain.ReferralStats
+	Achievements []*domain.Achievement
+	Audit        []*domain.AuditEntry
+}
+
+func (p *AdminPanel) usersPage(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	ctx := r.Context()
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		users, err := p.repo.GetRecentUsers(ctx, 50)
+		if err != nil {
+			log.Printf("Error loading users: %v", err)
+		}
+		p.render(w, r, http.StatusOK, "users.html", "Пользователи", sess, users)
+		return
+	}
+
+	user, err := p.adminSvc.FindUser(ctx, q)
+	if err != nil {
+		msg := "Ошибка: " + err.Error()
+		if errors.Is(err, repository.ErrNotFound) {
+			msg = "Пользователь не найден: " + q
+		}
+		back(w, r, "/admin/users", errors.New(msg), "")
+		return
+	}
+
+	card := userCardData{User: user}
+	card.Habits, _ = p.repo.GetActiveHabits(ctx, user.ID)
+	card.Payments, _ = p.repo.GetUserPayments(ctx, user.ID, 20)
+	card.Referrals, _ = p.repo.GetReferralStats(ctx, user.ID)
+	card.Achievements, _ = p.repo.GetUserAchievements(ctx, user.ID)
+	card.Audit, _ = p.repo.GetUserAuditEntries(ctx, user.ID, 20)
+
+	p.render(w, r, http.StatusOK, "user.html", displayName(user), sess, card)
+}
+
+func (p *AdminPanel) userAction(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	if !postOnly(w, r) {
+		return
+	}
+	ctx := r.Context()
+
+	userID, _ := strconv.ParseInt(r.PostFormValue("user_id"), 10, 64)
+	user, err := p.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		back(w, r, "/admin/users", errors.New("Пользователь не найден"), "")
+		return
+	}
+	cardPath := "/admin/users?q=" + strconv.FormatInt(user.TelegramID, 10)
+
+	action := r.PostFormValue("action")
+	perm := domain.PermUsersManage
+	if action == "grant" || action == "revoke" {
+		perm = domain.PermUsersBill
+	}
+	if !sess.Role.Can(perm) {
+		back(w, r, cardPath, errors.New("Недостаточно прав"), "")
+		return
+	}
+
+	var notice string
+	switch action {
+	case "grant", "revoke":
+		days, convErr := strconv.Atoi(r.PostFormValue("days"))
+		if convErr != nil {
+			err = service.ErrInvalidDays
+		} else if action == "grant" {
+			err = p.adminSvc.GrantDays(ctx, sess.TelegramID, user, days)
+			notice = fmt.Sprintf("Начислено %d дн. Premium", days)
+		} else {
+			err = p.adminSvc.RevokeDays(ctx, sess.TelegramID, user, days)
+			notice = fmt.Sprintf("Списано %d дн. Premium", days)
+		}
+	case "ban", "unban":
+		err = p.adminSvc.SetBanned(ctx, sess.TelegramID, user, action == "ban")
+		notice = "Статус блокировки изменён"
+	case "resetdiscount":
+		err = p.adminSvc.ResetDiscount(ctx, sess.TelegramID, user)
+		notice = "Скидка и промокод сброшены"
+	default:
+		err = errors.New("Неизвестное действие")
+	}
+
+	back(w, r, cardPath, err, notice)
+}
+
+func displayName(user *domain.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return user.FirstName
+}
+
+// ==================== PAYMENTS ====================
+
+func (p *AdminPanel) paymentsPage(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	payments, err := p.repo.GetRecentPayments(r.Context(), 100)
+	if err != nil {
+		log.Printf("Error loading payments: %v", err)
+	}
+	p.render(w, r, http.StatusOK, "payments.html", "Платежи", sess, payments)
+}
+
+// ==================== PROMOCODES ====================
+
+func (p *AdminPanel) promosPage(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	promos, err := p.repo.GetAllPromocodes(r.Context())
+	if err != nil {
+		log.Printf("Error loading promocodes: %v", err)
+	}
+	p.render(w, r, http.StatusOK, "promos.html", "Промокоды", sess, promos)
+}
+
+func (p *AdminPanel) promoAction(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	if !postOnly(w, r) {
+		return
+	}
+	ctx := r.Context()
+	code := strings.ToUpper(strings.TrimSpace(r.PostFormValue("code")))
+
+	var err error
+	var notice string
+	switch r.PostFormValue("action") {
+	case "create":
+		value := strings.TrimSpace(r.PostFormValue("value"))
+		options := strings.Fields(r.PostFormValue("options"))
+		var promo *domain.Promocode
+		promo, err = service.ParsePromocode(code, value, options)
+		if err == nil {
+			err = p.repo.CreatePromocode(ctx, promo)
+		}
+		if err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditPromoCreate, nil, strings.TrimSpace("web: "+code+" "+value+" "+strings.Join(options, " ")))
+			notice = "Промокод " + promo.Code + " создан"
+		}
+	case "toggle":
+		if err = p.repo.TogglePromocode(ctx, code); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditPromoToggle, nil, "web: "+code)
+			notice = "Промокод " + code + " переключён"
+		}
+	case "delete":
+		if err = p.repo.DeletePromocode(ctx, code); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditPromoDelete, nil, "web: "+code)
+			notice = "Промокод " + code + " удалён"
+		}
+	default:
+		err = errors.New("Неизвестное действие")
+	}
+
+	back(w, r, "/admin/promos", err, notice)
+}
+
+// ==================== ADS ====================
+
+func (p *AdminPanel) adsPage(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	ads, err := p.repo.GetAllAds(r.Context())
+	if err != nil {
+		log.Printf("Error loading ads: %v", err)
+	}
+	p.render(w, r, http.StatusOK, "ads.html", "Реклама", sess, ads)
+}
+
+func (p *AdminPanel) adAction(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	if !postOnly(w, r) {
+		return
+	}
+	ctx := r.Context()
+	id, _ := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+
+	var err error
+	var notice string
+	switch r.PostFormValue("action") {
+	case "create":
+		ad := &domain.Ad{
+			Name:     strings.TrimSpace(r.PostFormValue("name")),
+			Text:     strings.TrimSpace(r.PostFormValue("text")),
+			IsActive: true,
+			Priority: 1,
+		}
+		ad.ButtonText, ad.ButtonURL = formButton(r)
+		if img := strings.TrimSpace(r.PostFormValue("image_url")); img != "" {
+			ad.ImageURL = &img
+		}
+		if ad.Name == "" || ad.Text == "" {
+			err = errors.New("Название и текст обязательны")
+			break
+		}
+		if err = service.CheckAd(ad); err != nil {
+			break
+		}
+		if err = service.ApplyAdTargeting(ad, strings.Fields(r.PostFormValue("targeting"))); err != nil {
+			break
+		}
+		if err = p.repo.CreateAd(ctx, ad); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditAdCreate, nil, fmt.Sprintf("web: #%d %s", ad.ID, ad.Name))
+			notice = fmt.Sprintf("Реклама #%d создана", ad.ID)
+		}
+	case "toggle":
+		var ad *domain.Ad
+		if ad, err = p.repo.GetAdByID(ctx, id); err == nil {
+			ad.IsActive = !ad.IsActive
+			if err = p.repo.UpdateAd(ctx, ad); err == nil {
+				p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditAdToggle, nil, fmt.Sprintf("web: #%d active=%t", id, ad.IsActive))
+				notice = fmt.Sprintf("Реклама #%d переключена", id)
+			}
+		}
+	case "target":
+		var ad *domain.Ad
+		if ad, err = p.repo.GetAdByID(ctx, id); err != nil {
+			break
+		}
+		if err = service.ApplyAdTargeting(ad, strings.Fields(r.PostFormValue("targeting"))); err != nil {
+			break
+		}
+		if err = p.repo.UpdateAd(ctx, ad); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditAdTarget, nil, fmt.Sprintf("web: #%d %s", id, ad.Targeting()))
+			notice = fmt.Sprintf("Таргетинг рекламы #%d: %s", id, ad.Targeting())
+		}
+	case "delete":
+		if err = p.repo.DeleteAd(ctx, id); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditAdDelete, nil, fmt.Sprintf("web: #%d", id))
+			notice = fmt.Sprintf("Реклама #%d удалена", id)
+		}
+	default:
+		err = errors.New("Неизвестное действие")
+	}
+
+	if err == nil {
+		p.adSvc.RefreshCache(ctx)
+	}
+	back(w, r, "/admin/ads", err, notice)
+}
+
+// formButton — необязательная кнопка из полей button_text и button_url
+func formButton(r *http.Request) (*string, *string) {
+	text := strings.TrimSpace(r.PostFormValue("button_text"))
+	link := strings.TrimSpace(r.PostFormValue("button_url"))
+	if text == "" || link == "" {
+		return nil, nil
+	}
+	return &text, &link
+}
+
+// ==================== BROADCASTS ====================
+
+func (p *AdminPanel) broadcastsPage(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	broadcasts, err := p.repo.GetAllBroadcasts(r.Context())
+	if err != nil {
+		log.Printf("Error loading broadcasts: %v", err)
+	}
+	p.render(w, r, http.StatusOK, "broadcasts.html", "Рассылки", sess, struct {
+		Broadcasts []*domain.Broadcast
+		Running    bool
+	}{broadcasts, p.broadcastSvc.IsRunning()})
+}
+
+func (p *AdminPanel) broadcastAction(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	if !postOnly(w, r) {
+		return
+	}
+	ctx := r.Context()
+	id, _ := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+
+	var err error
+	var notice string
+	switch r.PostFormValue("action") {
+	case "create":
+		b := &domain.Broadcast{
+			Name:   strings.TrimSpace(r.PostFormValue("name")),
+			Text:   strings.TrimSpace(r.PostFormValue("text")),
+			Status: domain.BroadcastDraft,
+		}
+		if b.Name == "" || b.Text == "" {
+			err = errors.New("Название и текст обязательны")
+			break
+		}
+		if b.Buttons, err = service.ParseButtons(r.PostFormValue("buttons")); err != nil {
+			break
+		}
+		base := b.AllVariants()[0]
+		if err = service.CheckVariant(&base); err != nil {
+			break
+		}
+		if b.Segment, err = service.ParseSegment(strings.Fields(r.PostFormValue("segment"))); err != nil {
+			break
+		}
+		if err = p.repo.CreateBroadcast(ctx, b); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastCreate, nil, fmt.Sprintf("web: #%d %s", b.ID, b.Name))
+			audience, _ := p.broadcastSvc.PreviewAudience(ctx, b.Segment)
+			notice = fmt.Sprintf("Рассылка #%d создана, получателей: %d", b.ID, audience)
+		}
+	case "test":
+		if err = p.broadcastSvc.SendTest(ctx, id, sess.TelegramID); err == nil {
+			notice = fmt.Sprintf("Рассылка #%d отправлена вам в Telegram", id)
+		}
+	case "segment":
+		var segment domain.BroadcastSegment
+		if segment, err = service.ParseSegment(strings.Fields(r.PostFormValue("segment"))); err != nil {
+			break
+		}
+		var audience int
+		if audience, err = p.broadcastSvc.SetSegment(ctx, id, segment); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastSegment, nil, fmt.Sprintf("web: #%d %s", id, segment.Describe()))
+			notice = fmt.Sprintf("Аудитория рассылки #%d: %s, получателей: %d", id, segment.Describe(), audience)
+		}
+	case "start":
+		// Рассылка живёт дольше HTTP-запроса, поэтому не используем его контекст
+		if err = p.broadcastSvc.StartBroadcast(context.Background(), id); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastStart, nil, fmt.Sprintf("web: #%d", id))
+			notice = fmt.Sprintf("Рассылка #%d запущена", id)
+		}
+	case "schedule":
+		var at time.Time
+		if at, err = time.ParseInLocation("2006-01-02T15:04", r.PostFormValue("scheduled_at"), time.Local); err != nil {
+			err = errors.New("Укажите дату и время запуска")
+			break
+		}
+		perUserLocal := r.PostFormValue("local") != ""
+		if err = p.broadcastSvc.Schedule(ctx, id, at, perUserLocal); err == nil {
+			label := at.Format("02.01.2006 15:04")
+			if perUserLocal {
+				label += " по времени пользователя"
+			}
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastSchedule, nil, fmt.Sprintf("web: #%d %s", id, label))
+			notice = fmt.Sprintf("Рассылка #%d запланирована: %s", id, label)
+		}
+	case "retry":
+		var failed int
+		if failed, err = p.broadcastSvc.RetryFailed(context.Background(), id); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastRetry, nil, fmt.Sprintf("web: #%d, %d получ.", id, failed))
+			notice = fmt.Sprintf("Повторная отправка рассылки #%d: %d получателей", id, failed)
+		}
+	case "unschedule":
+		if err = p.broadcastSvc.CancelSchedule(ctx, id); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastUnschedule, nil, fmt.Sprintf("web: #%d", id))
+			notice = fmt.Sprintf("Рассылка #%d снята с расписания", id)
+		}
+	case "stop":
+		if !p.broadcastSvc.IsRunning() {
+			err = errors.New("Нет активной рассылки")
+		} else {
+			p.broadcastSvc.StopBroadcast()
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastStop, nil, "web")
+			notice = "Рассылка остановлена"
+		}
+	case "resume":
+		if err = p.broadcastSvc.ResumeBroadcast(context.Background()); err == nil {
+			p.adminSvc.Audit(ctx, sess.TelegramID, domain.AuditBroadcastResume, nil, "web")
+			notice = "Рассылка продолжена"
+		}
+	default:
+		err = errors.New("Неизвестное действие")
+	}
+
+	back(w, r, "/admin/broadcasts", err, notice)
+}
+
+// ==================== AUDIT ====================
+
+func (p *AdminPanel) auditPage(w http.ResponseWriter, r *http.Request, sess *adminSession) {
+	entries, err := p.repo.GetAuditEntries(r.Context(), 200)
+	if err != nil {
+		log.Printf("Error loading audit log: %v", err)
+	}
+	p.render(w, r, http.StatusOK, "audit.html", "Журнал", sess, entries)
+}
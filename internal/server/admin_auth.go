@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "admin_session"
+	sessionTTL        = 12 * time.Hour
+	loginMaxAge       = 24 * time.Hour // сколько живут данные от Telegram Login Widget
+)
+
+var errInvalidLogin = errors.New("invalid telegram login")
+
+// verifyTelegramLogin — проверяет подпись Telegram Login Widget и возвращает Telegram ID.
+// https://core.telegram.org/widgets/login#checking-authorization
+func verifyTelegramLogin(query url.Values, botToken string, now time.Time) (int64, error) {
+	hash := query.Get("hash")
+	if hash == "" {
+		return 0, errInvalidLogin
+	}
+
+	var pairs []string
+	for key := range query {
+		if key == "hash" {
+			continue
+		}
+		pairs = append(pairs, key+"="+query.Get(key))
+	}
+	sort.Strings(pairs)
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(hash)) {
+		return 0, errInvalidLogin
+	}
+
+	authDate, err := strconv.ParseInt(query.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(authDate, 0)) > loginMaxAge {
+		return 0, errInvalidLogin
+	}
+
+	telegramID, err := strconv.ParseInt(query.Get("id"), 10, 64)
+	if err != nil {
+		return 0, errInvalidLogin
+	}
+	return telegramID, nil
+}
+
+// sessionSigner — подписывает cookie сессии и CSRF-токены ключом, производным от токена бота
+type sessionSigner struct {
+	key []byte
+}
+
+func newSessionSigner(botToken string) *sessionSigner {
+	key := sha256.Sum256([]byte("admin-panel-session:" + botToken))
+	return &sessionSigner{key: key[:]}
+}
+
+func (s *sessionSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issue — значение cookie: "telegramID.expiresUnix.подпись"
+func (s *sessionSigner) issue(telegramID int64, now time.Time) string {
+	payload := fmt.Sprintf("%d.%d", telegramID, now.Add(sessionTTL).Unix())
+	return payload + "." + s.sign(payload)
+}
+
+func (s *sessionSigner) parse(value string, now time.Time) (int64, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(s.sign(payload)), []byte(parts[2])) {
+		return 0, false
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return 0, false
+	}
+	telegramID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return telegramID, true
+}
+
+// csrfToken — токен форм, привязанный к конкретной сессии
+func (s *sessionSigner) csrfToken(session string) string {
+	return s.sign("csrf:" + session)[:32]
+}
+
+func (p *AdminPanel) setSession(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/admin",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   p.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
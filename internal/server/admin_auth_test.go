@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBotToken = "123456:test-token"
+
+// signedLogin — данные Telegram Login Widget с подписью, как их присылает Telegram
+func signedLogin(botToken string, fields map[string]string) url.Values {
+	var pairs []string
+	query := url.Values{}
+	for key, value := range fields {
+		pairs = append(pairs, key+"="+value)
+		query.Set(key, value)
+	}
+	sort.Strings(pairs)
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	query.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return query
+}
+
+func TestVerifyTelegramLogin(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	fields := func(authDate time.Time) map[string]string {
+		return map[string]string{
+			"id":         "42",
+			"first_name": "Ivan",
+			"username":   "ivan",
+			"auth_date":  strconv.FormatInt(authDate.Unix(), 10),
+		}
+	}
+
+	valid := signedLogin(testBotToken, fields(now.Add(-time.Hour)))
+
+	tampered := signedLogin(testBotToken, fields(now.Add(-time.Hour)))
+	tampered.Set("id", "43")
+
+	added := signedLogin(testBotToken, fields(now.Add(-time.Hour)))
+	added.Set("last_name", "Petrov")
+
+	noHash := signedLogin(testBotToken, fields(now.Add(-time.Hour)))
+	noHash.Del("hash")
+
+	tests := []struct {
+		name    string
+		query   url.Values
+		wantID  int64
+		wantErr bool
+	}{
+		{"valid", valid, 42, false},
+		{"tampered field", tampered, 0, true},
+		{"added field", added, 0, true},
+		{"other bot token", signedLogin("654321:other", fields(now.Add(-time.Hour))), 0, true},
+		{"expired auth_date", signedLogin(testBotToken, fields(now.Add(-loginMaxAge-time.Minute))), 0, true},
+		{"no hash", noHash, 0, true},
+		{"empty", url.Values{}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := verifyTelegramLogin(tt.query, testBotToken, now)
+			if (err != nil) != tt.wantErr || id != tt.wantID {
+				t.Errorf("verifyTelegramLogin() = %d, %v; want %d, error %v", id, err, tt.wantID, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSessionSigner(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signer := newSessionSigner(testBotToken)
+	session := signer.issue(42, now)
+	parts := strings.Split(session, ".")
+
+	tests := []struct {
+		name   string
+		value  string
+		at     time.Time
+		wantID int64
+		wantOK bool
+	}{
+		{"valid", session, now.Add(time.Hour), 42, true},
+		{"tampered id", "43." + parts[1] + "." + parts[2], now, 0, false},
+		{"extended expiry", parts[0] + "." + strconv.FormatInt(now.Add(48*time.Hour).Unix(), 10) + "." + parts[2], now, 0, false},
+		{"bad signature", session[:len(session)-1] + "0", now, 0, false},
+		{"other bot token", newSessionSigner("654321:other").issue(42, now), now, 0, false},
+		{"expired", session, now.Add(sessionTTL + time.Minute), 0, false},
+		{"malformed", "42." + parts[1], now, 0, false},
+		{"empty", "", now, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := signer.parse(tt.value, tt.at)
+			if ok != tt.wantOK || id != tt.wantID {
+				t.Errorf("parse(%q) = %d, %v; want %d, %v", tt.value, id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
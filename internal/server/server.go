@@ -17,6 +17,10 @@ type Server struct {
 	repo       repository.Repository
 	tinkoffSvc *service.TinkoffService
 	handlers   *telegram.Handlers
+	admin      *AdminPanel
+	tracker    *service.ClickTracker
+	clicks     *service.BroadcastService
+	adClicks   *service.AdService
 	port       string
 }
 
@@ -24,10 +28,28 @@ func NewServer(repo repository.Repository, tinkoffSvc *service.TinkoffService, h
 	return &Server{repo: repo, tinkoffSvc: tinkoffSvc, handlers: handlers, port: port}
 }
 
+// SetAdminPanel — подключает веб-админку на /admin/
+func (s *Server) SetAdminPanel(p *AdminPanel) {
+	s.admin = p
+}
+
+// SetClickTracking — подключает отслеживаемые редиректы кнопок рассылок и рекламы на /go/
+func (s *Server) SetClickTracking(tracker *service.ClickTracker, broadcastSvc *service.BroadcastService, adSvc *service.AdService) {
+	s.tracker = tracker
+	s.clicks = broadcastSvc
+	s.adClicks = adSvc
+}
+
 func (s *Server) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", s.healthHandler)
 	mux.HandleFunc("/tinkoff/webhook", s.tinkoffWebhookHandler)
+	if s.admin != nil {
+		s.admin.Register(mux)
+	}
+	if s.tracker.Enabled() {
+		mux.HandleFunc(service.ClickTrackPrefix, s.clickHandler)
+	}
 
 	server := &http.Server{Addr: ":" + s.port, Handler: mux}
 
@@ -40,6 +62,34 @@ func (s *Server) Start(ctx context.Context) error {
 	return server.ListenAndServe()
 }
 
+// clickHandler — засчитывает клик по подписанной ссылке и перенаправляет на адрес кнопки
+func (s *Server) clickHandler(w http.ResponseWriter, r *http.Request) {
+	kind, parts, ok := s.tracker.Parse(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var target string
+	var err error
+	switch kind {
+	case service.ClickKindBroadcast:
+		target, err = s.clicks.TrackClick(r.Context(), parts)
+	case service.ClickKindAd:
+		target, err = s.adClicks.TrackClick(r.Context(), parts)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error tracking click %s: %v", r.URL.Path, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
@@ -61,19 +111,18 @@ func (s *Server) tinkoffWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Tinkoff webhook: OrderId=%s, Status=%s", notification.OrderId, notification.Status)
 
 	ctx := context.Background()
-	if err := s.tinkoffSvc.ProcessNotification(ctx, &notification); err != nil {
+	applied, err := s.tinkoffSvc.ProcessNotification(ctx, &notification)
+	if err != nil {
 		log.Printf("Error processing notification: %v", err)
 		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
 
-	if notification.Status == "CONFIRMED" {
+	// Повторные уведомления о том же платеже не дублируют сообщение пользователю
+	if applied {
 		payment, err := s.tinkoffSvc.GetPaymentByOrderID(ctx, notification.OrderId)
 		if err == nil {
-			user, err := s.repo.GetUserByID(ctx, payment.UserID)
-			if err == nil {
-				s.handlers.NotifyPaymentSuccess(user.TelegramID)
-			}
+			s.handlers.NotifyPaymentConfirmed(payment)
 		}
 	}
 
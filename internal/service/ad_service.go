@@ -2,23 +2,35 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/format"
 	"habit-tracker-bot/internal/repository"
 )
 
 type AdService struct {
 	repo       repository.Repository
+	tracker    *ClickTracker
+	frequency  int // реклама показывается бесплатным пользователям раз в столько действий
 	cache      []*domain.Ad
 	lastUpdate time.Time
 	mu         sync.RWMutex
+	notify     func(ad *domain.Ad) error // рекламодателю: оплаченные показы закончились
 }
 
-func NewAdService(repo repository.Repository) *AdService {
-	return &AdService{repo: repo}
+func NewAdService(repo repository.Repository, tracker *ClickTracker, frequency int) *AdService {
+	return &AdService{repo: repo, tracker: tracker, frequency: frequency}
+}
+
+func (s *AdService) SetNotifyFunc(fn func(ad *domain.Ad) error) {
+	s.notify = fn
 }
 
 func (s *AdService) RefreshCache(ctx context.Context) error {
@@ -48,7 +60,7 @@ func (s *AdService) ShouldShowAd(ctx context.Context, userID int64) (bool, error
 		return false, err
 	}
 
-	if count >= domain.AdFrequency {
+	if count >= s.frequency {
 		s.repo.ResetActionCount(ctx, userID)
 		return true, nil
 	}
@@ -56,7 +68,9 @@ func (s *AdService) ShouldShowAd(ctx context.Context, userID int64) (bool, error
 	return false, nil
 }
 
-func (s *AdService) GetRandomAd(ctx context.Context) *domain.Ad {
+// PickAd — взвешенный по приоритету выбор среди реклам, подходящих пользователю:
+// аудитория совпадает, лимит показов не исчерпан и по рекламе он ещё не кликал
+func (s *AdService) PickAd(ctx context.Context, userID int64) *domain.Ad {
 	s.mu.RLock()
 	needRefresh := time.Since(s.lastUpdate) > 5*time.Minute || len(s.cache) == 0
 	s.mu.RUnlock()
@@ -65,33 +79,216 @@ func (s *AdService) GetRandomAd(ctx context.Context) *domain.Ad {
 		s.RefreshCache(ctx)
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	if len(s.cache) == 0 {
+	viewer, err := s.repo.GetAdViewer(ctx, userID)
+	if err != nil {
+		log.Printf("Error loading ad viewer %d: %v", userID, err)
 		return nil
 	}
 
+	now := time.Now()
+	var candidates []*domain.Ad
 	totalWeight := 0
+
+	s.mu.RLock()
 	for _, ad := range s.cache {
+		imp := viewer.Impressions[ad.ID]
+		if imp.Clicked || (ad.MaxPerUser > 0 && imp.Views >= ad.MaxPerUser) {
+			continue
+		}
+		if !ad.Audience.Matches(viewer, now) {
+			continue
+		}
+		candidates = append(candidates, ad)
 		totalWeight += ad.Priority + 1
 	}
+	s.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return nil
+	}
 
 	r := rand.Intn(totalWeight)
-	for _, ad := range s.cache {
+	for _, ad := range candidates {
 		r -= ad.Priority + 1
 		if r < 0 {
 			return ad
 		}
 	}
 
-	return s.cache[0]
+	return candidates[0]
+}
+
+// TrackView — засчитывает показ; на последнем оплаченном показе реклама снимается
+// с показа и рекламодатель получает уведомление
+func (s *AdService) TrackView(ctx context.Context, adID, userID int64) {
+	if err := s.repo.RecordAdView(ctx, adID, userID); err != nil {
+		log.Printf("Error recording ad view: %v", err)
+	}
+
+	exhausted, err := s.repo.IncrementAdViews(ctx, adID)
+	if err != nil {
+		log.Printf("Error counting ad view: %v", err)
+		return
+	}
+	if !exhausted {
+		return
+	}
+
+	ok, err := s.repo.SetAdStatus(ctx, adID, domain.AdStatusActive, domain.AdStatusCompleted, "")
+	if err != nil || !ok {
+		return
+	}
+	s.RefreshCache(ctx)
+
+	ad, err := s.repo.GetAdByID(ctx, adID)
+	if err == nil && ad.AdvertiserID != nil && s.notify != nil {
+		if err := s.notify(ad); err != nil {
+			log.Printf("Error notifying advertiser of ad %d: %v", adID, err)
+		}
+	}
+}
+
+// ButtonLink — адрес кнопки рекламы для пользователя: через отслеживаемый редирект,
+// если он включён, иначе напрямую (тогда клики не учитываются)
+func (s *AdService) ButtonLink(ad *domain.Ad, userID int64) string {
+	if ad.ButtonURL == nil || *ad.ButtonURL == "" {
+		return ""
+	}
+	if !s.tracker.Enabled() {
+		return *ad.ButtonURL
+	}
+	return s.tracker.Link(ClickKindAd, strconv.FormatInt(ad.ID, 10), strconv.FormatInt(userID, 10))
+}
+
+// TrackClick — засчитывает клик по ссылке a/ID рекламы/ID пользователя и возвращает
+// адрес кнопки. Повторные клики того же пользователя не считаются.
+func (s *AdService) TrackClick(ctx context.Context, parts []string) (string, error) {
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid ad link")
+	}
+	adID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", err
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	ad, err := s.repo.GetAdByID(ctx, adID)
+	if err != nil {
+		return "", err
+	}
+	if ad.ButtonURL == nil || *ad.ButtonURL == "" {
+		return "", fmt.Errorf("ad %d has no button", adID)
+	}
+
+	first, err := s.repo.RecordAdClick(ctx, adID, userID)
+	if err != nil {
+		log.Printf("Error recording ad click: %v", err)
+	} else if first {
+		if err := s.repo.IncrementAdClicks(ctx, adID); err != nil {
+			log.Printf("Error counting ad click: %v", err)
+		}
+	}
+	return *ad.ButtonURL, nil
+}
+
+// DailyStats — показы, клики и CTR рекламы по дням
+func (s *AdService) DailyStats(ctx context.Context, adID int64, days int) ([]domain.AdDailyStat, error) {
+	return s.repo.GetAdDailyStats(ctx, adID, days)
 }
 
-func (s *AdService) TrackView(ctx context.Context, adID int64) {
-	s.repo.IncrementAdViews(ctx, adID)
+// CheckAd — разметка текста рекламы и лимит длины: с вложением текст идёт подписью
+func CheckAd(ad *domain.Ad) error {
+	if err := format.Validate(ad.Text); err != nil {
+		return err
+	}
+	length := format.Length(ad.Text)
+	if ad.HasMedia() && length > domain.MaxCaptionLength {
+		return fmt.Errorf("подпись к медиа не длиннее %d символов, сейчас %d", domain.MaxCaptionLength, length)
+	}
+	if length > domain.MaxMessageLength {
+		return fmt.Errorf("текст не длиннее %d символов, сейчас %d", domain.MaxMessageLength, length)
+	}
+	return nil
 }
 
-func (s *AdService) TrackClick(ctx context.Context, adID int64) {
-	s.repo.IncrementAdClicks(ctx, adID)
+// AdTargetingUsage — синтаксис таргетинга рекламы для админки
+const AdTargetingUsage = `Таргетинг (опции через пробел, меняются только указанные):
+  new=7 — зарегистрировались за последние 7 дней
+  inactive=3 — не отмечали привычки 3 дня
+  emoji=🏃,📚 — есть привычка с одним из эмодзи
+  все — снять ограничения аудитории
+  cap=3 — не больше 3 показов одному пользователю (0 — без лимита)
+  from=2026-10-20 to=2026-10-31 — период показа включительно ("-" — без даты)`
+
+// ApplyAdTargeting — меняет у рекламы аудиторию, лимит и период по опциям вида "new=7 cap=3"
+func ApplyAdTargeting(ad *domain.Ad, opts []string) error {
+	for _, opt := range opts {
+		if lower := strings.ToLower(opt); lower == "все" || lower == "all" {
+			ad.Audience = domain.AdAudience{}
+			continue
+		}
+
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || value == "" {
+			return fmt.Errorf("Неизвестная опция: %s", opt)
+		}
+
+		key = strings.ToLower(key)
+		switch key {
+		case "new", "inactive":
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 0 || days > 365 {
+				return fmt.Errorf("%s — от 0 до 365 дней", key)
+			}
+			if key == "new" {
+				ad.Audience.NewDays = days
+			} else {
+				ad.Audience.InactiveDays = days
+			}
+		case "emoji":
+			ad.Audience.Emojis = nil
+			if value == "-" {
+				continue
+			}
+			for _, emoji := range strings.Split(value, ",") {
+				if emoji = strings.TrimSpace(emoji); emoji != "" {
+					ad.Audience.Emojis = append(ad.Audience.Emojis, emoji)
+				}
+			}
+		case "cap":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return fmt.Errorf("cap — число показов, 0 — без лимита")
+			}
+			ad.MaxPerUser = limit
+		case "from", "to":
+			var date *time.Time
+			if value != "-" {
+				d, err := time.ParseInLocation("2006-01-02", value, time.Local)
+				if err != nil {
+					return fmt.Errorf("Неверная дата: %s", value)
+				}
+				date = &d
+			}
+			if key == "from" {
+				ad.StartDate = date
+			} else {
+				if date != nil {
+					end := date.AddDate(0, 0, 1)
+					date = &end
+				}
+				ad.EndDate = date
+			}
+		default:
+			return fmt.Errorf("Неизвестная опция: %s", opt)
+		}
+	}
+
+	if ad.StartDate != nil && ad.EndDate != nil && !ad.StartDate.Before(*ad.EndDate) {
+		return fmt.Errorf("Дата окончания раньше даты начала")
+	}
+	return nil
 }
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+)
+
+var (
+	ErrInvalidDays = errors.New("Количество дней от 1 до 3650")
+	ErrBanSelf     = errors.New("Нельзя заблокировать самого себя")
+)
+
+// MaxAdminGrantDays — сколько дней Premium можно начислить или списать за одно действие
+const MaxAdminGrantDays = 3650
+
+// AdminService — действия администраторов над пользователями с записью в журнал.
+// Используется и командами бота, и веб-админкой.
+type AdminService struct {
+	repo     repository.Repository
+	habitSvc *HabitService
+}
+
+func NewAdminService(repo repository.Repository, habitSvc *HabitService) *AdminService {
+	return &AdminService{repo: repo, habitSvc: habitSvc}
+}
+
+// FindUser — пользователь по Telegram ID или @username
+func (s *AdminService) FindUser(ctx context.Context, ref string) (*domain.User, error) {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "@") {
+		return s.repo.GetUserByUsername(ctx, strings.TrimPrefix(ref, "@"))
+	}
+	telegramID, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return s.repo.GetUserByUsername(ctx, ref)
+	}
+	return s.repo.GetUserByTelegramID(ctx, telegramID)
+}
+
+// Audit — записывает действие администратора в журнал. Ошибка записи только логируется,
+// чтобы сбой журнала не отменял уже выполненное действие.
+func (s *AdminService) Audit(ctx context.Context, adminID int64, action domain.AuditAction, target *domain.User, details string) {
+	entry := &domain.AuditEntry{
+		AdminTelegramID: adminID,
+		Action:          action,
+		Details:         details,
+	}
+	if target != nil {
+		entry.TargetUserID = &target.ID
+	}
+	if err := s.repo.CreateAuditEntry(ctx, entry); err != nil {
+		log.Printf("Error writing admin audit (%s): %v", action, err)
+	}
+}
+
+func (s *AdminService) GrantDays(ctx context.Context, adminID int64, user *domain.User, days int) error {
+	if days < 1 || days > MaxAdminGrantDays {
+		return ErrInvalidDays
+	}
+	if err := s.repo.AddSubscriptionDays(ctx, user.ID, days); err != nil {
+		return err
+	}
+	s.Audit(ctx, adminID, domain.AuditGrantDays, user, fmt.Sprintf("%d дн.", days))
+	s.syncHabitLocks(ctx, user.ID)
+	return nil
+}
+
+func (s *AdminService) RevokeDays(ctx context.Context, adminID int64, user *domain.User, days int) error {
+	if days < 1 || days > MaxAdminGrantDays {
+		return ErrInvalidDays
+	}
+	if err := s.repo.RevokeSubscriptionDays(ctx, user.ID, days); err != nil {
+		return err
+	}
+	s.Audit(ctx, adminID, domain.AuditRevokeDays, user, fmt.Sprintf("%d дн.", days))
+	s.syncHabitLocks(ctx, user.ID)
+	return nil
+}
+
+// ResetDiscount — обнуляет реферальную скидку и снимает активный промокод
+func (s *AdminService) ResetDiscount(ctx context.Context, adminID int64, user *domain.User) error {
+	if err := s.repo.ResetDiscount(ctx, user.ID); err != nil {
+		return err
+	}
+	_ = s.repo.ClearUserActivePromocode(ctx, user.ID)
+	s.Audit(ctx, adminID, domain.AuditResetDiscount, user, fmt.Sprintf("было %d%%", user.DiscountPercent))
+	return nil
+}
+
+// SetBanned — блокировка и разблокировка. Заблокированный пользователь не получает ответов,
+// напоминаний и рассылок.
+func (s *AdminService) SetBanned(ctx context.Context, adminID int64, user *domain.User, banned bool) error {
+	if banned && user.TelegramID == adminID {
+		return ErrBanSelf
+	}
+	if err := s.repo.SetUserBanned(ctx, user.ID, banned); err != nil {
+		return err
+	}
+	action := domain.AuditUnban
+	if banned {
+		action = domain.AuditBan
+	}
+	s.Audit(ctx, adminID, action, user, "")
+	s.syncHabitLocks(ctx, user.ID)
+	return nil
+}
+
+// syncHabitLocks — приводит блокировки привычек к подписке после действия админа
+func (s *AdminService) syncHabitLocks(ctx context.Context, userID int64) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Error getting user %d: %v", userID, err)
+		return
+	}
+	if _, err := s.habitSvc.SyncHabitLocks(ctx, user); err != nil {
+		log.Printf("Error syncing habit locks for user %d: %v", userID, err)
+	}
+}
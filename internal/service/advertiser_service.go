@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+)
+
+var (
+	ErrAdPackageNotFound = errors.New("неизвестный пакет показов")
+	ErrAdNotOnModeration = errors.New("реклама не на модерации")
+)
+
+// AdvertiserService — самостоятельное размещение рекламы: рекламодатель оплачивает пакет
+// показов, админ одобряет объявление, AdService показывает его до конца пакета
+type AdvertiserService struct {
+	repo       repository.Repository
+	tinkoffSvc *TinkoffService
+	adSvc      *AdService
+}
+
+func NewAdvertiserService(repo repository.Repository, tinkoffSvc *TinkoffService, adSvc *AdService) *AdvertiserService {
+	return &AdvertiserService{repo: repo, tinkoffSvc: tinkoffSvc, adSvc: adSvc}
+}
+
+// CreateOrder — сохраняет объявление, ожидающее оплаты, и создаёт платёж за пакет.
+// Объявление создаётся первым: оплаченный платёж всегда есть к чему привязать.
+func (s *AdvertiserService) CreateOrder(ctx context.Context, advertiser *domain.User, ad *domain.Ad, packageCode string) (*domain.Payment, error) {
+	pkg := domain.GetAdPackage(packageCode)
+	if pkg == nil {
+		return nil, ErrAdPackageNotFound
+	}
+	if err := CheckAd(ad); err != nil {
+		return nil, err
+	}
+
+	ad.AdvertiserID = &advertiser.ID
+	ad.Status = domain.AdStatusPendingPayment
+	ad.ImpressionsLimit = pkg.Impressions
+	ad.IsActive = false
+	ad.Priority = 1
+	if err := s.repo.CreateAd(ctx, ad); err != nil {
+		return nil, fmt.Errorf("save ad: %w", err)
+	}
+
+	description := fmt.Sprintf("Реклама в боте #%d: %d показов", ad.ID, pkg.Impressions)
+	payment, err := s.tinkoffSvc.CreatePlanPayment(ctx, advertiser.TelegramID, pkg.Price, description, domain.PaymentPurposeAd, 0)
+	if err != nil {
+		s.repo.DeleteAd(ctx, ad.ID)
+		return nil, fmt.Errorf("create payment: %w", err)
+	}
+
+	if err := s.repo.SetAdOrderID(ctx, ad.ID, payment.OrderID); err != nil {
+		return nil, fmt.Errorf("link ad payment: %w", err)
+	}
+	ad.OrderID = &payment.OrderID
+
+	return payment, nil
+}
+
+func (s *AdvertiserService) GetAd(ctx context.Context, id int64) (*domain.Ad, error) {
+	return s.repo.GetAdByID(ctx, id)
+}
+
+func (s *AdvertiserService) GetAdByOrderID(ctx context.Context, orderID string) (*domain.Ad, error) {
+	return s.repo.GetAdByOrderID(ctx, orderID)
+}
+
+// AdvertiserAds — оплаченные объявления рекламодателя со статистикой
+func (s *AdvertiserService) AdvertiserAds(ctx context.Context, userID int64) ([]*domain.Ad, error) {
+	return s.repo.GetAdvertiserAds(ctx, userID)
+}
+
+// ModerationQueue — оплаченные объявления, ждущие решения админа
+func (s *AdvertiserService) ModerationQueue(ctx context.Context) ([]*domain.Ad, error) {
+	return s.repo.GetAdsByStatus(ctx, domain.AdStatusModeration)
+}
+
+// Approve — запускает показы объявления
+func (s *AdvertiserService) Approve(ctx context.Context, id int64) (*domain.Ad, error) {
+	return s.moderate(ctx, id, domain.AdStatusActive, "")
+}
+
+// Reject — отклоняет объявление с причиной для рекламодателя
+func (s *AdvertiserService) Reject(ctx context.Context, id int64, reason string) (*domain.Ad, error) {
+	return s.moderate(ctx, id, domain.AdStatusRejected, reason)
+}
+
+func (s *AdvertiserService) moderate(ctx context.Context, id int64, status domain.AdStatus, reason string) (*domain.Ad, error) {
+	ok, err := s.repo.SetAdStatus(ctx, id, domain.AdStatusModeration, status, reason)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrAdNotOnModeration
+	}
+	if status == domain.AdStatusActive {
+		s.adSvc.RefreshCache(ctx)
+	}
+	return s.repo.GetAdByID(ctx, id)
+}
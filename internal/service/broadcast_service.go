@@ -2,44 +2,152 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/format"
 	"habit-tracker-bot/internal/repository"
 )
 
+const (
+	broadcastBatchSize = 25
+	broadcastLeaseTTL  = 2 * time.Minute
+)
+
+var (
+	ErrBroadcastLeased    = errors.New("рассылка уже идёт на другом экземпляре бота")
+	ErrBroadcastCompleted = errors.New("рассылка уже завершена")
+)
+
 type BroadcastService struct {
-	repo      repository.Repository
-	bot       *tgbotapi.BotAPI
-	mu        sync.Mutex
-	isRunning bool
-	stopChan  chan struct{}
+	repo       repository.Repository
+	bot        *tgbotapi.BotAPI
+	tracker    *ClickTracker
+	sender     *Sender
+	instanceID string // владелец аренды рассылки в БД
+	mu         sync.Mutex
+	isRunning  bool
+	shutdown   bool
+	stopChan   chan struct{}
+	done       chan struct{}
 }
 
-func NewBroadcastService(repo repository.Repository, bot *tgbotapi.BotAPI) *BroadcastService {
+func NewBroadcastService(repo repository.Repository, bot *tgbotapi.BotAPI, tracker *ClickTracker, sender *Sender) *BroadcastService {
 	return &BroadcastService{
-		repo:     repo,
-		bot:      bot,
-		stopChan: make(chan struct{}),
+		repo:       repo,
+		bot:        bot,
+		tracker:    tracker,
+		sender:     sender,
+		instanceID: newInstanceID(),
+		stopChan:   make(chan struct{}),
 	}
 }
 
+// newInstanceID — уникальный идентификатор процесса: имя хоста и случайный суффикс
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// StartBroadcast — запускает черновик, запланированную или прерванную рассылку.
+// Завершённую повторно не отправляет: для недошедших есть RetryFailed.
 func (s *BroadcastService) StartBroadcast(ctx context.Context, broadcastID int64) error {
+	b, err := s.repo.GetBroadcastByID(ctx, broadcastID)
+	if err != nil {
+		return fmt.Errorf("рассылка не найдена")
+	}
+	if !b.Status.CanLaunch() {
+		return ErrBroadcastCompleted
+	}
+	return s.launch(ctx, broadcastID, s.runBroadcast)
+}
+
+// RetryFailed — повторяет отправку тем, кому рассылка не дошла из-за временной ошибки.
+// Возвращает число таких получателей.
+func (s *BroadcastService) RetryFailed(ctx context.Context, broadcastID int64) (int, error) {
+	b, err := s.repo.GetBroadcastByID(ctx, broadcastID)
+	if err != nil {
+		return 0, fmt.Errorf("рассылка не найдена")
+	}
+	if b.Status != domain.BroadcastCompleted && b.Status != domain.BroadcastPaused {
+		return 0, fmt.Errorf("повторить можно только завершённую или приостановленную рассылку")
+	}
+
+	stats, err := s.repo.GetBroadcastDeliveryStats(ctx, broadcastID)
+	if err != nil {
+		return 0, err
+	}
+	failed := stats[domain.DeliveryFailed]
+	if failed == 0 {
+		return 0, fmt.Errorf("нет получателей с ошибкой доставки")
+	}
+	return failed, s.launch(ctx, broadcastID, s.runRetry)
+}
+
+// launch — берёт аренду рассылки и запускает run в фоне. Одновременно в процессе
+// работает одна рассылка.
+func (s *BroadcastService) launch(ctx context.Context, broadcastID int64, run func(ctx context.Context, b *domain.Broadcast, stop chan struct{})) error {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.isRunning {
-		s.mu.Unlock()
 		return fmt.Errorf("рассылка уже запущена")
 	}
+	// Остановленный запуск завершается не сразу: пока он не отпустил аренду, новый не начинаем
+	if s.done != nil {
+		select {
+		case <-s.done:
+		default:
+			return fmt.Errorf("предыдущая рассылка ещё останавливается, попробуйте через несколько секунд")
+		}
+	}
+	broadcast, err := s.repo.GetBroadcastByID(ctx, broadcastID)
+	if err != nil {
+		return fmt.Errorf("рассылка не найдена")
+	}
+	ok, err := s.repo.AcquireBroadcastLease(ctx, broadcastID, s.instanceID, broadcastLeaseTTL)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBroadcastLeased
+	}
+
 	s.isRunning = true
-	s.stopChan = make(chan struct{})
-	s.mu.Unlock()
+	s.shutdown = false
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	s.stopChan = stop
+	s.done = done
 
-	go s.runBroadcast(ctx, broadcastID)
+	go func() {
+		defer close(done)
+		defer func() {
+			s.mu.Lock()
+			if s.stopChan == stop {
+				s.isRunning = false
+			}
+			s.mu.Unlock()
+		}()
+		defer s.repo.ReleaseBroadcastLease(ctx, broadcastID, s.instanceID)
+
+		run(ctx, broadcast, stop)
+	}()
 	return nil
 }
 
@@ -53,102 +161,328 @@ func (s *BroadcastService) StopBroadcast() {
 	}
 }
 
+// Shutdown — прерывает рассылку при остановке процесса, не меняя статус running:
+// после перезапуска она продолжится автоматически
+func (s *BroadcastService) Shutdown(timeout time.Duration) {
+	s.mu.Lock()
+	if !s.isRunning {
+		s.mu.Unlock()
+		return
+	}
+	s.shutdown = true
+	close(s.stopChan)
+	s.isRunning = false
+	done := s.done
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Broadcast did not stop in %s", timeout)
+	}
+}
+
 func (s *BroadcastService) IsRunning() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	return s.isRunning
 }
 
-func (s *BroadcastService) runBroadcast(ctx context.Context, broadcastID int64) {
-	defer func() {
-		s.mu.Lock()
-		s.isRunning = false
-		s.mu.Unlock()
-	}()
+func (s *BroadcastService) runBroadcast(ctx context.Context, broadcast *domain.Broadcast, stop chan struct{}) {
+	broadcastID := broadcast.ID
 
-	broadcast, err := s.repo.GetBroadcastByID(ctx, broadcastID)
+	// TotalUsers — размер сегмента; при продолжении пересчитывается с учётом новых пользователей
+	totalUsers, err := s.repo.CountBroadcastAudience(ctx, &broadcast.Segment)
 	if err != nil {
-		log.Printf("Broadcast error: %v", err)
-		return
+		log.Printf("Error counting broadcast audience: %v", err)
+	}
+
+	// Локальная рассылка идёт волнами: каждая волна — пояса, где уже наступило нужное время
+	segment := broadcast.Segment
+	if broadcast.PerUserLocal {
+		if len(broadcast.WaveTimezones) == 0 {
+			due, _, err := s.splitTimezones(ctx, broadcast, time.Now())
+			if err != nil {
+				log.Printf("Broadcast %d: error getting timezones: %v", broadcastID, err)
+				return
+			}
+			if len(due) == 0 {
+				log.Printf("Broadcast %d: no timezones due yet", broadcastID)
+				return
+			}
+			started, err := s.repo.StartBroadcastWave(ctx, broadcastID, due, totalUsers)
+			if err != nil {
+				log.Printf("Broadcast %d: error starting wave: %v", broadcastID, err)
+				return
+			}
+			if !started {
+				log.Printf("Broadcast %d: already completed, not starting", broadcastID)
+				return
+			}
+			broadcast.WaveTimezones = due
+			broadcast.DoneTimezones = append(broadcast.DoneTimezones, due...)
+			broadcast.LastUserID = 0
+		} else {
+			started, err := s.repo.ResumeBroadcastWave(ctx, broadcastID)
+			if err != nil || !started {
+				log.Printf("Broadcast %d: not resuming wave (completed or error: %v)", broadcastID, err)
+				return
+			}
+		}
+		segment.Timezones = broadcast.WaveTimezones
+	} else {
+		started, err := s.repo.StartBroadcast(ctx, broadcastID, totalUsers)
+		if err != nil || !started {
+			log.Printf("Broadcast %d: not starting (completed or error: %v)", broadcastID, err)
+			return
+		}
 	}
 
-	totalUsers, _ := s.repo.GetTotalUsersCount(ctx)
-	s.repo.StartBroadcast(ctx, broadcastID, totalUsers)
+	sendCtx, cancel := s.sendContext(ctx, broadcastID, stop)
+	defer cancel()
 
 	lastUserID := broadcast.LastUserID
 	sentCount := broadcast.SentCount
 	failedCount := broadcast.FailedCount
-	batchSize := 25
 
-	for {
-		select {
-		case <-s.stopChan:
-			s.repo.UpdateBroadcastStatus(ctx, broadcastID, domain.BroadcastPaused)
-			log.Printf("Broadcast %d paused at user %d", broadcastID, lastUserID)
-			return
-		default:
-		}
-
-		userIDs, maxID, err := s.repo.GetUsersForBroadcast(ctx, lastUserID, batchSize)
+	for sendCtx.Err() == nil {
+		recipients, err := s.repo.GetUsersForBroadcast(ctx, &segment, lastUserID, broadcastBatchSize)
 		if err != nil {
 			log.Printf("Error getting users: %v", err)
 			time.Sleep(time.Second)
 			continue
 		}
 
-		if len(userIDs) == 0 {
+		if len(recipients) == 0 {
+			if broadcast.PerUserLocal {
+				_, waiting, err := s.splitTimezones(ctx, broadcast, time.Now())
+				if err != nil || waiting > 0 {
+					s.repo.FinishBroadcastWave(ctx, broadcastID)
+					log.Printf("Broadcast %d wave %v done: sent=%d, failed=%d", broadcastID, broadcast.WaveTimezones, sentCount, failedCount)
+					return
+				}
+			}
 			s.repo.CompleteBroadcast(ctx, broadcastID)
 			log.Printf("Broadcast %d completed: sent=%d, failed=%d", broadcastID, sentCount, failedCount)
 			return
 		}
 
-		for _, telegramID := range userIDs {
-			err := s.sendBroadcastMessage(telegramID, broadcast)
+		for _, rc := range recipients {
+			err := s.deliver(ctx, sendCtx, broadcast, rc)
+			if errors.Is(err, context.Canceled) {
+				break
+			}
 			if err != nil {
 				failedCount++
-				log.Printf("Failed to send to %d: %v", telegramID, err)
 			} else {
 				sentCount++
 			}
-			time.Sleep(40 * time.Millisecond)
+			lastUserID = rc.UserID
 		}
 
-		lastUserID = maxID
 		s.repo.UpdateBroadcastProgress(ctx, broadcastID, sentCount, failedCount, lastUserID)
 	}
+
+	select {
+	case <-stop:
+		s.mu.Lock()
+		shutdown := s.shutdown
+		s.mu.Unlock()
+		if shutdown {
+			log.Printf("Broadcast %d interrupted by shutdown at user %d", broadcastID, lastUserID)
+			return
+		}
+		s.repo.UpdateBroadcastStatus(ctx, broadcastID, domain.BroadcastPaused)
+		log.Printf("Broadcast %d paused at user %d", broadcastID, lastUserID)
+	default:
+		log.Printf("Broadcast %d: lease lost at user %d", broadcastID, lastUserID)
+	}
 }
 
-func (s *BroadcastService) sendBroadcastMessage(telegramID int64, broadcast *domain.Broadcast) error {
-	var msg tgbotapi.Chattable
+// runRetry — повторная отправка получателям с ошибкой доставки. Статус рассылки не меняется.
+func (s *BroadcastService) runRetry(ctx context.Context, broadcast *domain.Broadcast, stop chan struct{}) {
+	sendCtx, cancel := s.sendContext(ctx, broadcast.ID, stop)
+	defer cancel()
 
-	if broadcast.ImageURL != nil && *broadcast.ImageURL != "" {
-		photo := tgbotapi.NewPhoto(telegramID, tgbotapi.FileURL(*broadcast.ImageURL))
-		photo.Caption = broadcast.Text
-		photo.ParseMode = "Markdown"
-		if broadcast.ButtonText != nil && broadcast.ButtonURL != nil && *broadcast.ButtonText != "" && *broadcast.ButtonURL != "" {
-			photo.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonURL(*broadcast.ButtonText, *broadcast.ButtonURL),
-				),
-			)
+	var lastUserID int64
+	delivered, failed := 0, 0
+	for sendCtx.Err() == nil {
+		recipients, err := s.repo.GetFailedDeliveries(ctx, broadcast.ID, lastUserID, broadcastBatchSize)
+		if err != nil {
+			log.Printf("Error getting failed deliveries: %v", err)
+			break
 		}
-		msg = photo
-	} else {
-		textMsg := tgbotapi.NewMessage(telegramID, broadcast.Text)
-		textMsg.ParseMode = "Markdown"
-		if broadcast.ButtonText != nil && broadcast.ButtonURL != nil && *broadcast.ButtonText != "" && *broadcast.ButtonURL != "" {
-			textMsg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonURL(*broadcast.ButtonText, *broadcast.ButtonURL),
-				),
-			)
+		if len(recipients) == 0 {
+			break
+		}
+
+		for _, rc := range recipients {
+			err := s.deliver(ctx, sendCtx, broadcast, rc)
+			if errors.Is(err, context.Canceled) {
+				break
+			}
+			if err != nil {
+				failed++
+			} else {
+				delivered++
+			}
+			lastUserID = rc.UserID
+		}
+	}
+
+	if err := s.repo.AddBroadcastRetried(ctx, broadcast.ID, delivered); err != nil {
+		log.Printf("Error updating broadcast %d counters: %v", broadcast.ID, err)
+	}
+	log.Printf("Broadcast %d retry finished: delivered=%d, failed=%d", broadcast.ID, delivered, failed)
+}
+
+// sendContext — контекст отправки, который отменяется остановкой рассылки или потерей аренды
+func (s *BroadcastService) sendContext(ctx context.Context, broadcastID int64, stop chan struct{}) (context.Context, context.CancelFunc) {
+	sendCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-sendCtx.Done():
 		}
-		msg = textMsg
+	}()
+	go s.keepLease(sendCtx, broadcastID, cancel)
+	return sendCtx, cancel
+}
+
+// keepLease — продлевает аренду, пока идёт рассылка. Если аренду забрал другой экземпляр,
+// отменяет отправку.
+func (s *BroadcastService) keepLease(ctx context.Context, broadcastID int64, lost context.CancelFunc) {
+	ticker := time.NewTicker(broadcastLeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := s.repo.AcquireBroadcastLease(ctx, broadcastID, s.instanceID, broadcastLeaseTTL)
+			if err != nil {
+				log.Printf("Broadcast %d: error renewing lease: %v", broadcastID, err)
+				continue
+			}
+			if !ok {
+				lost()
+				return
+			}
+		}
+	}
+}
+
+// deliver — отправляет рассылку получателю и пишет результат в журнал доставки.
+// Пользователь, заблокировавший бота, помечается и больше не получает сообщений.
+func (s *BroadcastService) deliver(ctx, sendCtx context.Context, broadcast *domain.Broadcast, rc domain.BroadcastRecipient) error {
+	variant := broadcast.VariantFor(rc.UserID)
+	err := s.send(sendCtx, rc.TelegramID, s.buildMessage(broadcast.ID, variant, rc))
+	if errors.Is(err, context.Canceled) {
+		return err
 	}
 
-	_, err := s.bot.Send(msg)
+	status, errText := domain.DeliverySent, ""
+	if err != nil {
+		log.Printf("Failed to send to %d: %v", rc.TelegramID, err)
+		status, errText = domain.DeliveryFailed, err.Error()
+		if IsChatUnreachable(err) {
+			status = domain.DeliveryBlocked
+			if markErr := s.repo.MarkUserBlocked(ctx, rc.UserID); markErr != nil {
+				log.Printf("Error marking user %d blocked: %v", rc.UserID, markErr)
+			}
+		}
+	}
+	if recErr := s.repo.RecordBroadcastDelivery(ctx, broadcast.ID, rc.UserID, variant.Label, status, errText); recErr != nil {
+		log.Printf("Error recording delivery to %d: %v", rc.TelegramID, recErr)
+	}
 	return err
 }
+
+// IsChatUnreachable — пользователь заблокировал бота, удалил аккаунт или чата не существует:
+// повторять отправку бессмысленно
+func IsChatUnreachable(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		return false
+	}
+	return tgErr.Code == http.StatusForbidden || strings.Contains(tgErr.Message, "chat not found")
+}
+
+// send — отправка с учётом общих лимитов. context.Canceled означает, что сообщение не отправлялось.
+func (s *BroadcastService) send(ctx context.Context, telegramID int64, msg tgbotapi.Chattable) error {
+	return s.sender.Send(ctx, telegramID, func() error {
+		_, err := s.bot.Send(msg)
+		return err
+	})
+}
+
+// buildMessage — сообщение варианта рассылки для получателя. Кнопки ведут через
+// отслеживаемый редирект, если он включён.
+func (s *BroadcastService) buildMessage(broadcastID int64, v domain.BroadcastVariant, rc domain.BroadcastRecipient) tgbotapi.Chattable {
+	link := func(index int, btn domain.BroadcastButton) string {
+		if !s.tracker.Enabled() {
+			return btn.URL
+		}
+		return s.tracker.Link(ClickKindBroadcast, strconv.FormatInt(broadcastID, 10), v.Label,
+			strconv.FormatInt(rc.UserID, 10), strconv.Itoa(index))
+	}
+	return variantMessage(rc.TelegramID, v, link)
+}
+
+// variantMessage — сообщение с медиа и клавиатурой варианта. link возвращает адрес
+// кнопки по её порядковому номеру; nil — исходные адреса.
+func variantMessage(chatID int64, v domain.BroadcastVariant, link func(int, domain.BroadcastButton) string) tgbotapi.Chattable {
+	var markup interface{}
+	if rows := v.Keyboard(); len(rows) > 0 {
+		keyboard := tgbotapi.InlineKeyboardMarkup{}
+		index := 0
+		for _, row := range rows {
+			var buttons []tgbotapi.InlineKeyboardButton
+			for _, btn := range row {
+				href := btn.URL
+				if link != nil {
+					href = link(index, btn)
+				}
+				buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonURL(btn.Text, href))
+				index++
+			}
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, buttons)
+		}
+		markup = keyboard
+	}
+	return MediaMessage(chatID, v.Text, v.MediaType, v.MediaFileID, v.ImageURL, markup)
+}
+
+// ResumeInterrupted — продолжает рассылку, оставшуюся в статусе running после падения или
+// перезапуска. Вызывается при старте и планировщиком: рассылку упавшего экземпляра
+// другой подхватит, когда истечёт аренда.
+func (s *BroadcastService) ResumeInterrupted(ctx context.Context) {
+	if s.IsRunning() {
+		return
+	}
+
+	broadcast, err := s.repo.GetRunningBroadcast(ctx)
+	if errors.Is(err, repository.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting running broadcast: %v", err)
+		return
+	}
+
+	err = s.StartBroadcast(ctx, broadcast.ID)
+	switch {
+	case err == nil:
+		log.Printf("Broadcast %d resumed from user %d", broadcast.ID, broadcast.LastUserID)
+	case errors.Is(err, ErrBroadcastLeased):
+		// рассылку ведёт другой экземпляр
+	default:
+		log.Printf("Error resuming broadcast %d: %v", broadcast.ID, err)
+	}
+}
+
 func (s *BroadcastService) ResumeBroadcast(ctx context.Context) error {
 	broadcast, err := s.repo.GetRunningBroadcast(ctx)
 	if err != nil {
@@ -167,3 +501,429 @@ func (s *BroadcastService) ResumeBroadcast(ctx context.Context) error {
 
 	return s.StartBroadcast(ctx, broadcast.ID)
 }
+
+// ==================== A/B-ТЕСТ ====================
+
+// AddVariant — добавляет к черновику вариант для A/B-теста, возвращает его метку
+func (s *BroadcastService) AddVariant(ctx context.Context, broadcastID int64, v *domain.BroadcastVariant) (string, error) {
+	b, err := s.repo.GetBroadcastByID(ctx, broadcastID)
+	if err != nil {
+		return "", fmt.Errorf("рассылка не найдена")
+	}
+	if b.Status != domain.BroadcastDraft {
+		return "", fmt.Errorf("варианты можно менять только у черновика")
+	}
+	if len(b.AllVariants()) >= domain.MaxBroadcastVariants {
+		return "", fmt.Errorf("не больше %d вариантов в рассылке", domain.MaxBroadcastVariants)
+	}
+	if err := CheckVariant(v); err != nil {
+		return "", err
+	}
+
+	v.Label = b.NextVariantLabel()
+	ok, err := s.repo.AddBroadcastVariant(ctx, broadcastID, v)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("варианты можно менять только у черновика")
+	}
+	return v.Label, nil
+}
+
+func (s *BroadcastService) DeleteVariant(ctx context.Context, broadcastID int64, label string) error {
+	label = strings.ToUpper(label)
+	if label == domain.BaseVariantLabel {
+		return fmt.Errorf("вариант A — сама рассылка, его нельзя удалить")
+	}
+	ok, err := s.repo.DeleteBroadcastVariant(ctx, broadcastID, label)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("вариант %s не найден или рассылка уже не черновик", label)
+	}
+	return nil
+}
+
+// TrackClick — клик по отслеживаемой кнопке рассылки. parts — ID рассылки, вариант,
+// ID пользователя и номер кнопки из подписанной ссылки (в ссылках до появления
+// нескольких кнопок номера нет). Возвращает адрес кнопки для редиректа.
+func (s *BroadcastService) TrackClick(ctx context.Context, parts []string) (string, error) {
+	if len(parts) != 3 && len(parts) != 4 {
+		return "", fmt.Errorf("invalid broadcast link")
+	}
+	index := 0
+	if len(parts) == 4 {
+		var err error
+		if index, err = strconv.Atoi(parts[3]); err != nil {
+			return "", err
+		}
+	}
+	broadcastID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", err
+	}
+	userID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := s.repo.GetBroadcastByID(ctx, broadcastID)
+	if err != nil {
+		return "", err
+	}
+	v := b.Variant(parts[1])
+	if v == nil {
+		return "", fmt.Errorf("broadcast %d has no variant %s", broadcastID, parts[1])
+	}
+	btn := v.Button(index)
+	if btn == nil {
+		return "", fmt.Errorf("broadcast %d has no button %d for variant %s", broadcastID, index, parts[1])
+	}
+
+	if err := s.repo.RecordBroadcastClick(ctx, broadcastID, v.Label, userID); err != nil {
+		log.Printf("Error recording broadcast click: %v", err)
+	}
+	return btn.URL, nil
+}
+
+// SendTest — присылает админу рассылку так, как её увидят получатели, по сообщению на
+// вариант. Клики по тестовым кнопкам не учитываются.
+func (s *BroadcastService) SendTest(ctx context.Context, broadcastID, chatID int64) error {
+	b, err := s.repo.GetBroadcastByID(ctx, broadcastID)
+	if err != nil {
+		return fmt.Errorf("рассылка не найдена")
+	}
+
+	variants := b.AllVariants()
+	for _, v := range variants {
+		if len(variants) > 1 {
+			note := tgbotapi.NewMessage(chatID, fmt.Sprintf("🧪 Тест рассылки #%d, вариант %s:", b.ID, v.Label))
+			if _, err := s.bot.Send(note); err != nil {
+				return err
+			}
+		}
+		if _, err := s.bot.Send(variantMessage(chatID, v, nil)); err != nil {
+			return fmt.Errorf("вариант %s не отправился: %w", v.Label, err)
+		}
+	}
+	return nil
+}
+
+// UpdateContent — сохраняет изменённые название, текст, вложение и кнопки черновика
+func (s *BroadcastService) UpdateContent(ctx context.Context, b *domain.Broadcast) error {
+	base := b.AllVariants()[0]
+	if err := CheckVariant(&base); err != nil {
+		return err
+	}
+	updated, err := s.repo.UpdateBroadcastContent(ctx, b)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("изменять можно только черновик")
+	}
+	return nil
+}
+
+// CheckVariant — разметка текста корректна и он помещается в сообщение: у медиа подпись
+// короче обычного текста
+func CheckVariant(v *domain.BroadcastVariant) error {
+	if err := format.Validate(v.Text); err != nil {
+		return err
+	}
+	length := format.Length(v.Text)
+	if v.HasMedia() && length > domain.MaxCaptionLength {
+		return fmt.Errorf("подпись к медиа не длиннее %d символов, сейчас %d", domain.MaxCaptionLength, length)
+	}
+	if length > domain.MaxMessageLength {
+		return fmt.Errorf("текст не длиннее %d символов, сейчас %d", domain.MaxMessageLength, length)
+	}
+	return nil
+}
+
+// Ограничения клавиатуры рассылки
+const (
+	maxButtonRows   = 10
+	maxButtonsInRow = 8
+)
+
+// ButtonsUsage — формат кнопок для админки
+const ButtonsUsage = `Кнопки: каждая строка — ряд, кнопки в ряду через ";"
+  Подробнее|https://example.com
+  Да|https://example.com/yes; Нет|https://example.com/no
+"нет" — без кнопок`
+
+// ParseButtons — разбирает клавиатуру из строк вида "Текст|url; Текст|url".
+// "нет" или "-" — без кнопок.
+func ParseButtons(text string) ([][]domain.BroadcastButton, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.EqualFold(text, "нет") || text == "-" {
+		return nil, nil
+	}
+
+	var rows [][]domain.BroadcastButton
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var row []domain.BroadcastButton
+		for _, item := range strings.Split(line, ";") {
+			parts := strings.SplitN(item, "|", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("кнопка %q: нужен формат Текст|url", strings.TrimSpace(item))
+			}
+			btn := domain.BroadcastButton{Text: strings.TrimSpace(parts[0]), URL: strings.TrimSpace(parts[1])}
+			if btn.Text == "" {
+				return nil, fmt.Errorf("у кнопки %q нет текста", btn.URL)
+			}
+			if u, err := url.Parse(btn.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tg") || (u.Host == "" && u.Opaque == "") {
+				return nil, fmt.Errorf("кнопка %q: неверная ссылка %q", btn.Text, btn.URL)
+			}
+			row = append(row, btn)
+		}
+		if len(row) > maxButtonsInRow {
+			return nil, fmt.Errorf("не больше %d кнопок в ряду", maxButtonsInRow)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) > maxButtonRows {
+		return nil, fmt.Errorf("не больше %d рядов кнопок", maxButtonRows)
+	}
+	return rows, nil
+}
+
+// PreviewAudience — размер аудитории сегмента до запуска рассылки
+func (s *BroadcastService) PreviewAudience(ctx context.Context, seg domain.BroadcastSegment) (int, error) {
+	return s.repo.CountBroadcastAudience(ctx, &seg)
+}
+
+// SetSegment — меняет аудиторию черновика и возвращает её размер
+func (s *BroadcastService) SetSegment(ctx context.Context, broadcastID int64, seg domain.BroadcastSegment) (int, error) {
+	updated, err := s.repo.UpdateBroadcastSegment(ctx, broadcastID, seg)
+	if err != nil {
+		return 0, err
+	}
+	if !updated {
+		return 0, fmt.Errorf("сегмент можно менять только у черновика")
+	}
+	return s.PreviewAudience(ctx, seg)
+}
+
+// SegmentUsage — синтаксис сегмента для админки
+const SegmentUsage = `Сегмент (опции через пробел, "все" — без ограничений):
+  premium / free — только Premium / только бесплатные
+  inactive=7 — не отмечали привычки 7 дней
+  from=2026-01-01 to=2026-01-31 — дата регистрации (включительно)
+  referrals — пригласили хотя бы одного друга
+  tz=Europe/Moscow,Asia/Almaty — часовой пояс
+  lang=ru,en — язык Telegram`
+
+// ParseSegment — разбирает сегмент из опций вида "free inactive=7 lang=ru"
+func ParseSegment(opts []string) (domain.BroadcastSegment, error) {
+	var seg domain.BroadcastSegment
+	for _, opt := range opts {
+		switch strings.ToLower(opt) {
+		case "все", "all":
+			continue
+		case "premium":
+			premium := true
+			seg.Premium = &premium
+			continue
+		case "free":
+			premium := false
+			seg.Premium = &premium
+			continue
+		case "referrals":
+			seg.HasReferrals = true
+			continue
+		}
+
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || value == "" {
+			return seg, fmt.Errorf("Неизвестная опция: %s", opt)
+		}
+
+		switch strings.ToLower(key) {
+		case "inactive":
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 1 || days > 365 {
+				return seg, fmt.Errorf("inactive — от 1 до 365 дней")
+			}
+			seg.InactiveDays = days
+		case "from", "to":
+			date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return seg, fmt.Errorf("Неверная дата: %s", value)
+			}
+			if key == "from" {
+				seg.RegisteredFrom = &date
+			} else {
+				end := date.AddDate(0, 0, 1)
+				seg.RegisteredTo = &end
+			}
+		case "tz":
+			for _, tz := range strings.Split(value, ",") {
+				if _, err := time.LoadLocation(tz); err != nil {
+					return seg, fmt.Errorf("Неизвестный часовой пояс: %s", tz)
+				}
+				seg.Timezones = append(seg.Timezones, tz)
+			}
+		case "lang":
+			for _, lang := range strings.Split(strings.ToLower(value), ",") {
+				seg.Languages = append(seg.Languages, strings.TrimSpace(lang))
+			}
+		default:
+			return seg, fmt.Errorf("Неизвестная опция: %s", opt)
+		}
+	}
+
+	if seg.RegisteredFrom != nil && seg.RegisteredTo != nil && !seg.RegisteredFrom.Before(*seg.RegisteredTo) {
+		return seg, fmt.Errorf("Дата окончания раньше даты начала")
+	}
+	return seg, nil
+}
+
+// ==================== РАСПИСАНИЕ ====================
+
+// Крайние смещения часовых поясов: локальная рассылка начинается с UTC+14 и заканчивается на UTC-12
+const (
+	maxUTCOffset = 14 * time.Hour
+	minUTCOffset = -12 * time.Hour
+)
+
+// ScheduleLayout — формат времени запуска в командах админки (время сервера)
+const ScheduleLayout = "2006-01-02 15:04"
+
+// ParseSchedule — разбирает "2026-10-20 10:00 [local]". local (или "локально") — время
+// на часах каждого пользователя, иначе — время сервера.
+func ParseSchedule(args []string) (time.Time, bool, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return time.Time{}, false, fmt.Errorf("формат: ГГГГ-ММ-ДД ЧЧ:ММ [local]")
+	}
+	at, err := time.ParseInLocation(ScheduleLayout, args[0]+" "+args[1], time.Local)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("неверная дата или время: %s %s", args[0], args[1])
+	}
+	if len(args) == 3 {
+		switch strings.ToLower(args[2]) {
+		case "local", "локально":
+			return at, true, nil
+		default:
+			return time.Time{}, false, fmt.Errorf("неизвестная опция: %s", args[2])
+		}
+	}
+	return at, false, nil
+}
+
+// Schedule — планирует черновик или переносит ещё не начатую запланированную рассылку.
+// При perUserLocal учитывается только показание часов at, без пояса.
+func (s *BroadcastService) Schedule(ctx context.Context, broadcastID int64, at time.Time, perUserLocal bool) error {
+	now := time.Now()
+	if perUserLocal {
+		at = time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+		if !now.Before(at.Add(-minUTCOffset)) {
+			return fmt.Errorf("это время уже прошло во всех часовых поясах")
+		}
+	} else if !now.Before(at) {
+		return fmt.Errorf("время запуска уже прошло")
+	}
+
+	ok, err := s.repo.ScheduleBroadcast(ctx, broadcastID, at, perUserLocal)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("запланировать можно только черновик или ещё не начатую рассылку")
+	}
+	return nil
+}
+
+func (s *BroadcastService) CancelSchedule(ctx context.Context, broadcastID int64) error {
+	ok, err := s.repo.CancelBroadcastSchedule(ctx, broadcastID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("рассылка #%d не запланирована", broadcastID)
+	}
+	return nil
+}
+
+// RunScheduled — запускает наступившие запланированные рассылки. Вызывается планировщиком
+// раз в минуту; одновременно идёт одна рассылка, остальные дождутся следующего запуска.
+// Заодно подхватывает прерванную рассылку.
+func (s *BroadcastService) RunScheduled(ctx context.Context) {
+	s.ResumeInterrupted(ctx)
+	if s.IsRunning() {
+		return
+	}
+
+	now := time.Now()
+	broadcasts, err := s.repo.GetScheduledBroadcasts(ctx, now.Add(maxUTCOffset))
+	if err != nil {
+		log.Printf("Error getting scheduled broadcasts: %v", err)
+		return
+	}
+
+	for _, b := range broadcasts {
+		if b.PerUserLocal {
+			due, waiting, err := s.splitTimezones(ctx, b, now)
+			if err != nil {
+				log.Printf("Broadcast %d: error getting timezones: %v", b.ID, err)
+				continue
+			}
+			if len(due) == 0 {
+				// Все пояса уже получили рассылку или время прошло везде, а получателей нет
+				if waiting == 0 && (len(b.DoneTimezones) > 0 || !now.Before(b.ScheduledAt.UTC().Add(-minUTCOffset))) {
+					s.repo.CompleteBroadcast(ctx, b.ID)
+				}
+				continue
+			}
+		} else if b.ScheduledAt.After(now) {
+			continue
+		}
+
+		if err := s.StartBroadcast(ctx, b.ID); err != nil {
+			log.Printf("Error starting scheduled broadcast %d: %v", b.ID, err)
+		} else {
+			log.Printf("Scheduled broadcast %d started", b.ID)
+		}
+		return
+	}
+}
+
+// splitTimezones — пояса аудитории локальной рассылки, ещё не получившие её: в скольких
+// время уже наступило (due) и сколько ждут своего часа (waiting)
+func (s *BroadcastService) splitTimezones(ctx context.Context, b *domain.Broadcast, now time.Time) ([]string, int, error) {
+	zones, err := s.repo.GetBroadcastAudienceTimezones(ctx, &b.Segment)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	done := make(map[string]bool, len(b.DoneTimezones))
+	for _, tz := range b.DoneTimezones {
+		done[tz] = true
+	}
+
+	wall := b.ScheduledAt.UTC()
+	var due []string
+	waiting := 0
+	for _, tz := range zones {
+		if done[tz] {
+			continue
+		}
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			loc = time.Local
+		}
+		target := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, loc)
+		if now.Before(target) {
+			waiting++
+		} else {
+			due = append(due, tz)
+		}
+	}
+	return due, waiting, nil
+}
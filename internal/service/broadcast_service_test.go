@@ -0,0 +1,81 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"habit-tracker-bot/internal/domain"
+)
+
+func TestParseSegment(t *testing.T) {
+	yes, no := true, false
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	to := time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		opts    []string
+		want    domain.BroadcastSegment
+		wantErr bool
+	}{
+		{name: "empty", opts: nil},
+		{name: "all", opts: []string{"все"}},
+		{name: "premium", opts: []string{"Premium"}, want: domain.BroadcastSegment{Premium: &yes}},
+		{name: "free inactive", opts: []string{"free", "inactive=7"}, want: domain.BroadcastSegment{Premium: &no, InactiveDays: 7}},
+		{name: "dates inclusive", opts: []string{"from=2026-01-01", "to=2026-01-31"}, want: domain.BroadcastSegment{RegisteredFrom: &from, RegisteredTo: &to}},
+		{name: "referrals", opts: []string{"referrals"}, want: domain.BroadcastSegment{HasReferrals: true}},
+		{name: "timezones", opts: []string{"tz=Europe/Moscow,Asia/Almaty"}, want: domain.BroadcastSegment{Timezones: []string{"Europe/Moscow", "Asia/Almaty"}}},
+		{name: "languages", opts: []string{"lang=RU,en"}, want: domain.BroadcastSegment{Languages: []string{"ru", "en"}}},
+		{name: "inactive zero", opts: []string{"inactive=0"}, wantErr: true},
+		{name: "inactive too long", opts: []string{"inactive=366"}, wantErr: true},
+		{name: "bad date", opts: []string{"from=2026-13-01"}, wantErr: true},
+		{name: "reversed dates", opts: []string{"from=2026-02-01", "to=2026-01-01"}, wantErr: true},
+		{name: "unknown timezone", opts: []string{"tz=Mars/Base"}, wantErr: true},
+		{name: "unknown option", opts: []string{"vip"}, wantErr: true},
+		{name: "empty value", opts: []string{"lang="}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSegment(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSegment(%v) error = %v, wantErr %v", tt.opts, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSegment(%v) = %+v, want %+v", tt.opts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	at := time.Date(2026, 10, 20, 10, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name      string
+		args      []string
+		want      time.Time
+		wantLocal bool
+		wantErr   bool
+	}{
+		{name: "server time", args: []string{"2026-10-20", "10:00"}, want: at},
+		{name: "local", args: []string{"2026-10-20", "10:00", "local"}, want: at, wantLocal: true},
+		{name: "local in russian", args: []string{"2026-10-20", "10:00", "Локально"}, want: at, wantLocal: true},
+		{name: "no time", args: []string{"2026-10-20"}, wantErr: true},
+		{name: "too many args", args: []string{"2026-10-20", "10:00", "local", "x"}, wantErr: true},
+		{name: "bad time", args: []string{"2026-10-20", "25:00"}, wantErr: true},
+		{name: "bad date", args: []string{"20.10.2026", "10:00"}, wantErr: true},
+		{name: "unknown option", args: []string{"2026-10-20", "10:00", "utc"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, local, err := ParseSchedule(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) || local != tt.wantLocal {
+				t.Errorf("ParseSchedule(%v) = %v, %v; want %v, %v", tt.args, got, local, tt.want, tt.wantLocal)
+			}
+		})
+	}
+}
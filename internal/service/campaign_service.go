@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+)
+
+// PromoOffer — персональное предложение, которое уходит пользователю
+type PromoOffer struct {
+	Campaign  domain.PromoCampaign
+	Promocode *domain.Promocode
+}
+
+// CampaignService — автоматические промо-кампании: первое предложение
+// через несколько дней после регистрации и еженедельное напоминание
+type CampaignService struct {
+	repo   repository.Repository
+	sender *Sender
+	notify func(user *domain.User, offer *PromoOffer) error
+}
+
+func NewCampaignService(repo repository.Repository, sender *Sender) *CampaignService {
+	return &CampaignService{repo: repo, sender: sender}
+}
+
+func (s *CampaignService) SetNotifyFunc(fn func(user *domain.User, offer *PromoOffer) error) {
+	s.notify = fn
+}
+
+func (s *CampaignService) RunCampaigns(ctx context.Context) {
+	if s.notify == nil {
+		return
+	}
+
+	now := time.Now()
+
+	firstUsers, err := s.repo.GetUsersForFirstPromo(ctx, now.AddDate(0, 0, -domain.FirstPromoDelayDays), domain.PromoCampaignBatch)
+	if err != nil {
+		log.Printf("Error getting users for first promo: %v", err)
+	}
+	for _, user := range firstUsers {
+		s.sendOffer(ctx, user, domain.PromoCampaignFirst)
+	}
+
+	weeklyUsers, err := s.repo.GetUsersForWeeklyPromo(ctx, now.AddDate(0, 0, -domain.WeeklyPromoInterval), domain.PromoCampaignBatch)
+	if err != nil {
+		log.Printf("Error getting users for weekly promo: %v", err)
+	}
+	for _, user := range weeklyUsers {
+		s.sendOffer(ctx, user, domain.PromoCampaignWeekly)
+	}
+}
+
+func (s *CampaignService) sendOffer(ctx context.Context, user *domain.User, campaign domain.PromoCampaign) {
+	promo := newCampaignPromocode(user, campaign)
+	if err := s.repo.CreatePromocode(ctx, promo); err != nil {
+		log.Printf("Error creating %s promocode for user %d: %v", campaign, user.ID, err)
+		return
+	}
+
+	// Отмечаем до отправки: лучше пропустить одно предложение, чем прислать два
+	var err error
+	if campaign == domain.PromoCampaignFirst {
+		err = s.repo.MarkFirstPromoSent(ctx, user.ID)
+	} else {
+		err = s.repo.MarkWeeklyPromoSent(ctx, user.ID)
+	}
+	if err != nil {
+		log.Printf("Error marking %s promo for user %d: %v", campaign, user.ID, err)
+		return
+	}
+
+	if err := s.repo.CreateCampaignSend(ctx, user.ID, campaign, promo.ID); err != nil {
+		log.Printf("Error saving %s promo send for user %d: %v", campaign, user.ID, err)
+	}
+
+	// Применяем сразу, если у пользователя нет другого промокода
+	if active, _ := s.repo.GetUserActivePromocode(ctx, user.ID); active == nil {
+		s.repo.SetUserActivePromocode(ctx, user.ID, promo.ID)
+	}
+
+	err = s.sender.Send(ctx, user.TelegramID, func() error {
+		return s.notify(user, &PromoOffer{Campaign: campaign, Promocode: promo})
+	})
+	if err != nil {
+		log.Printf("Error sending %s promo to %d: %v", campaign, user.TelegramID, err)
+		if IsChatUnreachable(err) {
+			s.repo.MarkUserBlocked(ctx, user.ID)
+		}
+	}
+}
+
+func newCampaignPromocode(user *domain.User, campaign domain.PromoCampaign) *domain.Promocode {
+	maxUses := 1
+	endsAt := time.Now().AddDate(0, 0, domain.PromoOfferValidDays)
+	ownerID := user.ID
+
+	promo := &domain.Promocode{
+		MaxUses:     &maxUses,
+		EndsAt:      &endsAt,
+		OwnerUserID: &ownerID,
+		Campaign:    campaign,
+	}
+
+	if campaign == domain.PromoCampaignFirst {
+		promo.Code = domain.GeneratePromoCode("HELLO")
+		promo.DiscountPercent = domain.FirstPromoDiscount
+		promo.FirstPaymentOnly = true
+	} else {
+		promo.Code = domain.GeneratePromoCode("BACK")
+		promo.DiscountPercent = domain.WeeklyPromoDiscount
+	}
+	return promo
+}
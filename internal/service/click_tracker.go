@@ -0,0 +1,63 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ClickTrackPrefix — путь отслеживаемых редиректов на HTTP-сервере
+const ClickTrackPrefix = "/go/"
+
+// Виды отслеживаемых ссылок
+const (
+	ClickKindBroadcast = "b" // b/ID рассылки/вариант/ID пользователя
+	ClickKindAd        = "a" // a/ID рекламы/ID пользователя
+)
+
+// ClickTracker — подписанные ссылки для учёта кликов по кнопкам. Кнопка ведёт на наш
+// сервер, клик засчитывается, и пользователь уходит на адрес, сохранённый в БД.
+// Подпись не даёт накрутить клики чужим пользователям.
+type ClickTracker struct {
+	baseURL string
+	key     []byte
+}
+
+// NewClickTracker — без BASE_URL отслеживание выключено и кнопки ведут прямо на адрес
+func NewClickTracker(baseURL, botToken string) *ClickTracker {
+	key := sha256.Sum256([]byte("click-tracker:" + botToken))
+	return &ClickTracker{baseURL: strings.TrimRight(baseURL, "/"), key: key[:]}
+}
+
+func (t *ClickTracker) Enabled() bool {
+	return t != nil && t.baseURL != ""
+}
+
+// Link — ссылка вида BASE_URL/go/KIND/ЧАСТЬ/.../ПОДПИСЬ
+func (t *ClickTracker) Link(kind string, parts ...string) string {
+	path := kind + "/" + strings.Join(parts, "/")
+	return t.baseURL + ClickTrackPrefix + path + "/" + t.sign(path)
+}
+
+// Parse — проверяет подпись пути после /go/ и возвращает вид ссылки и её части
+func (t *ClickTracker) Parse(path string) (string, []string, bool) {
+	path = strings.TrimPrefix(path, ClickTrackPrefix)
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "", nil, false
+	}
+	payload, sig := path[:i], path[i+1:]
+	if !hmac.Equal([]byte(t.sign(payload)), []byte(sig)) {
+		return "", nil, false
+	}
+
+	parts := strings.Split(payload, "/")
+	return parts[0], parts[1:], true
+}
+
+func (t *ClickTracker) sign(payload string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
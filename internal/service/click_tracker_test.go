@@ -0,0 +1,39 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClickTrackerParse(t *testing.T) {
+	tracker := NewClickTracker("https://bot.example.com/", "token")
+	link := tracker.Link(ClickKindBroadcast, "12", "1", "345")
+	path := strings.TrimPrefix(link, "https://bot.example.com")
+	foreign := strings.TrimPrefix(NewClickTracker("https://bot.example.com", "other").Link(ClickKindBroadcast, "12", "1", "345"), "https://bot.example.com")
+
+	tests := []struct {
+		name      string
+		path      string
+		wantKind  string
+		wantParts []string
+		wantOK    bool
+	}{
+		{"valid", path, ClickKindBroadcast, []string{"12", "1", "345"}, true},
+		{"without prefix", strings.TrimPrefix(path, ClickTrackPrefix), ClickKindBroadcast, []string{"12", "1", "345"}, true},
+		{"ad link", strings.TrimPrefix(tracker.Link(ClickKindAd, "7", "8"), "https://bot.example.com"), ClickKindAd, []string{"7", "8"}, true},
+		{"changed user", strings.Replace(path, "/345/", "/346/", 1), "", nil, false},
+		{"bad signature", path[:len(path)-1] + "0", "", nil, false},
+		{"other token", foreign, "", nil, false},
+		{"no signature", ClickTrackPrefix + "b", "", nil, false},
+		{"empty", "", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, parts, ok := tracker.Parse(tt.path)
+			if ok != tt.wantOK || kind != tt.wantKind || !reflect.DeepEqual(parts, tt.wantParts) {
+				t.Errorf("Parse(%q) = %q, %v, %v; want %q, %v, %v", tt.path, kind, parts, ok, tt.wantKind, tt.wantParts, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+)
+
+var (
+	ErrFamilyNeedsPlan = errors.New("нет оплаченного семейного тарифа")
+	ErrFamilyNotFound  = errors.New("семейная группа по приглашению не найдена")
+	ErrFamilyFull      = errors.New("семейная группа заполнена")
+	ErrAlreadyInFamily = errors.New("пользователь уже состоит в семейной группе")
+	ErrFamilyOwnerJoin = errors.New("владелец семейной группы вступает в другую")
+	ErrFamilyJoinOwn   = errors.New("вступление в собственную семейную группу")
+	ErrNotFamilyMember = errors.New("пользователь не состоит в группе")
+)
+
+type FamilyService struct {
+	repo       repository.Repository
+	maxMembers int // сколько участников (кроме владельца) можно добавить в группу
+}
+
+func NewFamilyService(repo repository.Repository, maxMembers int) *FamilyService {
+	return &FamilyService{repo: repo, maxMembers: maxMembers}
+}
+
+// MaxMembers — лимит участников семейной группы (без владельца)
+func (s *FamilyService) MaxMembers() int {
+	return s.maxMembers
+}
+
+// GetOrCreateGroup — семейная группа владельца, создаётся при первом обращении
+func (s *FamilyService) GetOrCreateGroup(ctx context.Context, owner *domain.User) (*domain.FamilyGroup, error) {
+	group, err := s.repo.GetFamilyGroupByOwner(ctx, owner.ID)
+	if err == nil {
+		return group, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	if !owner.HasFamilyPlan() {
+		return nil, ErrFamilyNeedsPlan
+	}
+	if _, err := s.repo.GetFamilyGroupByMember(ctx, owner.ID); err == nil {
+		return nil, ErrAlreadyInFamily
+	}
+
+	group = &domain.FamilyGroup{OwnerID: owner.ID, InviteCode: domain.GenerateReferralCode()}
+	if err := s.repo.CreateFamilyGroup(ctx, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *FamilyService) GetOwnedGroup(ctx context.Context, ownerID int64) (*domain.FamilyGroup, error) {
+	return s.repo.GetFamilyGroupByOwner(ctx, ownerID)
+}
+
+func (s *FamilyService) GetMemberGroup(ctx context.Context, userID int64) (*domain.FamilyGroup, error) {
+	return s.repo.GetFamilyGroupByMember(ctx, userID)
+}
+
+func (s *FamilyService) GetMembers(ctx context.Context, groupID int64) ([]*domain.User, error) {
+	return s.repo.GetFamilyMembers(ctx, groupID)
+}
+
+func (s *FamilyService) GetInviteLink(group *domain.FamilyGroup, botUsername string) string {
+	return fmt.Sprintf("https://t.me/%s?start=family_%s", botUsername, group.InviteCode)
+}
+
+// Join — вступление в группу по коду приглашения
+func (s *FamilyService) Join(ctx context.Context, code string, user *domain.User) (*domain.FamilyGroup, error) {
+	group, err := s.repo.GetFamilyGroupByInviteCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrFamilyNotFound
+		}
+		return nil, err
+	}
+
+	if group.OwnerID == user.ID {
+		return nil, ErrFamilyJoinOwn
+	}
+	if _, err := s.repo.GetFamilyGroupByOwner(ctx, user.ID); err == nil {
+		return nil, ErrFamilyOwnerJoin
+	}
+	if _, err := s.repo.GetFamilyGroupByMember(ctx, user.ID); err == nil {
+		return nil, ErrAlreadyInFamily
+	}
+
+	added, err := s.repo.AddFamilyMember(ctx, group.ID, user.ID, s.maxMembers)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrFamilyNotFound
+	}
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		return nil, ErrAlreadyInFamily
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ErrFamilyFull
+	}
+	return group, nil
+}
+
+// RemoveMember — владелец исключает участника. Ссылка-приглашение
+// перевыпускается, чтобы исключённый не смог вернуться по старой.
+func (s *FamilyService) RemoveMember(ctx context.Context, owner *domain.User, memberID int64) (*domain.FamilyGroup, error) {
+	group, err := s.repo.GetFamilyGroupByOwner(ctx, owner.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberGroup, err := s.repo.GetFamilyGroupByMember(ctx, memberID)
+	if err != nil || memberGroup.ID != group.ID {
+		return nil, ErrNotFamilyMember
+	}
+
+	if err := s.repo.RemoveFamilyMember(ctx, group.ID, memberID); err != nil {
+		return nil, err
+	}
+
+	group.InviteCode = domain.GenerateReferralCode()
+	if err := s.repo.UpdateFamilyInviteCode(ctx, group.ID, group.InviteCode); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// Leave — участник сам выходит из группы
+func (s *FamilyService) Leave(ctx context.Context, user *domain.User) (*domain.FamilyGroup, error) {
+	group, err := s.repo.GetFamilyGroupByMember(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.RemoveFamilyMember(ctx, group.ID, user.ID); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// Disband — владелец удаляет группу, участники теряют доступ.
+// Возвращает бывших участников для уведомления.
+func (s *FamilyService) Disband(ctx context.Context, owner *domain.User) ([]*domain.User, error) {
+	group, err := s.repo.GetFamilyGroupByOwner(ctx, owner.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.repo.GetFamilyMembers(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.DeleteFamilyGroup(ctx, group.ID); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
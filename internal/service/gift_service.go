@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+)
+
+var (
+	ErrGiftNotFound        = errors.New("подарок не найден")
+	ErrGiftNotPaid         = errors.New("подарок ещё не оплачен")
+	ErrGiftAlreadyRedeemed = errors.New("подарок уже активирован")
+	ErrGiftOwn             = errors.New("попытка активировать собственный подарок")
+	ErrInvalidPlan         = errors.New("неизвестный тариф")
+)
+
+type GiftService struct {
+	repo       repository.Repository
+	subSvc     *SubscriptionService
+	tinkoffSvc *TinkoffService
+}
+
+func NewGiftService(repo repository.Repository, subSvc *SubscriptionService, tinkoffSvc *TinkoffService) *GiftService {
+	return &GiftService{repo: repo, subSvc: subSvc, tinkoffSvc: tinkoffSvc}
+}
+
+// CreateGift — создаёт платёж за тариф и неоплаченный подарок к нему
+func (s *GiftService) CreateGift(ctx context.Context, buyer *domain.User, planCode string) (*domain.Gift, *domain.Payment, error) {
+	plan := domain.GetPlan(planCode)
+	if plan == nil {
+		return nil, nil, ErrInvalidPlan
+	}
+
+	description := fmt.Sprintf("Подарок Premium: %s", plan.Title)
+	payment, err := s.tinkoffSvc.CreatePlanPayment(ctx, buyer.TelegramID, s.subSvc.GetPlanPrice(plan), description, domain.PaymentPurposeGift, plan.Days)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create payment: %w", err)
+	}
+
+	gift := &domain.Gift{
+		Code:     domain.GenerateGiftCode(),
+		BuyerID:  buyer.ID,
+		PlanCode: plan.Code,
+		Days:     plan.Days,
+		OrderID:  payment.OrderID,
+		Status:   domain.GiftPending,
+	}
+	if err := s.repo.CreateGift(ctx, gift); err != nil {
+		return nil, nil, fmt.Errorf("save gift: %w", err)
+	}
+
+	return gift, payment, nil
+}
+
+// RedeemGift — активирует подарок и начисляет получателю дни Premium
+func (s *GiftService) RedeemGift(ctx context.Context, code string, recipient *domain.User) (*domain.Gift, error) {
+	gift, err := s.repo.GetGiftByCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrGiftNotFound
+		}
+		return nil, err
+	}
+
+	switch gift.Status {
+	case domain.GiftPending:
+		return nil, ErrGiftNotPaid
+	case domain.GiftRedeemed:
+		return nil, ErrGiftAlreadyRedeemed
+	}
+	if gift.BuyerID == recipient.ID {
+		return nil, ErrGiftOwn
+	}
+
+	ok, err := s.repo.RedeemGift(ctx, gift.ID, recipient.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrGiftAlreadyRedeemed
+	}
+
+	return gift, nil
+}
+
+func (s *GiftService) GetGift(ctx context.Context, id int64) (*domain.Gift, error) {
+	return s.repo.GetGiftByID(ctx, id)
+}
+
+func (s *GiftService) GetGiftByOrderID(ctx context.Context, orderID string) (*domain.Gift, error) {
+	return s.repo.GetGiftByOrderID(ctx, orderID)
+}
+
+func (s *GiftService) GetUserGifts(ctx context.Context, userID int64) ([]*domain.Gift, error) {
+	return s.repo.GetUserGifts(ctx, userID)
+}
+
+func (s *GiftService) GetGiftLink(gift *domain.Gift, botUsername string) string {
+	return fmt.Sprintf("https://t.me/%s?start=gift_%s", botUsername, gift.Code)
+}
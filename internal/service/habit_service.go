@@ -14,6 +14,7 @@ var (
 	ErrHabitLimitReached = errors.New("достигнут лимит привычек")
 	ErrHabitNotFound     = errors.New("привычка не найдена")
 	ErrAccessDenied      = errors.New("доступ запрещён")
+	ErrHabitLocked       = errors.New("привычка заблокирована")
 )
 
 type HabitService struct {
@@ -30,12 +31,7 @@ func (s *HabitService) CreateHabit(ctx context.Context, user *domain.User, name,
 		return nil, fmt.Errorf("count habits: %w", err)
 	}
 
-	limit := domain.FreeHabitsLimit
-	if user.HasActiveSubscription() {
-		limit = domain.PremiumHabitsLimit
-	}
-
-	if count >= limit {
+	if count >= HabitLimit(user) {
 		return nil, ErrHabitLimitReached
 	}
 
@@ -60,12 +56,7 @@ func (s *HabitService) CreateHabitWithEmoji(ctx context.Context, user *domain.Us
 		return nil, err
 	}
 
-	limit := domain.FreeHabitsLimit
-	if user.HasActiveSubscription() {
-		limit = domain.PremiumHabitsLimit
-	}
-
-	if count >= limit {
+	if count >= HabitLimit(user) {
 		return nil, ErrHabitLimitReached
 	}
 
@@ -85,10 +76,96 @@ func (s *HabitService) CreateHabitWithEmoji(ctx context.Context, user *domain.Us
 	return habit, nil
 }
 
+// HabitLimit — сколько незаблокированных привычек может быть у пользователя
+func HabitLimit(user *domain.User) int {
+	if user.HasActiveSubscription() {
+		return domain.PremiumHabitsLimit
+	}
+	return domain.FreeHabitsLimit
+}
+
+// GetHabitLimitStatus — сколько привычек занято и какой лимит
+func (s *HabitService) GetHabitLimitStatus(ctx context.Context, user *domain.User) (int, int, error) {
+	count, err := s.repo.CountUserHabits(ctx, user.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, HabitLimit(user), nil
+}
+
 func (s *HabitService) GetUserHabits(ctx context.Context, userID int64) ([]*domain.Habit, error) {
 	return s.repo.GetActiveHabits(ctx, userID)
 }
 
+// GetUnlockedHabits — привычки, которые можно отмечать (без заблокированных)
+func (s *HabitService) GetUnlockedHabits(ctx context.Context, userID int64) ([]*domain.Habit, error) {
+	habits, err := s.repo.GetActiveHabits(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlocked := make([]*domain.Habit, 0, len(habits))
+	for _, habit := range habits {
+		if !habit.IsLocked {
+			unlocked = append(unlocked, habit)
+		}
+	}
+	return unlocked, nil
+}
+
+// SyncHabitLocks приводит блокировки в соответствие с подпиской:
+// с Premium все привычки разблокированы, без него активными остаются
+// не больше FreeHabitsLimit (самые старые). Возвращает число заблокированных сейчас.
+func (s *HabitService) SyncHabitLocks(ctx context.Context, user *domain.User) (int, error) {
+	if user.HasActiveSubscription() {
+		return 0, s.repo.UnlockUserHabits(ctx, user.ID)
+	}
+
+	count, err := s.repo.CountUserHabits(ctx, user.ID)
+	if err != nil {
+		return 0, fmt.Errorf("count habits: %w", err)
+	}
+	if count <= domain.FreeHabitsLimit {
+		return 0, nil
+	}
+
+	locked, err := s.repo.LockHabitsExcept(ctx, user.ID, domain.FreeHabitsLimit)
+	if err != nil {
+		return 0, fmt.Errorf("lock habits: %w", err)
+	}
+	return locked, nil
+}
+
+// ToggleHabitLock — пользователь сам выбирает, какие привычки остаются активными
+// после окончания Premium. Возвращает новое состояние блокировки.
+func (s *HabitService) ToggleHabitLock(ctx context.Context, user *domain.User, habitID int64) (bool, error) {
+	habit, err := s.repo.GetHabitByID(ctx, habitID)
+	if err != nil {
+		return false, fmt.Errorf("get habit: %w", err)
+	}
+
+	if habit.UserID != user.ID || !habit.IsActive {
+		return false, ErrAccessDenied
+	}
+
+	if !habit.IsLocked {
+		if user.HasActiveSubscription() {
+			return false, nil
+		}
+		return true, s.repo.SetHabitLocked(ctx, habitID, true)
+	}
+
+	count, err := s.repo.CountUserHabits(ctx, user.ID)
+	if err != nil {
+		return true, fmt.Errorf("count habits: %w", err)
+	}
+	if count >= HabitLimit(user) {
+		return true, ErrHabitLimitReached
+	}
+
+	return false, s.repo.SetHabitLocked(ctx, habitID, false)
+}
+
 func (s *HabitService) GetHabit(ctx context.Context, habitID int64) (*domain.Habit, error) {
 	habit, err := s.repo.GetHabitByID(ctx, habitID)
 	if errors.Is(err, repository.ErrNotFound) {
@@ -107,6 +184,10 @@ func (s *HabitService) CompleteHabit(ctx context.Context, habitID, userID int64)
 		return ErrAccessDenied
 	}
 
+	if habit.IsLocked {
+		return ErrHabitLocked
+	}
+
 	log := &domain.HabitLog{
 		HabitID:   habitID,
 		UserID:    userID,
@@ -127,6 +208,10 @@ func (s *HabitService) UncompleteHabit(ctx context.Context, habitID, userID int6
 		return ErrAccessDenied
 	}
 
+	if habit.IsLocked {
+		return ErrHabitLocked
+	}
+
 	log := &domain.HabitLog{
 		HabitID:   habitID,
 		UserID:    userID,
@@ -0,0 +1,55 @@
+package service
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/format"
+)
+
+// MediaMessage — сообщение рекламы или рассылки: вложение fileID (фото, видео, GIF, документ)
+// или картинка по ссылке imageURL с текстом в подписи, без них — просто текст.
+// markup — клавиатура или nil.
+func MediaMessage(chatID int64, text string, mediaType domain.MediaType, fileID string, imageURL *string, markup interface{}) tgbotapi.Chattable {
+	var media tgbotapi.RequestFileData
+	if fileID != "" {
+		media = tgbotapi.FileID(fileID)
+	} else if imageURL != nil && *imageURL != "" {
+		media = tgbotapi.FileURL(*imageURL)
+		mediaType = domain.MediaPhoto
+	} else {
+		mediaType = domain.MediaNone
+	}
+
+	switch mediaType {
+	case domain.MediaPhoto:
+		photo := tgbotapi.NewPhoto(chatID, media)
+		photo.Caption = text
+		photo.ParseMode = format.ParseMode
+		photo.ReplyMarkup = markup
+		return photo
+	case domain.MediaVideo:
+		video := tgbotapi.NewVideo(chatID, media)
+		video.Caption = text
+		video.ParseMode = format.ParseMode
+		video.ReplyMarkup = markup
+		return video
+	case domain.MediaAnimation:
+		animation := tgbotapi.NewAnimation(chatID, media)
+		animation.Caption = text
+		animation.ParseMode = format.ParseMode
+		animation.ReplyMarkup = markup
+		return animation
+	case domain.MediaDocument:
+		doc := tgbotapi.NewDocument(chatID, media)
+		doc.Caption = text
+		doc.ParseMode = format.ParseMode
+		doc.ReplyMarkup = markup
+		return doc
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = format.ParseMode
+	msg.ReplyMarkup = markup
+	return msg
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+)
+
+var (
+	ErrPromoNotFound     = errors.New("промокод не найден")
+	ErrPromoNotStarted   = errors.New("промокод ещё не действует")
+	ErrPromoExpired      = errors.New("срок промокода истёк")
+	ErrPromoAlreadyUsed  = errors.New("промокод уже использован пользователем")
+	ErrPromoFirstPayment = errors.New("промокод только для первой оплаты")
+	ErrPromoWrongPlan    = errors.New("промокод не действует на тариф")
+)
+
+type PromoService struct {
+	repo repository.Repository
+}
+
+func NewPromoService(repo repository.Repository) *PromoService {
+	return &PromoService{repo: repo}
+}
+
+// ApplyPromocode — проверяет промокод и применяет его: бесплатные дни начисляются сразу,
+// скидка запоминается до оплаты
+func (s *PromoService) ApplyPromocode(ctx context.Context, user *domain.User, code string) (*domain.Promocode, error) {
+	promo, err := s.repo.GetPromocodeByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil {
+		return nil, ErrPromoNotFound
+	}
+
+	if err := validatePromocode(ctx, s.repo, user, promo, ""); err != nil {
+		return nil, err
+	}
+
+	if promo.IsFreeDays() {
+		ok, err := s.repo.RedeemFreeDaysPromocode(ctx, promo.ID, user.ID, promo.FreeDays)
+		if errors.Is(err, repository.ErrAlreadyExists) {
+			return nil, ErrPromoAlreadyUsed
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrPromoExpired
+		}
+		return promo, nil
+	}
+
+	if err := s.repo.SetUserActivePromocode(ctx, user.ID, promo.ID); err != nil {
+		return nil, err
+	}
+	return promo, nil
+}
+
+// PlanPromocode — активный промокод пользователя, если он применим к тарифу при оплате:
+// те же проверки, что и при создании платежа
+func (s *PromoService) PlanPromocode(ctx context.Context, user *domain.User, planCode string) *domain.Promocode {
+	promo, err := s.repo.GetUserActivePromocode(ctx, user.ID)
+	if err != nil || promo == nil || promo.IsFreeDays() {
+		return nil
+	}
+	if err := validatePromocode(ctx, s.repo, user, promo, planCode); err != nil {
+		return nil
+	}
+	return promo
+}
+
+// validatePromocode — общие правила промокода. planCode пустой, если тариф ещё не выбран.
+func validatePromocode(ctx context.Context, repo repository.Repository, user *domain.User, promo *domain.Promocode, planCode string) error {
+	now := time.Now()
+	if !promo.IsActive {
+		return ErrPromoNotFound
+	}
+	if promo.OwnerUserID != nil && *promo.OwnerUserID != user.ID {
+		return ErrPromoNotFound
+	}
+	if promo.StartsAt != nil && now.Before(*promo.StartsAt) {
+		return ErrPromoNotStarted
+	}
+	if !promo.IsValidAt(now) || promo.IsExhausted() {
+		return ErrPromoExpired
+	}
+	if planCode != "" && !promo.AppliesToPlan(planCode) {
+		return ErrPromoWrongPlan
+	}
+
+	used, err := repo.HasUserUsedPromocode(ctx, user.ID, promo.ID)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrPromoAlreadyUsed
+	}
+
+	if promo.FirstPaymentOnly {
+		paid, err := repo.HasConfirmedPayments(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if paid {
+			return ErrPromoFirstPayment
+		}
+	}
+
+	return nil
+}
+
+// ParsePromocode — собирает промокод из аргументов админки: CODE ЗНАЧЕНИЕ [опции...].
+// Используется и командой /addpromo, и веб-панелью.
+func ParsePromocode(code, value string, opts []string) (*domain.Promocode, error) {
+	promo := &domain.Promocode{Code: strings.ToUpper(strings.TrimSpace(code))}
+	if promo.Code == "" {
+		return nil, fmt.Errorf("Не указан код")
+	}
+	if err := parsePromoValue(promo, value); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		if err := parsePromoOption(promo, opt); err != nil {
+			return nil, err
+		}
+	}
+
+	if promo.StartsAt != nil && promo.EndsAt != nil && !promo.StartsAt.Before(*promo.EndsAt) {
+		return nil, fmt.Errorf("Дата окончания раньше даты начала")
+	}
+	return promo, nil
+}
+
+// parsePromoValue — 50 (проценты), 150р (рубли) или 7д (бесплатные дни)
+func parsePromoValue(promo *domain.Promocode, value string) error {
+	value = strings.ToLower(value)
+	switch {
+	case strings.HasSuffix(value, "р") || strings.HasSuffix(value, "₽"):
+		rubles, err := strconv.ParseInt(strings.TrimRight(value, "р₽"), 10, 64)
+		if err != nil || rubles < 1 {
+			return fmt.Errorf("Скидка в рублях должна быть положительной")
+		}
+		promo.DiscountAmount = rubles * 100
+	case strings.HasSuffix(value, "д") || strings.HasSuffix(value, "d"):
+		days, err := strconv.Atoi(strings.TrimRight(value, "дd"))
+		if err != nil || days < 1 || days > 365 {
+			return fmt.Errorf("Количество дней от 1 до 365")
+		}
+		promo.FreeDays = days
+	default:
+		discount, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || discount < 1 || discount > 100 {
+			return fmt.Errorf("Скидка от 1 до 100")
+		}
+		promo.DiscountPercent = discount
+	}
+	return nil
+}
+
+func parsePromoOption(promo *domain.Promocode, opt string) error {
+	if opt == "first" {
+		promo.FirstPaymentOnly = true
+		return nil
+	}
+
+	if limit, err := strconv.Atoi(opt); err == nil {
+		if limit > 0 {
+			promo.MaxUses = &limit
+		}
+		return nil
+	}
+
+	key, value, ok := strings.Cut(opt, "=")
+	if !ok {
+		return fmt.Errorf("Неизвестная опция: %s", opt)
+	}
+
+	switch key {
+	case "from", "to":
+		date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return fmt.Errorf("Неверная дата: %s", value)
+		}
+		if key == "from" {
+			promo.StartsAt = &date
+		} else {
+			end := date.AddDate(0, 0, 1)
+			promo.EndsAt = &end
+		}
+	case "plans":
+		for _, code := range strings.Split(value, ",") {
+			if domain.GetPlan(code) == nil {
+				return fmt.Errorf("Неизвестный тариф: %s", code)
+			}
+			promo.PlanCodes = append(promo.PlanCodes, code)
+		}
+	default:
+		return fmt.Errorf("Неизвестная опция: %s", opt)
+	}
+	return nil
+}
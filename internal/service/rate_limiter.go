@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter — token bucket для отправки сообщений: общий лимит бота, лимит на один чат
+// и глобальная пауза по retry_after от Telegram
+type rateLimiter struct {
+	mu           sync.Mutex
+	rate         float64 // токенов в секунду
+	burst        float64
+	tokens       float64
+	updatedAt    time.Time
+	blockedUntil time.Time
+	chatInterval time.Duration
+	chatNext     map[int64]time.Time
+}
+
+func newRateLimiter(ratePerSecond float64, burst int, chatInterval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		rate:         ratePerSecond,
+		burst:        float64(burst),
+		tokens:       float64(burst),
+		updatedAt:    time.Now(),
+		chatInterval: chatInterval,
+		chatNext:     make(map[int64]time.Time),
+	}
+}
+
+// Wait — ждёт, пока можно отправить сообщение в чат chatID. Ошибка — только отмена ctx.
+func (l *rateLimiter) Wait(ctx context.Context, chatID int64) error {
+	for {
+		delay := l.reserve(chatID, time.Now())
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pause — Telegram ответил 429: все отправки ждут retry_after
+func (l *rateLimiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// reserve — забирает токен и возвращает 0 или сколько ещё ждать
+func (l *rateLimiter) reserve(chatID int64, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.blockedUntil) {
+		return l.blockedUntil.Sub(now)
+	}
+	if next, ok := l.chatNext[chatID]; ok && now.Before(next) {
+		return next.Sub(now)
+	}
+
+	l.tokens += now.Sub(l.updatedAt).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.updatedAt = now
+	if l.tokens < 1 {
+		return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	l.tokens--
+
+	// Рассылка пишет в каждый чат редко, поэтому карта чистится только когда разрастается
+	if len(l.chatNext) > 10000 {
+		for id, next := range l.chatNext {
+			if now.After(next) {
+				delete(l.chatNext, id)
+			}
+		}
+	}
+	l.chatNext[chatID] = now.Add(l.chatInterval)
+	return 0
+}
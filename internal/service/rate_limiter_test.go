@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	start := time.Now()
+	l := newRateLimiter(10, 2, time.Second)
+	l.updatedAt = start
+
+	steps := []struct {
+		name   string
+		chatID int64
+		after  time.Duration
+		want   time.Duration
+	}{
+		{"burst token", 1, 0, 0},
+		{"same chat waits interval", 1, 0, time.Second},
+		{"second burst token", 2, 0, 0},
+		{"bucket empty", 3, 0, 100 * time.Millisecond},
+		{"token refilled", 3, 100 * time.Millisecond, 0},
+		{"chat interval passed", 1, time.Second, 0},
+		{"refill capped by burst", 4, 10 * time.Second, 0},
+		{"burst after idle", 5, 10 * time.Second, 0},
+		{"bucket empty again", 6, 10 * time.Second, 100 * time.Millisecond},
+	}
+	for _, s := range steps {
+		if got := l.reserve(s.chatID, start.Add(s.after)); got != s.want {
+			t.Errorf("%s: reserve = %v, want %v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	l := newRateLimiter(10, 2, time.Second)
+	l.Pause(5 * time.Second)
+	l.Pause(time.Second) // более короткая пауза не сокращает уже назначенную
+
+	if got := l.reserve(1, time.Now()); got < 4*time.Second || got > 5*time.Second {
+		t.Errorf("reserve during pause = %v, want about 5s", got)
+	}
+	if got := l.reserve(1, time.Now().Add(6*time.Second)); got != 0 {
+		t.Errorf("reserve after pause = %v, want 0", got)
+	}
+}
@@ -27,6 +27,13 @@ func (s *ReminderService) SetNotifyFunc(fn func(telegramID int64, habitName stri
 	s.notify = fn
 }
 
+// AddJob регистрирует дополнительную периодическую задачу в общем планировщике.
+// Вызывать до Start.
+func (s *ReminderService) AddJob(spec string, job func()) error {
+	_, err := s.cron.AddFunc(spec, job)
+	return err
+}
+
 func (s *ReminderService) Start() {
 	s.cron.AddFunc("* * * * *", func() {
 		s.checkReminders()
@@ -59,6 +66,9 @@ func (s *ReminderService) checkReminders() {
 		if s.notify != nil {
 			if err := s.notify(telegramID, habit.Name); err != nil {
 				log.Printf("Error sending reminder: %v", err)
+				if IsChatUnreachable(err) {
+					s.repo.MarkUserBlocked(ctx, habit.UserID)
+				}
 			}
 		}
 	}
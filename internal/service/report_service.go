@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+)
+
+// ReportService — еженедельные и ежемесячные отчёты о прогрессе для подписавшихся:
+// по воскресеньям и в последний день месяца вечером по местному времени пользователя
+type ReportService struct {
+	repo           repository.Repository
+	sender         *Sender
+	habitSvc       *HabitService
+	achievementSvc *AchievementService
+	notify         func(telegramID int64, report *domain.ProgressReport) error
+}
+
+func NewReportService(repo repository.Repository, sender *Sender, habitSvc *HabitService, achievementSvc *AchievementService) *ReportService {
+	return &ReportService{repo: repo, sender: sender, habitSvc: habitSvc, achievementSvc: achievementSvc}
+}
+
+func (s *ReportService) SetNotifyFunc(fn func(telegramID int64, report *domain.ProgressReport) error) {
+	s.notify = fn
+}
+
+func (s *ReportService) GetSettings(ctx context.Context, userID int64) (*domain.ReportSettings, error) {
+	return s.repo.GetReportSettings(ctx, userID)
+}
+
+func (s *ReportService) SetSettings(ctx context.Context, userID int64, settings domain.ReportSettings) error {
+	return s.repo.SetReportSettings(ctx, userID, settings)
+}
+
+// RunDue — отправляет отчёты, время которых наступило. Вызывается планировщиком
+// несколько раз в час: отчёт за период уходит один раз, даже после перезапуска.
+func (s *ReportService) RunDue(ctx context.Context) {
+	if s.notify == nil {
+		return
+	}
+
+	subscribers, err := s.repo.GetReportSubscribers(ctx)
+	if err != nil {
+		log.Printf("Error getting report subscribers: %v", err)
+		return
+	}
+
+	for _, sub := range subscribers {
+		now := time.Now().In(UserLocation(sub.Timezone))
+		if now.Hour() < domain.ReportHour {
+			continue
+		}
+
+		if sub.Settings.Weekly && now.Weekday() == time.Sunday {
+			s.sendDue(ctx, sub, domain.ReportWeekly, now, sub.LastWeekly)
+		}
+		if sub.Settings.Monthly && now.AddDate(0, 0, 1).Month() != now.Month() {
+			s.sendDue(ctx, sub, domain.ReportMonthly, now, sub.LastMonthly)
+		}
+	}
+}
+
+func (s *ReportService) sendDue(ctx context.Context, sub *domain.ReportSubscriber, period domain.ReportPeriod, now time.Time, lastFrom *time.Time) {
+	from, _ := reportRange(period, now)
+	if lastFrom != nil && lastFrom.Format("2006-01-02") == from.Format("2006-01-02") {
+		return
+	}
+
+	// Отмечаем до отправки: лучше пропустить один отчёт, чем прислать два
+	if err := s.repo.MarkReportSent(ctx, sub.UserID, period, from); err != nil {
+		log.Printf("Error marking %s report for user %d: %v", period, sub.UserID, err)
+		return
+	}
+
+	report, err := s.BuildReport(ctx, sub.UserID, period, now)
+	if err != nil {
+		log.Printf("Error building %s report for user %d: %v", period, sub.UserID, err)
+		return
+	}
+	if len(report.Habits) == 0 {
+		return
+	}
+
+	err = s.sender.Send(ctx, sub.TelegramID, func() error {
+		return s.notify(sub.TelegramID, report)
+	})
+	if err != nil {
+		log.Printf("Error sending %s report to %d: %v", period, sub.TelegramID, err)
+		if IsChatUnreachable(err) {
+			s.repo.MarkUserBlocked(ctx, sub.UserID)
+		}
+	}
+}
+
+// BuildReport — отчёт за неделю или месяц, в который попадает now, по сегодняшний день
+// включительно, в сравнении с предыдущим периодом
+func (s *ReportService) BuildReport(ctx context.Context, userID int64, period domain.ReportPeriod, now time.Time) (*domain.ProgressReport, error) {
+	from, to := reportRange(period, now)
+	prevFrom, _ := reportRange(period, from.AddDate(0, 0, -1))
+
+	current, err := s.repo.GetHabitPeriodStats(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.repo.GetHabitPeriodStats(ctx, userID, prevFrom, from)
+	if err != nil {
+		return nil, err
+	}
+	prevByHabit := make(map[int64]*domain.HabitPeriodStat, len(previous))
+	for _, p := range previous {
+		prevByHabit[p.HabitID] = p
+	}
+
+	streaks := make(map[int64]int)
+	if habitStreaks, err := s.repo.GetHabitsStreaks(ctx, userID); err == nil {
+		for _, hs := range habitStreaks {
+			streaks[hs.HabitID] = hs.Streak
+		}
+	}
+
+	// Считаем по сегодняшний день: будущие дни периода ещё не прошли
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+	if today.Before(to) {
+		to = today
+	}
+
+	report := &domain.ProgressReport{Period: period, From: from, To: to}
+	var done, expected, prevDone, prevExpected int
+	for _, cur := range current {
+		curExpected := cur.Expected(periodDays(cur.CreatedAt, from, to))
+		if curExpected == 0 {
+			continue
+		}
+		hr := domain.HabitReport{Name: cur.Name, Rate: completionRate(cur.Done, curExpected), Streak: streaks[cur.HabitID]}
+		done, expected = done+min(cur.Done, curExpected), expected+curExpected
+
+		if prev, ok := prevByHabit[cur.HabitID]; ok {
+			if e := prev.Expected(periodDays(prev.CreatedAt, prevFrom, from)); e > 0 {
+				hr.PrevRate, hr.HasPrev = completionRate(prev.Done, e), true
+				prevDone, prevExpected = prevDone+min(prev.Done, e), prevExpected+e
+			}
+		}
+		report.Habits = append(report.Habits, hr)
+	}
+
+	if expected > 0 {
+		report.Rate = completionRate(done, expected)
+	}
+	if prevExpected > 0 {
+		report.PrevRate, report.HasPrev = completionRate(prevDone, prevExpected), true
+	}
+
+	for i := range report.Habits {
+		h := &report.Habits[i]
+		if report.Best == nil || h.Rate > report.Best.Rate {
+			report.Best = h
+		}
+		if report.Worst == nil || h.Rate < report.Worst.Rate {
+			report.Worst = h
+		}
+	}
+	if report.Worst != nil && report.Worst.Rate == report.Best.Rate {
+		report.Worst = nil
+	}
+
+	report.OverallStreak, _ = s.habitSvc.GetUserOverallStreak(ctx, userID)
+	report.NextAchievement, report.DaysLeft, _ = s.achievementSvc.GetNextAchievement(ctx, userID, report.OverallStreak)
+
+	return report, nil
+}
+
+// UserLocation — часовой пояс пользователя; пустой или неизвестный — пояс сервера
+func UserLocation(tz string) *time.Location {
+	if tz == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// reportRange — календарная неделя (с понедельника) или месяц, в которые попадает t
+func reportRange(period domain.ReportPeriod, t time.Time) (time.Time, time.Time) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == domain.ReportMonthly {
+		from := day.AddDate(0, 0, 1-day.Day())
+		return from, from.AddDate(0, 1, 0)
+	}
+	from := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	return from, from.AddDate(0, 0, 7)
+}
+
+// periodDays — сколько дней [from, to) привычка уже существовала
+func periodDays(createdAt, from, to time.Time) int {
+	created := createdAt.In(from.Location())
+	created = time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, from.Location())
+	if created.After(from) {
+		from = created
+	}
+	if !to.After(from) {
+		return 0
+	}
+	return int(to.Sub(from).Hours()/24 + 0.5)
+}
+
+func completionRate(done, expected int) float64 {
+	return float64(min(done, expected)) / float64(expected) * 100
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Лимиты фоновой отправки: Telegram пропускает ~30 сообщений в секунду на бота и ~1 в секунду
+// в один чат. Часть общего лимита оставляем обычным ответам бота.
+const (
+	sendRate         = 25
+	sendBurst        = 5
+	sendChatInterval = time.Second
+	maxSendAttempts  = 3
+)
+
+// Sender — общий лимит для фоновых отправок (рассылки, промо-кампании, отчёты):
+// все они делят один token bucket и вместе ждут retry_after от Telegram
+type Sender struct {
+	limiter *rateLimiter
+}
+
+func NewSender() *Sender {
+	return &Sender{limiter: newRateLimiter(sendRate, sendBurst, sendChatInterval)}
+}
+
+// Send — вызывает send для чата chatID, когда позволяют лимиты. На 429 ждёт retry_after
+// и повторяет. context.Canceled означает, что send не вызывался.
+func (s *Sender) Send(ctx context.Context, chatID int64, send func() error) error {
+	var err error
+	for attempt := 0; attempt < maxSendAttempts; attempt++ {
+		if waitErr := s.limiter.Wait(ctx, chatID); waitErr != nil {
+			return waitErr
+		}
+
+		err = send()
+		var tgErr *tgbotapi.Error
+		if !errors.As(err, &tgErr) || tgErr.RetryAfter == 0 {
+			return err
+		}
+		log.Printf("Telegram flood limit, retry after %ds", tgErr.RetryAfter)
+		s.limiter.Pause(time.Duration(tgErr.RetryAfter) * time.Second)
+	}
+	return err
+}
@@ -2,18 +2,37 @@ package service
 
 import (
 	"context"
+	"log"
 	"time"
 
+	"habit-tracker-bot/internal/domain"
 	"habit-tracker-bot/internal/repository"
 )
 
+// expiredNoticeLookback — насколько далеко назад искать закончившиеся подписки,
+// чтобы после деплоя не уведомлять давно ушедших пользователей
+const expiredNoticeLookback = 3 * 24 * time.Hour
+
+type expiryWindow struct {
+	notice   domain.SubscriptionNotice
+	from, to time.Time
+}
+
 type SubscriptionService struct {
-	repo  repository.Repository
-	price int64
+	repo        repository.Repository
+	habitSvc    *HabitService
+	price       int64
+	familyPrice int64 // цена месяца семейного тарифа
+	graceDays   int   // льготный период после окончания подписки, дней
+	notify      func(user *domain.User, notice domain.SubscriptionNotice) error
+}
+
+func NewSubscriptionService(repo repository.Repository, habitSvc *HabitService, price, familyPrice int64, graceDays int) *SubscriptionService {
+	return &SubscriptionService{repo: repo, habitSvc: habitSvc, price: price, familyPrice: familyPrice, graceDays: graceDays}
 }
 
-func NewSubscriptionService(repo repository.Repository, price int64) *SubscriptionService {
-	return &SubscriptionService{repo: repo, price: price}
+func (s *SubscriptionService) SetNotifyFunc(fn func(user *domain.User, notice domain.SubscriptionNotice) error) {
+	s.notify = fn
 }
 
 func (s *SubscriptionService) GetPrice() int64 {
@@ -27,6 +46,15 @@ func (s *SubscriptionService) GetPriceWithDiscount(discount int) int64 {
 	return s.price * int64(100-discount) / 100
 }
 
+// GetPlanPrice — цена тарифа с учётом скидки за длительный период
+func (s *SubscriptionService) GetPlanPrice(plan *domain.Plan) int64 {
+	price := s.price
+	if plan.Family {
+		price = s.familyPrice
+	}
+	return price * int64(plan.Months) * int64(100-plan.DiscountPercent) / 100
+}
+
 func (s *SubscriptionService) GetPriceRubles() float64 {
 	return float64(s.price) / 100
 }
@@ -46,3 +74,91 @@ func (s *SubscriptionService) IsSubscribed(ctx context.Context, userID int64) (b
 	}
 	return user.HasActiveSubscription(), nil
 }
+
+// CheckExpirations рассылает напоминания о скором окончании подписки
+// (за 3 дня и за 1 день), уведомление о начале льготного периода и об окончании Premium.
+// Каждое уведомление отправляется один раз на конкретную дату окончания.
+// С окончанием Premium блокируются привычки сверх бесплатного лимита.
+func (s *SubscriptionService) CheckExpirations(ctx context.Context) {
+	now := time.Now()
+	grace := time.Duration(s.graceDays) * 24 * time.Hour
+
+	windows := []expiryWindow{
+		{domain.SubscriptionNoticeExpiring1d, now, now.Add(24 * time.Hour)},
+		{domain.SubscriptionNoticeExpiring3d, now.Add(24 * time.Hour), now.Add(3 * 24 * time.Hour)},
+		{domain.SubscriptionNoticeExpired, now.Add(-grace - expiredNoticeLookback), now.Add(-grace)},
+	}
+	if grace > 0 {
+		windows = append(windows, expiryWindow{domain.SubscriptionNoticeGraceStart, now.Add(-grace), now})
+	}
+
+	for _, w := range windows {
+		users, err := s.repo.GetUsersBySubscriptionEnd(ctx, w.from, w.to)
+		if err != nil {
+			log.Printf("Error getting users for %s notice: %v", w.notice, err)
+			continue
+		}
+
+		for _, user := range users {
+			// Участники семьи не теряют Premium вместе со своей подпиской
+			if user.HasFamilyPremium() {
+				continue
+			}
+			if w.notice == domain.SubscriptionNoticeExpired {
+				s.lockExpiredHabits(ctx, user)
+			}
+			s.sendNotice(ctx, user, w.notice)
+		}
+	}
+}
+
+// lockExpiredHabits — Premium закончился: блокирует лишние привычки пользователя
+// и участников его семейной группы, которые получали Premium от него
+func (s *SubscriptionService) lockExpiredHabits(ctx context.Context, user *domain.User) {
+	if _, err := s.habitSvc.SyncHabitLocks(ctx, user); err != nil {
+		log.Printf("Error locking habits for user %d: %v", user.ID, err)
+	}
+
+	group, err := s.repo.GetFamilyGroupByOwner(ctx, user.ID)
+	if err != nil {
+		return
+	}
+	members, err := s.repo.GetFamilyMembers(ctx, group.ID)
+	if err != nil {
+		log.Printf("Error getting family members of group %d: %v", group.ID, err)
+		return
+	}
+	for _, m := range members {
+		member, err := s.repo.GetUserByID(ctx, m.ID)
+		if err != nil {
+			continue
+		}
+		if _, err := s.habitSvc.SyncHabitLocks(ctx, member); err != nil {
+			log.Printf("Error locking habits for user %d: %v", member.ID, err)
+		}
+	}
+}
+
+func (s *SubscriptionService) sendNotice(ctx context.Context, user *domain.User, notice domain.SubscriptionNotice) {
+	if s.notify == nil {
+		return
+	}
+
+	isNew, err := s.repo.MarkSubscriptionNotice(ctx, user.ID, notice, *user.SubscriptionEnd)
+	if err != nil {
+		log.Printf("Error marking %s notice for user %d: %v", notice, user.ID, err)
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	// Отметка ставится до отправки, чтобы параллельная проверка не отправила дубль,
+	// и снимается, если сообщение не дошло
+	if err := s.notify(user, notice); err != nil {
+		log.Printf("Error sending %s notice to %d: %v", notice, user.TelegramID, err)
+		if err := s.repo.ClearSubscriptionNotice(ctx, user.ID, notice, *user.SubscriptionEnd); err != nil {
+			log.Printf("Error clearing %s notice for user %d: %v", notice, user.ID, err)
+		}
+	}
+}
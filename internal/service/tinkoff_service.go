@@ -21,6 +21,9 @@ const (
 	TinkoffTestAPIURL = "https://rest-api-test.tinkoff.ru/v2"
 )
 
+// paymentReservationTTL — сколько держим промокод за неоплаченным платежом
+const paymentReservationTTL = 25 * time.Hour
+
 type TinkoffService struct {
 	repo        repository.Repository
 	terminalKey string
@@ -50,23 +53,16 @@ func (s *TinkoffService) IsConfigured() bool {
 	return s.terminalKey != "" && s.password != ""
 }
 
-func (s *TinkoffService) CreatePayment(ctx context.Context, telegramID int64, baseAmount int64, description string) (*domain.Payment, error) {
+// CreatePayment — оплата подписки по тарифу с реферальной скидкой или промокодом
+func (s *TinkoffService) CreatePayment(ctx context.Context, telegramID int64, plan *domain.Plan, baseAmount int64, description string) (*domain.Payment, error) {
 	// Получаем юзера
 	user, err := s.repo.GetUserByTelegramID(ctx, telegramID)
 	if err != nil {
 		return nil, fmt.Errorf("get user: %w", err)
 	}
 
-	// Проверяем промокод
-	promo, _ := s.repo.GetUserActivePromocode(ctx, telegramID)
-
-	// Берём максимальную скидку: реферальную или промокод
+	// Реферальная скидка
 	discountPercent := user.DiscountPercent
-	if promo != nil && promo.DiscountPercent > discountPercent {
-		discountPercent = promo.DiscountPercent
-	}
-
-	// Считаем финальную сумму
 	finalAmount := baseAmount
 	if discountPercent > 0 {
 		finalAmount = baseAmount * int64(100-discountPercent) / 100
@@ -78,9 +74,102 @@ func (s *TinkoffService) CreatePayment(ctx context.Context, telegramID int64, ba
 
 	orderID := uuid.New().String()
 
+	// Промокод применяем, только если он действует на этот тариф и выгоднее реферальной скидки.
+	// Он резервируется за платежом и списывается только после подтверждения оплаты.
+	promo, _ := s.repo.GetUserActivePromocode(ctx, user.ID)
+	reserved := false
+	if promo != nil && !promo.IsFreeDays() {
+		if err := validatePromocode(ctx, s.repo, user, promo, plan.Code); err != nil {
+			log.Printf("Promocode %s not applied for user %d: %v", promo.Code, user.ID, err)
+		} else if price := promo.Apply(baseAmount); price < finalAmount {
+			ok, err := s.repo.ReservePromocode(ctx, promo.ID, user.ID, orderID)
+			if err != nil {
+				log.Printf("Error reserving promocode %s for user %d: %v", promo.Code, user.ID, err)
+			} else if ok {
+				reserved = true
+				finalAmount = price
+				discountPercent = int((baseAmount - finalAmount) * 100 / baseAmount)
+			}
+		}
+	}
+
+	tinkoffResp, err := s.initPayment(orderID, finalAmount, description, telegramID)
+	if err != nil {
+		if reserved {
+			s.repo.ReleasePromocodeReservation(ctx, orderID)
+		}
+		return nil, err
+	}
+
+	purpose := domain.PaymentPurposeSubscription
+	if plan.Family {
+		purpose = domain.PaymentPurposeFamily
+	}
+
+	payment := &domain.Payment{
+		UserID:          user.ID,
+		TinkoffID:       tinkoffResp.PaymentId,
+		OrderID:         orderID,
+		Amount:          finalAmount,
+		OriginalAmount:  baseAmount,
+		DiscountPercent: discountPercent,
+		Status:          domain.PaymentStatus(tinkoffResp.Status),
+		PaymentURL:      tinkoffResp.PaymentURL,
+		Description:     description,
+		Purpose:         purpose,
+		Days:            plan.Days,
+	}
+
+	if err := s.repo.CreatePayment(ctx, payment); err != nil {
+		if reserved {
+			s.repo.ReleasePromocodeReservation(ctx, orderID)
+		}
+		return nil, fmt.Errorf("save payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+// CreatePlanPayment — платёж за тариф без персональных скидок (например, подарок).
+// Цена уже посчитана вызывающим кодом.
+func (s *TinkoffService) CreatePlanPayment(ctx context.Context, telegramID int64, amount int64, description string, purpose domain.PaymentPurpose, days int) (*domain.Payment, error) {
+	user, err := s.repo.GetUserByTelegramID(ctx, telegramID)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	orderID := uuid.New().String()
+
+	tinkoffResp, err := s.initPayment(orderID, amount, description, telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &domain.Payment{
+		UserID:         user.ID,
+		TinkoffID:      tinkoffResp.PaymentId,
+		OrderID:        orderID,
+		Amount:         amount,
+		OriginalAmount: amount,
+		Status:         domain.PaymentStatus(tinkoffResp.Status),
+		PaymentURL:     tinkoffResp.PaymentURL,
+		Description:    description,
+		Purpose:        purpose,
+		Days:           days,
+	}
+
+	if err := s.repo.CreatePayment(ctx, payment); err != nil {
+		return nil, fmt.Errorf("save payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+// initPayment — вызов Init в API Тинькофф
+func (s *TinkoffService) initPayment(orderID string, amount int64, description string, telegramID int64) (*domain.TinkoffInitResponse, error) {
 	params := map[string]string{
 		"TerminalKey": s.terminalKey,
-		"Amount":      fmt.Sprintf("%d", finalAmount),
+		"Amount":      fmt.Sprintf("%d", amount),
 		"OrderId":     orderID,
 		"Description": description,
 	}
@@ -88,7 +177,7 @@ func (s *TinkoffService) CreatePayment(ctx context.Context, telegramID int64, ba
 
 	req := domain.TinkoffInitRequest{
 		TerminalKey: s.terminalKey,
-		Amount:      finalAmount,
+		Amount:      amount,
 		OrderId:     orderID,
 		Description: description,
 		Token:       token,
@@ -131,58 +220,38 @@ func (s *TinkoffService) CreatePayment(ctx context.Context, telegramID int64, ba
 		return nil, fmt.Errorf("tinkoff error: %s - %s", tinkoffResp.ErrorCode, tinkoffResp.Message)
 	}
 
-	payment := &domain.Payment{
-		UserID:          user.ID,
-		TinkoffID:       tinkoffResp.PaymentId,
-		OrderID:         orderID,
-		Amount:          finalAmount,
-		OriginalAmount:  baseAmount,
-		DiscountPercent: discountPercent,
-		Status:          domain.PaymentStatus(tinkoffResp.Status),
-		PaymentURL:      tinkoffResp.PaymentURL,
-		Description:     description,
-	}
-
-	if err := s.repo.CreatePayment(ctx, payment); err != nil {
-		return nil, fmt.Errorf("save payment: %w", err)
-	}
-
-	// Отмечаем промокод как использованный
-	if promo != nil {
-		s.repo.IncrementPromocodeUsage(ctx, promo.ID, telegramID)
-		s.repo.ClearUserActivePromocode(ctx, telegramID)
-	}
-
-	return payment, nil
+	return &tinkoffResp, nil
 }
 
-func (s *TinkoffService) ProcessNotification(ctx context.Context, notification *domain.TinkoffNotification) error {
+// ProcessNotification обрабатывает webhook от Тинькофф.
+// applied = true, если оплата подтверждена и начислена именно этим вызовом.
+func (s *TinkoffService) ProcessNotification(ctx context.Context, notification *domain.TinkoffNotification) (bool, error) {
 	if !domain.VerifyTinkoffToken(notification, s.password) {
-		return fmt.Errorf("invalid token")
+		return false, fmt.Errorf("invalid token")
 	}
 
 	payment, err := s.repo.GetPaymentByOrderID(ctx, notification.OrderId)
 	if err != nil {
-		return fmt.Errorf("get payment: %w", err)
+		return false, fmt.Errorf("get payment: %w", err)
 	}
 
 	status := domain.PaymentStatus(notification.Status)
 	tinkoffID := fmt.Sprintf("%d", notification.PaymentId)
 
 	if err := s.repo.UpdatePaymentStatus(ctx, notification.OrderId, status, tinkoffID); err != nil {
-		return fmt.Errorf("update payment status: %w", err)
+		return false, fmt.Errorf("update payment status: %w", err)
 	}
 
-	if status == domain.PaymentStatusConfirmed {
-		if err := s.repo.UpdatePaymentPaid(ctx, notification.OrderId, time.Now()); err != nil {
-			return fmt.Errorf("update payment paid: %w", err)
-		}
-		if err := s.repo.AddSubscriptionDays(ctx, payment.UserID, domain.SubscriptionDays); err != nil {
-			return fmt.Errorf("add subscription: %w", err)
+	switch {
+	case status == domain.PaymentStatusConfirmed:
+		return s.applyConfirmedPayment(ctx, payment)
+	case status.IsFailed():
+		if err := s.repo.ReleasePromocodeReservation(ctx, payment.OrderID); err != nil {
+			return false, fmt.Errorf("release promocode: %w", err)
 		}
 	}
 
-	return nil
+	return false, nil
 }
 
 func (s *TinkoffService) GetPaymentByOrderID(ctx context.Context, orderID string) (*domain.Payment, error) {
@@ -254,19 +323,35 @@ func (s *TinkoffService) GetPaymentStatus(ctx context.Context, orderID string) (
 
 // ProcessConfirmedPayment активирует подписку без проверки токена.
 // Используется только при ручной проверке через GetState.
-func (s *TinkoffService) ProcessConfirmedPayment(ctx context.Context, orderID string) error {
+func (s *TinkoffService) ProcessConfirmedPayment(ctx context.Context, orderID string) (bool, error) {
 	payment, err := s.repo.GetPaymentByOrderID(ctx, orderID)
 	if err != nil {
-		return fmt.Errorf("get payment: %w", err)
+		return false, fmt.Errorf("get payment: %w", err)
 	}
 
-	now := time.Now()
-	if err := s.repo.UpdatePaymentPaid(ctx, orderID, now); err != nil {
-		return fmt.Errorf("update payment paid: %w", err)
-	}
-	if err := s.repo.AddSubscriptionDays(ctx, payment.UserID, domain.SubscriptionDays); err != nil {
-		return fmt.Errorf("add subscription: %w", err)
+	return s.applyConfirmedPayment(ctx, payment)
+}
+
+// applyConfirmedPayment — начисляет то, за что заплатили:
+// дни подписки покупателю, оплаченный подарок или рекламу на модерацию.
+// Webhook и ручная проверка могут прийти одновременно — начисление происходит один раз.
+func (s *TinkoffService) applyConfirmedPayment(ctx context.Context, payment *domain.Payment) (bool, error) {
+	applied, err := s.repo.ApplyPaidPayment(ctx, payment, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("apply payment: %w", err)
 	}
+	return applied, nil
+}
 
-	return nil
+// ReleaseStaleReservations — возвращает промокоды из брошенных платежей.
+// Ссылка на оплату Тинькофф живёт сутки, берём с запасом.
+func (s *TinkoffService) ReleaseStaleReservations(ctx context.Context) {
+	released, err := s.repo.ReleaseStalePromocodeReservations(ctx, time.Now().Add(-paymentReservationTTL))
+	if err != nil {
+		log.Printf("Error releasing promocode reservations: %v", err)
+		return
+	}
+	if released > 0 {
+		log.Printf("Released %d stale promocode reservations", released)
+	}
 }
@@ -2,14 +2,17 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/format"
 	"habit-tracker-bot/internal/repository"
 	"habit-tracker-bot/internal/service"
 )
@@ -17,14 +20,20 @@ import (
 type AdminState struct {
 	Action string
 	Data   map[string]string
+	Perm   domain.AdminPermission // право, без которого шаг мастера не выполняется
 }
 
 type AdminHandlers struct {
-	bot          *tgbotapi.BotAPI
-	repo         repository.Repository
-	broadcastSvc *service.BroadcastService
-	adSvc        *service.AdService
-	adminStates  map[int64]*AdminState
+	bot           *tgbotapi.BotAPI
+	repo          repository.Repository
+	broadcastSvc  *service.BroadcastService
+	adSvc         *service.AdService
+	advertiserSvc *service.AdvertiserService
+	adminSvc      *service.AdminService
+	adminStates   map[int64]*AdminState
+
+	// resetUserState — сброс диалога пользователя в Handlers, подставляется в SetAdminHandlers
+	resetUserState func(telegramID int64)
 }
 
 func NewAdminHandlers(
@@ -32,48 +41,125 @@ func NewAdminHandlers(
 	repo repository.Repository,
 	broadcastSvc *service.BroadcastService,
 	adSvc *service.AdService,
+	advertiserSvc *service.AdvertiserService,
+	adminSvc *service.AdminService,
 ) *AdminHandlers {
 	return &AdminHandlers{
-		bot:          bot,
-		repo:         repo,
-		broadcastSvc: broadcastSvc,
-		adSvc:        adSvc,
-		adminStates:  make(map[int64]*AdminState),
+		bot:           bot,
+		repo:          repo,
+		broadcastSvc:  broadcastSvc,
+		adSvc:         adSvc,
+		advertiserSvc: advertiserSvc,
+		adminSvc:      adminSvc,
+		adminStates:   make(map[int64]*AdminState),
 	}
 }
 
+// adminCommandPermissions — какое право нужно для каждой админ-команды.
+// /admin доступна любой роли. Команды, которых здесь нет, админ-обработчик не выполняет
+// и отдаёт обычным обработчикам.
+var adminCommandPermissions = map[string]domain.AdminPermission{
+	"/stats":     domain.PermStats,
+	"/campaigns": domain.PermStats,
+
+	"/user":          domain.PermUsersView,
+	"/grant":         domain.PermUsersBill,
+	"/revoke":        domain.PermUsersBill,
+	"/resetdiscount": domain.PermUsersManage,
+	"/ban":           domain.PermUsersManage,
+	"/unban":         domain.PermUsersManage,
+	"/resetstate":    domain.PermUsersManage,
+
+	"/promos":      domain.PermPromos,
+	"/addpromo":    domain.PermPromos,
+	"/delpromo":    domain.PermPromos,
+	"/togglepromo": domain.PermPromos,
+
+	"/ads":       domain.PermAds,
+	"/addad":     domain.PermAds,
+	"/deletead":  domain.PermAds,
+	"/togglead":  domain.PermAds,
+	"/adtarget":  domain.PermAds,
+	"/adqueue":   domain.PermAds,
+	"/approvead": domain.PermAds,
+	"/rejectad":  domain.PermAds,
+
+	"/broadcasts":        domain.PermBroadcasts,
+	"/newbroadcast":      domain.PermBroadcasts,
+	"/editbroadcast":     domain.PermBroadcasts,
+	"/testbroadcast":     domain.PermBroadcasts,
+	"/segment":           domain.PermBroadcasts,
+	"/schedulebroadcast": domain.PermBroadcasts,
+	"/cancelschedule":    domain.PermBroadcasts,
+	"/deliveries":        domain.PermBroadcasts,
+	"/addvariant":        domain.PermBroadcasts,
+	"/delvariant":        domain.PermBroadcasts,
+	"/retrybroadcast":    domain.PermBroadcasts,
+	"/startbroadcast":    domain.PermBroadcasts,
+	"/stopbroadcast":     domain.PermBroadcasts,
+	"/resumebroadcast":   domain.PermBroadcasts,
+
+	"/audit":       domain.PermAudit,
+	"/admins":      domain.PermAdmins,
+	"/addadmin":    domain.PermAdmins,
+	"/removeadmin": domain.PermAdmins,
+}
+
 func (h *AdminHandlers) HandleAdminCommand(ctx context.Context, msg *tgbotapi.Message) bool {
-	isAdmin, _ := h.repo.IsAdmin(ctx, msg.From.ID)
-
-	// Автоматически назначаем админом пользователя, чей Telegram ID
-	// совпадает с ADMIN_TELEGRAM_ID из окружения. Это удобно для
-	// первого запуска, когда таблица admins ещё пустая.
-	if !isAdmin {
-		if mainIDStr := os.Getenv("ADMIN_TELEGRAM_ID"); mainIDStr != "" {
-			if mainID, err := strconv.ParseInt(mainIDStr, 10, 64); err == nil && mainID == msg.From.ID {
-				_ = h.repo.AddAdmin(ctx, msg.From.ID)
-				isAdmin = true
-			}
-		}
-	}
-	if !isAdmin {
+	role, err := h.repo.GetAdminRole(ctx, msg.From.ID)
+	if err != nil || !role.IsValid() {
+		// Снятый с должности админ не продолжает начатый мастер
+		delete(h.adminStates, msg.From.ID)
 		return false
 	}
 
 	if state, ok := h.adminStates[msg.From.ID]; ok {
+		if msg.Text == "/cancel" {
+			delete(h.adminStates, msg.From.ID)
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "↩️ Отменено"))
+			return true
+		}
+		// Права проверяются на каждом шаге: роль могли понизить, пока мастер был открыт
+		if !role.Can(state.Perm) {
+			delete(h.adminStates, msg.From.ID)
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "⛔ Недостаточно прав, действие отменено"))
+			return true
+		}
 		return h.handleAdminState(ctx, msg, state)
 	}
 
+	var command string
+	if fields := strings.Fields(msg.Text); len(fields) > 0 {
+		command = fields[0]
+	}
+	if command != "/admin" {
+		perm, ok := adminCommandPermissions[command]
+		if !ok {
+			return false
+		}
+		if !role.Can(perm) {
+			// /stats без прав — обычная пользовательская статистика
+			if command == "/stats" {
+				return false
+			}
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "⛔ Недостаточно прав для этой команды"))
+			return true
+		}
+	}
+
 	switch {
 	case msg.Text == "/admin":
-		h.showAdminMenu(msg.Chat.ID)
+		h.showAdminMenu(msg.Chat.ID, role)
 		return true
-	case msg.Text == "/stats":
-		h.showStats(ctx, msg.Chat.ID)
+	case msg.Text == "/stats" || strings.HasPrefix(msg.Text, "/stats "):
+		h.showStats(ctx, msg)
 		return true
 	case msg.Text == "/ads":
 		h.showAds(ctx, msg.Chat.ID)
 		return true
+	case strings.HasPrefix(msg.Text, "/ads "):
+		h.showAdStats(ctx, msg)
+		return true
 	case msg.Text == "/addad":
 		h.startAddAd(msg.From.ID, msg.Chat.ID)
 		return true
@@ -83,20 +169,59 @@ func (h *AdminHandlers) HandleAdminCommand(ctx context.Context, msg *tgbotapi.Me
 	case strings.HasPrefix(msg.Text, "/togglead "):
 		h.toggleAd(ctx, msg)
 		return true
+	case strings.HasPrefix(msg.Text, "/adtarget"):
+		h.setAdTargeting(ctx, msg)
+		return true
+	case msg.Text == "/adqueue":
+		h.showAdQueue(ctx, msg.Chat.ID)
+		return true
+	case strings.HasPrefix(msg.Text, "/approvead "):
+		h.approveAd(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/rejectad "):
+		h.rejectAd(ctx, msg)
+		return true
 	case msg.Text == "/broadcasts":
 		h.showBroadcasts(ctx, msg.Chat.ID)
 		return true
 	case msg.Text == "/newbroadcast":
 		h.startNewBroadcast(msg.From.ID, msg.Chat.ID)
 		return true
+	case msg.Text == "/editbroadcast" || strings.HasPrefix(msg.Text, "/editbroadcast "):
+		h.startEditBroadcast(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/testbroadcast "):
+		h.testBroadcast(ctx, msg)
+		return true
+	case msg.Text == "/segment" || strings.HasPrefix(msg.Text, "/segment "):
+		h.setBroadcastSegment(ctx, msg)
+		return true
+	case msg.Text == "/schedulebroadcast" || strings.HasPrefix(msg.Text, "/schedulebroadcast "):
+		h.scheduleBroadcast(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/cancelschedule "):
+		h.cancelBroadcastSchedule(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/addvariant "):
+		h.startAddVariant(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/delvariant "):
+		h.deleteVariant(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/deliveries "):
+		h.showDeliveries(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/retrybroadcast "):
+		h.retryBroadcast(ctx, msg)
+		return true
 	case strings.HasPrefix(msg.Text, "/startbroadcast "):
 		h.startBroadcast(ctx, msg)
 		return true
 	case msg.Text == "/stopbroadcast":
-		h.stopBroadcast(msg.Chat.ID)
+		h.stopBroadcast(ctx, msg)
 		return true
 	case msg.Text == "/resumebroadcast":
-		h.resumeBroadcast(ctx, msg.Chat.ID)
+		h.resumeBroadcast(ctx, msg)
 		return true
 	case msg.Text == "/promos":
 		h.showPromos(ctx, msg.Chat.ID)
@@ -110,51 +235,210 @@ func (h *AdminHandlers) HandleAdminCommand(ctx context.Context, msg *tgbotapi.Me
 	case strings.HasPrefix(msg.Text, "/togglepromo "):
 		h.togglePromo(ctx, msg)
 		return true
+	case msg.Text == "/campaigns" || strings.HasPrefix(msg.Text, "/campaigns "):
+		h.showCampaigns(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/user "):
+		h.showUser(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/grant "), strings.HasPrefix(msg.Text, "/revoke "):
+		h.changeUserDays(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/resetdiscount "):
+		h.resetUserDiscount(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/ban "), strings.HasPrefix(msg.Text, "/unban "):
+		h.setUserBanned(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/resetstate "):
+		h.resetState(ctx, msg)
+		return true
+	case msg.Text == "/audit" || strings.HasPrefix(msg.Text, "/audit "):
+		h.showAudit(ctx, msg)
+		return true
+	case msg.Text == "/admins":
+		h.showAdmins(ctx, msg.Chat.ID)
+		return true
+	case strings.HasPrefix(msg.Text, "/addadmin "):
+		h.addAdmin(ctx, msg)
+		return true
+	case strings.HasPrefix(msg.Text, "/removeadmin "):
+		h.removeAdmin(ctx, msg)
+		return true
 	}
 
-	return false
+	// Команда из таблицы прав, но в неверном формате: обычным обработчикам её не отдаём
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❓ Неверный формат команды, справка — /admin"))
+	return true
 }
 
-func (h *AdminHandlers) showAdminMenu(chatID int64) {
-	text := `🔐 *Админ-панель*
-
-*Статистика:*
-/stats - Общая статистика
-
-*Промокоды:*
+// adminMenuSections — разделы справки /admin, каждый показывается только при наличии права
+var adminMenuSections = []struct {
+	perm domain.AdminPermission
+	text string
+}{
+	{domain.PermStats, `<b>Статистика:</b>
+/stats [дней | с по] - Метрики, например /stats 7 или /stats 2026-01-01 2026-01-31
+/campaigns [дней] - Конверсия промо-кампаний`},
+	{domain.PermUsersView, `<b>Пользователи:</b>
+/user ID|@username - Карточка пользователя`},
+	{domain.PermUsersBill, `/grant ID ДНЕЙ - Начислить Premium
+/revoke ID ДНЕЙ - Списать Premium`},
+	{domain.PermUsersManage, `/resetdiscount ID - Сбросить скидку и промокод
+/ban ID, /unban ID - Заблокировать / разблокировать
+/resetstate ID - Сбросить диалог и счётчик действий`},
+	{domain.PermPromos, `<b>Промокоды:</b>
 /promos - Список промокодов
-/addpromo CODE СКИДКА [ЛИМИТ] - Создать
+/addpromo CODE СКИДКА [ЛИМИТ] [опции] - Создать
 /delpromo CODE - Удалить
-/togglepromo CODE - Вкл/Выкл
-
-*Реклама:*
+/togglepromo CODE - Вкл/Выкл`},
+	{domain.PermAds, `<b>Реклама:</b>
 /ads - Список рекламы
+/ads [id] [дней] - Показы, клики и CTR по дням
 /addad - Добавить рекламу
 /deletead [id] - Удалить
 /togglead [id] - Вкл/Выкл
-
-*Рассылки:*
+/adtarget [id] [опции] - Аудитория, лимит и период показа
+/adqueue - Реклама рекламодателей на модерации
+/approvead [id] - Одобрить
+/rejectad [id] [причина] - Отклонить`},
+	{domain.PermBroadcasts, `<b>Рассылки:</b>
 /broadcasts - Список рассылок
 /newbroadcast - Новая рассылка
+/editbroadcast [id] [название|текст|медиа|кнопки] - Изменить черновик
+/testbroadcast [id] - Прислать рассылку себе
+/cancel - Отменить ввод
+/segment [id] [опции] - Аудитория рассылки и число получателей
+/addvariant [id] - Добавить вариант для A/B-теста
+/delvariant [id] [B-D] - Удалить вариант
 /startbroadcast [id] - Запустить
+/schedulebroadcast [id] ГГГГ-ММ-ДД ЧЧ:ММ [local] - Запланировать или перенести
+/cancelschedule [id] - Снять с расписания
 /stopbroadcast - Остановить
-/resumebroadcast - Продолжить`
+/resumebroadcast - Продолжить
+/deliveries [id] - Доставка и ошибки по получателям
+/retrybroadcast [id] - Повторить неудачные отправки`},
+	{domain.PermAudit, `<b>Журнал:</b>
+/audit [N] - Последние действия админов`},
+	{domain.PermAdmins, `<b>Администраторы:</b>
+/admins - Список
+/addadmin ID|@username РОЛЬ - Назначить (owner, admin, support, marketer)
+/removeadmin ID|@username - Снять`},
+}
+
+func (h *AdminHandlers) showAdminMenu(chatID int64, role domain.AdminRole) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔐 <b>Админ-панель</b> (%s)", role.Title()))
+	for _, section := range adminMenuSections {
+		if !role.Can(section.perm) {
+			continue
+		}
+		sep := "\n\n"
+		if !strings.HasPrefix(section.text, "<b>") {
+			sep = "\n"
+		}
+		sb.WriteString(sep + section.text)
+	}
 
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ParseMode = "Markdown"
+	msg := tgbotapi.NewMessage(chatID, sb.String())
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 }
 
-func (h *AdminHandlers) showStats(ctx context.Context, chatID int64) {
-	totalUsers, _ := h.repo.GetTotalUsersCount(ctx)
+func (h *AdminHandlers) showStats(ctx context.Context, msg *tgbotapi.Message) {
+	from, to, err := parseStatsRange(strings.Fields(msg.Text)[1:])
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\nФормат: /stats [дней] или /stats 2026-01-01 2026-01-31"))
+		return
+	}
+
+	st, err := h.repo.GetAdminStats(ctx, from, to)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
 
-	text := fmt.Sprintf(`📊 *Статистика*
+	arpu := float64(0)
+	if st.PaymentsCount > 0 {
+		arpu = float64(st.Revenue) / float64(st.PaymentsCount) / 100
+	}
 
-👥 Всего пользователей: *%d*`, totalUsers)
+	text := fmt.Sprintf(`📊 <b>Статистика за %s — %s</b>
+
+👥 <b>Пользователи</b>
+Всего: <b>%d</b>
+Заблокировали бота: <b>%d</b>
+Новых за период: <b>%d</b>
+DAU / WAU / MAU: <b>%d / %d / %d</b>
+Привычек на пользователя: <b>%.1f</b>
+
+⭐️ <b>Подписки</b>
+Premium сейчас: <b>%d</b>
+Конверсия новых в оплату: <b>%.1f%%</b> (%d)
+Ушли без продления: <b>%d</b>
+
+💰 <b>Выручка</b>
+Оплат: <b>%d</b>
+Сумма: <b>%.0f₽</b>
+Средний чек: <b>%.0f₽</b>
+
+🔗 <b>Рефералы</b>
+Приглашено: <b>%d</b>
+Этап 1: <b>%d</b>
+Этап 2: <b>%d</b>`,
+		st.From.Format("02.01.2006"), st.To.AddDate(0, 0, -1).Format("02.01.2006"),
+		st.TotalUsers, st.BlockedUsers, st.NewUsers, st.DAU, st.WAU, st.MAU, st.AvgHabitsPerUser,
+		st.PremiumUsers, st.ConversionRate(), st.NewPayingUsers, st.ChurnedUsers,
+		st.PaymentsCount, float64(st.Revenue)/100, arpu,
+		st.ReferralsTotal, st.ReferralsStage1, st.ReferralsStage2)
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ParseMode = format.ParseMode
+	h.bot.Send(reply)
+
+	png, err := GenerateAdminStatsChart(st.Daily)
+	if err != nil {
+		log.Printf("Error rendering stats chart: %v", err)
+	} else if png != nil {
+		h.bot.Send(chartPhoto(msg.Chat.ID, png))
+	}
+}
 
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ParseMode = "Markdown"
-	h.bot.Send(msg)
+// maxStatsDays — ограничение периода, чтобы график оставался читаемым
+const maxStatsDays = 366
+
+// parseStatsRange — период [from, to): без аргументов 30 дней, "N" — последние N дней,
+// "ДАТА ДАТА" — с и по включительно
+func parseStatsRange(args []string) (time.Time, time.Time, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	to := today.AddDate(0, 0, 1)
+
+	switch len(args) {
+	case 0:
+		return to.AddDate(0, 0, -30), to, nil
+	case 1:
+		days, err := strconv.Atoi(args[0])
+		if err != nil || days < 1 || days > maxStatsDays {
+			return time.Time{}, time.Time{}, fmt.Errorf("Количество дней от 1 до %d", maxStatsDays)
+		}
+		return to.AddDate(0, 0, -days), to, nil
+	case 2:
+		from, err := time.ParseInLocation("2006-01-02", args[0], now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Неверная дата: %s", args[0])
+		}
+		end, err := time.ParseInLocation("2006-01-02", args[1], now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Неверная дата: %s", args[1])
+		}
+		to = end.AddDate(0, 0, 1)
+		if !from.Before(to) || to.Sub(from) > maxStatsDays*24*time.Hour {
+			return time.Time{}, time.Time{}, fmt.Errorf("Неверный период")
+		}
+		return from, to, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("Слишком много аргументов")
 }
 
 func (h *AdminHandlers) showAds(ctx context.Context, chatID int64) {
@@ -166,40 +450,104 @@ func (h *AdminHandlers) showAds(ctx context.Context, chatID int64) {
 	}
 
 	var sb strings.Builder
-	sb.WriteString("📢 *Рекламные объявления:*\n\n")
+	sb.WriteString("📢 <b>Рекламные объявления:</b>\n\n")
 
 	for _, ad := range ads {
 		status := "✅"
 		if !ad.IsActive {
 			status = "❌"
 		}
-		ctr := float64(0)
-		if ad.ViewsCount > 0 {
-			ctr = float64(ad.ClicksCount) / float64(ad.ViewsCount) * 100
+		media := ""
+		if ad.HasMedia() {
+			media = " 📎"
 		}
-		sb.WriteString(fmt.Sprintf("%s *#%d* %s\n", status, ad.ID, ad.Name))
-		sb.WriteString(fmt.Sprintf("   👁 %d | 👆 %d | CTR: %.1f%%\n\n", ad.ViewsCount, ad.ClicksCount, ctr))
+		sb.WriteString(fmt.Sprintf("%s <b>#%d</b> %s%s\n", status, ad.ID, format.Escape(ad.Name), media))
+		sb.WriteString(fmt.Sprintf("   👁 %d | 👆 %d | CTR: %.1f%%\n", ad.ViewsCount, ad.ClicksCount, ad.CTR()))
+		if ad.AdvertiserID != nil {
+			sb.WriteString(fmt.Sprintf("   💳 %s, оплачено %d показов\n", ad.Status.Title(), ad.ImpressionsLimit))
+		}
+		sb.WriteString(fmt.Sprintf("   🎯 %s\n\n", format.Escape(ad.Targeting())))
 	}
 
+	sb.WriteString("По дням: /ads ID [дней]")
+
 	msg := tgbotapi.NewMessage(chatID, sb.String())
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 }
 
+// showAdStats — /ads ID [дней]: показы, клики и CTR рекламы по дням (по умолчанию за 14 дней)
+func (h *AdminHandlers) showAdStats(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	id, _ := strconv.ParseInt(parts[1], 10, 64)
+	days := 14
+	if len(parts) > 2 {
+		d, err := strconv.Atoi(parts[2])
+		if err != nil || d < 1 || d > 365 {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Дней — от 1 до 365"))
+			return
+		}
+		days = d
+	}
+
+	ad, err := h.repo.GetAdByID(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не найдено"))
+		return
+	}
+	stats, err := h.adSvc.DailyStats(ctx, id, days)
+	if err != nil {
+		log.Printf("Error loading ad stats: %v", err)
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка загрузки статистики"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📊 <b>#%d %s</b> за %d дн.\n\n", ad.ID, format.Escape(ad.Name), days))
+	if len(stats) == 0 {
+		sb.WriteString("Показов не было\n")
+	}
+	total := domain.AdDailyStat{}
+	for _, st := range stats {
+		sb.WriteString(fmt.Sprintf("%s  👁 %d | 👆 %d | CTR: %.1f%%\n", st.Date.Format("02.01"), st.Views, st.Clicks, st.CTR()))
+		total.Views += st.Views
+		total.Clicks += st.Clicks
+	}
+	sb.WriteString(fmt.Sprintf("\n<b>Итого:</b> 👁 %d | 👆 %d | CTR: %.1f%%\n", total.Views, total.Clicks, total.CTR()))
+	sb.WriteString(fmt.Sprintf("<b>За всё время:</b> 👁 %d | 👆 %d | CTR: %.1f%%", ad.ViewsCount, ad.ClicksCount, ad.CTR()))
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, sb.String())
+	reply.ParseMode = format.ParseMode
+	h.bot.Send(reply)
+}
+
 func (h *AdminHandlers) startAddAd(userID int64, chatID int64) {
-	h.adminStates[userID] = &AdminState{Action: "add_ad_name", Data: make(map[string]string)}
+	h.adminStates[userID] = &AdminState{Action: "add_ad_name", Data: make(map[string]string), Perm: domain.PermAds}
 	h.bot.Send(tgbotapi.NewMessage(chatID, "📝 Введи название рекламы:"))
 }
 func (h *AdminHandlers) handleAdminState(ctx context.Context, msg *tgbotapi.Message, state *AdminState) bool {
 	switch state.Action {
 	case "add_ad_name":
 		state.Data["name"] = msg.Text
+		state.Action = "add_ad_media"
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, mediaPrompt))
+		return true
+
+	case "add_ad_media":
+		if !readMedia(msg, state.Data) {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не понял вложение.\n\n"+mediaPrompt))
+			return true
+		}
 		state.Action = "add_ad_text"
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "📝 Введи текст рекламы (Markdown):"))
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "📝 Введи текст рекламы:\n\n"+formattingHint))
 		return true
 
 	case "add_ad_text":
 		state.Data["text"] = msg.Text
+		if err := service.CheckAd(adFromState(state.Data)); err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\nВведи текст ещё раз:"))
+			return true
+		}
 		state.Action = "add_ad_button"
 		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "📝 Введи кнопку (текст|url) или 'нет':"))
 		return true
@@ -207,24 +555,15 @@ func (h *AdminHandlers) handleAdminState(ctx context.Context, msg *tgbotapi.Mess
 	case "add_ad_button":
 		if msg.Text != "нет" && msg.Text != "-" {
 			parts := strings.SplitN(msg.Text, "|", 2)
-			if len(parts) == 2 {
-				state.Data["button_text"] = strings.TrimSpace(parts[0])
-				state.Data["button_url"] = strings.TrimSpace(parts[1])
+			if len(parts) != 2 || !strings.HasPrefix(strings.TrimSpace(parts[1]), "http") {
+				h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Формат: Текст|https://... или 'нет'"))
+				return true
 			}
+			state.Data["button_text"] = strings.TrimSpace(parts[0])
+			state.Data["button_url"] = strings.TrimSpace(parts[1])
 		}
 
-		ad := &domain.Ad{
-			Name:     state.Data["name"],
-			Text:     state.Data["text"],
-			IsActive: true,
-			Priority: 1,
-		}
-		if bt, ok := state.Data["button_text"]; ok {
-			ad.ButtonText = &bt
-			bu := state.Data["button_url"]
-			ad.ButtonURL = &bu
-		}
-
+		ad := adFromState(state.Data)
 		err := h.repo.CreateAd(ctx, ad)
 		delete(h.adminStates, msg.From.ID)
 
@@ -232,50 +571,127 @@ func (h *AdminHandlers) handleAdminState(ctx context.Context, msg *tgbotapi.Mess
 			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка создания"))
 		} else {
 			h.adSvc.RefreshCache(ctx)
-			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Реклама #%d создана!", ad.ID)))
+			h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdCreate, nil, fmt.Sprintf("#%d %s", ad.ID, ad.Name))
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Реклама #%d создана!\n\nТаргетинг и лимиты: /adtarget %d опции", ad.ID, ad.ID)))
+			// Превью с прямой ссылкой: клики админа не попадают в статистику
+			link := ""
+			if ad.ButtonURL != nil {
+				link = *ad.ButtonURL
+			}
+			h.bot.Send(adMessage(msg.Chat.ID, ad, AdKeyboard(ad.ID, adButtonText(ad), link)))
 		}
 		return true
 
 	case "broadcast_name":
 		state.Data["name"] = msg.Text
-		state.Action = "broadcast_text"
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "📝 Введи текст рассылки:"))
+		state.Action = "broadcast_media"
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, mediaPrompt))
+		return true
+
+	case "broadcast_media", "variant_media":
+		if !readMedia(msg, state.Data) {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не понял вложение.\n\n"+mediaPrompt))
+			return true
+		}
+		if state.Action == "broadcast_media" {
+			state.Action = "broadcast_text"
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "📝 Введи текст рассылки:\n\n"+formattingHint))
+		} else {
+			state.Action = "variant_text"
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "📝 Введи текст варианта:\n\n"+formattingHint))
+		}
 		return true
 
-	case "broadcast_text":
+	case "broadcast_text", "variant_text":
+		if msg.Text == "" {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Нужен текст сообщения"))
+			return true
+		}
 		state.Data["text"] = msg.Text
-		state.Action = "broadcast_button"
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "📝 Введи кнопку (текст|url) или 'нет':"))
+		v := variantFromState(state.Data)
+		if err := service.CheckVariant(&v); err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\nВведи текст ещё раз:"))
+			return true
+		}
+		if state.Action == "broadcast_text" {
+			state.Action = "broadcast_button"
+		} else {
+			state.Action = "variant_button"
+		}
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "🔘 "+service.ButtonsUsage))
 		return true
 
 	case "broadcast_button":
-		if msg.Text != "нет" && msg.Text != "-" {
-			parts := strings.SplitN(msg.Text, "|", 2)
-			if len(parts) == 2 {
-				state.Data["button_text"] = strings.TrimSpace(parts[0])
-				state.Data["button_url"] = strings.TrimSpace(parts[1])
-			}
+		if _, err := service.ParseButtons(msg.Text); err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\n"+service.ButtonsUsage))
+			return true
 		}
+		state.Data["buttons"] = msg.Text
+		state.Action = "broadcast_segment"
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "🎯 Кому отправить?\n\n"+service.SegmentUsage))
+		return true
 
-		b := &domain.Broadcast{
-			Name:   state.Data["name"],
-			Text:   state.Data["text"],
-			Status: domain.BroadcastDraft,
+	case "variant_button":
+		if _, err := service.ParseButtons(msg.Text); err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\n"+service.ButtonsUsage))
+			return true
+		}
+		state.Data["buttons"] = msg.Text
+		delete(h.adminStates, msg.From.ID)
+
+		v := variantFromState(state.Data)
+		id, _ := strconv.ParseInt(state.Data["broadcast_id"], 10, 64)
+		label, err := h.broadcastSvc.AddVariant(ctx, id, &v)
+		if err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+			return true
+		}
+		h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastVariant, nil, fmt.Sprintf("#%d +%s", id, label))
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf(
+			"✅ Вариант %s добавлен к рассылке #%d\n\nПолучатели делятся между вариантами случайно, итоги — в /broadcasts", label, id)))
+		h.sendBroadcastTest(ctx, msg.Chat.ID, id)
+		return true
+
+	case "broadcast_edit":
+		h.editBroadcast(ctx, msg, state)
+		return true
+
+	case "broadcast_segment":
+		segment, err := service.ParseSegment(strings.Fields(msg.Text))
+		if err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\n"+service.SegmentUsage))
+			return true
 		}
-		if bt, ok := state.Data["button_text"]; ok {
-			b.ButtonText = &bt
-			bu := state.Data["button_url"]
-			b.ButtonURL = &bu
+
+		v := variantFromState(state.Data)
+		b := &domain.Broadcast{
+			Name:        state.Data["name"],
+			Text:        v.Text,
+			ImageURL:    v.ImageURL,
+			Status:      domain.BroadcastDraft,
+			Segment:     segment,
+			MediaType:   v.MediaType,
+			MediaFileID: v.MediaFileID,
+			Buttons:     v.Buttons,
 		}
 
-		err := h.repo.CreateBroadcast(ctx, b)
+		err = h.repo.CreateBroadcast(ctx, b)
 		delete(h.adminStates, msg.From.ID)
 
 		if err != nil {
 			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка создания"))
-		} else {
-			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Рассылка #%d создана!\n\nЗапустить: /startbroadcast %d", b.ID, b.ID)))
+			return true
+		}
+		h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastCreate, nil, fmt.Sprintf("#%d %s", b.ID, b.Name))
+
+		audience, err := h.broadcastSvc.PreviewAudience(ctx, segment)
+		if err != nil {
+			log.Printf("Error counting broadcast audience: %v", err)
 		}
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf(
+			"✅ Рассылка #%d создана!\n\n🎯 Аудитория: %s\n👥 Получателей: %d\n\nЗапустить: /startbroadcast %d\nЗапланировать: /schedulebroadcast %d ГГГГ-ММ-ДД ЧЧ:ММ [local]\nИзменить: /editbroadcast %d\nИзменить аудиторию: /segment %d опции\nA/B-тест: /addvariant %d",
+			b.ID, segment.Describe(), audience, b.ID, b.ID, b.ID, b.ID, b.ID)))
+		h.sendBroadcastTest(ctx, msg.Chat.ID, b.ID)
 		return true
 	}
 
@@ -286,9 +702,141 @@ func (h *AdminHandlers) deleteAd(ctx context.Context, msg *tgbotapi.Message) {
 	id, _ := strconv.ParseInt(strings.TrimPrefix(msg.Text, "/deletead "), 10, 64)
 	h.repo.DeleteAd(ctx, id)
 	h.adSvc.RefreshCache(ctx)
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdDelete, nil, fmt.Sprintf("#%d", id))
 	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Реклама #%d удалена", id)))
 }
 
+// setAdTargeting — /adtarget ID [опции]: без опций показывает текущий таргетинг,
+// с опциями меняет указанные поля
+func (h *AdminHandlers) setAdTargeting(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) < 2 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Формат: /adtarget ID [опции]\n\n"+service.AdTargetingUsage))
+		return
+	}
+
+	id, _ := strconv.ParseInt(parts[1], 10, 64)
+	ad, err := h.repo.GetAdByID(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не найдено"))
+		return
+	}
+
+	if len(parts) > 2 {
+		if err := service.ApplyAdTargeting(ad, parts[2:]); err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\n"+service.AdTargetingUsage))
+			return
+		}
+		if err := h.repo.UpdateAd(ctx, ad); err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка сохранения"))
+			return
+		}
+		h.adSvc.RefreshCache(ctx)
+		h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdTarget, nil, fmt.Sprintf("#%d %s", id, ad.Targeting()))
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🎯 Реклама #%d\n%s", id, ad.Targeting())))
+}
+
+// NotifyAdModeration — админам с правом на рекламу: оплачено новое объявление
+func (h *AdminHandlers) NotifyAdModeration(ctx context.Context, ad *domain.Ad) {
+	admins, err := h.repo.GetAllAdmins(ctx)
+	if err != nil {
+		log.Printf("Error loading admins for ad moderation: %v", err)
+		return
+	}
+	for _, admin := range admins {
+		if admin.Role.Can(domain.PermAds) {
+			h.sendModerationCard(admin.TelegramID, ad)
+		}
+	}
+}
+
+// sendModerationCard — объявление рекламодателя так, как его увидят пользователи, и команды решения
+func (h *AdminHandlers) sendModerationCard(chatID int64, ad *domain.Ad) {
+	link := ""
+	if ad.ButtonURL != nil {
+		link = *ad.ButtonURL
+	}
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("🆕 <b>#%d %s</b> — на модерации, %d показов\n\nОдобрить: /approvead %d\nОтклонить: /rejectad %d причина",
+		ad.ID, format.Escape(ad.Name), ad.ImpressionsLimit, ad.ID, ad.ID))
+	msg.ParseMode = format.ParseMode
+	h.bot.Send(msg)
+	h.bot.Send(adMessage(chatID, ad, AdKeyboard(ad.ID, adButtonText(ad), link)))
+}
+
+func (h *AdminHandlers) showAdQueue(ctx context.Context, chatID int64) {
+	ads, err := h.advertiserSvc.ModerationQueue(ctx)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка загрузки"))
+		return
+	}
+	if len(ads) == 0 {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "✅ Очередь модерации пуста"))
+		return
+	}
+	for _, ad := range ads {
+		h.sendModerationCard(chatID, ad)
+	}
+}
+
+func (h *AdminHandlers) approveAd(ctx context.Context, msg *tgbotapi.Message) {
+	id, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/approvead ")), 10, 64)
+	ad, err := h.advertiserSvc.Approve(ctx, id)
+	if err != nil {
+		text, ok := errorText(err)
+		if !ok {
+			text = err.Error()
+		}
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+text))
+		return
+	}
+
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdApprove, nil, fmt.Sprintf("#%d %s", ad.ID, ad.Name))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Реклама #%d одобрена и показывается", ad.ID)))
+	h.notifyAdvertiser(ctx, ad, fmt.Sprintf("✅ Объявление <b>%s</b> одобрено и уже показывается.\n\nСтатистика: /myads", format.Escape(ad.Name)))
+}
+
+// rejectAd — /rejectad ID причина: причину увидит рекламодатель
+func (h *AdminHandlers) rejectAd(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/rejectad ")), " ", 2)
+	if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Формат: /rejectad ID причина"))
+		return
+	}
+	id, _ := strconv.ParseInt(parts[0], 10, 64)
+	reason := strings.TrimSpace(parts[1])
+
+	ad, err := h.advertiserSvc.Reject(ctx, id, reason)
+	if err != nil {
+		text, ok := errorText(err)
+		if !ok {
+			text = err.Error()
+		}
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+text))
+		return
+	}
+
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdReject, nil, fmt.Sprintf("#%d %s: %s", ad.ID, ad.Name, reason))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Реклама #%d отклонена", ad.ID)))
+	h.notifyAdvertiser(ctx, ad, fmt.Sprintf("❌ Объявление <b>%s</b> отклонено модератором.\n\nПричина: %s\n\nПо возврату оплаты напиши в поддержку.",
+		format.Escape(ad.Name), format.Escape(reason)))
+}
+
+func (h *AdminHandlers) notifyAdvertiser(ctx context.Context, ad *domain.Ad, text string) {
+	if ad.AdvertiserID == nil {
+		return
+	}
+	user, err := h.repo.GetUserByID(ctx, *ad.AdvertiserID)
+	if err != nil {
+		log.Printf("Error getting advertiser %d: %v", *ad.AdvertiserID, err)
+		return
+	}
+	reply := tgbotapi.NewMessage(user.TelegramID, text)
+	reply.ParseMode = format.ParseMode
+	h.bot.Send(reply)
+}
+
 func (h *AdminHandlers) toggleAd(ctx context.Context, msg *tgbotapi.Message) {
 	id, _ := strconv.ParseInt(strings.TrimPrefix(msg.Text, "/togglead "), 10, 64)
 	ad, err := h.repo.GetAdByID(ctx, id)
@@ -299,6 +847,7 @@ func (h *AdminHandlers) toggleAd(ctx context.Context, msg *tgbotapi.Message) {
 	ad.IsActive = !ad.IsActive
 	h.repo.UpdateAd(ctx, ad)
 	h.adSvc.RefreshCache(ctx)
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdToggle, nil, fmt.Sprintf("#%d active=%t", id, ad.IsActive))
 
 	status := "включена ✅"
 	if !ad.IsActive {
@@ -316,10 +865,12 @@ func (h *AdminHandlers) showBroadcasts(ctx context.Context, chatID int64) {
 	}
 
 	var sb strings.Builder
-	sb.WriteString("📬 *Рассылки:*\n\n")
+	sb.WriteString("📬 <b>Рассылки:</b>\n\n")
 	for _, b := range broadcasts {
 		status := "📝"
 		switch b.Status {
+		case domain.BroadcastScheduled:
+			status = "⏰"
 		case domain.BroadcastRunning:
 			status = "▶️"
 		case domain.BroadcastPaused:
@@ -332,125 +883,949 @@ func (h *AdminHandlers) showBroadcasts(ctx context.Context, chatID int64) {
 		if b.TotalUsers > 0 {
 			progress = fmt.Sprintf(" (%d/%d)", b.SentCount, b.TotalUsers)
 		}
-		sb.WriteString(fmt.Sprintf("%s *#%d* %s%s\n", status, b.ID, b.Name, progress))
+		sb.WriteString(fmt.Sprintf("%s <b>#%d</b> %s%s\n", status, b.ID, format.Escape(b.Name), progress))
+		if !b.Segment.IsEmpty() {
+			sb.WriteString("   🎯 " + format.Escape(b.Segment.Describe()) + "\n")
+		}
+		if b.Status == domain.BroadcastScheduled {
+			sb.WriteString("   ⏰ " + b.ScheduleLabel() + "\n")
+		}
+		if b.Status != domain.BroadcastDraft && b.Status != domain.BroadcastScheduled {
+			h.writeBroadcastResults(ctx, &sb, b.ID)
+		}
 	}
 
 	msg := tgbotapi.NewMessage(chatID, sb.String())
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 }
 
-func (h *AdminHandlers) startNewBroadcast(userID int64, chatID int64) {
-	h.adminStates[userID] = &AdminState{Action: "broadcast_name", Data: make(map[string]string)}
-	h.bot.Send(tgbotapi.NewMessage(chatID, "📝 Введи название рассылки:"))
+// writeBroadcastResults — доставки и клики по вариантам. Для рассылки без вариантов
+// одна строка, для A/B-теста — строка на вариант.
+func (h *AdminHandlers) writeBroadcastResults(ctx context.Context, sb *strings.Builder, broadcastID int64) {
+	results, err := h.repo.GetBroadcastResults(ctx, broadcastID)
+	if err != nil {
+		log.Printf("Error getting broadcast results: %v", err)
+		return
+	}
+	for _, r := range results {
+		prefix := "   📈 "
+		if len(results) > 1 {
+			prefix += r.Label + ": "
+		}
+		sb.WriteString(fmt.Sprintf("%s%d дост., %d нажали (CTR %.1f%%), кликов %d\n", prefix, r.Delivered, r.Clickers, r.CTR(), r.Clicks))
+	}
 }
 
-func (h *AdminHandlers) startBroadcast(ctx context.Context, msg *tgbotapi.Message) {
-	id, _ := strconv.ParseInt(strings.TrimPrefix(msg.Text, "/startbroadcast "), 10, 64)
+func (h *AdminHandlers) startAddVariant(ctx context.Context, msg *tgbotapi.Message) {
+	id, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/addvariant ")), 10, 64)
 
-	if h.broadcastSvc.IsRunning() {
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Уже есть запущенная рассылка"))
+	b, err := h.repo.GetBroadcastByID(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Рассылка не найдена"))
 		return
 	}
-
-	if err := h.broadcastSvc.StartBroadcast(ctx, id); err != nil {
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+	if b.Status != domain.BroadcastDraft {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Варианты можно добавлять только к черновику"))
+		return
+	}
+	if len(b.AllVariants()) >= domain.MaxBroadcastVariants {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не больше %d вариантов в рассылке", domain.MaxBroadcastVariants)))
 		return
 	}
 
-	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("▶️ Рассылка #%d запущена!", id)))
+	h.adminStates[msg.From.ID] = &AdminState{Action: "variant_media", Data: map[string]string{"broadcast_id": strconv.FormatInt(id, 10)}, Perm: domain.PermBroadcasts}
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🧪 Вариант %s для рассылки #%d\n\n%s", b.NextVariantLabel(), id, mediaPrompt)))
 }
 
-func (h *AdminHandlers) stopBroadcast(chatID int64) {
-	if !h.broadcastSvc.IsRunning() {
-		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Нет активной рассылки"))
+func (h *AdminHandlers) deleteVariant(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) != 3 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Формат: /delvariant ID B"))
 		return
 	}
-	h.broadcastSvc.StopBroadcast()
-	h.bot.Send(tgbotapi.NewMessage(chatID, "⏸️ Рассылка остановлена"))
-}
+	id, _ := strconv.ParseInt(parts[1], 10, 64)
 
-func (h *AdminHandlers) resumeBroadcast(ctx context.Context, chatID int64) {
-	if err := h.broadcastSvc.ResumeBroadcast(ctx); err != nil {
-		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+	if err := h.broadcastSvc.DeleteVariant(ctx, id, parts[2]); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
 		return
 	}
-	h.bot.Send(tgbotapi.NewMessage(chatID, "▶️ Рассылка продолжена"))
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastVariant, nil, fmt.Sprintf("#%d -%s", id, strings.ToUpper(parts[2])))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🗑 Вариант %s удалён из рассылки #%d", strings.ToUpper(parts[2]), id)))
 }
 
-func (h *AdminHandlers) showPromos(ctx context.Context, chatID int64) {
-	promos, _ := h.repo.GetAllPromocodes(ctx)
+func (h *AdminHandlers) startNewBroadcast(userID int64, chatID int64) {
+	h.adminStates[userID] = &AdminState{Action: "broadcast_name", Data: make(map[string]string), Perm: domain.PermBroadcasts}
+	h.bot.Send(tgbotapi.NewMessage(chatID, "📝 Введи название рассылки (/cancel — отменить):"))
+}
 
-	if len(promos) == 0 {
-		h.bot.Send(tgbotapi.NewMessage(chatID, "Нет промокодов"))
-		return
-	}
+// formattingHint — подсказка к текстам, которые админ вводит с разметкой
+const formattingHint = `Разметка HTML: <b>жирный</b>, <i>курсив</i>, <code>код</code>, <a href="https://example.com">ссылка</a>.
+Символы <, > и & вне тегов пиши как &lt; &gt; &amp;`
 
-	var sb strings.Builder
-	sb.WriteString("🎟 *Промокоды:*\n\n")
-	for _, p := range promos {
-		status := "✅"
-		if !p.IsActive {
-			status = "❌"
-		}
-		sb.WriteString(fmt.Sprintf("%s %s — %d%%", status, p.Code, p.DiscountPercent))
-		sb.WriteString(fmt.Sprintf(" (исп: %d", p.UsedCount))
-		if p.MaxUses != nil {
-			sb.WriteString(fmt.Sprintf("/%d", *p.MaxUses))
-		}
-		sb.WriteString(")\n")
-	}
+const mediaPrompt = "🖼 Отправь фото, видео, GIF или документ, ссылку на картинку или 'нет':"
 
-	msg := tgbotapi.NewMessage(chatID, sb.String())
-	msg.ParseMode = "Markdown"
-	h.bot.Send(msg)
+// mediaFromMessage — вложение сообщения: самое крупное фото, видео, GIF или документ.
+// GIF приходит и как документ, поэтому проверяется раньше.
+func mediaFromMessage(msg *tgbotapi.Message) (domain.MediaType, string) {
+	switch {
+	case len(msg.Photo) > 0:
+		return domain.MediaPhoto, msg.Photo[len(msg.Photo)-1].FileID
+	case msg.Video != nil:
+		return domain.MediaVideo, msg.Video.FileID
+	case msg.Animation != nil:
+		return domain.MediaAnimation, msg.Animation.FileID
+	case msg.Document != nil:
+		return domain.MediaDocument, msg.Document.FileID
+	}
+	return domain.MediaNone, ""
 }
 
-func (h *AdminHandlers) addPromo(ctx context.Context, msg *tgbotapi.Message) {
-	// /addpromo CODE 50 20
-	parts := strings.Fields(msg.Text)
-	if len(parts) < 3 {
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID,
-			"Формат: /addpromo CODE СКИДКА [ЛИМИТ]\nПример: /addpromo EARLYBIRD 50 20"))
-		return
-	}
+// readMedia — ответ на шаг с вложением: файл, ссылка на картинку или "нет".
+// false, если ответ не подходит.
+func readMedia(msg *tgbotapi.Message, data map[string]string) bool {
+	delete(data, "media_type")
+	delete(data, "media_file_id")
+	delete(data, "image_url")
 
-	code := strings.ToUpper(parts[1])
-	discount, err := strconv.Atoi(parts[2])
-	if err != nil || discount < 1 || discount > 100 {
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Скидка от 1 до 100"))
-		return
+	if mediaType, fileID := mediaFromMessage(msg); fileID != "" {
+		data["media_type"], data["media_file_id"] = string(mediaType), fileID
+		return true
 	}
-
-	maxUses := 0
-	if len(parts) >= 4 {
-		maxUses, _ = strconv.Atoi(parts[3])
+	text := strings.TrimSpace(msg.Text)
+	switch {
+	case text == "нет" || text == "-":
+		return true
+	case strings.HasPrefix(text, "http://") || strings.HasPrefix(text, "https://"):
+		data["image_url"] = text
+		return true
 	}
+	return false
+}
 
-	err = h.repo.CreatePromocode(ctx, code, discount, maxUses)
-	if err != nil {
-		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
-		return
+// adFromState — реклама из ответов мастера /addad
+func adFromState(data map[string]string) *domain.Ad {
+	ad := &domain.Ad{
+		Name:        data["name"],
+		Text:        data["text"],
+		IsActive:    true,
+		Priority:    1,
+		MediaType:   domain.MediaType(data["media_type"]),
+		MediaFileID: data["media_file_id"],
 	}
+	if img, ok := data["image_url"]; ok {
+		ad.ImageURL = &img
+	}
+	if bt, ok := data["button_text"]; ok {
+		ad.ButtonText = &bt
+		bu := data["button_url"]
+		ad.ButtonURL = &bu
+	}
+	return ad
+}
+
+// variantFromState — вариант рассылки из ответов мастера
+func variantFromState(data map[string]string) domain.BroadcastVariant {
+	v := domain.BroadcastVariant{
+		Text:        data["text"],
+		MediaType:   domain.MediaType(data["media_type"]),
+		MediaFileID: data["media_file_id"],
+	}
+	if img, ok := data["image_url"]; ok {
+		v.ImageURL = &img
+	}
+	v.Buttons, _ = service.ParseButtons(data["buttons"])
+	return v
+}
+
+// sendBroadcastTest — присылает админу рассылку так, как её увидят получатели
+func (h *AdminHandlers) sendBroadcastTest(ctx context.Context, chatID, broadcastID int64) {
+	h.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("👀 Так рассылку #%d увидят получатели:", broadcastID)))
+	if err := h.broadcastSvc.SendTest(ctx, broadcastID, chatID); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Тест не отправился: "+err.Error()))
+	}
+}
+
+func (h *AdminHandlers) testBroadcast(ctx context.Context, msg *tgbotapi.Message) {
+	id, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/testbroadcast ")), 10, 64)
+	h.sendBroadcastTest(ctx, msg.Chat.ID, id)
+}
+
+// broadcastEditFields — что можно изменить в черновике через /editbroadcast
+var broadcastEditFields = map[string]string{
+	"name":     "name",
+	"название": "name",
+	"text":     "text",
+	"текст":    "text",
+	"media":    "media",
+	"медиа":    "media",
+	"buttons":  "buttons",
+	"кнопки":   "buttons",
+}
+
+const editBroadcastUsage = "Формат: /editbroadcast ID название|текст|медиа|кнопки"
+
+// startEditBroadcast — /editbroadcast ID поле: следующее сообщение заменит поле черновика
+func (h *AdminHandlers) startEditBroadcast(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) != 3 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, editBroadcastUsage))
+		return
+	}
+	field, ok := broadcastEditFields[strings.ToLower(parts[2])]
+	if !ok {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, editBroadcastUsage))
+		return
+	}
+
+	id, _ := strconv.ParseInt(parts[1], 10, 64)
+	b, err := h.repo.GetBroadcastByID(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Рассылка не найдена"))
+		return
+	}
+	if b.Status != domain.BroadcastDraft {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Изменять можно только черновик"))
+		return
+	}
+
+	prompt := map[string]string{
+		"name":    "📝 Новое название:",
+		"text":    "📝 Новый текст:\n\n" + formattingHint,
+		"media":   mediaPrompt,
+		"buttons": "🔘 " + service.ButtonsUsage,
+	}[field]
+	h.adminStates[msg.From.ID] = &AdminState{Action: "broadcast_edit", Data: map[string]string{"broadcast_id": parts[1], "field": field}, Perm: domain.PermBroadcasts}
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✏️ Рассылка #%d (/cancel — отменить)\n\n%s", id, prompt)))
+}
+
+// editBroadcast — применяет ответ на /editbroadcast. При неверном вводе ждёт новый ответ.
+func (h *AdminHandlers) editBroadcast(ctx context.Context, msg *tgbotapi.Message, state *AdminState) {
+	id, _ := strconv.ParseInt(state.Data["broadcast_id"], 10, 64)
+	b, err := h.repo.GetBroadcastByID(ctx, id)
+	if err != nil {
+		delete(h.adminStates, msg.From.ID)
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Рассылка не найдена"))
+		return
+	}
+
+	field := state.Data["field"]
+	switch field {
+	case "name", "text":
+		if msg.Text == "" {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Нужен текст сообщения"))
+			return
+		}
+		if field == "name" {
+			b.Name = msg.Text
+		} else {
+			b.Text = msg.Text
+		}
+	case "media":
+		media := make(map[string]string)
+		if !readMedia(msg, media) {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не понял вложение.\n\n"+mediaPrompt))
+			return
+		}
+		v := variantFromState(media)
+		b.MediaType, b.MediaFileID, b.ImageURL = v.MediaType, v.MediaFileID, v.ImageURL
+	case "buttons":
+		buttons, err := service.ParseButtons(msg.Text)
+		if err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\n"+service.ButtonsUsage))
+			return
+		}
+		// Кнопки целиком заменяют старую одиночную кнопку
+		b.Buttons, b.ButtonText, b.ButtonURL = buttons, nil, nil
+	}
+
+	if err := h.broadcastSvc.UpdateContent(ctx, b); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+	delete(h.adminStates, msg.From.ID)
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastEdit, nil, fmt.Sprintf("#%d %s", id, field))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Рассылка #%d изменена", id)))
+	h.sendBroadcastTest(ctx, msg.Chat.ID, id)
+}
+
+// setBroadcastSegment — /segment ID [опции]: без опций показывает текущую аудиторию,
+// с опциями меняет её у черновика. В обоих случаях выводит число получателей.
+func (h *AdminHandlers) setBroadcastSegment(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) < 2 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Формат: /segment ID [опции]\n\n"+service.SegmentUsage))
+		return
+	}
+
+	id, _ := strconv.ParseInt(parts[1], 10, 64)
+	b, err := h.repo.GetBroadcastByID(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Рассылка не найдена"))
+		return
+	}
+
+	segment := b.Segment
+	var audience int
+	if len(parts) > 2 {
+		segment, err = service.ParseSegment(parts[2:])
+		if err != nil {
+			h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\n"+service.SegmentUsage))
+			return
+		}
+		audience, err = h.broadcastSvc.SetSegment(ctx, id, segment)
+		if err == nil {
+			h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastSegment, nil, fmt.Sprintf("#%d %s", id, segment.Describe()))
+		}
+	} else {
+		audience, err = h.broadcastSvc.PreviewAudience(ctx, segment)
+	}
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🎯 Рассылка #%d\nАудитория: %s\n👥 Получателей: %d", id, segment.Describe(), audience)))
+}
+
+func (h *AdminHandlers) startBroadcast(ctx context.Context, msg *tgbotapi.Message) {
+	id, _ := strconv.ParseInt(strings.TrimPrefix(msg.Text, "/startbroadcast "), 10, 64)
+
+	if h.broadcastSvc.IsRunning() {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Уже есть запущенная рассылка"))
+		return
+	}
+
+	if err := h.broadcastSvc.StartBroadcast(ctx, id); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastStart, nil, fmt.Sprintf("#%d", id))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("▶️ Рассылка #%d запущена!", id)))
+}
+
+// scheduleBroadcast — /schedulebroadcast ID ГГГГ-ММ-ДД ЧЧ:ММ [local]. Повторный вызов переносит запуск.
+func (h *AdminHandlers) scheduleBroadcast(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) < 4 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Формат: /schedulebroadcast ID ГГГГ-ММ-ДД ЧЧ:ММ [local]\n\nlocal — в это время по часам каждого пользователя, иначе по времени сервера"))
+		return
+	}
+
+	id, _ := strconv.ParseInt(parts[1], 10, 64)
+	at, perUserLocal, err := service.ParseSchedule(parts[2:])
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+	if err := h.broadcastSvc.Schedule(ctx, id, at, perUserLocal); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+
+	b, err := h.repo.GetBroadcastByID(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Рассылка не найдена"))
+		return
+	}
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastSchedule, nil, fmt.Sprintf("#%d %s", id, b.ScheduleLabel()))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("⏰ Рассылка #%d запланирована: %s\n\nОтменить: /cancelschedule %d", id, b.ScheduleLabel(), id)))
+}
+
+func (h *AdminHandlers) cancelBroadcastSchedule(ctx context.Context, msg *tgbotapi.Message) {
+	id, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/cancelschedule ")), 10, 64)
+
+	if err := h.broadcastSvc.CancelSchedule(ctx, id); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastUnschedule, nil, fmt.Sprintf("#%d", id))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🚫 Рассылка #%d снята с расписания", id)))
+}
+
+// maxDeliveryFailuresShown — сколько последних ошибок доставки показывать в /deliveries
+const maxDeliveryFailuresShown = 15
+
+func (h *AdminHandlers) showDeliveries(ctx context.Context, msg *tgbotapi.Message) {
+	id, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/deliveries ")), 10, 64)
+
+	b, err := h.repo.GetBroadcastByID(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Рассылка не найдена"))
+		return
+	}
+	stats, err := h.repo.GetBroadcastDeliveryStats(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+
+	// Ошибки Telegram и username выводятся как есть, поэтому без разметки
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📬 Доставка рассылки #%d %s\n\n", b.ID, b.Name)
+	fmt.Fprintf(&sb, "✅ Доставлено: %d\n", stats[domain.DeliverySent])
+	fmt.Fprintf(&sb, "⚠️ Ошибки: %d\n", stats[domain.DeliveryFailed])
+	fmt.Fprintf(&sb, "⛔️ Заблокировали бота: %d\n", stats[domain.DeliveryBlocked])
+
+	failures, err := h.repo.GetBroadcastFailures(ctx, id, maxDeliveryFailuresShown)
+	if err != nil {
+		log.Printf("Error getting broadcast failures: %v", err)
+	}
+	if len(failures) > 0 {
+		sb.WriteString("\nПоследние ошибки:\n")
+		for _, d := range failures {
+			who := strconv.FormatInt(d.TelegramID, 10)
+			if d.Username != "" {
+				who = "@" + d.Username + " (" + who + ")"
+			}
+			icon := "⚠️"
+			if d.Status == domain.DeliveryBlocked {
+				icon = "⛔️"
+			}
+			fmt.Fprintf(&sb, "%s %s — %s", icon, who, d.Error)
+			if d.Attempts > 1 {
+				fmt.Fprintf(&sb, " (попыток: %d)", d.Attempts)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if stats[domain.DeliveryFailed] > 0 {
+		fmt.Fprintf(&sb, "\nПовторить: /retrybroadcast %d", id)
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+}
+
+func (h *AdminHandlers) retryBroadcast(ctx context.Context, msg *tgbotapi.Message) {
+	id, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/retrybroadcast ")), 10, 64)
+
+	failed, err := h.broadcastSvc.RetryFailed(ctx, id)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastRetry, nil, fmt.Sprintf("#%d, %d получ.", id, failed))
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🔁 Повторная отправка рассылки #%d: %d получателей", id, failed)))
+}
+
+func (h *AdminHandlers) stopBroadcast(ctx context.Context, msg *tgbotapi.Message) {
+	if !h.broadcastSvc.IsRunning() {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Нет активной рассылки"))
+		return
+	}
+	h.broadcastSvc.StopBroadcast()
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastStop, nil, "")
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "⏸️ Рассылка остановлена"))
+}
 
-	text := fmt.Sprintf("✅ Промокод создан\n\nКод: `%s`\nСкидка: %d%%", code, discount)
-	if maxUses > 0 {
-		text += fmt.Sprintf("\nЛимит: %d", maxUses)
+func (h *AdminHandlers) resumeBroadcast(ctx context.Context, msg *tgbotapi.Message) {
+	if err := h.broadcastSvc.ResumeBroadcast(ctx); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditBroadcastResume, nil, "")
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "▶️ Рассылка продолжена"))
+}
+
+func (h *AdminHandlers) showPromos(ctx context.Context, chatID int64) {
+	promos, _ := h.repo.GetAllPromocodes(ctx)
+
+	if len(promos) == 0 {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "Нет промокодов"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🎟 <b>Промокоды:</b>\n\n")
+	for _, p := range promos {
+		status := "✅"
+		if !p.IsActive {
+			status = "❌"
+		}
+		if !p.IsValidAt(time.Now()) && p.IsActive {
+			status = "⏸"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s — %s", status, format.Escape(p.Code), p.DiscountText()))
+		sb.WriteString(fmt.Sprintf(" (исп: %d", p.UsedCount))
+		if p.MaxUses != nil {
+			sb.WriteString(fmt.Sprintf("/%d", *p.MaxUses))
+		}
+		sb.WriteString(")")
+		if p.EndsAt != nil {
+			sb.WriteString(" до " + p.EndsAt.AddDate(0, 0, -1).Format("02.01"))
+		}
+		if len(p.PlanCodes) > 0 {
+			sb.WriteString(" (тарифы: " + format.Escape(strings.Join(p.PlanCodes, ",")) + ")")
+		}
+		if p.FirstPaymentOnly {
+			sb.WriteString(" 1️⃣")
+		}
+		sb.WriteString("\n")
+	}
+
+	msg := tgbotapi.NewMessage(chatID, sb.String())
+	msg.ParseMode = format.ParseMode
+	h.bot.Send(msg)
+}
+
+const addPromoUsage = `Формат: /addpromo CODE СКИДКА [ЛИМИТ] [опции]
+
+СКИДКА:
+  50 — скидка 50%
+  150р — скидка 150₽
+  7д — 7 дней Premium без оплаты
+
+Опции:
+  from=2026-01-01 — начало действия
+  to=2026-01-31 — окончание (включительно)
+  plans=3m,12m — только для тарифов
+  first — только на первую оплату
+
+Пример: /addpromo EARLYBIRD 50 20 to=2026-01-31 first`
+
+func (h *AdminHandlers) addPromo(ctx context.Context, msg *tgbotapi.Message) {
+	// /addpromo CODE 50 20 to=2026-01-31 plans=12m first
+	parts := strings.Fields(msg.Text)
+	if len(parts) < 3 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, addPromoUsage))
+		return
+	}
+
+	promo, err := service.ParsePromocode(parts[1], parts[2], parts[3:])
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()+"\n\n"+addPromoUsage))
+		return
+	}
+
+	if err := h.repo.CreatePromocode(ctx, promo); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
 	}
 
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditPromoCreate, nil, strings.TrimPrefix(msg.Text, "/addpromo "))
+
+	text := fmt.Sprintf("✅ Промокод создан\n\nКод: <code>%s</code>\n%s", format.Escape(promo.Code), format.Escape(formatPromoRules(promo)))
+
 	m := tgbotapi.NewMessage(msg.Chat.ID, text)
-	m.ParseMode = "Markdown"
+	m.ParseMode = format.ParseMode
 	h.bot.Send(m)
 }
 
+// formatPromoRules — условия промокода одной-двумя строками
+func formatPromoRules(p *domain.Promocode) string {
+	var rules []string
+	if p.IsFreeDays() {
+		rules = append(rules, fmt.Sprintf("Бесплатно: %d дн.", p.FreeDays))
+	} else {
+		rules = append(rules, "Скидка: "+p.DiscountText())
+	}
+	if p.MaxUses != nil {
+		rules = append(rules, fmt.Sprintf("Лимит: %d", *p.MaxUses))
+	}
+	if p.StartsAt != nil {
+		rules = append(rules, "С "+p.StartsAt.Format("02.01.2006"))
+	}
+	if p.EndsAt != nil {
+		rules = append(rules, "По "+p.EndsAt.AddDate(0, 0, -1).Format("02.01.2006"))
+	}
+	if len(p.PlanCodes) > 0 {
+		rules = append(rules, "Тарифы: "+strings.Join(p.PlanCodes, ","))
+	}
+	if p.FirstPaymentOnly {
+		rules = append(rules, "Только первая оплата")
+	}
+	return strings.Join(rules, "\n")
+}
+
 func (h *AdminHandlers) deletePromo(ctx context.Context, msg *tgbotapi.Message) {
 	code := strings.ToUpper(strings.TrimPrefix(msg.Text, "/delpromo "))
 	h.repo.DeletePromocode(ctx, code)
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditPromoDelete, nil, code)
 	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Промокод %s удалён", code)))
 }
 
 func (h *AdminHandlers) togglePromo(ctx context.Context, msg *tgbotapi.Message) {
 	code := strings.ToUpper(strings.TrimPrefix(msg.Text, "/togglepromo "))
 	h.repo.TogglePromocode(ctx, code)
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditPromoToggle, nil, code)
 	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Промокод %s переключён", code)))
 }
+
+func (h *AdminHandlers) showCampaigns(ctx context.Context, msg *tgbotapi.Message) {
+	days := 30
+	if parts := strings.Fields(msg.Text); len(parts) > 1 {
+		if d, err := strconv.Atoi(parts[1]); err == nil && d > 0 {
+			days = d
+		}
+	}
+
+	stats, err := h.repo.GetCampaignStats(ctx, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+
+	if len(stats) == 0 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("За %d дней предложений не отправлялось", days)))
+		return
+	}
+
+	titles := map[domain.PromoCampaign]string{
+		domain.PromoCampaignFirst:  "🎁 Первое предложение",
+		domain.PromoCampaignWeekly: "🔁 Еженедельное",
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📣 <b>Промо-кампании за %d дней:</b>\n", days))
+	for _, st := range stats {
+		sb.WriteString(fmt.Sprintf("\n%s\nОтправлено: %d\nОплат: %d (%.1f%%)\nВыручка: %.0f₽\n",
+			titles[st.Campaign], st.Sent, st.Converted, st.ConversionRate(), float64(st.Revenue)/100))
+	}
+
+	m := tgbotapi.NewMessage(msg.Chat.ID, sb.String())
+	m.ParseMode = format.ParseMode
+	h.bot.Send(m)
+}
+
+// ==================== USERS ====================
+
+// userFromArgs — разбирает "/команда ID|@username [аргументы...]"
+func (h *AdminHandlers) userFromArgs(ctx context.Context, msg *tgbotapi.Message, minArgs int) (*domain.User, []string, bool) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) < minArgs+1 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Недостаточно аргументов. Справка: /admin"))
+		return nil, nil, false
+	}
+
+	user, err := h.adminSvc.FindUser(ctx, parts[1])
+	if errors.Is(err, repository.ErrNotFound) {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Пользователь не найден: "+parts[1]))
+		return nil, nil, false
+	}
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return nil, nil, false
+	}
+	return user, parts[2:], true
+}
+
+func (h *AdminHandlers) showUser(ctx context.Context, msg *tgbotapi.Message) {
+	user, _, ok := h.userFromArgs(ctx, msg, 1)
+	if !ok {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "👤 Пользователь #%d\n\n", user.ID)
+	fmt.Fprintf(&b, "Telegram ID: %d\n", user.TelegramID)
+	if user.Username != "" {
+		fmt.Fprintf(&b, "Username: @%s\n", user.Username)
+	}
+	fmt.Fprintf(&b, "Имя: %s\n", user.FirstName)
+	fmt.Fprintf(&b, "Регистрация: %s\n", user.CreatedAt.Format("02.01.2006"))
+	fmt.Fprintf(&b, "Часовой пояс: %s\n", user.Timezone)
+	if user.IsBanned {
+		b.WriteString("🚫 Заблокирован\n")
+	}
+	if user.IsBlocked {
+		b.WriteString("⛔️ Заблокировал бота\n")
+	}
+	if !user.SubscribedToBroadcasts {
+		b.WriteString("🔕 Отписан от рассылок\n")
+	}
+
+	b.WriteString("\n⭐️ Подписка\n")
+	switch {
+	case user.HasOwnSubscription():
+		fmt.Fprintf(&b, "Premium до %s\n", user.SubscriptionEnd.Format("02.01.2006 15:04"))
+	case user.HasFamilyPremium():
+		fmt.Fprintf(&b, "Семейный Premium до %s\n", user.FamilySubscriptionEnd.Format("02.01.2006"))
+	case user.SubscriptionEnd != nil:
+		fmt.Fprintf(&b, "Нет (истекла %s)\n", user.SubscriptionEnd.Format("02.01.2006"))
+	default:
+		b.WriteString("Нет\n")
+	}
+	fmt.Fprintf(&b, "Скидка: %d%%\n", user.DiscountPercent)
+	if promo, err := h.repo.GetUserActivePromocode(ctx, user.ID); err == nil && promo != nil {
+		fmt.Fprintf(&b, "Активный промокод: %s (%s)\n", promo.Code, promo.DiscountText())
+	}
+
+	if habits, err := h.repo.GetActiveHabits(ctx, user.ID); err == nil {
+		fmt.Fprintf(&b, "\n📋 Привычки: %d\n", len(habits))
+		for i, habit := range habits {
+			if i == 5 {
+				fmt.Fprintf(&b, "… и ещё %d\n", len(habits)-i)
+				break
+			}
+			fmt.Fprintf(&b, "• %s\n", habit.Name)
+		}
+	}
+
+	if payments, err := h.repo.GetUserPayments(ctx, user.ID, 5); err == nil && len(payments) > 0 {
+		b.WriteString("\n💳 Платежи\n")
+		for _, p := range payments {
+			fmt.Fprintf(&b, "• %s — %.0f₽, %s, %s\n",
+				p.CreatedAt.Format("02.01.2006"), float64(p.Amount)/100, p.Status, p.Purpose)
+		}
+	}
+
+	if stats, err := h.repo.GetReferralStats(ctx, user.ID); err == nil {
+		fmt.Fprintf(&b, "\n🔗 Рефералы: %d (этап 1: %d, этап 2: %d), бонусных дней: %d\n",
+			stats.TotalReferrals, stats.Stage1Completed, stats.Stage2Completed, stats.TotalBonusDays)
+	}
+
+	if achievements, err := h.repo.GetUserAchievements(ctx, user.ID); err == nil && len(achievements) > 0 {
+		var titles []string
+		for _, a := range achievements {
+			if cfg := domain.GetAchievementConfig(a.Type); cfg != nil {
+				titles = append(titles, cfg.Emoji+" "+cfg.Title)
+			} else {
+				titles = append(titles, string(a.Type))
+			}
+		}
+		fmt.Fprintf(&b, "\n🏆 Достижения: %s\n", strings.Join(titles, ", "))
+	}
+
+	if entries, err := h.repo.GetUserAuditEntries(ctx, user.ID, 5); err == nil && len(entries) > 0 {
+		b.WriteString("\n📝 Действия админов\n")
+		for _, e := range entries {
+			fmt.Fprintf(&b, "• %s — %s %s (админ %d)\n",
+				e.CreatedAt.Format("02.01 15:04"), e.Action, e.Details, e.AdminTelegramID)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nДействия: /grant %[1]d ДНЕЙ, /revoke %[1]d ДНЕЙ, /resetdiscount %[1]d, ", user.TelegramID)
+	if user.IsBanned {
+		fmt.Fprintf(&b, "/unban %d, ", user.TelegramID)
+	} else {
+		fmt.Fprintf(&b, "/ban %d, ", user.TelegramID)
+	}
+	fmt.Fprintf(&b, "/resetstate %d", user.TelegramID)
+
+	// Без разметки: имена и username выводятся как есть
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, b.String()))
+}
+
+// changeUserDays — /grant и /revoke: начисление или списание дней Premium
+func (h *AdminHandlers) changeUserDays(ctx context.Context, msg *tgbotapi.Message) {
+	user, args, ok := h.userFromArgs(ctx, msg, 2)
+	if !ok {
+		return
+	}
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+service.ErrInvalidDays.Error()))
+		return
+	}
+
+	grant := strings.HasPrefix(msg.Text, "/grant ")
+	if grant {
+		err = h.adminSvc.GrantDays(ctx, msg.From.ID, user, days)
+	} else {
+		err = h.adminSvc.RevokeDays(ctx, msg.From.ID, user, days)
+	}
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+
+	end := "нет"
+	if updated, err := h.repo.GetUserByID(ctx, user.ID); err == nil && updated.SubscriptionEnd != nil {
+		end = updated.SubscriptionEnd.Format("02.01.2006 15:04")
+	}
+
+	if grant {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Начислено %d дн. Premium. Подписка до: %s", days, end)))
+		h.bot.Send(tgbotapi.NewMessage(user.TelegramID, fmt.Sprintf("🎁 Тебе начислено %d дн. Premium!", days)))
+	} else {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Списано %d дн. Premium. Подписка до: %s", days, end)))
+	}
+}
+
+func (h *AdminHandlers) resetUserDiscount(ctx context.Context, msg *tgbotapi.Message) {
+	user, _, ok := h.userFromArgs(ctx, msg, 1)
+	if !ok {
+		return
+	}
+
+	if err := h.adminSvc.ResetDiscount(ctx, msg.From.ID, user); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Скидка и активный промокод сброшены"))
+}
+
+// setUserBanned — /ban и /unban. Заблокированный пользователь не получает ответов, напоминаний и рассылок.
+func (h *AdminHandlers) setUserBanned(ctx context.Context, msg *tgbotapi.Message) {
+	user, _, ok := h.userFromArgs(ctx, msg, 1)
+	if !ok {
+		return
+	}
+
+	ban := strings.HasPrefix(msg.Text, "/ban ")
+	if err := h.adminSvc.SetBanned(ctx, msg.From.ID, user, ban); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ "+err.Error()))
+		return
+	}
+
+	if ban {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "🚫 Пользователь заблокирован"))
+	} else {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Пользователь разблокирован"))
+	}
+}
+
+// resetState — сбрасывает зависший диалог и счётчик действий (показ рекламы)
+func (h *AdminHandlers) resetState(ctx context.Context, msg *tgbotapi.Message) {
+	user, _, ok := h.userFromArgs(ctx, msg, 1)
+	if !ok {
+		return
+	}
+
+	if h.resetUserState != nil {
+		h.resetUserState(user.TelegramID)
+	}
+	delete(h.adminStates, user.TelegramID)
+	if err := h.repo.ResetActionCount(ctx, user.ID); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditResetState, user, "")
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Состояние пользователя сброшено"))
+}
+
+// ==================== ADMINS ====================
+
+func (h *AdminHandlers) showAudit(ctx context.Context, msg *tgbotapi.Message) {
+	limit := 20
+	if parts := strings.Fields(msg.Text); len(parts) > 1 {
+		if n, err := strconv.Atoi(parts[1]); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+
+	entries, err := h.repo.GetAuditEntries(ctx, limit)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+	if len(entries) == 0 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Журнал пуст"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📝 Журнал действий админов\n\n")
+	for _, e := range entries {
+		sb.WriteString(fmt.Sprintf("%s — %d: %s", e.CreatedAt.Format("02.01 15:04"), e.AdminTelegramID, e.Action))
+		if e.TargetUserID != nil {
+			sb.WriteString(fmt.Sprintf(" → #%d", *e.TargetUserID))
+		}
+		if e.Details != "" {
+			sb.WriteString(" " + e.Details)
+		}
+		sb.WriteString("\n")
+	}
+
+	// Без разметки: коды и названия в деталях выводятся как есть
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+}
+
+func (h *AdminHandlers) showAdmins(ctx context.Context, chatID int64) {
+	admins, err := h.repo.GetAllAdmins(ctx)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🔐 Администраторы\n\n")
+	for _, a := range admins {
+		name := ""
+		if user, err := h.repo.GetUserByTelegramID(ctx, a.TelegramID); err == nil {
+			name = " " + displayName(user)
+		}
+		sb.WriteString(fmt.Sprintf("• %d%s — %s\n", a.TelegramID, name, a.Role.Title()))
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(chatID, sb.String()))
+}
+
+// adminTelegramID — Telegram ID из аргумента: число или @username зарегистрированного пользователя
+func (h *AdminHandlers) adminTelegramID(ctx context.Context, ref string) (int64, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return id, nil
+	}
+	user, err := h.adminSvc.FindUser(ctx, ref)
+	if err != nil {
+		return 0, err
+	}
+	return user.TelegramID, nil
+}
+
+func (h *AdminHandlers) addAdmin(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) != 3 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Формат: /addadmin ID|@username РОЛЬ\nРоли: owner, admin, support, marketer"))
+		return
+	}
+
+	role := domain.AdminRole(strings.ToLower(parts[2]))
+	if !role.IsValid() {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Неизвестная роль. Роли: owner, admin, support, marketer"))
+		return
+	}
+
+	telegramID, err := h.adminTelegramID(ctx, parts[1])
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Пользователь не найден: "+parts[1]))
+		return
+	}
+	if telegramID == msg.From.ID {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Нельзя менять собственную роль"))
+		return
+	}
+
+	if err := h.repo.AddAdmin(ctx, telegramID, role); err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdminAdd, nil, fmt.Sprintf("%d %s", telegramID, role))
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ %d назначен: %s", telegramID, role.Title())))
+}
+
+func (h *AdminHandlers) removeAdmin(ctx context.Context, msg *tgbotapi.Message) {
+	parts := strings.Fields(msg.Text)
+	if len(parts) != 2 {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Формат: /removeadmin ID|@username"))
+		return
+	}
+
+	telegramID, err := h.adminTelegramID(ctx, parts[1])
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Пользователь не найден: "+parts[1]))
+		return
+	}
+	if telegramID == msg.From.ID {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Нельзя снять самого себя"))
+		return
+	}
+
+	removed, err := h.repo.RemoveAdmin(ctx, telegramID)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Ошибка: "+err.Error()))
+		return
+	}
+	if !removed {
+		h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Этот пользователь не администратор"))
+		return
+	}
+	h.adminSvc.Audit(ctx, msg.From.ID, domain.AuditAdminRemove, nil, strconv.FormatInt(telegramID, 10))
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ %d больше не администратор", telegramID)))
+}
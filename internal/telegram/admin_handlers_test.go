@@ -0,0 +1,275 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/repository"
+	"habit-tracker-bot/internal/service"
+)
+
+var errFakeRepo = errors.New("fake repository")
+
+// fakeAdminRepo — админ с ролью role; остальные методы, нужные командам, отвечают ошибкой
+type fakeAdminRepo struct {
+	repository.Repository
+	role domain.AdminRole
+}
+
+func (r fakeAdminRepo) GetAdminRole(ctx context.Context, telegramID int64) (domain.AdminRole, error) {
+	if r.role == "" {
+		return "", repository.ErrNotFound
+	}
+	return r.role, nil
+}
+
+func (fakeAdminRepo) CancelBroadcastSchedule(ctx context.Context, id int64) (bool, error) {
+	return false, errFakeRepo
+}
+
+func (fakeAdminRepo) CreateAuditEntry(ctx context.Context, entry *domain.AuditEntry) error {
+	return errFakeRepo
+}
+
+func (fakeAdminRepo) CreatePromocode(ctx context.Context, promo *domain.Promocode) error {
+	return errFakeRepo
+}
+
+func (fakeAdminRepo) DeleteAd(ctx context.Context, id int64) error {
+	return errFakeRepo
+}
+
+func (fakeAdminRepo) DeletePromocode(ctx context.Context, code string) error {
+	return errFakeRepo
+}
+
+func (fakeAdminRepo) GetActiveAds(ctx context.Context) ([]*domain.Ad, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAdByID(ctx context.Context, id int64) (*domain.Ad, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAdminStats(ctx context.Context, from, to time.Time) (*domain.AdminStats, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAdsByStatus(ctx context.Context, status domain.AdStatus) ([]*domain.Ad, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAllAdmins(ctx context.Context) ([]*domain.Admin, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAllAds(ctx context.Context) ([]*domain.Ad, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAllBroadcasts(ctx context.Context) ([]*domain.Broadcast, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAllPromocodes(ctx context.Context) ([]*domain.Promocode, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetAuditEntries(ctx context.Context, limit int) ([]*domain.AuditEntry, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetBroadcastByID(ctx context.Context, id int64) (*domain.Broadcast, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetCampaignStats(ctx context.Context, since time.Time) ([]*domain.CampaignStats, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetRunningBroadcast(ctx context.Context) (*domain.Broadcast, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) GetUserByTelegramID(ctx context.Context, telegramID int64) (*domain.User, error) {
+	return nil, errFakeRepo
+}
+
+func (fakeAdminRepo) SetAdStatus(ctx context.Context, id int64, from, to domain.AdStatus, reason string) (bool, error) {
+	return false, errFakeRepo
+}
+
+func (fakeAdminRepo) TogglePromocode(ctx context.Context, code string) error {
+	return errFakeRepo
+}
+
+// fakeTelegram — Bot API, на любой запрос отвечающий успехом
+type fakeTelegram struct{}
+
+func (fakeTelegram) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":{}}`)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func newTestAdminHandlers(t *testing.T) *AdminHandlers {
+	t.Helper()
+	return newTestAdminHandlersWithRole(t, domain.RoleOwner)
+}
+
+func newTestAdminHandlersWithRole(t *testing.T, role domain.AdminRole) *AdminHandlers {
+	t.Helper()
+	bot, err := tgbotapi.NewBotAPIWithClient("test", tgbotapi.APIEndpoint, fakeTelegram{})
+	if err != nil {
+		t.Fatalf("bot: %v", err)
+	}
+
+	repo := fakeAdminRepo{role: role}
+	tracker := service.NewClickTracker("", "")
+	adSvc := service.NewAdService(repo, tracker, 5)
+	return NewAdminHandlers(
+		bot,
+		repo,
+		service.NewBroadcastService(repo, bot, tracker, service.NewSender()),
+		adSvc,
+		service.NewAdvertiserService(repo, nil, adSvc),
+		service.NewAdminService(repo, service.NewHabitService(repo)),
+	)
+}
+
+func TestHandleAdminCommandConsumesEveryCommand(t *testing.T) {
+	// Текст для каждой команды: с аргументами, если без них команда не распознаётся
+	texts := map[string]string{
+		"/stats":     "/stats",
+		"/campaigns": "/campaigns",
+
+		"/user":          "/user 1",
+		"/grant":         "/grant 1 30",
+		"/revoke":        "/revoke 1 30",
+		"/resetdiscount": "/resetdiscount 1",
+		"/ban":           "/ban 1",
+		"/unban":         "/unban 1",
+		"/resetstate":    "/resetstate 1",
+
+		"/promos":      "/promos",
+		"/addpromo":    "/addpromo CODE 30 10",
+		"/delpromo":    "/delpromo CODE",
+		"/togglepromo": "/togglepromo CODE",
+
+		"/ads":       "/ads",
+		"/addad":     "/addad",
+		"/deletead":  "/deletead 1",
+		"/togglead":  "/togglead 1",
+		"/adtarget":  "/adtarget",
+		"/adqueue":   "/adqueue",
+		"/approvead": "/approvead 1",
+		"/rejectad":  "/rejectad 1",
+
+		"/broadcasts":        "/broadcasts",
+		"/newbroadcast":      "/newbroadcast",
+		"/editbroadcast":     "/editbroadcast",
+		"/testbroadcast":     "/testbroadcast 1",
+		"/segment":           "/segment",
+		"/schedulebroadcast": "/schedulebroadcast",
+		"/cancelschedule":    "/cancelschedule 1",
+		"/deliveries":        "/deliveries 1",
+		"/addvariant":        "/addvariant 1",
+		"/delvariant":        "/delvariant 1",
+		"/retrybroadcast":    "/retrybroadcast 1",
+		"/startbroadcast":    "/startbroadcast 1",
+		"/stopbroadcast":     "/stopbroadcast",
+		"/resumebroadcast":   "/resumebroadcast",
+
+		"/audit":       "/audit",
+		"/admins":      "/admins",
+		"/addadmin":    "/addadmin 1 support",
+		"/removeadmin": "/removeadmin 1",
+	}
+
+	for command := range adminCommandPermissions {
+		text, ok := texts[command]
+		if !ok {
+			t.Errorf("%s: no test message, add it to the table", command)
+			continue
+		}
+		t.Run(command, func(t *testing.T) {
+			h := newTestAdminHandlers(t)
+			msg := &tgbotapi.Message{
+				Text: text,
+				From: &tgbotapi.User{ID: 1},
+				Chat: &tgbotapi.Chat{ID: 1},
+			}
+			if !h.HandleAdminCommand(context.Background(), msg) {
+				t.Errorf("HandleAdminCommand(%q) = false, want true", text)
+			}
+		})
+	}
+}
+
+func TestRemoveAdminWithoutArgument(t *testing.T) {
+	h := newTestAdminHandlers(t)
+	msg := &tgbotapi.Message{
+		Text: "/removeadmin ",
+		From: &tgbotapi.User{ID: 1},
+		Chat: &tgbotapi.Chat{ID: 1},
+	}
+	if !h.HandleAdminCommand(context.Background(), msg) {
+		t.Error("HandleAdminCommand(\"/removeadmin \") = false, want true")
+	}
+}
+
+func TestHandleAdminCommandSkipsUnknownCommands(t *testing.T) {
+	h := newTestAdminHandlers(t)
+	for _, text := range []string{"/start", "/habits", "/adminx", "/statsx 7", "привет", ""} {
+		msg := &tgbotapi.Message{
+			Text: text,
+			From: &tgbotapi.User{ID: 1},
+			Chat: &tgbotapi.Chat{ID: 1},
+		}
+		if h.HandleAdminCommand(context.Background(), msg) {
+			t.Errorf("HandleAdminCommand(%q) = true, want false", text)
+		}
+	}
+}
+
+func TestAdminStateRequiresPermission(t *testing.T) {
+	tests := []struct {
+		name      string
+		role      domain.AdminRole
+		wantState bool
+		wantOK    bool
+	}{
+		{"allowed", domain.RoleMarketer, true, true},
+		{"demoted", domain.RoleSupport, false, true},
+		{"removed", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestAdminHandlersWithRole(t, tt.role)
+			h.adminStates[1] = &AdminState{Action: "broadcast_name", Data: make(map[string]string), Perm: domain.PermBroadcasts}
+			msg := &tgbotapi.Message{
+				Text: "Весенняя акция",
+				From: &tgbotapi.User{ID: 1},
+				Chat: &tgbotapi.Chat{ID: 1},
+			}
+			ok := h.HandleAdminCommand(context.Background(), msg)
+			state, stateLeft := h.adminStates[1]
+			if ok != tt.wantOK || stateLeft != tt.wantState {
+				t.Fatalf("HandleAdminCommand() = %v, state kept %v; want %v, %v", ok, stateLeft, tt.wantOK, tt.wantState)
+			}
+			if stateLeft && state.Data["name"] != msg.Text {
+				t.Errorf("state name = %q, want %q", state.Data["name"], msg.Text)
+			}
+		})
+	}
+}
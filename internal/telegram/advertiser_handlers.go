@@ -0,0 +1,256 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/format"
+	"habit-tracker-bot/internal/service"
+)
+
+// Шаги мастера /advertise
+const (
+	StateAdvertiserName    = "advertiser_name"
+	StateAdvertiserMedia   = "advertiser_media"
+	StateAdvertiserText    = "advertiser_text"
+	StateAdvertiserButton  = "advertiser_button"
+	StateAdvertiserPackage = "advertiser_package"
+)
+
+const advertiseIntroText = `📢 <b>Реклама в боте</b>
+
+Твоё объявление увидят пользователи бесплатного тарифа. Оплачиваешь пакет показов — после проверки модератором объявление показывается, пока показы не закончатся.
+
+Статистика показов и кликов: /myads
+Отменить ввод: /cancel
+
+📝 Как назвать объявление? Название видишь только ты и модератор.`
+
+// handleAdvertise — /advertise: мастер объявления, затем выбор пакета и оплата
+func (h *Handlers) handleAdvertise(ctx context.Context, msg *tgbotapi.Message) {
+	if h.tinkoffSvc == nil || !h.tinkoffSvc.IsConfigured() {
+		h.sendMessage(msg.Chat.ID, "💡 Оплата временно недоступна")
+		return
+	}
+
+	h.userStates[msg.From.ID] = &UserState{State: StateAdvertiserName}
+	h.sendMessage(msg.Chat.ID, advertiseIntroText)
+}
+
+func (h *Handlers) handleAdvertiserState(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
+	if msg.Text == "/cancel" {
+		delete(h.userStates, msg.From.ID)
+		h.sendMessage(msg.Chat.ID, "❌ Отменено")
+		return
+	}
+
+	switch state.State {
+	case StateAdvertiserName:
+		name := strings.TrimSpace(msg.Text)
+		if name == "" || len([]rune(name)) > 100 {
+			h.sendError(msg.Chat.ID, "Название — от 1 до 100 символов")
+			return
+		}
+		state.AdDraft = &domain.Ad{Name: name}
+		state.State = StateAdvertiserMedia
+		h.sendMessage(msg.Chat.ID, "🖼 Отправь фото, видео или GIF для объявления или напиши «нет»:")
+
+	case StateAdvertiserMedia:
+		mediaType, fileID := mediaFromMessage(msg)
+		if fileID == "" && !strings.EqualFold(strings.TrimSpace(msg.Text), "нет") {
+			h.sendError(msg.Chat.ID, "Нужно фото, видео, GIF или «нет»")
+			return
+		}
+		state.AdDraft.MediaType, state.AdDraft.MediaFileID = mediaType, fileID
+		state.State = StateAdvertiserText
+		h.sendMessage(msg.Chat.ID, "📝 Напиши текст объявления:\n\n"+format.Escape(formattingHint))
+
+	case StateAdvertiserText:
+		if msg.Text == "" {
+			h.sendError(msg.Chat.ID, "Нужен текст объявления")
+			return
+		}
+		state.AdDraft.Text = msg.Text
+		if err := service.CheckAd(state.AdDraft); err != nil {
+			h.sendError(msg.Chat.ID, err.Error()+"\n\nНапиши текст ещё раз:")
+			return
+		}
+		state.State = StateAdvertiserButton
+		h.sendMessage(msg.Chat.ID, "🔘 Кнопка со ссылкой: <code>Текст|https://...</code> или «нет»:")
+
+	case StateAdvertiserButton:
+		if text := strings.TrimSpace(msg.Text); !strings.EqualFold(text, "нет") {
+			caption, link, ok := strings.Cut(text, "|")
+			caption, link = strings.TrimSpace(caption), strings.TrimSpace(link)
+			if !ok || caption == "" || !(strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://")) {
+				h.sendError(msg.Chat.ID, "Формат: Текст|https://... или «нет»")
+				return
+			}
+			state.AdDraft.ButtonText, state.AdDraft.ButtonURL = &caption, &link
+		}
+
+		state.State = StateAdvertiserPackage
+		h.sendMessage(msg.Chat.ID, "👀 Так объявление увидят пользователи:")
+		h.sendAdPreview(msg.Chat.ID, state.AdDraft)
+
+		reply := tgbotapi.NewMessage(msg.Chat.ID, "💳 Выбери пакет показов:")
+		reply.ReplyMarkup = AdPackagesKeyboard()
+		h.bot.Send(reply)
+
+	case StateAdvertiserPackage:
+		h.sendMessage(msg.Chat.ID, "💳 Выбери пакет показов кнопкой выше или отмени: /cancel")
+	}
+}
+
+// sendAdPreview — объявление с прямой ссылкой, как его увидят пользователи
+func (h *Handlers) sendAdPreview(chatID int64, ad *domain.Ad) {
+	link := ""
+	if ad.ButtonURL != nil {
+		link = *ad.ButtonURL
+	}
+	h.bot.Send(adMessage(chatID, ad, AdKeyboard(ad.ID, adButtonText(ad), link)))
+}
+
+func (h *Handlers) handleAdPackageCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	state, ok := h.userStates[callback.From.ID]
+	if !ok || state.State != StateAdvertiserPackage || state.AdDraft == nil {
+		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "❌ Объявление не найдено, начни заново: /advertise", nil)
+		return
+	}
+
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		h.sendError(callback.Message.Chat.ID, "Пользователь не найден")
+		return
+	}
+
+	ad := state.AdDraft
+	payment, err := h.advertiserSvc.CreateOrder(ctx, user, ad, strings.TrimPrefix(callback.Data, "adpkg_"))
+	if err != nil {
+		log.Printf("Error creating ad order: %v", err)
+		if text, ok := errorText(err); ok {
+			h.sendError(callback.Message.Chat.ID, text)
+		} else {
+			h.sendError(callback.Message.Chat.ID, "Ошибка создания платежа")
+		}
+		return
+	}
+	delete(h.userStates, callback.From.ID)
+
+	text := fmt.Sprintf(`💳 <b>Оплата рекламы</b>
+
+Объявление: <b>%s</b>
+Показов: <b>%d</b>
+Сумма: <b>%.0f₽</b>
+
+Нажми кнопку для оплаты.
+После оплаты нажми "Проверить оплату" — объявление уйдёт на модерацию.`, format.Escape(ad.Name), ad.ImpressionsLimit, float64(payment.Amount)/100)
+
+	keyboard := AdPaymentKeyboard(payment.PaymentURL, ad.ID)
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+}
+
+func (h *Handlers) handleAdPaymentCheckCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	adID, _ := strconv.ParseInt(strings.TrimPrefix(callback.Data, "adpay_check_"), 10, 64)
+
+	ad, err := h.advertiserSvc.GetAd(ctx, adID)
+	if err != nil || ad.OrderID == nil {
+		h.answerCallback(callback.ID, "Объявление не найдено")
+		return
+	}
+
+	if ad.Status == domain.AdStatusPendingPayment {
+		tinkoffResp, err := h.tinkoffSvc.GetPaymentStatus(ctx, *ad.OrderID)
+		if err != nil {
+			log.Printf("Ошибка GetState для OrderID=%s: %v", *ad.OrderID, err)
+			h.answerCallback(callback.ID, "Не удалось проверить платёж")
+			return
+		}
+		if tinkoffResp.Status != "CONFIRMED" {
+			h.answerCallback(callback.ID, "Оплата ещё не поступила")
+			return
+		}
+		applied, err := h.tinkoffSvc.ProcessConfirmedPayment(ctx, *ad.OrderID)
+		if err != nil {
+			log.Printf("Ошибка оплаты рекламы: %v", err)
+			h.answerCallback(callback.ID, "Ошибка при активации")
+			return
+		}
+		// Если webhook успел раньше, модераторы уже получили уведомление
+		if applied {
+			h.notifyAdPaid(ctx, callback.From.ID, *ad.OrderID)
+		}
+	}
+
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "🎉 <b>Реклама оплачена!</b>", nil)
+}
+
+// notifyAdPaid — рекламодателю: объявление на модерации; модераторам: новое объявление в очереди
+func (h *Handlers) notifyAdPaid(ctx context.Context, telegramID int64, orderID string) {
+	ad, err := h.advertiserSvc.GetAdByOrderID(ctx, orderID)
+	if err != nil {
+		log.Printf("Error getting ad for payment %s: %v", orderID, err)
+		return
+	}
+
+	h.sendMessage(telegramID, fmt.Sprintf("✅ Оплата получена. Объявление <b>%s</b> на модерации — сообщим, когда его проверят.\n\nСтатистика: /myads", format.Escape(ad.Name)))
+	if h.adminHandlers != nil {
+		h.adminHandlers.NotifyAdModeration(ctx, ad)
+	}
+}
+
+// handleMyAds — /myads: статус, показы, клики и CTR объявлений рекламодателя
+func (h *Handlers) handleMyAds(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := h.repo.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения данных")
+		return
+	}
+
+	ads, err := h.advertiserSvc.AdvertiserAds(ctx, user.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения объявлений")
+		return
+	}
+	if len(ads) == 0 {
+		h.sendMessage(msg.Chat.ID, "📢 У тебя пока нет объявлений.\n\nРазместить рекламу: /advertise")
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📢 <b>Моя реклама</b>\n")
+	for _, ad := range ads {
+		sb.WriteString(fmt.Sprintf("\n<b>#%d %s</b> — %s\n", ad.ID, format.Escape(ad.Name), ad.Status.Title()))
+		sb.WriteString(fmt.Sprintf("👁 %d из %d | 👆 %d | CTR: %.1f%%\n", ad.ViewsCount, ad.ImpressionsLimit, ad.ClicksCount, ad.CTR()))
+		if ad.Status == domain.AdStatusRejected && ad.RejectReason != "" {
+			sb.WriteString("Причина: " + format.Escape(ad.RejectReason) + "\n")
+		}
+	}
+	h.sendMessage(msg.Chat.ID, sb.String())
+}
+
+// SendAdCompleted — рекламодателю: оплаченные показы закончились
+func (h *Handlers) SendAdCompleted(ad *domain.Ad) error {
+	user, err := h.repo.GetUserByID(context.Background(), *ad.AdvertiserID)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf(`🏁 <b>Показы закончились</b>
+
+Объявление <b>%s</b> показано %d раз.
+👆 Кликов: %d | CTR: %.1f%%
+
+Разместить снова: /advertise`, format.Escape(ad.Name), ad.ViewsCount, ad.ClicksCount, ad.CTR())
+
+	msg := tgbotapi.NewMessage(user.TelegramID, text)
+	msg.ParseMode = format.ParseMode
+	_, err = h.bot.Send(msg)
+	return err
+}
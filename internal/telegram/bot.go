@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
@@ -18,6 +19,10 @@ type Bot struct {
 	adminHandler *AdminHandlers
 	reminderSvc  *service.ReminderService
 	broadcastSvc *service.BroadcastService
+	clickTracker *service.ClickTracker
+	adSvc        *service.AdService
+	adminSvc     *service.AdminService
+	botUsername  string
 	cfg          *config.Config
 }
 
@@ -37,25 +42,65 @@ func NewBot(cfg *config.Config, repo repository.Repository) (*Bot, error) {
 
 	// Services
 	habitSvc := service.NewHabitService(repo)
-	subSvc := service.NewSubscriptionService(repo, cfg.SubscriptionPrice)
+	subSvc := service.NewSubscriptionService(repo, habitSvc, cfg.SubscriptionPrice, cfg.FamilyPrice, cfg.SubscriptionGraceDays)
 	referralSvc := service.NewReferralService(repo, subSvc)
 	achievementSvc := service.NewAchievementService(repo, subSvc)
 	tinkoffSvc := service.NewTinkoffService(repo, cfg.TinkoffTerminalKey, cfg.TinkoffPassword, cfg.TinkoffTestMode)
-	adSvc := service.NewAdService(repo)
 	exportSvc := service.NewExportService(repo)
 	reminderSvc := service.NewReminderService(repo)
-	broadcastSvc := service.NewBroadcastService(repo, api)
+	clickTracker := service.NewClickTracker(cfg.BaseURL, cfg.TelegramToken)
+	sender := service.NewSender()
+	broadcastSvc := service.NewBroadcastService(repo, api, clickTracker, sender)
+	adSvc := service.NewAdService(repo, clickTracker, cfg.AdFrequency)
+	giftSvc := service.NewGiftService(repo, subSvc, tinkoffSvc)
+	familySvc := service.NewFamilyService(repo, cfg.FamilyMaxMembers)
+	promoSvc := service.NewPromoService(repo)
+	campaignSvc := service.NewCampaignService(repo, sender)
+	adminSvc := service.NewAdminService(repo, habitSvc)
+	advertiserSvc := service.NewAdvertiserService(repo, tinkoffSvc, adSvc)
+	reportSvc := service.NewReportService(repo, sender, habitSvc, achievementSvc)
 
 	// Handlers
-	handlers := NewHandlers(api, repo, habitSvc, subSvc, referralSvc, achievementSvc, tinkoffSvc, adSvc, exportSvc, botUsername, cfg.SubscriptionPrice)
-	adminHandlers := NewAdminHandlers(api, repo, broadcastSvc, adSvc)
+	handlers := NewHandlers(api, repo, habitSvc, subSvc, referralSvc, achievementSvc, tinkoffSvc, adSvc, exportSvc, giftSvc, familySvc, promoSvc, advertiserSvc, reportSvc, botUsername, cfg.SubscriptionPrice)
+	adminHandlers := NewAdminHandlers(api, repo, broadcastSvc, adSvc, advertiserSvc, adminSvc)
 	handlers.SetAdminHandlers(adminHandlers)
 
 	reminderSvc.SetNotifyFunc(handlers.SendReminder)
+	subSvc.SetNotifyFunc(handlers.SendSubscriptionNotice)
+	adSvc.SetNotifyFunc(handlers.SendAdCompleted)
+	if err := reminderSvc.AddJob("*/30 * * * *", func() {
+		subSvc.CheckExpirations(context.Background())
+	}); err != nil {
+		return nil, fmt.Errorf("schedule subscription check: %w", err)
+	}
+	campaignSvc.SetNotifyFunc(handlers.SendPromoOffer)
+	if err := reminderSvc.AddJob("0 12 * * *", func() {
+		campaignSvc.RunCampaigns(context.Background())
+	}); err != nil {
+		return nil, fmt.Errorf("schedule promo campaigns: %w", err)
+	}
+	if err := reminderSvc.AddJob("15 * * * *", func() {
+		tinkoffSvc.ReleaseStaleReservations(context.Background())
+	}); err != nil {
+		return nil, fmt.Errorf("schedule promocode release: %w", err)
+	}
+	if err := reminderSvc.AddJob("* * * * *", func() {
+		broadcastSvc.RunScheduled(context.Background())
+	}); err != nil {
+		return nil, fmt.Errorf("schedule broadcasts: %w", err)
+	}
+	reportSvc.SetNotifyFunc(handlers.SendProgressReport)
+	if err := reminderSvc.AddJob("*/10 * * * *", func() {
+		reportSvc.RunDue(context.Background())
+	}); err != nil {
+		return nil, fmt.Errorf("schedule progress reports: %w", err)
+	}
 
-	// Add admin
+	// ADMIN_TELEGRAM_ID становится владельцем, пока владельца в базе нет
 	if cfg.AdminTelegramID != 0 {
-		repo.AddAdmin(context.Background(), cfg.AdminTelegramID)
+		if err := repo.BootstrapOwner(context.Background(), cfg.AdminTelegramID); err != nil {
+			log.Printf("Error bootstrapping owner: %v", err)
+		}
 	}
 
 	return &Bot{
@@ -64,6 +109,10 @@ func NewBot(cfg *config.Config, repo repository.Repository) (*Bot, error) {
 		adminHandler: adminHandlers,
 		reminderSvc:  reminderSvc,
 		broadcastSvc: broadcastSvc,
+		clickTracker: clickTracker,
+		adSvc:        adSvc,
+		adminSvc:     adminSvc,
+		botUsername:  botUsername,
 		cfg:          cfg,
 	}, nil
 }
@@ -72,6 +121,9 @@ func (b *Bot) Start(ctx context.Context) error {
 	b.reminderSvc.Start()
 	defer b.reminderSvc.Stop()
 
+	// Рассылка, прерванная падением или перезапуском, продолжается с последнего получателя
+	b.broadcastSvc.ResumeInterrupted(context.Background())
+
 	updateConfig := tgbotapi.NewUpdate(0)
 	updateConfig.Timeout = 60
 
@@ -82,6 +134,7 @@ func (b *Bot) Start(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
+			b.broadcastSvc.Shutdown(10 * time.Second)
 			log.Println("Bot stopped")
 			return nil
 		case update := <-updates:
@@ -98,6 +151,22 @@ func (b *Bot) GetBroadcastService() *service.BroadcastService {
 	return b.broadcastSvc
 }
 
+func (b *Bot) GetClickTracker() *service.ClickTracker {
+	return b.clickTracker
+}
+
+func (b *Bot) GetAdService() *service.AdService {
+	return b.adSvc
+}
+
+func (b *Bot) GetAdminService() *service.AdminService {
+	return b.adminSvc
+}
+
+func (b *Bot) GetBotUsername() string {
+	return b.botUsername
+}
+
 func (b *Bot) GetBotAPI() *tgbotapi.BotAPI {
 	return b.api
 }
@@ -2,9 +2,11 @@ package telegram
 
 import (
 	"fmt"
-	"net/url"
-	"strings"
+	"math"
 	"time"
+
+	"habit-tracker-bot/internal/chart"
+	"habit-tracker-bot/internal/domain"
 )
 
 // ChartData — данные для графика
@@ -14,162 +16,78 @@ type ChartData struct {
 	Marks  []string // "✅" или "❌" для каждого дня
 }
 
-// GenerateWeeklyChart — генерирует URL графика за неделю
-func GenerateWeeklyChart(data ChartData) string {
-	// Формируем конфиг для QuickChart
-	labelsJSON := `["` + strings.Join(data.Labels, `","`) + `"]`
-	valuesJSON := intsToString(data.Values)
-
-	chartConfig := fmt.Sprintf(`{
-    "type": "bar",
-    "data": {
-      "labels": %s,
-      "datasets": [{
-        "label": "Выполнено",
-        "data": [%s],
-        "backgroundColor": "rgba(75, 192, 192, 0.8)",
-        "borderColor": "rgba(75, 192, 192, 1)",
-        "borderWidth": 1
-      }]
-    },
-    "options": {
-      "scales": {
-        "y": {
-          "beginAtZero": true,
-          "ticks": {"stepSize": 1}
-        }
-      },
-      "plugins": {
-        "legend": {"display": false},
-        "title": {
-          "display": true,
-          "text": "Привычки за неделю"
-        }
-      }
-    }
-  }`, labelsJSON, valuesJSON)
-
-	return "https://quickchart.io/chart?c=" + url.QueryEscape(chartConfig)
+// GenerateWeeklyChart — PNG графика за неделю
+func GenerateWeeklyChart(data ChartData) ([]byte, error) {
+	return chart.Bar("Привычки за неделю", data.Labels, data.Values)
 }
 
-// GenerateHabitCalendar — генерирует "календарь" привычки (30 дней)
-func GenerateHabitCalendar(habitName string, completedDays map[string]bool) string {
-	// Собираем данные за 30 дней
-	var labels []string
-	var colors []string
+// GenerateHabitCalendar — PNG "календаря" привычки (30 дней)
+func GenerateHabitCalendar(habitName string, completedDays map[string]bool) ([]byte, error) {
+	var days []chart.Day
 
 	now := time.Now()
 	for i := 29; i >= 0; i-- {
 		date := now.AddDate(0, 0, -i)
-		dateStr := date.Format("2006-01-02")
-		dayLabel := date.Format("02")
+		days = append(days, chart.Day{Date: date, Done: completedDays[date.Format("2006-01-02")]})
+	}
 
-		labels = append(labels, dayLabel)
+	return chart.Calendar(habitName+" — 30 дней", days)
+}
 
-		if completedDays[dateStr] {
-			colors = append(colors, "rgba(75, 192, 192, 0.9)") // зелёный
-		} else {
-			colors = append(colors, "rgba(255, 99, 132, 0.5)") // красный
-		}
+// GenerateStreakChart — PNG графика серий
+func GenerateStreakChart(habits []HabitStreakData) ([]byte, error) {
+	if len(habits) == 0 {
+		return nil, nil
 	}
 
-	labelsJSON := `["` + strings.Join(labels, `","`) + `"]`
-	colorsJSON := strings.Join(colors, ",")
-
-	// Все значения = 1 для одинаковой высоты
-	values := make([]string, 30)
-	for i := range values {
-		values[i] = "1"
+	var labels []string
+	var values []int
+	for _, h := range habits {
+		labels = append(labels, h.Name)
+		values = append(values, h.Streak)
 	}
-	valuesJSON := strings.Join(values, ",")
-
-	chartConfig := fmt.Sprintf(`{
-    "type": "bar",
-    "data": {
-      "labels": %s,
-      "datasets": [{
-        "data": [%s],
-        "backgroundColor": [%s],
-        "borderWidth": 0
-      }]
-    },
-    "options": {
-      "scales": {
-        "y": {"display": false},
-        "x": {"grid": {"display": false}}
-      },
-      "plugins": {
-        "legend": {"display": false},
-        "title": {
-          "display": true,
-          "text": "%s — последние 30 дней"
-        }
-      }
-    }
-  }`, labelsJSON, valuesJSON, colorsJSON, habitName)
-
-	return "https://quickchart.io/chart?c=" + url.QueryEscape(chartConfig)
+
+	return chart.HBar("Текущие серии (дней подряд)", labels, values)
 }
 
-// GenerateStreakChart — график серий
-func GenerateStreakChart(habits []HabitStreakData) string {
-	if len(habits) == 0 {
-		return ""
+// GenerateAdminStatsChart — новые и активные пользователи по дням и выручка (правая ось)
+func GenerateAdminStatsChart(daily []domain.DailyMetric) ([]byte, error) {
+	if len(daily) == 0 {
+		return nil, nil
 	}
 
 	var labels []string
-	var values []string
+	newUsers := make([]int, len(daily))
+	activeUsers := make([]int, len(daily))
+	revenue := make([]int, len(daily))
+	for i, d := range daily {
+		labels = append(labels, d.Date.Format("02.01"))
+		newUsers[i] = d.NewUsers
+		activeUsers[i] = d.ActiveUsers
+		revenue[i] = int(d.Revenue / 100)
+	}
 
-	for _, h := range habits {
-		// Обрезаем длинные названия
-		name := h.Name
-		if len(name) > 15 {
-			name = name[:12] + "..."
-		}
-		labels = append(labels, name)
-		values = append(values, fmt.Sprintf("%d", h.Streak))
+	return chart.Trend("Пользователи и выручка по дням", labels,
+		chart.Series{Name: "Выручка, ₽", Values: revenue},
+		chart.Series{Name: "Активные", Values: activeUsers},
+		chart.Series{Name: "Новые", Values: newUsers},
+	)
+}
+
+// GenerateReportChart — PNG процента выполнения привычек за период отчёта
+func GenerateReportChart(report *domain.ProgressReport) ([]byte, error) {
+	if len(report.Habits) == 0 {
+		return nil, nil
 	}
 
-	labelsJSON := `["` + strings.Join(labels, `","`) + `"]`
-	valuesJSON := strings.Join(values, ",")
-
-	// Используем обычный bar вместо horizontalBar
-	chartConfig := fmt.Sprintf(`{
-	  "type": "bar",
-	  "data": {
-		"labels": %s,
-		"datasets": [{
-		  "label": "Дней подряд",
-		  "data": [%s],
-		  "backgroundColor": [
-			"rgba(255, 99, 132, 0.8)",
-			"rgba(54, 162, 235, 0.8)",
-			"rgba(255, 206, 86, 0.8)",
-			"rgba(75, 192, 192, 0.8)",
-			"rgba(153, 102, 255, 0.8)",
-			"rgba(255, 159, 64, 0.8)"
-		  ]
-		}]
-	  },
-	  "options": {
-		"indexAxis": "y",
-		"scales": {
-		  "x": {
-			"beginAtZero": true,
-			"ticks": {"stepSize": 1}
-		  }
-		},
-		"plugins": {
-		  "legend": {"display": false},
-		  "title": {
-			"display": true,
-			"text": "Текущие серии (дней подряд)"
-		  }
-		}
-	  }
-	}`, labelsJSON, valuesJSON)
-
-	return "https://quickchart.io/chart?c=" + url.QueryEscape(chartConfig)
+	var labels []string
+	var values []int
+	for _, h := range report.Habits {
+		labels = append(labels, h.Name)
+		values = append(values, int(math.Round(h.Rate)))
+	}
+
+	return chart.HBar(fmt.Sprintf("Выполнено за %s, %%", report.Period.Title()), labels, values)
 }
 
 type HabitStreakData struct {
@@ -177,10 +95,7 @@ type HabitStreakData struct {
 	Streak int
 }
 
-func intsToString(data []int) string {
-	strs := make([]string, len(data))
-	for i, v := range data {
-		strs[i] = fmt.Sprintf("%d", v)
-	}
-	return strings.Join(strs, ",")
+// chartFingerprint — отпечаток данных графика для кэша
+func chartFingerprint(data ...any) string {
+	return fmt.Sprint(data...)
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"regexp"
 	"sort"
 	"strconv"
@@ -13,7 +14,9 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
+	"habit-tracker-bot/internal/chart"
 	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/format"
 	"habit-tracker-bot/internal/repository"
 	"habit-tracker-bot/internal/service"
 )
@@ -30,6 +33,9 @@ const (
 	StateEditingEmoji        = "editing_emoji"
 )
 
+// chartCacheSize — сколько готовых графиков держать в памяти (PNG по 20–60 КБ)
+const chartCacheSize = 1000
+
 type UserState struct {
 	State        string
 	HabitName    string
@@ -38,6 +44,7 @@ type UserState struct {
 	SelectedDays map[int]bool
 	EditHabitID  int64
 	Emoji        string
+	AdDraft      *domain.Ad // объявление рекламодателя в мастере /advertise
 }
 
 type Handlers struct {
@@ -50,8 +57,14 @@ type Handlers struct {
 	tinkoffSvc     *service.TinkoffService
 	adSvc          *service.AdService
 	exportSvc      *service.ExportService
+	giftSvc        *service.GiftService
+	familySvc      *service.FamilyService
+	promoSvc       *service.PromoService
+	advertiserSvc  *service.AdvertiserService
+	reportSvc      *service.ReportService
 	adminHandlers  *AdminHandlers
 	userStates     map[int64]*UserState
+	charts         *chart.Cache
 	botUsername    string
 	subPrice       int64
 }
@@ -66,6 +79,11 @@ func NewHandlers(
 	tinkoffSvc *service.TinkoffService,
 	adSvc *service.AdService,
 	exportSvc *service.ExportService,
+	giftSvc *service.GiftService,
+	familySvc *service.FamilyService,
+	promoSvc *service.PromoService,
+	advertiserSvc *service.AdvertiserService,
+	reportSvc *service.ReportService,
 	botUsername string,
 	subPrice int64,
 ) *Handlers {
@@ -79,7 +97,13 @@ func NewHandlers(
 		tinkoffSvc:     tinkoffSvc,
 		adSvc:          adSvc,
 		exportSvc:      exportSvc,
+		giftSvc:        giftSvc,
+		familySvc:      familySvc,
+		promoSvc:       promoSvc,
+		advertiserSvc:  advertiserSvc,
+		reportSvc:      reportSvc,
 		userStates:     make(map[int64]*UserState),
+		charts:         chart.NewCache(chartCacheSize),
 		botUsername:    botUsername,
 		subPrice:       subPrice,
 	}
@@ -88,6 +112,12 @@ func NewHandlers(
 
 func (h *Handlers) SetAdminHandlers(ah *AdminHandlers) {
 	h.adminHandlers = ah
+	ah.resetUserState = h.resetUserState
+}
+
+// resetUserState — сбрасывает незавершённый диалог пользователя (создание привычки, ввод времени и т.п.)
+func (h *Handlers) resetUserState(telegramID int64) {
+	delete(h.userStates, telegramID)
 }
 
 func (h *Handlers) HandleUpdate(update tgbotapi.Update) {
@@ -112,18 +142,36 @@ func (h *Handlers) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 		referralCode = strings.TrimPrefix(msg.Text, "/start ref_")
 	}
 
+	// Проверяем подарочный код
+	var giftCode string
+	if strings.HasPrefix(msg.Text, "/start gift_") {
+		giftCode = strings.TrimPrefix(msg.Text, "/start gift_")
+	}
+
+	// Проверяем приглашение в семейную группу
+	var familyCode string
+	if strings.HasPrefix(msg.Text, "/start family_") {
+		familyCode = strings.TrimPrefix(msg.Text, "/start family_")
+	}
+
 	// Регистрируем пользователя
 	user := &domain.User{
 		TelegramID:   msg.From.ID,
 		Username:     msg.From.UserName,
 		FirstName:    msg.From.FirstName,
 		Timezone:     "Europe/Moscow",
+		LanguageCode: msg.From.LanguageCode,
 		ReferralCode: domain.GenerateReferralCode(),
 	}
 
 	existingUser, err := h.repo.GetUserByTelegramID(ctx, msg.From.ID)
 	isNewUser := errors.Is(err, repository.ErrNotFound)
 
+	// Заблокированных пользователей молча игнорируем
+	if existingUser != nil && existingUser.IsBanned {
+		return
+	}
+
 	if err := h.repo.CreateUser(ctx, user); err != nil {
 		log.Printf("Error creating user: %v", err)
 	}
@@ -147,6 +195,16 @@ func (h *Handlers) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 		user = existingUser
 	}
 
+	if giftCode != "" {
+		h.redeemGift(ctx, msg.Chat.ID, user, giftCode)
+		return
+	}
+
+	if familyCode != "" {
+		h.joinFamily(ctx, msg.Chat.ID, user, familyCode)
+		return
+	}
+
 	// Проверяем состояние пользователя
 	if state, ok := h.userStates[msg.From.ID]; ok {
 		h.handleUserState(ctx, msg, state)
@@ -172,6 +230,20 @@ func (h *Handlers) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
 		h.handlePremium(ctx, msg)
 	case msg.Text == "❓ Помощь" || msg.Text == "/help":
 		h.handleHelp(ctx, msg)
+	case msg.Text == "/gift":
+		h.handleGift(ctx, msg)
+	case msg.Text == "/gifts":
+		h.handleMyGifts(ctx, msg)
+	case msg.Text == "/advertise":
+		h.handleAdvertise(ctx, msg)
+	case msg.Text == "/myads":
+		h.handleMyAds(ctx, msg)
+	case msg.Text == "/family":
+		h.handleFamily(ctx, msg)
+	case msg.Text == "/offers":
+		h.handleToggleOffers(ctx, msg)
+	case msg.Text == "/reports":
+		h.handleReports(ctx, msg)
 	case strings.HasPrefix(msg.Text, "/promo "):
 		code := strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/promo ")))
 		h.applyPromocode(ctx, msg.Chat.ID, msg.From.ID, code)
@@ -187,21 +259,21 @@ func (h *Handlers) sendReferralWelcome(ctx context.Context, chatID int64, user *
 		referrerName = referrer.FirstName
 	}
 
-	text := fmt.Sprintf(`🎉 *Добро пожаловать!*
+	text := fmt.Sprintf(`🎉 <b>Добро пожаловать!</b>
 
-Ты пришёл по приглашению от *%s*!
+Ты пришёл по приглашению от <b>%s</b>!
 
-🎁 *Этап 1 выполнен!*
+🎁 <b>Этап 1 выполнен!</b>
 +%d дня Premium тебе!
 
-💡 *Этап 2:*
+💡 <b>Этап 2:</b>
 Отмечай привычки %d дней подряд и получи ещё +%d дней Premium!
 
 Начни формировать полезные привычки прямо сейчас!`,
-		referrerName, result.ReferredBonus, domain.ReferralStage2Streak, domain.ReferralStage2Bonus)
+		format.Escape(referrerName), result.ReferredBonus, domain.ReferralStage2Streak, domain.ReferralStage2Bonus)
 
 	reply := tgbotapi.NewMessage(chatID, text)
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	reply.ReplyMarkup = MainMenuKeyboard()
 	h.bot.Send(reply)
 }
@@ -214,50 +286,50 @@ func (h *Handlers) notifyReferrerStage1(ctx context.Context, result *service.Ref
 
 	var text string
 	if result.IsDiscount {
-		text = fmt.Sprintf(`🎉 *Новый реферал!*
+		text = fmt.Sprintf(`🎉 <b>Новый реферал!</b>
 
-По твоей ссылке пришёл *%s*!
+По твоей ссылке пришёл <b>%s</b>!
 
-🎁 Твоя скидка увеличена на *%d%%*!
+🎁 Твоя скидка увеличена на <b>%d%%</b>!
 
 💡 Когда %s достигнет %d дней серии — он получит ещё бонус!`,
-			referredName, result.ReferrerBonus, referredName, domain.ReferralStage2Streak)
+			format.Escape(referredName), result.ReferrerBonus, format.Escape(referredName), domain.ReferralStage2Streak)
 	} else {
-		text = fmt.Sprintf(`🎉 *Новый реферал!*
+		text = fmt.Sprintf(`🎉 <b>Новый реферал!</b>
 
-По твоей ссылке пришёл *%s*!
+По твоей ссылке пришёл <b>%s</b>!
 
-🎁 *Этап 1:* +%d дня Premium!
+🎁 <b>Этап 1:</b> +%d дня Premium!
 
 💡 Когда %s достигнет %d дней серии — вы оба получите ещё +%d дней!`,
-			referredName, result.ReferrerBonus, referredName, domain.ReferralStage2Streak, domain.ReferralStage2Bonus)
+			format.Escape(referredName), result.ReferrerBonus, format.Escape(referredName), domain.ReferralStage2Streak, domain.ReferralStage2Bonus)
 	}
 
 	msg := tgbotapi.NewMessage(referrer.TelegramID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 }
 
 func (h *Handlers) handleStart(ctx context.Context, msg *tgbotapi.Message) {
-	text := fmt.Sprintf(`👋 Привет, *%s*!
+	text := fmt.Sprintf(`👋 Привет, <b>%s</b>!
 
 Я помогу тебе сформировать полезные привычки и отслеживать прогресс.
 
-🎯 *Что я умею:*
+🎯 <b>Что я умею:</b>
 • Создавать и отслеживать привычки
 • Напоминать о выполнении (Premium)
 • Показывать статистику и серии
 
 📌 Нажми "➕ Новая привычка" чтобы начать!
 
-🆓 *Бесплатно:* до 3 привычек
-⭐️ *Premium:* безлимит + напоминания + без рекламы
+🆓 <b>Бесплатно:</b> до 3 привычек
+⭐️ <b>Premium:</b> безлимит + напоминания + без рекламы
 
-👥 *Реферальная программа:*
-Отмечай привычки 7 дней подряд и приглашай друзей!`, msg.From.FirstName)
+👥 <b>Реферальная программа:</b>
+Отмечай привычки 7 дней подряд и приглашай друзей!`, format.Escape(msg.From.FirstName))
 
 	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	reply.ReplyMarkup = MainMenuKeyboard()
 	h.bot.Send(reply)
 }
@@ -272,9 +344,9 @@ func (h *Handlers) handleHabits(ctx context.Context, msg *tgbotapi.Message) {
 	habits, _ := h.habitSvc.GetUserHabits(ctx, user.ID)
 
 	if len(habits) == 0 {
-		text := "📋 *Мои привычки*\n\nУ тебя пока нет привычек. Создай первую!"
+		text := "📋 <b>Мои привычки</b>\n\nУ тебя пока нет привычек. Создай первую!"
 		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-		reply.ParseMode = "Markdown"
+		reply.ParseMode = format.ParseMode
 		reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData("➕ Создать привычку", "create_habit"),
@@ -295,18 +367,30 @@ func (h *Handlers) handleHabits(ctx context.Context, msg *tgbotapi.Message) {
 	}
 
 	var sb strings.Builder
-	sb.WriteString("📋 *Мои привычки*\n\n")
-	sb.WriteString(fmt.Sprintf("Всего: *%d* привычек\n\n", len(habits)))
+	sb.WriteString("📋 <b>Мои привычки</b>\n\n")
+	sb.WriteString(fmt.Sprintf("Всего: <b>%d</b> привычек\n\n", len(habits)))
 
 	for emoji, count := range counts {
 		sb.WriteString(fmt.Sprintf("%s — %d\n", emoji, count))
 	}
 
+	lockedCount := countLockedHabits(habits)
+	if lockedCount > 0 {
+		sb.WriteString(fmt.Sprintf("\n🔒 Заблокировано: %d (только просмотр)\n", lockedCount))
+	}
+
 	sb.WriteString("\n👇 Выбери категорию или все:")
 
+	keyboard := HabitsViewKeyboard()
+	if lockedCount > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔒 Выбрать активные привычки", "choose_active_habits"),
+		))
+	}
+
 	reply := tgbotapi.NewMessage(msg.Chat.ID, sb.String())
-	reply.ParseMode = "Markdown"
-	reply.ReplyMarkup = HabitsViewKeyboard()
+	reply.ParseMode = format.ParseMode
+	reply.ReplyMarkup = keyboard
 	h.bot.Send(reply)
 
 	h.maybeShowAd(ctx, msg.Chat.ID, user.ID)
@@ -319,21 +403,16 @@ func (h *Handlers) handleNewHabit(ctx context.Context, msg *tgbotapi.Message) {
 		return
 	}
 
-	count, _ := h.repo.CountUserHabits(ctx, user.ID)
-	limit := domain.FreeHabitsLimit
-	if user.HasActiveSubscription() {
-		limit = domain.PremiumHabitsLimit
-	}
-
+	count, limit, _ := h.habitSvc.GetHabitLimitStatus(ctx, user)
 	if count >= limit {
-		text := fmt.Sprintf(`⚠️ *Достигнут лимит привычек*
+		text := fmt.Sprintf(`⚠️ <b>Достигнут лимит привычек</b>
   
   У тебя уже %d из %d привычек.
   
   Оформи Premium или пригласи друзей!`, count, limit)
 
 		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-		reply.ParseMode = "Markdown"
+		reply.ParseMode = format.ParseMode
 		reply.ReplyMarkup = PremiumKeyboard("", user.DiscountPercent)
 		h.bot.Send(reply)
 		return
@@ -341,9 +420,9 @@ func (h *Handlers) handleNewHabit(ctx context.Context, msg *tgbotapi.Message) {
 
 	h.userStates[msg.From.ID] = &UserState{State: "awaiting_name"}
 
-	text := "➕ *Новая привычка*\n\nВведи название привычки:"
+	text := "➕ <b>Новая привычка</b>\n\nВведи название привычки:"
 	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	reply.ReplyMarkup = CancelKeyboard()
 	h.bot.Send(reply)
 }
@@ -353,7 +432,7 @@ func (h *Handlers) handleViewAllHabitsCallback(ctx context.Context, callback *tg
 	habits, _ := h.habitSvc.GetUserHabits(ctx, user.ID)
 	completedToday, _ := h.habitSvc.GetTodayStatus(ctx, user.ID)
 
-	text := "📋 *Все привычки*\n\nВыбери привычку:"
+	text := "📋 <b>Все привычки</b>\n\nВыбери привычку:"
 	keyboard := HabitsListKeyboard(habits, completedToday)
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 }
@@ -382,13 +461,16 @@ func (h *Handlers) handleViewEmojiHabitsCallback(ctx context.Context, callback *
 		return
 	}
 
-	text := fmt.Sprintf("%s *Категория*\n\nВыбери привычку:", emoji)
+	text := fmt.Sprintf("%s <b>Категория</b>\n\nВыбери привычку:", format.Escape(emoji))
 	keyboard := HabitsListKeyboardWithBack(habits, completedToday)
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 }
 
 func (h *Handlers) handleUserState(ctx context.Context, msg *tgbotapi.Message, state *UserState) {
 	switch state.State {
+	case StateAdvertiserName, StateAdvertiserMedia, StateAdvertiserText, StateAdvertiserButton, StateAdvertiserPackage:
+		h.handleAdvertiserState(ctx, msg, state)
+
 	case "awaiting_name":
 		if len(msg.Text) > 100 {
 			h.sendError(msg.Chat.ID, "Название слишком длинное (макс. 100 символов)")
@@ -397,9 +479,9 @@ func (h *Handlers) handleUserState(ctx context.Context, msg *tgbotapi.Message, s
 		state.HabitName = msg.Text
 		state.State = StateWaitingEmoji
 
-		text := fmt.Sprintf("📝 Привычка: *%s*\n\nВыбери категорию:", state.HabitName)
+		text := fmt.Sprintf("📝 Привычка: <b>%s</b>\n\nВыбери категорию:", format.Escape(state.HabitName))
 		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-		reply.ParseMode = "Markdown"
+		reply.ParseMode = format.ParseMode
 		reply.ReplyMarkup = EmojiKeyboard()
 		h.bot.Send(reply)
 
@@ -431,10 +513,10 @@ func (h *Handlers) handleUserState(ctx context.Context, msg *tgbotapi.Message, s
 
 		delete(h.userStates, msg.From.ID)
 
-		text := fmt.Sprintf("✅ Название изменено на *%s*", msg.Text)
+		text := fmt.Sprintf("✅ Название изменено на <b>%s</b>", format.Escape(msg.Text))
 		keyboard := BackKeyboard(fmt.Sprintf("habit_%d", state.EditHabitID))
 		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-		reply.ParseMode = "Markdown"
+		reply.ParseMode = format.ParseMode
 		reply.ReplyMarkup = keyboard
 		h.bot.Send(reply)
 	}
@@ -447,7 +529,7 @@ func (h *Handlers) handleToday(ctx context.Context, msg *tgbotapi.Message) {
 		return
 	}
 
-	habits, _ := h.habitSvc.GetUserHabits(ctx, user.ID)
+	habits, _ := h.habitSvc.GetUnlockedHabits(ctx, user.ID)
 	if len(habits) == 0 {
 		h.sendMessage(msg.Chat.ID, "У тебя пока нет привычек. Создай первую!")
 		return
@@ -463,10 +545,10 @@ func (h *Handlers) handleToday(ctx context.Context, msg *tgbotapi.Message) {
 
 	streak, _ := h.habitSvc.GetUserOverallStreak(ctx, user.ID)
 
-	text := fmt.Sprintf("✅ *Сегодняшний прогресс*\n\nВыполнено: %d из %d\n🔥 Серия: %d дн.", completed, len(habits), streak)
+	text := fmt.Sprintf("✅ <b>Сегодняшний прогресс</b>\n\nВыполнено: %d из %d\n🔥 Серия: %d дн.", completed, len(habits), streak)
 
 	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	reply.ReplyMarkup = TodayChecklistKeyboard(habits, completedToday)
 	h.bot.Send(reply)
 }
@@ -480,30 +562,30 @@ func (h *Handlers) handleStats(ctx context.Context, msg *tgbotapi.Message) {
 
 	stats, _ := h.habitSvc.GetUserStats(ctx, user.ID)
 	if len(stats) == 0 {
-		h.sendMessage(msg.Chat.ID, "📊 *Статистика*\n\nУ тебя пока нет привычек.")
+		h.sendMessage(msg.Chat.ID, "📊 <b>Статистика</b>\n\nУ тебя пока нет привычек.")
 		return
 	}
 
 	overallStreak, _ := h.habitSvc.GetUserOverallStreak(ctx, user.ID)
 
 	var sb strings.Builder
-	sb.WriteString("📊 *Твоя статистика*\n\n")
-	sb.WriteString(fmt.Sprintf("🔥 *Общая серия:* %d дн.\n\n", overallStreak))
+	sb.WriteString("📊 <b>Твоя статистика</b>\n\n")
+	sb.WriteString(fmt.Sprintf("🔥 <b>Общая серия:</b> %d дн.\n\n", overallStreak))
 
 	for _, s := range stats {
 		emoji := "🔥"
 		if s.CurrentStreak == 0 {
 			emoji = "💤"
 		}
-		sb.WriteString(fmt.Sprintf("*%s*\n", s.HabitName))
+		sb.WriteString(fmt.Sprintf("<b>%s</b>\n", format.Escape(s.HabitName)))
 		sb.WriteString(fmt.Sprintf("  %s Серия: %d дн. | 🏆 Лучшая: %d дн.\n", emoji, s.CurrentStreak, s.BestStreak))
 		sb.WriteString(fmt.Sprintf("  📈 Выполнено: %.0f%%\n\n", s.CompletionRate))
 	}
 
-	sb.WriteString("👇 *Выбери график:*")
+	sb.WriteString("👇 <b>Выбери график:</b>")
 
 	reply := tgbotapi.NewMessage(msg.Chat.ID, sb.String())
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	reply.ReplyMarkup = StatsKeyboard()
 	h.bot.Send(reply)
 
@@ -522,7 +604,7 @@ func (h *Handlers) handleAchievements(ctx context.Context, msg *tgbotapi.Message
 	nextAch, daysLeft, _ := h.achievementSvc.GetNextAchievement(ctx, user.ID, streak)
 
 	var sb strings.Builder
-	sb.WriteString("🏆 *Твои достижения*\n\n")
+	sb.WriteString("🏆 <b>Твои достижения</b>\n\n")
 	if len(achievements) == 0 {
 		sb.WriteString("Пока нет достижений.\n\n")
 	} else {
@@ -533,26 +615,26 @@ func (h *Handlers) handleAchievements(ctx context.Context, msg *tgbotapi.Message
 				if cfg.BonusDays > 0 {
 					bonus = fmt.Sprintf(" (+%d дней)", cfg.BonusDays)
 				}
-				sb.WriteString(fmt.Sprintf("%s *%s*%s\n", cfg.Emoji, cfg.Title, bonus))
+				sb.WriteString(fmt.Sprintf("%s <b>%s</b>%s\n", cfg.Emoji, cfg.Title, bonus))
 			}
 		}
 		sb.WriteString("\n")
 	}
 
-	sb.WriteString(fmt.Sprintf("🔥 Текущая серия: *%d* дней\n\n", streak))
+	sb.WriteString(fmt.Sprintf("🔥 Текущая серия: <b>%d</b> дней\n\n", streak))
 
 	if nextAch != nil {
 		bonus := ""
 		if nextAch.BonusDays > 0 {
 			bonus = fmt.Sprintf(" (+%d дней Premium)", nextAch.BonusDays)
 		}
-		sb.WriteString(fmt.Sprintf("📍 *Следующее:* %s %s%s\n", nextAch.Emoji, nextAch.Title, bonus))
+		sb.WriteString(fmt.Sprintf("📍 <b>Следующее:</b> %s %s%s\n", nextAch.Emoji, nextAch.Title, bonus))
 		sb.WriteString(fmt.Sprintf("   Осталось: %d дней\n", daysLeft))
 	} else {
-		sb.WriteString("🎊 *Все достижения получены!*\n")
+		sb.WriteString("🎊 <b>Все достижения получены!</b>\n")
 	}
 
-	sb.WriteString("\n📊 *Все достижения:*\n")
+	sb.WriteString("\n📊 <b>Все достижения:</b>\n")
 	for _, cfg := range domain.AchievementsConfig {
 		has, _ := h.repo.HasAchievement(ctx, user.ID, cfg.Type)
 		status := "⬜️"
@@ -567,7 +649,7 @@ func (h *Handlers) handleAchievements(ctx context.Context, msg *tgbotapi.Message
 	}
 
 	reply := tgbotapi.NewMessage(msg.Chat.ID, sb.String())
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	h.bot.Send(reply)
 }
 
@@ -581,26 +663,26 @@ func (h *Handlers) handleReferral(ctx context.Context, msg *tgbotapi.Message) {
 	stats, _ := h.referralSvc.GetReferralStats(ctx, user.ID)
 
 	if !stats.CanInvite {
-		text := fmt.Sprintf(`👥 *Реферальная программа*
+		text := fmt.Sprintf(`👥 <b>Реферальная программа</b>
 	
-	🔒 *Пока заблокировано*
+	🔒 <b>Пока заблокировано</b>
 	
 	Выполняй все привычки %d дней подряд!
 	
-	📊 *Прогресс:* %d из %d дней
+	📊 <b>Прогресс:</b> %d из %d дней
 	
-	🎁 *За первых %d друзей:*
+	🎁 <b>За первых %d друзей:</b>
 	• Этап 1: +%d дня (регистрация)
 	• Этап 2: +%d дня (7 дней серии)
 	
-	🎁 *После %d друзей:*
+	🎁 <b>После %d друзей:</b>
 	• Скидка %d%% за каждого (до %d%%)`,
 			domain.ReferralUnlockStreak, stats.CurrentStreak, domain.ReferralUnlockStreak,
 			domain.ReferralBonusLimit, domain.ReferralStage1Bonus, domain.ReferralStage2Bonus,
 			domain.ReferralBonusLimit, domain.ReferralDiscountPerRef, domain.MaxReferralDiscount)
 
 		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-		reply.ParseMode = "Markdown"
+		reply.ParseMode = format.ParseMode
 		reply.ReplyMarkup = ReferralLockedKeyboard()
 		h.bot.Send(reply)
 		return
@@ -618,27 +700,27 @@ func (h *Handlers) handleReferral(ctx context.Context, msg *tgbotapi.Message) {
 
 	discountInfo := ""
 	if stats.AccumulatedDiscount > 0 {
-		discountInfo = fmt.Sprintf("\n💳 Накопленная скидка: *%d%%*", stats.AccumulatedDiscount)
+		discountInfo = fmt.Sprintf("\n💳 Накопленная скидка: <b>%d%%</b>", stats.AccumulatedDiscount)
 	}
 
-	text := fmt.Sprintf(`👥 *Реферальная программа*
+	text := fmt.Sprintf(`👥 <b>Реферальная программа</b>
 	
-	🎉 *Разблокировано!*
+	🎉 <b>Разблокировано!</b>
 	
-	📊 *Статистика:*
+	📊 <b>Статистика:</b>
 	• Приглашено: %d
 	• С бонусом: %d | Со скидкой: %d
 	• Получено дней: %d%s
 	
 	%s
 	
-	🔗 *Твоя ссылка:*
-	`+"`%s`",
+	🔗 <b>Твоя ссылка:</b>
+	`+"<code>%s</code>",
 		stats.TotalReferrals, stats.BonusReferrals, stats.DiscountReferrals,
 		stats.TotalBonusDays, discountInfo, bonusStatus, referralLink)
 
 	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	reply.ReplyMarkup = ReferralKeyboard(referralLink)
 	h.bot.Send(reply)
 }
@@ -651,74 +733,94 @@ func (h *Handlers) handlePremium(ctx context.Context, msg *tgbotapi.Message) {
 	}
 
 	if user.HasActiveSubscription() {
-		text := fmt.Sprintf(`⭐️ *Premium активен*
+		var until string
+		switch {
+		case !user.HasOwnSubscription():
+			until = fmt.Sprintf("👨‍👩‍👧 Семейный доступ до: <b>%s</b>", user.FamilySubscriptionEnd.Format("02.01.2006"))
+		case user.InGracePeriod():
+			until = fmt.Sprintf("⚠️ Подписка закончилась, льготный период до: <b>%s</b>", user.PremiumUntil().Format("02.01.2006"))
+		default:
+			until = fmt.Sprintf("Подписка до: <b>%s</b>", user.SubscriptionEnd.Format("02.01.2006"))
+		}
+
+		text := fmt.Sprintf(`⭐️ <b>Premium активен</b>
 	
-	Подписка до: *%s*
+	%s
 	
 	✅ Безлимитные привычки
 	✅ Напоминания о привычках
 	✅ Статистика за год
 	✅ Экспорт данных
-	✅ Без рекламы`, user.SubscriptionEnd.Format("02.01.2006"))
+	✅ Без рекламы`, until)
 		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-		reply.ParseMode = "Markdown"
+		reply.ParseMode = format.ParseMode
 		reply.ReplyMarkup = PremiumActiveKeyboard()
 		h.bot.Send(reply)
 		return
 	}
 
-	// Проверяем промокод
-	promo, _ := h.repo.GetUserActivePromocode(ctx, msg.From.ID)
-
-	// Берём максимальную скидку: реферальную или промокод
+	// Берём максимальную скидку: реферальную или промокод, если он пройдёт проверку при оплате
+	plan := domain.GetPlan(domain.DefaultPlanCode)
 	discount := user.DiscountPercent
+	finalAmount := h.subSvc.GetPriceWithDiscount(discount)
 	promoText := ""
 
-	if promo != nil && promo.DiscountPercent > discount {
-		discount = promo.DiscountPercent
-		promoText = fmt.Sprintf("\n🎟 Промокод %s применён!", promo.Code)
+	if promo := h.promoSvc.PlanPromocode(ctx, user, plan.Code); promo != nil {
+		if price := promo.Apply(h.subPrice); price < finalAmount {
+			finalAmount = price
+			discount = int((h.subPrice - finalAmount) * 100 / h.subPrice)
+			promoText = fmt.Sprintf("\n🎟 Промокод %s применён!", format.Escape(promo.Code))
+		}
 	}
 
 	originalPrice := float64(h.subPrice) / 100
-	finalPrice := originalPrice * (1 - float64(discount)/100)
+	finalPrice := float64(finalAmount) / 100
 
 	discountText := ""
 	if discount > 0 {
-		discountText = fmt.Sprintf("\n\n🎁 *Твоя скидка:* %d%%%s\n💰 Цена для тебя: *%.0f₽* ~%.0f₽~",
+		discountText = fmt.Sprintf("\n\n🎁 <b>Твоя скидка:</b> %d%%%s\n💰 Цена для тебя: <b>%.0f₽</b> <s>%.0f₽</s>",
 			discount, promoText, finalPrice, originalPrice)
 	}
 
-	text := fmt.Sprintf(`⭐️ *Premium подписка*
+	text := fmt.Sprintf(`⭐️ <b>Premium подписка</b>
 
-✨ *Что входит:*
+✨ <b>Что входит:</b>
 • ♾️ Безлимитные привычки
 • ⏰ Напоминания о привычках
 • 📊 Статистика за год
 • 📥 Экспорт данных
 • 🚫 Без рекламы
 
-💰 *Стоимость:* %.0f₽/месяц%s
+💰 <b>Стоимость:</b> %.0f₽/месяц%s
 
-💡 *Или бесплатно:* приглашай друзей!`, originalPrice, discountText)
+💡 <b>Или бесплатно:</b> приглашай друзей!`, originalPrice, discountText)
 
 	var paymentURL string
 	if h.tinkoffSvc != nil && h.tinkoffSvc.IsConfigured() {
 		pending, _ := h.repo.GetUserPendingPayment(ctx, user.ID)
-		if pending != nil && pending.DiscountPercent == discount {
+		if pending != nil && pending.DiscountPercent == discount && pending.Days == plan.Days {
 			paymentURL = pending.PaymentURL
 		}
 	}
 
+	keyboard := PremiumKeyboard(paymentURL, discount)
+	if h.tinkoffSvc != nil && h.tinkoffSvc.IsConfigured() {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, LongerPlansRows(h.subSvc.GetPlanPrice)...)
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🎁 Подарить Premium", "gift_menu"),
+	))
+
 	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-	reply.ParseMode = "Markdown"
-	reply.ReplyMarkup = PremiumKeyboard(paymentURL, discount)
+	reply.ParseMode = format.ParseMode
+	reply.ReplyMarkup = keyboard
 	h.bot.Send(reply)
 }
 
 func (h *Handlers) handleHelp(ctx context.Context, msg *tgbotapi.Message) {
-	text := `📖 *Справка*
+	text := `📖 <b>Справка</b>
 
-*Команды:*
+<b>Команды:</b>
 /start - Начать
 /habits - Мои привычки
 /new - Создать привычку
@@ -728,25 +830,32 @@ func (h *Handlers) handleHelp(ctx context.Context, msg *tgbotapi.Message) {
 /referral - Рефералы
 /premium - Подписка
 /promo - использовать промокод
-
-*🆓 Бесплатно:*
+/gift - подарить Premium другу
+/gifts - мои подарки
+/advertise - разместить рекламу
+/myads - моя реклама и статистика
+/family - семейный доступ
+/offers - вкл/выкл рассылки и предложения
+/reports - еженедельные и ежемесячные отчёты
+
+<b>🆓 Бесплатно:</b>
 • До 3 привычек
 • Статистика за 7 дней
 
-*⭐️ Premium:*
+<b>⭐️ Premium:</b>
 • Безлимитные привычки
 • ⏰ Напоминания
 • Статистика за год
 • Экспорт данных
 • Без рекламы
 
-*👥 Реферальная программа:*
+<b>👥 Реферальная программа:</b>
 1. Отмечай привычки 7 дней подряд
 2. Приглашай до 5 друзей = до 25 дней
 3. Больше 5 = скидка до 50%`
 
 	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-	reply.ParseMode = "Markdown"
+	reply.ParseMode = format.ParseMode
 	h.bot.Send(reply)
 }
 
@@ -761,6 +870,10 @@ func (h *Handlers) handleUnknown(ctx context.Context, msg *tgbotapi.Message) {
 func (h *Handlers) handleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
 	h.bot.Send(tgbotapi.NewCallback(callback.ID, ""))
 
+	if user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID); err == nil && user.IsBanned {
+		return
+	}
+
 	data := callback.Data
 
 	switch {
@@ -819,7 +932,7 @@ func (h *Handlers) handleCallback(ctx context.Context, callback *tgbotapi.Callba
 	case data == "create_habit":
 		h.handleCreateHabitCallback(ctx, callback)
 
-	case data == "subscribe":
+	case data == "subscribe" || strings.HasPrefix(data, "subscribe_plan_") || strings.HasPrefix(data, "subscribe_family_"):
 		h.handleSubscribeCallback(ctx, callback)
 
 	case data == "check_payment":
@@ -849,6 +962,12 @@ func (h *Handlers) handleCallback(ctx context.Context, callback *tgbotapi.Callba
 	case strings.HasPrefix(data, "chart_habit_"):
 		h.handleChartHabitCallback(ctx, callback)
 
+	case data == "chart_year":
+		h.handleChartYearCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "heatmap_"):
+		h.handleHeatmapCallback(ctx, callback)
+
 	case data == "back_to_stats" || data == "back_to_stats_text":
 		h.handleBackToStatsCallback(ctx, callback)
 
@@ -876,6 +995,51 @@ func (h *Handlers) handleCallback(ctx context.Context, callback *tgbotapi.Callba
 	case data == "back_to_categories":
 		h.handleBackToCategoriesCallback(ctx, callback)
 
+	case data == "choose_active_habits":
+		h.handleChooseActiveHabitsCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "toggle_lock_"):
+		h.handleToggleLockCallback(ctx, callback)
+
+	case data == "locks_done":
+		h.handleLocksDoneCallback(ctx, callback)
+
+	case data == "gift_menu":
+		h.handleGiftMenuCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "gift_plan_"):
+		h.handleGiftPlanCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "gift_check_"):
+		h.handleGiftCheckCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "adpkg_"):
+		h.handleAdPackageCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "adpay_check_"):
+		h.handleAdPaymentCheckCallback(ctx, callback)
+
+	case data == "family_menu" || data == "family_create":
+		h.handleFamilyMenuCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "family_remove_"):
+		h.handleFamilyRemoveCallback(ctx, callback)
+
+	case data == "family_leave":
+		h.handleFamilyLeaveCallback(ctx, callback)
+
+	case data == "family_disband":
+		h.handleFamilyDisbandCallback(ctx, callback)
+
+	case data == "unsubscribe_offers":
+		h.handleUnsubscribeOffersCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "report_toggle_"):
+		h.handleReportToggleCallback(ctx, callback)
+
+	case strings.HasPrefix(data, "report_preview_"):
+		h.handleReportPreviewCallback(ctx, callback)
+
 	case strings.HasPrefix(data, "close_ad_"):
 		h.bot.Send(tgbotapi.NewDeleteMessage(callback.Message.Chat.ID, callback.Message.MessageID))
 	}
@@ -906,7 +1070,7 @@ func (h *Handlers) handleFrequencyCallback(ctx context.Context, callback *tgbota
 			"monthly": "Ежемесячно",
 		}
 
-		text := fmt.Sprintf("✅ Периодичность изменена: *%s*", freqText[freq])
+		text := fmt.Sprintf("✅ Периодичность изменена: <b>%s</b>", freqText[freq])
 		keyboard := BackKeyboard(fmt.Sprintf("habit_%d", state.EditHabitID))
 		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 		return
@@ -938,7 +1102,10 @@ func (h *Handlers) handleCompleteCallback(ctx context.Context, callback *tgbotap
 	habitID, _ := strconv.ParseInt(strings.TrimPrefix(callback.Data, "complete_"), 10, 64)
 
 	user, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
-	h.habitSvc.CompleteHabit(ctx, habitID, user.ID)
+	if err := h.habitSvc.CompleteHabit(ctx, habitID, user.ID); errors.Is(err, service.ErrHabitLocked) {
+		h.answerCallback(callback.ID, "🔒 Привычка заблокирована — оформи Premium")
+		return
+	}
 
 	streak, _ := h.habitSvc.GetUserOverallStreak(ctx, user.ID)
 
@@ -972,7 +1139,7 @@ func (h *Handlers) handleUncompleteCallback(ctx context.Context, callback *tgbot
 
 func (h *Handlers) refreshToday(ctx context.Context, callback *tgbotapi.CallbackQuery) {
 	user, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
-	habits, _ := h.habitSvc.GetUserHabits(ctx, user.ID)
+	habits, _ := h.habitSvc.GetUnlockedHabits(ctx, user.ID)
 	completedToday, _ := h.habitSvc.GetTodayStatus(ctx, user.ID)
 
 	completed := 0
@@ -983,7 +1150,7 @@ func (h *Handlers) refreshToday(ctx context.Context, callback *tgbotapi.Callback
 	}
 
 	streak, _ := h.habitSvc.GetUserOverallStreak(ctx, user.ID)
-	text := fmt.Sprintf("✅ *Сегодняшний прогресс*\n\nВыполнено: %d из %d\n🔥 Серия: %d дн.", completed, len(habits), streak)
+	text := fmt.Sprintf("✅ <b>Сегодняшний прогресс</b>\n\nВыполнено: %d из %d\n🔥 Серия: %d дн.", completed, len(habits), streak)
 
 	keyboard := TodayChecklistKeyboard(habits, completedToday)
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
@@ -1000,6 +1167,21 @@ func (h *Handlers) handleHabitDetailCallback(ctx context.Context, callback *tgbo
 		emoji = "🎯"
 	}
 
+	if habit.IsLocked {
+		text := fmt.Sprintf(`🔒 %s <b>%s</b>
+  
+  Привычка заблокирована после окончания Premium.
+  История сохранена, но отмечать и редактировать её нельзя.
+  
+  📊 <b>Статистика:</b>
+  🏆 Лучшая серия: %d дн.
+  📈 Выполнено: %.0f%%`, emoji, format.Escape(habit.Name), stats.BestStreak, stats.CompletionRate)
+
+		keyboard := LockedHabitKeyboard(habitID)
+		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+		return
+	}
+
 	var freq string
 	switch habit.Frequency {
 	case domain.FrequencyDaily:
@@ -1019,14 +1201,14 @@ func (h *Handlers) handleHabitDetailCallback(ctx context.Context, callback *tgbo
 		reminder = "🔒 Только в Premium"
 	}
 
-	text := fmt.Sprintf(`%s *%s*
+	text := fmt.Sprintf(`%s <b>%s</b>
   
   📅 Периодичность: %s
   ⏰ Напоминание: %s
   
-  📊 *Статистика:*
+  📊 <b>Статистика:</b>
   🔥 Серия: %d дн. | 🏆 Лучшая: %d дн.
-  📈 Выполнено: %.0f%%`, emoji, habit.Name, freq, reminder, stats.CurrentStreak, stats.BestStreak, stats.CompletionRate)
+  📈 Выполнено: %.0f%%`, emoji, format.Escape(habit.Name), freq, reminder, stats.CurrentStreak, stats.BestStreak, stats.CompletionRate)
 
 	keyboard := HabitDetailKeyboard(habitID, user.HasActiveSubscription())
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
@@ -1036,14 +1218,14 @@ func (h *Handlers) handleStatsCallback(ctx context.Context, callback *tgbotapi.C
 	habitID, _ := strconv.ParseInt(strings.TrimPrefix(callback.Data, "stats_"), 10, 64)
 	stats, _ := h.habitSvc.GetHabitStats(ctx, habitID)
 
-	text := fmt.Sprintf(`📊 *%s*
+	text := fmt.Sprintf(`📊 <b>%s</b>
 
-🔥 Текущая серия: *%d* дн.
-🏆 Лучшая серия: *%d* дн.
+🔥 Текущая серия: <b>%d</b> дн.
+🏆 Лучшая серия: <b>%d</b> дн.
 📅 Дней отслеживания: %d
 ✅ Выполнено: %d
-📈 Процент: *%.0f%%*`,
-		stats.HabitName, stats.CurrentStreak, stats.BestStreak,
+📈 Процент: <b>%.0f%%</b>`,
+		format.Escape(stats.HabitName), stats.CurrentStreak, stats.BestStreak,
 		stats.TotalDays, stats.CompletedDays, stats.CompletionRate)
 
 	keyboard := BackKeyboard(fmt.Sprintf("habit_%d", habitID))
@@ -1092,7 +1274,7 @@ func (h *Handlers) handleDeleteCallback(ctx context.Context, callback *tgbotapi.
 	habitID, _ := strconv.ParseInt(strings.TrimPrefix(callback.Data, "delete_"), 10, 64)
 	habit, _ := h.habitSvc.GetHabit(ctx, habitID)
 
-	text := fmt.Sprintf("🗑 Удалить *%s*?\n\nСтатистика будет потеряна!", habit.Name)
+	text := fmt.Sprintf("🗑 Удалить <b>%s</b>?\n\nСтатистика будет потеряна!", format.Escape(habit.Name))
 	keyboard := ConfirmDeleteKeyboard(habitID)
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 }
@@ -1111,7 +1293,7 @@ func (h *Handlers) handleBackToHabits(ctx context.Context, callback *tgbotapi.Ca
 	habits, _ := h.habitSvc.GetUserHabits(ctx, user.ID)
 	completedToday, _ := h.habitSvc.GetTodayStatus(ctx, user.ID)
 
-	text := "📋 *Мои привычки*\n\n"
+	text := "📋 <b>Мои привычки</b>\n\n"
 	if len(habits) == 0 {
 		text += "У тебя пока нет привычек."
 	} else {
@@ -1134,7 +1316,21 @@ func (h *Handlers) handleSubscribeCallback(ctx context.Context, callback *tgbota
 		return
 	}
 
-	payment, err := h.tinkoffSvc.CreatePayment(ctx, callback.From.ID, h.subPrice, "Premium подписка на 1 месяц")
+	var plan *domain.Plan
+	if code, ok := strings.CutPrefix(callback.Data, "subscribe_family_"); ok {
+		plan = domain.GetFamilyPlan(code)
+	} else {
+		plan = domain.GetPlan(strings.TrimPrefix(callback.Data, "subscribe_plan_"))
+	}
+	if plan == nil {
+		plan = domain.GetPlan(domain.DefaultPlanCode)
+	}
+
+	description := fmt.Sprintf("Premium подписка на %s", plan.Title)
+	if plan.Family {
+		description = fmt.Sprintf("Семейная Premium подписка на %s", strings.TrimPrefix(plan.Title, "Семейный, "))
+	}
+	payment, err := h.tinkoffSvc.CreatePayment(ctx, callback.From.ID, plan, h.subSvc.GetPlanPrice(plan), description)
 	if err != nil {
 		log.Printf("Error creating payment: %v", err)
 		h.sendError(callback.Message.Chat.ID, "Ошибка создания платежа")
@@ -1146,12 +1342,13 @@ func (h *Handlers) handleSubscribeCallback(ctx context.Context, callback *tgbota
 		priceText = fmt.Sprintf("%.0f₽ (скидка %d%%)", float64(payment.Amount)/100, payment.DiscountPercent)
 	}
 
-	text := fmt.Sprintf(`💳 *Оплата подписки*
+	text := fmt.Sprintf(`💳 <b>Оплата подписки</b>
 
-Сумма: *%s*
+Тариф: <b>%s</b>
+Сумма: <b>%s</b>
 
 Нажми кнопку для оплаты.
-После оплаты нажми "Проверить оплату".`, priceText)
+После оплаты нажми "Проверить оплату".`, plan.Title, priceText)
 
 	keyboard := PremiumKeyboard(payment.PaymentURL, payment.DiscountPercent)
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
@@ -1164,11 +1361,15 @@ func (h *Handlers) handleCheckPaymentCallback(ctx context.Context, callback *tgb
 		return
 	}
 
-	// Если уже Premium — показываем статус
-	if user.HasActiveSubscription() {
-		text := fmt.Sprintf(`🎉 *Оплата прошла успешно!*
+	// Ищем последний pending платёж
+	payment, err := h.repo.GetUserPendingPayment(ctx, user.ID)
+	hasPending := err == nil && payment != nil
+
+	// Если уже Premium и продление не начато — показываем статус
+	if !hasPending && user.HasOwnSubscription() {
+		text := fmt.Sprintf(`🎉 <b>Оплата прошла успешно!</b>
 
-Premium активен до: *%s*
+Premium активен до: <b>%s</b>
 
 Теперь тебе доступны:
 ✅ Безлимитные привычки
@@ -1180,9 +1381,7 @@ Premium активен до: *%s*
 		return
 	}
 
-	// Ищем последний pending платёж
-	payment, err := h.repo.GetUserPendingPayment(ctx, user.ID)
-	if err != nil || payment == nil {
+	if !hasPending {
 		h.bot.Send(tgbotapi.NewCallback(callback.ID, "Нет активного платежа"))
 		return
 	}
@@ -1197,7 +1396,8 @@ Premium активен до: *%s*
 
 	if tinkoffResp.Status == "CONFIRMED" {
 		// Активируем подписку напрямую
-		if err := h.tinkoffSvc.ProcessConfirmedPayment(ctx, payment.OrderID); err != nil {
+		applied, err := h.tinkoffSvc.ProcessConfirmedPayment(ctx, payment.OrderID)
+		if err != nil {
 			log.Printf("Ошибка активации подписки: %v", err)
 			h.bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка при активации"))
 			return
@@ -1205,9 +1405,9 @@ Premium активен до: *%s*
 
 		// Обновляем данные пользователя
 		updatedUser, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
-		text := fmt.Sprintf(`🎉 *Оплата прошла успешно!*
+		text := fmt.Sprintf(`🎉 <b>Оплата прошла успешно!</b>
 
-Premium активен до: *%s*
+Premium активен до: <b>%s</b>
 
 Теперь тебе доступны:
 ✅ Безлимитные привычки
@@ -1217,8 +1417,10 @@ Premium активен до: *%s*
 ✅ Отсутствие рекламы`, updatedUser.SubscriptionEnd.Format("02.01.2006"))
 		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, nil)
 
-		// Уведомление
-		h.NotifyPaymentSuccess(callback.From.ID)
+		// Уведомление — только если начисление сделал этот вызов, а не webhook
+		if applied {
+			h.NotifyPaymentConfirmed(payment)
+		}
 	} else {
 		h.bot.Send(tgbotapi.NewCallback(callback.ID, "Оплата ещё не поступила"))
 	}
@@ -1228,7 +1430,7 @@ func (h *Handlers) handleExportDataCallback(ctx context.Context, callback *tgbot
 	user, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
 
 	if !user.HasActiveSubscription() {
-		text := "🔒 *Экспорт данных — Premium функция*"
+		text := "🔒 <b>Экспорт данных — Premium функция</b>"
 		keyboard := PremiumKeyboard("", user.DiscountPercent)
 		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 		return
@@ -1251,7 +1453,7 @@ func (h *Handlers) handleExportDataCallback(ctx context.Context, callback *tgbot
 func (h *Handlers) handleNeedPremiumReminder(ctx context.Context, callback *tgbotapi.CallbackQuery) {
 	user, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
 
-	text := `🔒 *Напоминания — Premium функция*
+	text := `🔒 <b>Напоминания — Premium функция</b>
 
 С напоминаниями ты не пропустишь ни одного дня!
 
@@ -1277,7 +1479,7 @@ func (h *Handlers) handleMyReferralsCallback(ctx context.Context, callback *tgbo
 	referrals, _ := h.referralSvc.GetUserReferrals(ctx, user.ID)
 
 	var sb strings.Builder
-	sb.WriteString("📋 *Мои приглашения*\n\n")
+	sb.WriteString("📋 <b>Мои приглашения</b>\n\n")
 
 	if len(referrals) == 0 {
 		sb.WriteString("Ты ещё никого не пригласил.")
@@ -1300,7 +1502,7 @@ func (h *Handlers) handleMyReferralsCallback(ctx context.Context, callback *tgbo
 			if ref.GaveDiscount {
 				bonusText = "скидка"
 			}
-			sb.WriteString(fmt.Sprintf("%d. *%s* [%s|%s] %s\n", i+1, name, stage1, stage2, bonusText))
+			sb.WriteString(fmt.Sprintf("%d. <b>%s</b> [%s|%s] %s\n", i+1, format.Escape(name), stage1, stage2, bonusText))
 		}
 	}
 
@@ -1313,57 +1515,57 @@ func (h *Handlers) handleMyReferralsCallback(ctx context.Context, callback *tgbo
 func (h *Handlers) notifyAchievement(telegramID int64, achievement *domain.AchievementConfig) {
 	bonus := ""
 	if achievement.BonusDays > 0 {
-		bonus = fmt.Sprintf("\n\n🎁 Бонус: *+%d дней* Premium!", achievement.BonusDays)
+		bonus = fmt.Sprintf("\n\n🎁 Бонус: <b>+%d дней</b> Premium!", achievement.BonusDays)
 	}
 
-	text := fmt.Sprintf(`%s *Новое достижение!*
+	text := fmt.Sprintf(`%s <b>Новое достижение!</b>
 
-*%s*
+<b>%s</b>
 %s%s`, achievement.Emoji, achievement.Title, achievement.Description, bonus)
 
 	msg := tgbotapi.NewMessage(telegramID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 }
 
 func (h *Handlers) notifyReferralStage2(ctx context.Context, result *service.ReferralResult, referredUser *domain.User) {
-	text := fmt.Sprintf(`🎉 *Этап 2 выполнен!*
+	text := fmt.Sprintf(`🎉 <b>Этап 2 выполнен!</b>
 
 Ты отмечал привычки %d дней подряд!
 
 🎁 +%d дней Premium тебе и твоему пригласившему!`, domain.ReferralStage2Streak, result.ReferredBonus)
 
 	msg := tgbotapi.NewMessage(referredUser.TelegramID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 
 	referrer, _ := h.repo.GetUserByID(ctx, result.ReferrerUserID)
 	if referrer != nil {
-		text := fmt.Sprintf(`🎉 *Реферал завершён!*
+		text := fmt.Sprintf(`🎉 <b>Реферал завершён!</b>
 
-*%s* достиг %d дней серии!
+<b>%s</b> достиг %d дней серии!
 
-🎁 *Этап 2:* +%d дней Premium!`, referredUser.FirstName, domain.ReferralStage2Streak, result.ReferrerBonus)
+🎁 <b>Этап 2:</b> +%d дней Premium!`, format.Escape(referredUser.FirstName), domain.ReferralStage2Streak, result.ReferrerBonus)
 
 		msg := tgbotapi.NewMessage(referrer.TelegramID, text)
-		msg.ParseMode = "Markdown"
+		msg.ParseMode = format.ParseMode
 		h.bot.Send(msg)
 	}
 }
 
 func (h *Handlers) notifyReferralUnlock(telegramID int64) {
-	text := `🔓 *Реферальная программа разблокирована!*
+	text := `🔓 <b>Реферальная программа разблокирована!</b>
 
 Ты выполнял привычки 7 дней подряд!
 
 Теперь можешь приглашать друзей:
-• *Этап 1:* +2 дня при регистрации
-• *Этап 2:* +3 дня при достижении серии
+• <b>Этап 1:</b> +2 дня при регистрации
+• <b>Этап 2:</b> +3 дня при достижении серии
 
 Нажми "👥 Рефералы"!`
 
 	msg := tgbotapi.NewMessage(telegramID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 }
 
@@ -1375,27 +1577,77 @@ func (h *Handlers) maybeShowAd(ctx context.Context, chatID int64, userID int64)
 		return
 	}
 
-	ad := h.adSvc.GetRandomAd(ctx)
+	ad := h.adSvc.PickAd(ctx, userID)
 	if ad == nil {
 		return
 	}
 
-	h.adSvc.TrackView(ctx, ad.ID)
+	// Показ засчитывается, только если сообщение дошло до пользователя
+	if _, err := h.bot.Send(adMessage(chatID, ad, AdKeyboard(ad.ID, adButtonText(ad), h.adSvc.ButtonLink(ad, userID)))); err != nil {
+		log.Printf("Error sending ad %d to user %d: %v", ad.ID, userID, err)
+		return
+	}
+	h.adSvc.TrackView(ctx, ad.ID, userID)
+}
+
+// adMessage — реклама с вложением (фото, видео, GIF) и текстом в подписи или просто текстом
+func adMessage(chatID int64, ad *domain.Ad, markup tgbotapi.InlineKeyboardMarkup) tgbotapi.Chattable {
+	return service.MediaMessage(chatID, ad.Text, ad.MediaType, ad.MediaFileID, ad.ImageURL, markup)
+}
 
-	msg := tgbotapi.NewMessage(chatID, ad.Text)
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = AdKeyboard(ad.ID)
-	h.bot.Send(msg)
+// adButtonText — подпись кнопки рекламодателя, по умолчанию «Подробнее»
+func adButtonText(ad *domain.Ad) string {
+	if ad.ButtonText != nil && *ad.ButtonText != "" {
+		return *ad.ButtonText
+	}
+	return "Подробнее"
 }
 
 // ==================== HELPERS ====================
 
 func (h *Handlers) sendMessage(chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	h.bot.Send(msg)
 }
 
+// serviceErrorTexts — что показать пользователю на ожидаемые ошибки сервисов
+var serviceErrorTexts = map[error]string{
+	service.ErrGiftNotFound:        "Подарок не найден",
+	service.ErrGiftNotPaid:         "Подарок ещё не оплачен",
+	service.ErrGiftAlreadyRedeemed: "Подарок уже активирован",
+	service.ErrGiftOwn:             "Нельзя активировать собственный подарок",
+	service.ErrInvalidPlan:         "Неизвестный тариф",
+
+	service.ErrFamilyNeedsPlan: "Семейный доступ доступен только на семейном тарифе",
+	service.ErrFamilyNotFound:  "Приглашение недействительно",
+	service.ErrFamilyFull:      "В семейной группе нет свободных мест",
+	service.ErrAlreadyInFamily: "Ты уже состоишь в семейной группе",
+	service.ErrFamilyOwnerJoin: "Владелец семейной группы не может вступить в другую",
+	service.ErrFamilyJoinOwn:   "Это твоя собственная семейная группа",
+	service.ErrNotFamilyMember: "Пользователь не состоит в группе",
+
+	service.ErrPromoNotFound:     "Промокод не найден",
+	service.ErrPromoNotStarted:   "Промокод ещё не действует",
+	service.ErrPromoExpired:      "Промокод больше не действует",
+	service.ErrPromoAlreadyUsed:  "Вы уже использовали этот промокод",
+	service.ErrPromoFirstPayment: "Промокод действует только на первую оплату",
+	service.ErrPromoWrongPlan:    "Промокод не действует на выбранный тариф",
+
+	service.ErrAdPackageNotFound: "Неизвестный пакет показов",
+	service.ErrAdNotOnModeration: "Реклама не на модерации",
+}
+
+// errorText — текст ожидаемой ошибки сервиса для пользователя; false, если ошибка неожиданная
+func errorText(err error) (string, bool) {
+	for target, text := range serviceErrorTexts {
+		if errors.Is(err, target) {
+			return text, true
+		}
+	}
+	return "", false
+}
+
 func (h *Handlers) sendError(chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, "❌ "+text)
 	h.bot.Send(msg)
@@ -1403,7 +1655,7 @@ func (h *Handlers) sendError(chatID int64, text string) {
 
 func (h *Handlers) editMessage(chatID int64, messageID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
-	edit.ParseMode = "Markdown"
+	edit.ParseMode = format.ParseMode
 	if keyboard != nil {
 		edit.ReplyMarkup = keyboard
 	}
@@ -1411,10 +1663,10 @@ func (h *Handlers) editMessage(chatID int64, messageID int, text string, keyboar
 }
 
 func (h *Handlers) SendReminder(telegramID int64, habitName string) error {
-	text := fmt.Sprintf("⏰ *Напоминание!*\n\nПора выполнить: *%s*", habitName)
+	text := fmt.Sprintf("⏰ <b>Напоминание!</b>\n\nПора выполнить: <b>%s</b>", format.Escape(habitName))
 
 	msg := tgbotapi.NewMessage(telegramID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("✅ Отметить", "go_today"),
@@ -1425,49 +1677,248 @@ func (h *Handlers) SendReminder(telegramID int64, habitName string) error {
 	return err
 }
 
-func (h *Handlers) NotifyPaymentSuccess(telegramID int64) {
-	text := `🎉 *Оплата прошла успешно!*
+func (h *Handlers) NotifyPaymentSuccess(telegramID int64, days int) {
+	ctx := context.Background()
+	if user, err := h.repo.GetUserByTelegramID(ctx, telegramID); err == nil {
+		if _, err := h.habitSvc.SyncHabitLocks(ctx, user); err != nil {
+			log.Printf("Error unlocking habits for user %d: %v", user.ID, err)
+		}
+	}
+
+	text := fmt.Sprintf(`🎉 <b>Оплата прошла успешно!</b>
 
-Твоя Premium подписка активирована на 30 дней!
+Твоя Premium подписка активирована на %d дней!
 
 ✅ Безлимитные привычки
 ✅ Напоминания
 ✅ Статистика за год
 ✅ Экспорт данных
-✅ Без рекламы`
+✅ Без рекламы`, days)
 
 	msg := tgbotapi.NewMessage(telegramID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	msg.ReplyMarkup = MainMenuKeyboard()
 	h.bot.Send(msg)
 }
 
+// NotifyPaymentConfirmed — уведомление об оплате с учётом назначения платежа
+func (h *Handlers) NotifyPaymentConfirmed(payment *domain.Payment) {
+	ctx := context.Background()
+	user, err := h.repo.GetUserByID(ctx, payment.UserID)
+	if err != nil {
+		log.Printf("Error getting user %d for payment %s: %v", payment.UserID, payment.OrderID, err)
+		return
+	}
+
+	if payment.Purpose == domain.PaymentPurposeAd {
+		h.notifyAdPaid(ctx, user.TelegramID, payment.OrderID)
+		return
+	}
+	if payment.Purpose != domain.PaymentPurposeGift {
+		h.NotifyPaymentSuccess(user.TelegramID, payment.Days)
+		return
+	}
+
+	gift, err := h.giftSvc.GetGiftByOrderID(ctx, payment.OrderID)
+	if err != nil {
+		log.Printf("Error getting gift for payment %s: %v", payment.OrderID, err)
+		return
+	}
+	h.sendGiftLink(user.TelegramID, gift)
+}
+
+// SendPromoOffer — персональное предложение из автоматической промо-кампании
+func (h *Handlers) SendPromoOffer(user *domain.User, offer *service.PromoOffer) error {
+	promo := offer.Promocode
+	validUntil := promo.EndsAt.Format("02.01")
+
+	var text string
+	if offer.Campaign == domain.PromoCampaignFirst {
+		text = fmt.Sprintf(`🎁 <b>%s, специально для тебя!</b>
+
+Попробуй Premium со скидкой <b>%d%%</b> на первую оплату:
+♾️ Безлимитные привычки
+⏰ Напоминания
+📊 Статистика за год
+🚫 Без рекламы
+
+Твой промокод: `+"<code>%s</code>"+`
+Действует до %s.`, format.Escape(user.FirstName), promo.DiscountPercent, format.Escape(promo.Code), validUntil)
+	} else {
+		text = fmt.Sprintf(`⭐️ <b>Возвращайся к Premium!</b>
+
+Персональная скидка <b>%d%%</b> — только для тебя и только один раз.
+
+Твой промокод: `+"<code>%s</code>"+`
+Действует до %s.`, promo.DiscountPercent, format.Escape(promo.Code), validUntil)
+	}
+
+	msg := tgbotapi.NewMessage(user.TelegramID, text)
+	msg.ParseMode = format.ParseMode
+	msg.ReplyMarkup = PromoOfferKeyboard(promo.DiscountPercent)
+	_, err := h.bot.Send(msg)
+	return err
+}
+
+func (h *Handlers) handleUnsubscribeOffersCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		return
+	}
+
+	if err := h.repo.SetBroadcastSubscription(ctx, user.ID, false); err != nil {
+		log.Printf("Error unsubscribing user %d: %v", user.ID, err)
+		return
+	}
+
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		"🔕 Больше не будем присылать предложения и рассылки.\n\nВключить обратно: /offers", nil)
+}
+
+func (h *Handlers) handleToggleOffers(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := h.repo.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения данных")
+		return
+	}
+
+	subscribed := !user.SubscribedToBroadcasts
+	if err := h.repo.SetBroadcastSubscription(ctx, user.ID, subscribed); err != nil {
+		h.sendError(msg.Chat.ID, "Не удалось изменить настройку")
+		return
+	}
+
+	if subscribed {
+		h.sendMessage(msg.Chat.ID, "🔔 Рассылки и персональные предложения включены")
+	} else {
+		h.sendMessage(msg.Chat.ID, "🔕 Рассылки и персональные предложения выключены")
+	}
+}
+
+// SendSubscriptionNotice — напоминание об окончании подписки с кнопкой продления
+func (h *Handlers) SendSubscriptionNotice(user *domain.User, notice domain.SubscriptionNotice) error {
+	endDate := user.SubscriptionEnd.Format("02.01.2006")
+
+	var text string
+	switch notice {
+	case domain.SubscriptionNoticeExpiring3d, domain.SubscriptionNoticeExpiring1d:
+		// Окно 3-дневного напоминания — от 1 до 3 дней, поэтому считаем по фактической дате
+		days := int(math.Ceil(time.Until(*user.SubscriptionEnd).Hours() / 24))
+		if days < 1 {
+			days = 1
+		}
+		text = fmt.Sprintf(`⏳ <b>Premium скоро закончится</b>
+
+Подписка действует до <b>%s</b> (осталось %s).
+
+Продли сейчас, чтобы не потерять напоминания и безлимит привычек!`, endDate, formatDaysLeft(days))
+
+	case domain.SubscriptionNoticeGraceStart:
+		text = fmt.Sprintf(`⚠️ <b>Подписка закончилась</b>
+
+Premium-функции сохранятся до <b>%s</b>.
+
+Продли подписку, чтобы ничего не потерять!`, user.PremiumUntil().Format("02.01.2006"))
+
+	case domain.SubscriptionNoticeExpired:
+		text = fmt.Sprintf(`😔 <b>Premium закончился</b>
+
+Без подписки ты теряешь:
+❌ Напоминания о привычках
+❌ Больше %d привычек
+❌ Статистику за год (останется %d дней)
+❌ Экспорт данных
+❌ Отключение рекламы
+
+Вся история сохранена — продли подписку и продолжай!`, domain.FreeHabitsLimit, domain.FreeHistoryDays)
+
+		// Блокировки уже выставлены в CheckExpirations
+		habits, err := h.habitSvc.GetUserHabits(context.Background(), user.ID)
+		if err != nil {
+			log.Printf("Error getting habits for user %d: %v", user.ID, err)
+		}
+		if locked := countLockedHabits(habits); locked > 0 {
+			text += fmt.Sprintf("\n\n🔒 Заблокировано привычек: <b>%d</b>. Выбери, какие %d останутся активными — остальные будут доступны только для просмотра.",
+				locked, domain.FreeHabitsLimit)
+
+			keyboard := RenewSubscriptionKeyboard(user.DiscountPercent)
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🔒 Выбрать активные привычки", "choose_active_habits"),
+			))
+
+			msg := tgbotapi.NewMessage(user.TelegramID, text)
+			msg.ParseMode = format.ParseMode
+			msg.ReplyMarkup = keyboard
+			_, err := h.bot.Send(msg)
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown subscription notice: %s", notice)
+	}
+
+	msg := tgbotapi.NewMessage(user.TelegramID, text)
+	msg.ParseMode = format.ParseMode
+	msg.ReplyMarkup = RenewSubscriptionKeyboard(user.DiscountPercent)
+	_, err := h.bot.Send(msg)
+	return err
+}
+
+func formatDaysLeft(days int) string {
+	switch {
+	case days%10 == 1 && days%100 != 11:
+		return fmt.Sprintf("%d день", days)
+	case days%10 >= 2 && days%10 <= 4 && (days%100 < 10 || days%100 >= 20):
+		return fmt.Sprintf("%d дня", days)
+	}
+	return fmt.Sprintf("%d дней", days)
+}
+
 func (h *Handlers) applyPromocode(ctx context.Context, chatID int64, userID int64, code string) {
-	promo, err := h.repo.GetPromocodeByCode(ctx, code)
-	if err != nil || promo == nil || !promo.IsActive {
-		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Промокод не найден"))
+	user, err := h.repo.GetUserByTelegramID(ctx, userID)
+	if err != nil {
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Пользователь не найден"))
 		return
 	}
 
-	// Проверяем лимит
-	if promo.MaxUses != nil && promo.UsedCount >= *promo.MaxUses {
-		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Промокод больше не действует"))
+	promo, err := h.promoSvc.ApplyPromocode(ctx, user, code)
+	if err != nil {
+		if text, ok := errorText(err); ok {
+			h.sendError(chatID, text)
+		} else {
+			log.Printf("Error applying promocode %s: %v", code, err)
+			h.sendError(chatID, "Не удалось применить промокод")
+		}
 		return
 	}
 
-	// Проверяем использовал ли
-	used, _ := h.repo.HasUserUsedPromocode(ctx, userID, promo.ID)
-	if used {
-		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Вы уже использовали этот промокод"))
+	if promo.IsFreeDays() {
+		if updated, err := h.repo.GetUserByID(ctx, user.ID); err == nil {
+			if _, err := h.habitSvc.SyncHabitLocks(ctx, updated); err != nil {
+				log.Printf("Error unlocking habits for user %d: %v", user.ID, err)
+			}
+		}
+		h.bot.Send(tgbotapi.NewMessage(chatID,
+			fmt.Sprintf("✅ Промокод применён!\n\n🎁 Начислено %d дней Premium.", promo.FreeDays)))
 		return
 	}
 
-	// Сохраняем
-	h.repo.SetUserActivePromocode(ctx, userID, promo.ID)
+	text := fmt.Sprintf("✅ Промокод применён!\n\nСкидка: %s", promo.DiscountText())
+	if len(promo.PlanCodes) > 0 {
+		var titles []string
+		for _, code := range promo.PlanCodes {
+			if plan := domain.GetPlan(code); plan != nil {
+				titles = append(titles, plan.Title)
+			}
+		}
+		text += "\nДействует на тарифы: " + strings.Join(titles, ", ")
+	}
+	if promo.EndsAt != nil {
+		text += "\nДействует до: " + promo.EndsAt.AddDate(0, 0, -1).Format("02.01.2006")
+	}
+	text += "\n\nПерейдите к оплате — скидка применится автоматически."
 
-	h.bot.Send(tgbotapi.NewMessage(chatID,
-		fmt.Sprintf("✅ Промокод применён!\n\nСкидка: %d%%\n\nПерейдите к оплате — скидка применится автоматически.",
-			promo.DiscountPercent)))
+	h.bot.Send(tgbotapi.NewMessage(chatID, text))
 }
 
 func (h *Handlers) handleReminderModeCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
@@ -1507,7 +1958,7 @@ func (h *Handlers) handleReminderModeCallback(ctx context.Context, callback *tgb
 			// Вернуться к привычке
 			keyboard := HabitDetailKeyboard(state.EditHabitID, true)
 			habit, _ := h.habitSvc.GetHabit(ctx, state.EditHabitID)
-			h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, fmt.Sprintf("📌 *%s*", habit.Name), &keyboard)
+			h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, fmt.Sprintf("📌 <b>%s</b>", format.Escape(habit.Name)), &keyboard)
 			delete(h.userStates, callback.From.ID)
 			return
 		}
@@ -1574,7 +2025,7 @@ func (h *Handlers) handleReminderDaysCallback(ctx context.Context, callback *tgb
 		delete(h.userStates, callback.From.ID)
 
 		daysText := formatDays(days)
-		text := fmt.Sprintf("✅ Напоминание установлено: *%s* (%s)", reminderTime, daysText)
+		text := fmt.Sprintf("✅ Напоминание установлено: <b>%s</b> (%s)", reminderTime, daysText)
 		keyboard := BackKeyboard(fmt.Sprintf("habit_%d", state.EditHabitID))
 		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 		return
@@ -1646,15 +2097,15 @@ func (h *Handlers) createHabitFinal(ctx context.Context, chatID int64, telegramI
 		h.repo.UpdateHabitReminder(ctx, habit.ID, reminderTime, reminderDays)
 	}
 
-	text := fmt.Sprintf("✅ Привычка создана!\n\n%s *%s*", emoji, habit.Name)
+	text := fmt.Sprintf("✅ Привычка создана!\n\n%s <b>%s</b>", emoji, format.Escape(habit.Name))
 	if reminderTime != nil {
 		daysText := formatDays(reminderDays)
-		text += fmt.Sprintf("\n⏰ Напоминание: *%s* (%s)", *reminderTime, daysText)
+		text += fmt.Sprintf("\n⏰ Напоминание: <b>%s</b> (%s)", *reminderTime, daysText)
 	}
 
 	keyboard := BackKeyboard("back_to_habits")
 	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ParseMode = "Markdown"
+	msg.ParseMode = format.ParseMode
 	msg.ReplyMarkup = keyboard
 	h.bot.Send(msg)
 }
@@ -1719,13 +2170,19 @@ func (h *Handlers) handleChartWeeklyCallback(ctx context.Context, callback *tgbo
 		Values: values,
 	}
 
-	chartURL := GenerateWeeklyChart(chartData)
-	log.Printf("Chart weekly URL: %s", chartURL[:100]) // первые 100 символов
+	png, err := h.charts.Get(chart.NewKey(user.ID, "weekly"), chartFingerprint(chartData), func() ([]byte, error) {
+		return GenerateWeeklyChart(chartData)
+	})
+	if err != nil {
+		log.Printf("Chart weekly: ошибка отрисовки: %v", err)
+		h.sendError(callback.Message.Chat.ID, "Не удалось построить график")
+		return
+	}
 
 	// Отправляем картинку
-	photo := tgbotapi.NewPhoto(callback.Message.Chat.ID, tgbotapi.FileURL(chartURL))
-	photo.Caption = "📊 *Выполнено привычек за неделю*"
-	photo.ParseMode = "Markdown"
+	photo := chartPhoto(callback.Message.Chat.ID, png)
+	photo.Caption = "📊 <b>Выполнено привычек за неделю</b>"
+	photo.ParseMode = format.ParseMode
 
 	// Удаляем старое сообщение
 	h.bot.Request(tgbotapi.NewDeleteMessage(callback.Message.Chat.ID, callback.Message.MessageID))
@@ -1769,13 +2226,19 @@ func (h *Handlers) handleChartStreaksCallback(ctx context.Context, callback *tgb
 		})
 	}
 
-	chartURL := GenerateStreakChart(chartData)
-	log.Printf("Chart streaks URL length: %d", len(chartURL))
+	png, err := h.charts.Get(chart.NewKey(user.ID, "streaks"), chartFingerprint(chartData), func() ([]byte, error) {
+		return GenerateStreakChart(chartData)
+	})
+	if err != nil {
+		log.Printf("Chart streaks: ошибка отрисовки: %v", err)
+		h.sendError(callback.Message.Chat.ID, "Не удалось построить график")
+		return
+	}
 
 	// Отправляем картинку
-	photo := tgbotapi.NewPhoto(callback.Message.Chat.ID, tgbotapi.FileURL(chartURL))
-	photo.Caption = "🔥 *Текущие серии привычек*\n\nЧем длиннее полоска — тем дольше серия!"
-	photo.ParseMode = "Markdown"
+	photo := chartPhoto(callback.Message.Chat.ID, png)
+	photo.Caption = "🔥 <b>Текущие серии привычек</b>\n\nЧем длиннее полоска — тем дольше серия!"
+	photo.ParseMode = format.ParseMode
 
 	h.bot.Request(tgbotapi.NewDeleteMessage(callback.Message.Chat.ID, callback.Message.MessageID))
 	h.bot.Send(photo)
@@ -1845,16 +2308,22 @@ func (h *Handlers) handleChartHabitCallback(ctx context.Context, callback *tgbot
 
 	log.Printf("Chart habit: найдено %d дней выполнения", len(completedDays))
 
-	chartURL := GenerateHabitCalendar(habit.Name, completedDays)
-	log.Printf("Chart habit: URL длина=%d", len(chartURL))
+	png, err := h.charts.Get(chart.NewKey(habit.UserID, fmt.Sprintf("calendar_%d", habit.ID)), chartFingerprint(habit.Name, completedDays), func() ([]byte, error) {
+		return GenerateHabitCalendar(habit.Name, completedDays)
+	})
+	if err != nil {
+		log.Printf("Chart habit: ошибка отрисовки: %v", err)
+		h.sendError(callback.Message.Chat.ID, "Не удалось построить график")
+		return
+	}
 
 	// Удаляем старое сообщение
 	h.bot.Request(tgbotapi.NewDeleteMessage(callback.Message.Chat.ID, callback.Message.MessageID))
 
 	// Отправляем картинку
-	photo := tgbotapi.NewPhoto(callback.Message.Chat.ID, tgbotapi.FileURL(chartURL))
-	photo.Caption = fmt.Sprintf("📅 *%s* — последние 30 дней\n\n🟢 — выполнено\n🔴 — пропущено", habit.Name)
-	photo.ParseMode = "Markdown"
+	photo := chartPhoto(callback.Message.Chat.ID, png)
+	photo.Caption = fmt.Sprintf("📅 <b>%s</b> — последние 30 дней\n\n🟢 — выполнено\n🔴 — пропущено", format.Escape(habit.Name))
+	photo.ParseMode = format.ParseMode
 
 	_, err = h.bot.Send(photo)
 	if err != nil {
@@ -1872,67 +2341,206 @@ func (h *Handlers) handleChartHabitCallback(ctx context.Context, callback *tgbot
 	h.bot.Send(msg)
 }
 
-func (h *Handlers) handleBackToStatsCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
-	user, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
-	stats, _ := h.habitSvc.GetUserStats(ctx, user.ID)
-	overallStreak, _ := h.habitSvc.GetUserOverallStreak(ctx, user.ID)
-
-	var sb strings.Builder
-	sb.WriteString("📊 *Твоя статистика*\n\n")
-	sb.WriteString(fmt.Sprintf("🔥 *Общая серия:* %d дн.\n\n", overallStreak))
+func (h *Handlers) handleChartYearCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		h.sendError(callback.Message.Chat.ID, "Ошибка")
+		return
+	}
 
-	for _, s := range stats {
-		emoji := "🔥"
-		if s.CurrentStreak == 0 {
-			emoji = "💤"
-		}
-		sb.WriteString(fmt.Sprintf("*%s*\n", s.HabitName))
-		sb.WriteString(fmt.Sprintf("  %s Серия: %d дн. | 🏆 Лучшая: %d дн.\n", emoji, s.CurrentStreak, s.BestStreak))
-		sb.WriteString(fmt.Sprintf("  📈 Выполнено: %.0f%%\n\n", s.CompletionRate))
+	if !user.HasActiveSubscription() {
+		text := fmt.Sprintf("🔒 <b>Карта за год — Premium функция</b>\n\nВся история отметок за %d дней, по каждой привычке и по всем сразу.", domain.PremiumHistoryDays)
+		keyboard := PremiumKeyboard("", user.DiscountPercent)
+		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+		return
 	}
 
-	sb.WriteString("👇 *Выбери график:*")
+	habits, err := h.habitSvc.GetUserHabits(ctx, user.ID)
+	if err != nil {
+		h.sendError(callback.Message.Chat.ID, "Ошибка загрузки привычек")
+		return
+	}
+	if len(habits) == 0 {
+		h.answerCallback(callback.ID, "У тебя нет привычек")
+		return
+	}
 
-	keyboard := StatsKeyboard()
-	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, sb.String(), &keyboard)
+	keyboard := HeatmapSelectKeyboard(habits, time.Now().Year())
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "🗓 Выбери привычку для карты за год:", &keyboard)
 }
 
-// -------------------- HELPERS --------------------------
+// handleHeatmapCallback — heatmap_<habitID>_<год>: годовая карта привычки (0 — все привычки).
+// Переход между годами заменяет картинку в том же сообщении.
+func (h *Handlers) handleHeatmapCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	var habitID int64
+	var year int
+	if _, err := fmt.Sscanf(callback.Data, "heatmap_%d_%d", &habitID, &year); err != nil {
+		h.answerCallback(callback.ID, "Ошибка")
+		return
+	}
 
-func (h *Handlers) handleEmojiCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
-	state, ok := h.userStates[callback.From.ID]
-	if !ok {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		h.sendError(callback.Message.Chat.ID, "Ошибка")
+		return
+	}
+	if !user.HasActiveSubscription() {
+		h.answerCallback(callback.ID, "🔒 Карта за год — Premium функция")
 		return
 	}
 
-	emoji := strings.TrimPrefix(callback.Data, "emoji:")
+	now := time.Now()
+	minYear, maxYear := user.CreatedAt.Year(), now.Year()
+	if year < minYear || year > maxYear {
+		h.answerCallback(callback.ID, "Нет данных за этот год")
+		return
+	}
 
-	// Если редактируем существующую привычку
-	if state.State == StateEditingEmoji {
-		err := h.repo.UpdateHabitEmoji(ctx, state.EditHabitID, emoji)
-		if err != nil {
-			h.sendError(callback.Message.Chat.ID, "Ошибка сохранения")
-			delete(h.userStates, callback.From.ID)
+	title := "Все привычки"
+	if habitID != 0 {
+		habit, err := h.habitSvc.GetHabit(ctx, habitID)
+		if err != nil || habit.UserID != user.ID {
+			h.answerCallback(callback.ID, "Привычка не найдена")
 			return
 		}
+		title = habit.Name
+	}
 
-		delete(h.userStates, callback.From.ID)
-
-		text := fmt.Sprintf("✅ Категория изменена: %s", emoji)
-		keyboard := BackKeyboard(fmt.Sprintf("habit_%d", state.EditHabitID))
-		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	ratios, err := h.repo.GetCompletionRatios(ctx, user.ID, habitID, from, from.AddDate(1, 0, 0))
+	if err != nil {
+		log.Printf("Heatmap: ошибка GetCompletionRatios для user.ID=%d: %v", user.ID, err)
+		h.sendError(callback.Message.Chat.ID, "Ошибка загрузки данных")
 		return
 	}
 
-	// Если создаём новую привычку
-	if state.State != StateWaitingEmoji {
+	key := chart.NewKey(user.ID, fmt.Sprintf("year_%d_%d", habitID, year))
+	png, err := h.charts.Get(key, chartFingerprint(title, ratios), func() ([]byte, error) {
+		return chart.Heatmap(fmt.Sprintf("%s — %d", title, year), year, ratios, now)
+	})
+	if err != nil {
+		log.Printf("Heatmap: ошибка отрисовки: %v", err)
+		h.sendError(callback.Message.Chat.ID, "Не удалось построить график")
+		return
+	}
+
+	activeDays, fullDays := 0, 0
+	for _, r := range ratios {
+		if r > 0 {
+			activeDays++
+		}
+		if r >= 1 {
+			fullDays++
+		}
+	}
+	caption := fmt.Sprintf("🗓 <b>%s — %d</b>\n\nДней с отметками: <b>%d</b>\nВыполнено всё: <b>%d</b>", format.Escape(title), year, activeDays, fullDays)
+	keyboard := HeatmapKeyboard(habitID, year, minYear, maxYear)
+
+	// Листание лет: картинка меняется в том же сообщении
+	if callback.Message.Photo != nil {
+		media := tgbotapi.NewInputMediaPhoto(tgbotapi.FileBytes{Name: "chart.png", Bytes: png})
+		media.Caption = caption
+		media.ParseMode = format.ParseMode
+		edit := tgbotapi.EditMessageMediaConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      callback.Message.Chat.ID,
+				MessageID:   callback.Message.MessageID,
+				ReplyMarkup: &keyboard,
+			},
+			Media: media,
+		}
+		if _, err := h.bot.Request(edit); err != nil {
+			log.Printf("Heatmap: ошибка замены картинки: %v", err)
+		}
+		return
+	}
+
+	h.bot.Request(tgbotapi.NewDeleteMessage(callback.Message.Chat.ID, callback.Message.MessageID))
+
+	photo := chartPhoto(callback.Message.Chat.ID, png)
+	photo.Caption = caption
+	photo.ParseMode = format.ParseMode
+	photo.ReplyMarkup = keyboard
+	if _, err := h.bot.Send(photo); err != nil {
+		log.Printf("Heatmap: ошибка отправки фото: %v", err)
+		h.sendMessage(callback.Message.Chat.ID, "❌ Не удалось загрузить график")
+	}
+}
+
+// chartPhoto — PNG графика как фото для отправки
+func chartPhoto(chatID int64, png []byte) tgbotapi.PhotoConfig {
+	return tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "chart.png", Bytes: png})
+}
+
+func (h *Handlers) handleBackToStatsCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	stats, _ := h.habitSvc.GetUserStats(ctx, user.ID)
+	overallStreak, _ := h.habitSvc.GetUserOverallStreak(ctx, user.ID)
+
+	var sb strings.Builder
+	sb.WriteString("📊 <b>Твоя статистика</b>\n\n")
+	sb.WriteString(fmt.Sprintf("🔥 <b>Общая серия:</b> %d дн.\n\n", overallStreak))
+
+	for _, s := range stats {
+		emoji := "🔥"
+		if s.CurrentStreak == 0 {
+			emoji = "💤"
+		}
+		sb.WriteString(fmt.Sprintf("<b>%s</b>\n", format.Escape(s.HabitName)))
+		sb.WriteString(fmt.Sprintf("  %s Серия: %d дн. | 🏆 Лучшая: %d дн.\n", emoji, s.CurrentStreak, s.BestStreak))
+		sb.WriteString(fmt.Sprintf("  📈 Выполнено: %.0f%%\n\n", s.CompletionRate))
+	}
+
+	sb.WriteString("👇 <b>Выбери график:</b>")
+
+	keyboard := StatsKeyboard()
+	// Подпись под картой за год в текст не превратить — статистика приходит новым сообщением
+	if callback.Message.Photo != nil {
+		reply := tgbotapi.NewMessage(callback.Message.Chat.ID, sb.String())
+		reply.ParseMode = format.ParseMode
+		reply.ReplyMarkup = keyboard
+		h.bot.Send(reply)
+		return
+	}
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, sb.String(), &keyboard)
+}
+
+// -------------------- HELPERS --------------------------
+
+func (h *Handlers) handleEmojiCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	state, ok := h.userStates[callback.From.ID]
+	if !ok {
+		return
+	}
+
+	emoji := strings.TrimPrefix(callback.Data, "emoji:")
+
+	// Если редактируем существующую привычку
+	if state.State == StateEditingEmoji {
+		err := h.repo.UpdateHabitEmoji(ctx, state.EditHabitID, emoji)
+		if err != nil {
+			h.sendError(callback.Message.Chat.ID, "Ошибка сохранения")
+			delete(h.userStates, callback.From.ID)
+			return
+		}
+
+		delete(h.userStates, callback.From.ID)
+
+		text := fmt.Sprintf("✅ Категория изменена: %s", format.Escape(emoji))
+		keyboard := BackKeyboard(fmt.Sprintf("habit_%d", state.EditHabitID))
+		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+		return
+	}
+
+	// Если создаём новую привычку
+	if state.State != StateWaitingEmoji {
 		return
 	}
 
 	state.Emoji = emoji
 	state.State = "awaiting_frequency"
 
-	text := fmt.Sprintf("%s *%s*\n\nВыбери периодичность:", emoji, state.HabitName)
+	text := fmt.Sprintf("%s <b>%s</b>\n\nВыбери периодичность:", emoji, format.Escape(state.HabitName))
 	keyboard := FrequencyKeyboard()
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 }
@@ -1953,12 +2561,17 @@ func (h *Handlers) handleEditHabitCallback(ctx context.Context, callback *tgbota
 		return
 	}
 
+	if habit.IsLocked {
+		h.answerCallback(callback.ID, "🔒 Привычка заблокирована — оформи Premium")
+		return
+	}
+
 	emoji := habit.Emoji
 	if emoji == "" {
 		emoji = "🎯"
 	}
 
-	text := fmt.Sprintf("✏️ *Редактирование*\n\n%s *%s*\n\nЧто изменить?", emoji, habit.Name)
+	text := fmt.Sprintf("✏️ <b>Редактирование</b>\n\n%s <b>%s</b>\n\nЧто изменить?", emoji, format.Escape(habit.Name))
 	keyboard := EditHabitKeyboard(habitID, user.HasActiveSubscription())
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
 }
@@ -2025,8 +2638,8 @@ func (h *Handlers) handleBackToCategoriesCallback(ctx context.Context, callback
 	}
 
 	var sb strings.Builder
-	sb.WriteString("📋 *Мои привычки*\n\n")
-	sb.WriteString(fmt.Sprintf("Всего: *%d* привычек\n\n", len(habits)))
+	sb.WriteString("📋 <b>Мои привычки</b>\n\n")
+	sb.WriteString(fmt.Sprintf("Всего: <b>%d</b> привычек\n\n", len(habits)))
 
 	for emoji, count := range counts {
 		sb.WriteString(fmt.Sprintf("%s — %d\n", emoji, count))
@@ -2037,3 +2650,542 @@ func (h *Handlers) handleBackToCategoriesCallback(ctx context.Context, callback
 	keyboard := HabitsViewKeyboard()
 	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, sb.String(), &keyboard)
 }
+
+// ==================== HABIT LOCKS ====================
+
+func countLockedHabits(habits []*domain.Habit) int {
+	count := 0
+	for _, habit := range habits {
+		if habit.IsLocked {
+			count++
+		}
+	}
+	return count
+}
+
+// syncFamilyHabitLocks — участник потерял семейный Premium: блокируем лишние привычки
+// и предлагаем выбрать активные
+func (h *Handlers) syncFamilyHabitLocks(ctx context.Context, userID int64) {
+	user, err := h.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Error getting user %d: %v", userID, err)
+		return
+	}
+	locked, err := h.habitSvc.SyncHabitLocks(ctx, user)
+	if err != nil {
+		log.Printf("Error syncing habit locks for user %d: %v", userID, err)
+		return
+	}
+	if locked > 0 {
+		h.sendHabitsLockedNotice(user.TelegramID, locked)
+	}
+}
+
+func (h *Handlers) sendHabitsLockedNotice(chatID int64, locked int) {
+	text := fmt.Sprintf(`🔒 <b>Premium закончился</b>
+
+Заблокировано привычек: <b>%d</b>. Активными остаются %d — выбери, какие именно.
+
+История сохранена, после продления всё разблокируется автоматически.`, locked, domain.FreeHabitsLimit)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = format.ParseMode
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔒 Выбрать активные привычки", "choose_active_habits"),
+		),
+	)
+	h.bot.Send(msg)
+}
+
+func (h *Handlers) showHabitLockChooser(ctx context.Context, callback *tgbotapi.CallbackQuery, user *domain.User) {
+	habits, _ := h.habitSvc.GetUserHabits(ctx, user.ID)
+	unlocked := len(habits) - countLockedHabits(habits)
+
+	text := fmt.Sprintf(`🔒 <b>Активные привычки</b>
+
+Без Premium активными могут быть %d привычки.
+Сейчас активно: <b>%d из %d</b>
+
+Нажми на привычку, чтобы заблокировать или разблокировать её.`, domain.FreeHabitsLimit, unlocked, domain.FreeHabitsLimit)
+
+	keyboard := HabitLockChooserKeyboard(habits)
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+}
+
+func (h *Handlers) handleChooseActiveHabitsCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		h.sendError(callback.Message.Chat.ID, "Ошибка получения данных")
+		return
+	}
+
+	if user.HasActiveSubscription() {
+		h.habitSvc.SyncHabitLocks(ctx, user)
+		h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "⭐️ Premium активен — все привычки разблокированы!", nil)
+		return
+	}
+
+	h.showHabitLockChooser(ctx, callback, user)
+}
+
+func (h *Handlers) handleToggleLockCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	habitID, _ := strconv.ParseInt(strings.TrimPrefix(callback.Data, "toggle_lock_"), 10, 64)
+
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		h.sendError(callback.Message.Chat.ID, "Ошибка получения данных")
+		return
+	}
+
+	_, err = h.habitSvc.ToggleHabitLock(ctx, user, habitID)
+	switch {
+	case errors.Is(err, service.ErrHabitLimitReached):
+		h.answerCallback(callback.ID, fmt.Sprintf("Активными могут быть только %d привычки — сначала заблокируй другую", domain.FreeHabitsLimit))
+		return
+	case err != nil:
+		log.Printf("Error toggling habit lock: %v", err)
+		h.answerCallback(callback.ID, "Ошибка")
+		return
+	}
+
+	h.showHabitLockChooser(ctx, callback, user)
+}
+
+func (h *Handlers) handleLocksDoneCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, _ := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	habits, _ := h.habitSvc.GetUnlockedHabits(ctx, user.ID)
+
+	var sb strings.Builder
+	sb.WriteString("✅ <b>Готово!</b>\n\nАктивные привычки:\n")
+	for _, habit := range habits {
+		sb.WriteString(fmt.Sprintf("• %s\n", format.Escape(habit.Name)))
+	}
+	sb.WriteString("\n⭐️ Оформи Premium, чтобы разблокировать все привычки.")
+
+	keyboard := RenewSubscriptionKeyboard(user.DiscountPercent)
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, sb.String(), &keyboard)
+}
+
+// ==================== GIFTS ====================
+
+func (h *Handlers) handleGift(ctx context.Context, msg *tgbotapi.Message) {
+	if h.tinkoffSvc == nil || !h.tinkoffSvc.IsConfigured() {
+		h.sendMessage(msg.Chat.ID, "💡 Оплата временно недоступна")
+		return
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, giftMenuText)
+	reply.ParseMode = format.ParseMode
+	reply.ReplyMarkup = GiftPlansKeyboard(h.subSvc.GetPlanPrice)
+	h.bot.Send(reply)
+}
+
+const giftMenuText = `🎁 <b>Подарить Premium</b>
+
+Выбери срок подписки. После оплаты ты получишь ссылку — отправь её другу, и он активирует Premium в один клик.`
+
+func (h *Handlers) handleGiftMenuCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	if h.tinkoffSvc == nil || !h.tinkoffSvc.IsConfigured() {
+		h.sendMessage(callback.Message.Chat.ID, "💡 Оплата временно недоступна")
+		return
+	}
+
+	keyboard := GiftPlansKeyboard(h.subSvc.GetPlanPrice)
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, giftMenuText, &keyboard)
+}
+
+func (h *Handlers) handleGiftPlanCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	planCode := strings.TrimPrefix(callback.Data, "gift_plan_")
+
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		h.sendError(callback.Message.Chat.ID, "Пользователь не найден")
+		return
+	}
+
+	gift, payment, err := h.giftSvc.CreateGift(ctx, user, planCode)
+	if err != nil {
+		log.Printf("Error creating gift: %v", err)
+		h.sendError(callback.Message.Chat.ID, "Ошибка создания платежа")
+		return
+	}
+
+	plan := domain.GetPlan(gift.PlanCode)
+	text := fmt.Sprintf(`💳 <b>Оплата подарка</b>
+
+Тариф: <b>%s</b>
+Сумма: <b>%.0f₽</b>
+
+Нажми кнопку для оплаты.
+После оплаты нажми "Проверить оплату" — и получишь ссылку для друга.`, plan.Title, float64(payment.Amount)/100)
+
+	keyboard := GiftPaymentKeyboard(payment.PaymentURL, gift.ID)
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, &keyboard)
+}
+
+func (h *Handlers) handleGiftCheckCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	giftID, _ := strconv.ParseInt(strings.TrimPrefix(callback.Data, "gift_check_"), 10, 64)
+
+	gift, err := h.giftSvc.GetGift(ctx, giftID)
+	if err != nil {
+		h.answerCallback(callback.ID, "Подарок не найден")
+		return
+	}
+
+	// Ссылку на подарок получает только покупатель
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil || user.ID != gift.BuyerID {
+		h.answerCallback(callback.ID, "Подарок не найден")
+		return
+	}
+
+	if gift.Status == domain.GiftPending {
+		tinkoffResp, err := h.tinkoffSvc.GetPaymentStatus(ctx, gift.OrderID)
+		if err != nil {
+			log.Printf("Ошибка GetState для OrderID=%s: %v", gift.OrderID, err)
+			h.answerCallback(callback.ID, "Не удалось проверить платёж")
+			return
+		}
+		if tinkoffResp.Status != "CONFIRMED" {
+			h.answerCallback(callback.ID, "Оплата ещё не поступила")
+			return
+		}
+		if _, err := h.tinkoffSvc.ProcessConfirmedPayment(ctx, gift.OrderID); err != nil {
+			log.Printf("Ошибка оплаты подарка: %v", err)
+			h.answerCallback(callback.ID, "Ошибка при активации")
+			return
+		}
+	}
+
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, "🎉 <b>Подарок оплачен!</b>", nil)
+	h.sendGiftLink(callback.From.ID, gift)
+}
+
+func (h *Handlers) sendGiftLink(telegramID int64, gift *domain.Gift) {
+	link := h.giftSvc.GetGiftLink(gift, h.botUsername)
+	plan := domain.GetPlan(gift.PlanCode)
+
+	// Без разметки: ссылка выводится как есть
+	text := fmt.Sprintf(`🎁 Подарок готов!
+
+Premium на %s ждёт получателя.
+
+Отправь другу эту ссылку:
+%s
+
+Ссылку можно активировать только один раз.`, plan.Title, link)
+
+	msg := tgbotapi.NewMessage(telegramID, text)
+	msg.ReplyMarkup = GiftShareKeyboard(link)
+	h.bot.Send(msg)
+}
+
+func (h *Handlers) redeemGift(ctx context.Context, chatID int64, user *domain.User, code string) {
+	gift, err := h.giftSvc.RedeemGift(ctx, code, user)
+	if err != nil {
+		if text, ok := errorText(err); ok {
+			h.sendError(chatID, text)
+		} else {
+			log.Printf("Error redeeming gift %s: %v", code, err)
+			h.sendError(chatID, "Не удалось активировать подарок")
+		}
+		return
+	}
+
+	if _, err := h.habitSvc.SyncHabitLocks(ctx, user); err != nil {
+		log.Printf("Error unlocking habits for user %d: %v", user.ID, err)
+	}
+
+	updatedUser, _ := h.repo.GetUserByID(ctx, user.ID)
+	untilText := ""
+	if updatedUser != nil && updatedUser.SubscriptionEnd != nil {
+		untilText = fmt.Sprintf("\n\nPremium активен до: <b>%s</b>", updatedUser.SubscriptionEnd.Format("02.01.2006"))
+	}
+
+	text := fmt.Sprintf(`🎁 <b>Тебе подарили Premium!</b>
+
+Начислено дней: <b>%d</b>%s
+
+✅ Безлимитные привычки
+✅ Напоминания
+✅ Статистика за год
+✅ Экспорт данных
+✅ Без рекламы`, gift.Days, untilText)
+
+	reply := tgbotapi.NewMessage(chatID, text)
+	reply.ParseMode = format.ParseMode
+	reply.ReplyMarkup = MainMenuKeyboard()
+	h.bot.Send(reply)
+
+	// Сообщаем дарителю
+	buyer, err := h.repo.GetUserByID(ctx, gift.BuyerID)
+	if err != nil {
+		return
+	}
+	name := user.FirstName
+	if user.Username != "" {
+		name = "@" + user.Username
+	}
+	h.bot.Send(tgbotapi.NewMessage(buyer.TelegramID, fmt.Sprintf("🎉 %s активировал(а) твой подарок — Premium на %d дней!", name, gift.Days)))
+}
+
+func (h *Handlers) handleMyGifts(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := h.repo.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения данных")
+		return
+	}
+
+	gifts, err := h.giftSvc.GetUserGifts(ctx, user.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения подарков")
+		return
+	}
+
+	if len(gifts) == 0 {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, "🎁 У тебя пока нет подарков.\n\nПодари Premium другу: /gift")
+		h.bot.Send(reply)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🎁 Мои подарки\n")
+	for _, g := range gifts {
+		title := g.PlanCode
+		if plan := domain.GetPlan(g.PlanCode); plan != nil {
+			title = plan.Title
+		}
+
+		if g.BuyerID == user.ID {
+			status := "⏳ ждёт активации: " + h.giftSvc.GetGiftLink(g, h.botUsername)
+			if g.Status == domain.GiftRedeemed {
+				status = fmt.Sprintf("✅ активирован %s", g.RedeemedAt.Format("02.01.2006"))
+			}
+			sb.WriteString(fmt.Sprintf("\n📤 Ты подарил(а) Premium на %s — %s", title, status))
+		} else {
+			sb.WriteString(fmt.Sprintf("\n📥 Тебе подарили Premium на %s — %s", title, g.RedeemedAt.Format("02.01.2006")))
+		}
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+}
+
+// ==================== FAMILY ====================
+
+func (h *Handlers) handleFamily(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := h.repo.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения данных")
+		return
+	}
+
+	text, keyboard := h.renderFamily(ctx, user, false)
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyMarkup = keyboard
+	h.bot.Send(reply)
+}
+
+func (h *Handlers) handleFamilyMenuCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		return
+	}
+
+	text, keyboard := h.renderFamily(ctx, user, callback.Data == "family_create")
+	h.editFamilyMessage(callback, text, keyboard)
+}
+
+// renderFamily — экран семейного доступа. Отправляется без разметки:
+// ссылка-приглашение и имена участников выводятся как есть.
+func (h *Handlers) renderFamily(ctx context.Context, user *domain.User, create bool) (string, tgbotapi.InlineKeyboardMarkup) {
+	// Участник чужой группы
+	if group, err := h.familySvc.GetMemberGroup(ctx, user.ID); err == nil {
+		owner, _ := h.repo.GetUserByID(ctx, group.OwnerID)
+		ownerName := "владельца"
+		if owner != nil {
+			ownerName = displayName(owner)
+		}
+
+		text := fmt.Sprintf("👨‍👩‍👧 Семейный доступ\n\nТы участник семейной группы %s.", ownerName)
+		if user.FamilySubscriptionEnd != nil && user.HasFamilyPremium() {
+			text += fmt.Sprintf("\nPremium действует, пока у владельца оплачен семейный тариф (сейчас до %s).", user.FamilySubscriptionEnd.Format("02.01.2006"))
+		} else {
+			text += "\nСемейный тариф владельца закончился — Premium сейчас не действует."
+		}
+		return text, FamilyMemberKeyboard()
+	}
+
+	var group *domain.FamilyGroup
+	if create {
+		g, err := h.familySvc.GetOrCreateGroup(ctx, user)
+		if err != nil {
+			text, ok := errorText(err)
+			if !ok {
+				log.Printf("Error creating family group: %v", err)
+				text = "Не удалось создать семейную группу"
+			}
+			if errors.Is(err, service.ErrFamilyNeedsPlan) {
+				return "❌ " + text, FamilyPlansKeyboard(h.subSvc.GetPlanPrice)
+			}
+			return "❌ " + text, PremiumKeyboard("", user.DiscountPercent)
+		}
+		group = g
+	} else if g, err := h.familySvc.GetOwnedGroup(ctx, user.ID); err == nil {
+		group = g
+	}
+
+	if group == nil {
+		text := fmt.Sprintf(`👨‍👩‍👧 Семейный доступ
+
+Поделись своим Premium с близкими: до %d человек получат все Premium-функции, пока действует твой семейный тариф.`, h.familySvc.MaxMembers())
+		if !user.HasFamilyPlan() {
+			return text + "\n\nДля создания группы нужен семейный тариф — он включает и твою Premium подписку.", FamilyPlansKeyboard(h.subSvc.GetPlanPrice)
+		}
+		return text, FamilyCreateKeyboard()
+	}
+
+	members, err := h.familySvc.GetMembers(ctx, group.ID)
+	if err != nil {
+		log.Printf("Error getting family members: %v", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("👨‍👩‍👧 Моя семейная группа\n\n")
+	sb.WriteString(fmt.Sprintf("Участники: %d/%d\n", len(members), h.familySvc.MaxMembers()))
+	for i, m := range members {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, displayName(m)))
+	}
+	if !user.HasFamilyPlan() {
+		sb.WriteString("\n⚠️ Семейный тариф закончился — участники потеряли Premium. Продли его, чтобы вернуть им доступ.\n")
+	}
+	if len(members) < h.familySvc.MaxMembers() {
+		sb.WriteString("\nОтправь ссылку-приглашение тем, кого хочешь добавить:\n")
+		sb.WriteString(h.familySvc.GetInviteLink(group, h.botUsername))
+	}
+
+	keyboard := FamilyOwnerKeyboard(members, h.familySvc.GetInviteLink(group, h.botUsername), len(members) < h.familySvc.MaxMembers())
+	if !user.HasFamilyPlan() {
+		keyboard.InlineKeyboard = append(FamilyPlansKeyboard(h.subSvc.GetPlanPrice).InlineKeyboard, keyboard.InlineKeyboard...)
+	}
+	return sb.String(), keyboard
+}
+
+func (h *Handlers) editFamilyMessage(callback *tgbotapi.CallbackQuery, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text)
+	edit.ReplyMarkup = &keyboard
+	h.bot.Send(edit)
+}
+
+func (h *Handlers) joinFamily(ctx context.Context, chatID int64, user *domain.User, code string) {
+	group, err := h.familySvc.Join(ctx, code, user)
+	if err != nil {
+		if text, ok := errorText(err); ok {
+			h.sendError(chatID, text)
+		} else {
+			log.Printf("Error joining family %s: %v", code, err)
+			h.sendError(chatID, "Не удалось вступить в семейную группу")
+		}
+		return
+	}
+
+	owner, err := h.repo.GetUserByID(ctx, group.OwnerID)
+	if err != nil {
+		log.Printf("Error getting family owner %d: %v", group.OwnerID, err)
+		return
+	}
+
+	// Перечитываем пользователя, чтобы подтянуть семейный Premium
+	if updated, err := h.repo.GetUserByID(ctx, user.ID); err == nil {
+		if _, err := h.habitSvc.SyncHabitLocks(ctx, updated); err != nil {
+			log.Printf("Error unlocking habits for user %d: %v", user.ID, err)
+		}
+	}
+
+	text := fmt.Sprintf(`👨‍👩‍👧 Ты в семейной группе %s!
+
+Premium-функции доступны, пока активна подписка владельца:
+✅ Безлимитные привычки
+✅ Напоминания
+✅ Статистика за год
+✅ Экспорт данных
+✅ Без рекламы`, displayName(owner))
+
+	reply := tgbotapi.NewMessage(chatID, text)
+	reply.ReplyMarkup = MainMenuKeyboard()
+	h.bot.Send(reply)
+
+	h.bot.Send(tgbotapi.NewMessage(owner.TelegramID, fmt.Sprintf("👨‍👩‍👧 %s присоединился(ась) к твоей семейной группе.", displayName(user))))
+}
+
+func (h *Handlers) handleFamilyRemoveCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	memberID, _ := strconv.ParseInt(strings.TrimPrefix(callback.Data, "family_remove_"), 10, 64)
+
+	owner, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		return
+	}
+
+	if _, err := h.familySvc.RemoveMember(ctx, owner, memberID); err != nil {
+		if !errors.Is(err, service.ErrNotFamilyMember) {
+			log.Printf("Error removing family member: %v", err)
+		}
+		h.answerCallback(callback.ID, "Не удалось исключить участника")
+		return
+	}
+
+	if member, err := h.repo.GetUserByID(ctx, memberID); err == nil {
+		h.bot.Send(tgbotapi.NewMessage(member.TelegramID, "👨‍👩‍👧 Владелец исключил тебя из семейной группы. Premium от семьи больше не действует."))
+	}
+	h.syncFamilyHabitLocks(ctx, memberID)
+
+	text, keyboard := h.renderFamily(ctx, owner, false)
+	h.editFamilyMessage(callback, text, keyboard)
+}
+
+func (h *Handlers) handleFamilyLeaveCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		return
+	}
+
+	group, err := h.familySvc.Leave(ctx, user)
+	if err != nil {
+		h.answerCallback(callback.ID, "Ты не состоишь в семейной группе")
+		return
+	}
+
+	h.editFamilyMessage(callback, "👋 Ты вышел(ла) из семейной группы.", tgbotapi.NewInlineKeyboardMarkup())
+	h.syncFamilyHabitLocks(ctx, user.ID)
+
+	if owner, err := h.repo.GetUserByID(ctx, group.OwnerID); err == nil {
+		h.bot.Send(tgbotapi.NewMessage(owner.TelegramID, fmt.Sprintf("👨‍👩‍👧 %s покинул(а) твою семейную группу.", displayName(user))))
+	}
+}
+
+func (h *Handlers) handleFamilyDisbandCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	owner, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		return
+	}
+
+	members, err := h.familySvc.Disband(ctx, owner)
+	if err != nil {
+		h.answerCallback(callback.ID, "Группа не найдена")
+		return
+	}
+
+	for _, m := range members {
+		h.bot.Send(tgbotapi.NewMessage(m.TelegramID, "👨‍👩‍👧 Семейная группа распущена владельцем. Premium от семьи больше не действует."))
+		h.syncFamilyHabitLocks(ctx, m.ID)
+	}
+
+	h.editFamilyMessage(callback, "✅ Семейная группа распущена.", tgbotapi.NewInlineKeyboardMarkup())
+}
+
+func displayName(user *domain.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return user.FirstName
+}
@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"fmt"
+	"net/url"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
@@ -37,6 +38,9 @@ func HabitsListKeyboard(habits []*domain.Habit, completedToday map[int64]bool) t
 		if completedToday[habit.ID] {
 			status = "✅"
 		}
+		if habit.IsLocked {
+			status = "🔒"
+		}
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(status+" "+habit.Name, fmt.Sprintf("habit_%d", habit.ID)),
 		))
@@ -110,6 +114,48 @@ func HabitDetailKeyboard(habitID int64, isPremium bool) tgbotapi.InlineKeyboardM
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
+// LockedHabitKeyboard — заблокированная привычка: только просмотр, выбор активных и удаление
+func LockedHabitKeyboard(habitID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📊 Статистика", fmt.Sprintf("stats_%d", habitID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔒 Выбрать активные привычки", "choose_active_habits"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⭐️ Разблокировать всё с Premium", "subscribe"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Удалить", fmt.Sprintf("delete_%d", habitID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("« Назад", "back_to_habits"),
+		),
+	)
+}
+
+// HabitLockChooserKeyboard — выбор привычек, которые остаются активными без Premium
+func HabitLockChooserKeyboard(habits []*domain.Habit) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for _, habit := range habits {
+		status := "✅"
+		if habit.IsLocked {
+			status = "🔒"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(status+" "+habit.Name, fmt.Sprintf("toggle_lock_%d", habit.ID)),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Готово", "locks_done"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
 func FrequencyKeyboard() tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
@@ -187,12 +233,151 @@ func PremiumKeyboard(paymentURL string, discount int) tgbotapi.InlineKeyboardMar
 
 func PremiumActiveKeyboard() tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Продлить подписку", "subscribe"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎁 Подарить Premium", "gift_menu"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👨‍👩‍👧 Семейный доступ", "family_menu"),
+		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("📥 Экспорт данных", "export_data"),
 		),
 	)
 }
 
+// LongerPlansRows — кнопки оплаты сразу за несколько месяцев
+func LongerPlansRows(planPrice func(plan *domain.Plan) int64) [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := range domain.Plans {
+		plan := &domain.Plans[i]
+		if plan.Code == domain.DefaultPlanCode {
+			continue
+		}
+		text := fmt.Sprintf("📅 %s — %.0f₽", plan.Title, float64(planPrice(plan))/100)
+		if plan.DiscountPercent > 0 {
+			text += fmt.Sprintf(" (−%d%%)", plan.DiscountPercent)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, "subscribe_plan_"+plan.Code),
+		))
+	}
+	return rows
+}
+
+// FamilyPlansKeyboard — оплата семейного тарифа
+func FamilyPlansKeyboard(planPrice func(plan *domain.Plan) int64) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := range domain.FamilyPlans {
+		plan := &domain.FamilyPlans[i]
+		text := fmt.Sprintf("👨‍👩‍👧 %s — %.0f₽", plan.Title, float64(planPrice(plan))/100)
+		if plan.DiscountPercent > 0 {
+			text += fmt.Sprintf(" (−%d%%)", plan.DiscountPercent)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, "subscribe_family_"+plan.Code),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// GiftPlansKeyboard — выбор срока подарочной подписки
+func GiftPlansKeyboard(planPrice func(plan *domain.Plan) int64) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for i := range domain.Plans {
+		plan := &domain.Plans[i]
+		text := fmt.Sprintf("%s — %.0f₽", plan.Title, float64(planPrice(plan))/100)
+		if plan.DiscountPercent > 0 {
+			text += fmt.Sprintf(" (−%d%%)", plan.DiscountPercent)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, "gift_plan_"+plan.Code),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func GiftPaymentKeyboard(paymentURL string, giftID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("💳 Оплатить", paymentURL),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Проверить оплату", fmt.Sprintf("gift_check_%d", giftID)),
+		),
+	)
+}
+
+func GiftShareKeyboard(link string) tgbotapi.InlineKeyboardMarkup {
+	shareURL := "https://t.me/share/url?url=" + url.QueryEscape(link) + "&text=" + url.QueryEscape("🎁 Дарю тебе Premium в трекере привычек!")
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("📤 Отправить другу", shareURL),
+		),
+	)
+}
+
+// AdPackagesKeyboard — пакеты показов для рекламодателя
+func AdPackagesKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, pkg := range domain.AdPackages {
+		text := fmt.Sprintf("%d показов — %.0f₽", pkg.Impressions, float64(pkg.Price)/100)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, "adpkg_"+pkg.Code),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func AdPaymentKeyboard(paymentURL string, adID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("💳 Оплатить", paymentURL),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Проверить оплату", fmt.Sprintf("adpay_check_%d", adID)),
+		),
+	)
+}
+
+// PromoOfferKeyboard — оплата по персональному предложению и отписка от предложений
+func PromoOfferKeyboard(discount int) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("💳 Оформить со скидкой %d%%", discount), "subscribe"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔕 Не присылать предложения", "unsubscribe_offers"),
+		),
+	)
+}
+
+// RenewSubscriptionKeyboard — продление в одно нажатие из уведомления об окончании
+func RenewSubscriptionKeyboard(discount int) tgbotapi.InlineKeyboardMarkup {
+	text := "⭐️ Продлить Premium"
+	if discount > 0 {
+		text = fmt.Sprintf("⭐️ Продлить Premium (скидка %d%%)", discount)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, "subscribe"),
+		),
+	)
+}
+
 func ReferralKeyboard(referralLink string) tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
@@ -215,8 +400,15 @@ func ReferralLockedKeyboard() tgbotapi.InlineKeyboardMarkup {
 	)
 }
 
-func AdKeyboard(adID int64) tgbotapi.InlineKeyboardMarkup {
-	return tgbotapi.NewInlineKeyboardMarkup(
+// AdKeyboard — кнопка рекламодателя (если есть ссылка), предложение Premium и закрытие
+func AdKeyboard(adID int64, buttonText, link string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if link != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL(buttonText, link),
+		))
+	}
+	rows = append(rows,
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("⭐️ Получить Premium", "subscribe"),
 		),
@@ -224,6 +416,7 @@ func AdKeyboard(adID int64) tgbotapi.InlineKeyboardMarkup {
 			tgbotapi.NewInlineKeyboardButtonData("❌ Закрыть", fmt.Sprintf("close_ad_%d", adID)),
 		),
 	)
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
 func BackKeyboard(callback string) tgbotapi.InlineKeyboardMarkup {
@@ -252,6 +445,9 @@ func StatsKeyboard() tgbotapi.InlineKeyboardMarkup {
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("🔥 Серии привычек", "chart_streaks"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗓 Карта за год ⭐️", "chart_year"),
+		),
 	)
 }
 
@@ -272,6 +468,69 @@ func HabitSelectForChartKeyboard(habits []*domain.Habit) tgbotapi.InlineKeyboard
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
+// HeatmapSelectKeyboard — выбор привычки для годовой карты (0 — все привычки)
+func HeatmapSelectKeyboard(habits []*domain.Habit, year int) tgbotapi.InlineKeyboardMarkup {
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🌐 Все привычки", fmt.Sprintf("heatmap_0_%d", year)),
+		),
+	}
+
+	for _, h := range habits {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(h.Name, fmt.Sprintf("heatmap_%d_%d", h.ID, year)),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("« Назад", "back_to_stats"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// HeatmapKeyboard — переход между годами карты в пределах [minYear, maxYear]
+func HeatmapKeyboard(habitID int64, year, minYear, maxYear int) tgbotapi.InlineKeyboardMarkup {
+	var nav []tgbotapi.InlineKeyboardButton
+	if year > minYear {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("« %d", year-1), fmt.Sprintf("heatmap_%d_%d", habitID, year-1)))
+	}
+	if year < maxYear {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d »", year+1), fmt.Sprintf("heatmap_%d_%d", habitID, year+1)))
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("« Назад к статистике", "back_to_stats_text"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ReportSettingsKeyboard — включение отчётов и отчёт за текущую неделю/месяц
+func ReportSettingsKeyboard(settings *domain.ReportSettings) tgbotapi.InlineKeyboardMarkup {
+	mark := func(on bool) string {
+		if on {
+			return "✅"
+		}
+		return "⬜️"
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(mark(settings.Weekly)+" Еженедельный", "report_toggle_weekly"),
+			tgbotapi.NewInlineKeyboardButtonData(mark(settings.Monthly)+" Ежемесячный", "report_toggle_monthly"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👀 Итоги недели", "report_preview_weekly"),
+			tgbotapi.NewInlineKeyboardButtonData("👀 Итоги месяца", "report_preview_monthly"),
+		),
+	)
+}
+
 // EditHabitKeyboard — что редактировать
 func EditHabitKeyboard(habitID int64, isPremium bool) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
@@ -360,6 +619,9 @@ func HabitsListKeyboardWithBack(habits []*domain.Habit, completedToday map[int64
 		if completedToday[h.ID] {
 			status = "✅"
 		}
+		if h.IsLocked {
+			status = "🔒"
+		}
 		emoji := h.Emoji
 		if emoji == "" {
 			emoji = "🎯"
@@ -376,3 +638,47 @@ func HabitsListKeyboardWithBack(habits []*domain.Habit, completedToday map[int64
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
+
+func FamilyCreateKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👨‍👩‍👧 Создать семейную группу", "family_create"),
+		),
+	)
+}
+
+// FamilyOwnerKeyboard — управление семейной группой: приглашение и исключение участников
+func FamilyOwnerKeyboard(members []*domain.User, inviteLink string, canInvite bool) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	if canInvite {
+		shareURL := "https://t.me/share/url?url=" + url.QueryEscape(inviteLink) + "&text=" + url.QueryEscape("👨‍👩‍👧 Присоединяйся к моему семейному Premium!")
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("📤 Пригласить", shareURL),
+		))
+	}
+
+	for _, m := range members {
+		name := m.FirstName
+		if m.Username != "" {
+			name = "@" + m.Username
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Исключить "+name, fmt.Sprintf("family_remove_%d", m.ID)),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🗑 Распустить группу", "family_disband"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func FamilyMemberKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🚪 Выйти из группы", "family_leave"),
+		),
+	)
+}
@@ -0,0 +1,184 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"habit-tracker-bot/internal/domain"
+	"habit-tracker-bot/internal/format"
+	"habit-tracker-bot/internal/service"
+)
+
+const reportsIntroText = `📬 <b>Отчёты о прогрессе</b>
+
+Еженедельный — в воскресенье, ежемесячный — в последний день месяца, в %d:00 по твоему времени (%s).
+
+В отчёте: процент выполнения по каждой привычке и сравнение с прошлым периодом, лучшая и отстающая привычка, серии и ближайшее достижение. К отчёту прикладывается график.`
+
+// handleReports — /reports: подписка на еженедельный и ежемесячный отчёты
+func (h *Handlers) handleReports(ctx context.Context, msg *tgbotapi.Message) {
+	user, err := h.repo.GetUserByTelegramID(ctx, msg.From.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения данных")
+		return
+	}
+
+	settings, err := h.reportSvc.GetSettings(ctx, user.ID)
+	if err != nil {
+		h.sendError(msg.Chat.ID, "Ошибка получения настроек")
+		return
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf(reportsIntroText, domain.ReportHour, format.Escape(service.UserLocation(user.Timezone).String())))
+	reply.ParseMode = format.ParseMode
+	reply.ReplyMarkup = ReportSettingsKeyboard(settings)
+	h.bot.Send(reply)
+}
+
+// handleReportToggleCallback — report_toggle_weekly / report_toggle_monthly
+func (h *Handlers) handleReportToggleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		return
+	}
+
+	settings, err := h.reportSvc.GetSettings(ctx, user.ID)
+	if err != nil {
+		h.answerCallback(callback.ID, "Ошибка получения настроек")
+		return
+	}
+
+	period := domain.ReportPeriod(strings.TrimPrefix(callback.Data, "report_toggle_"))
+	if period == domain.ReportMonthly {
+		settings.Monthly = !settings.Monthly
+	} else {
+		settings.Weekly = !settings.Weekly
+	}
+
+	if err := h.reportSvc.SetSettings(ctx, user.ID, *settings); err != nil {
+		log.Printf("Error saving report settings for user %d: %v", user.ID, err)
+		h.answerCallback(callback.ID, "Не удалось изменить настройку")
+		return
+	}
+
+	keyboard := ReportSettingsKeyboard(settings)
+	h.editMessage(callback.Message.Chat.ID, callback.Message.MessageID,
+		fmt.Sprintf(reportsIntroText, domain.ReportHour, format.Escape(service.UserLocation(user.Timezone).String())), &keyboard)
+}
+
+// handleReportPreviewCallback — report_preview_weekly / report_preview_monthly: отчёт за текущий период сейчас
+func (h *Handlers) handleReportPreviewCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
+	user, err := h.repo.GetUserByTelegramID(ctx, callback.From.ID)
+	if err != nil {
+		return
+	}
+
+	period := domain.ReportPeriod(strings.TrimPrefix(callback.Data, "report_preview_"))
+	report, err := h.reportSvc.BuildReport(ctx, user.ID, period, time.Now().In(service.UserLocation(user.Timezone)))
+	if err != nil {
+		log.Printf("Error building %s report for user %d: %v", period, user.ID, err)
+		h.answerCallback(callback.ID, "Ошибка загрузки данных")
+		return
+	}
+	if len(report.Habits) == 0 {
+		h.answerCallback(callback.ID, "У тебя нет привычек")
+		return
+	}
+
+	h.answerCallback(callback.ID, "")
+	if err := h.SendProgressReport(callback.Message.Chat.ID, report); err != nil {
+		log.Printf("Error sending report preview: %v", err)
+	}
+}
+
+// SendProgressReport — отчёт с графиком выполнения по привычкам. Текст уходит подписью
+// к графику, если помещается в лимит подписи, иначе отдельным сообщением.
+func (h *Handlers) SendProgressReport(telegramID int64, report *domain.ProgressReport) error {
+	text := formatProgressReport(report)
+
+	png, err := GenerateReportChart(report)
+	if err != nil {
+		log.Printf("Error rendering report chart: %v", err)
+	}
+	if png == nil {
+		msg := tgbotapi.NewMessage(telegramID, text)
+		msg.ParseMode = format.ParseMode
+		_, err := h.bot.Send(msg)
+		return err
+	}
+
+	photo := chartPhoto(telegramID, png)
+	photo.ParseMode = format.ParseMode
+	if format.Length(text) <= domain.MaxCaptionLength {
+		photo.Caption = text
+		_, err := h.bot.Send(photo)
+		return err
+	}
+
+	if _, err := h.bot.Send(photo); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(telegramID, text)
+	msg.ParseMode = format.ParseMode
+	_, err = h.bot.Send(msg)
+	return err
+}
+
+func formatProgressReport(r *domain.ProgressReport) string {
+	title, prev := "Итоги недели", "к прошлой неделе"
+	if r.Period == domain.ReportMonthly {
+		title, prev = "Итоги месяца", "к прошлому месяцу"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📈 <b>%s</b> · %s – %s\n\n", title, r.From.Format("02.01"), r.To.AddDate(0, 0, -1).Format("02.01")))
+	sb.WriteString(fmt.Sprintf("Выполнено: <b>%.0f%%</b>", r.Rate))
+	if r.HasPrev {
+		sb.WriteString(fmt.Sprintf(" (%s %s)", rateChange(r.Rate-r.PrevRate), prev))
+	}
+	sb.WriteString("\n\n")
+
+	for _, hr := range r.Habits {
+		sb.WriteString(fmt.Sprintf("• <b>%s</b> — %.0f%%", format.Escape(hr.Name), hr.Rate))
+		if hr.HasPrev {
+			sb.WriteString(" " + rateChange(hr.Rate-hr.PrevRate))
+		}
+		if hr.Streak > 0 {
+			sb.WriteString(fmt.Sprintf(" · 🔥 %d дн.", hr.Streak))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+
+	if r.Best != nil && len(r.Habits) > 1 {
+		sb.WriteString(fmt.Sprintf("🏆 Лучшая: <b>%s</b> (%.0f%%)\n", format.Escape(r.Best.Name), r.Best.Rate))
+	}
+	if r.Worst != nil {
+		sb.WriteString(fmt.Sprintf("🐢 Отстаёт: <b>%s</b> (%.0f%%)\n", format.Escape(r.Worst.Name), r.Worst.Rate))
+	}
+	sb.WriteString(fmt.Sprintf("🔥 Общая серия: <b>%d</b> дн.\n", r.OverallStreak))
+	if r.NextAchievement != nil {
+		sb.WriteString(fmt.Sprintf("📍 Следующее достижение: %s %s — осталось %d дн.\n", r.NextAchievement.Emoji, r.NextAchievement.Title, r.DaysLeft))
+	}
+
+	sb.WriteString("\nНастроить отчёты: /reports")
+	return sb.String()
+}
+
+// rateChange — изменение процента выполнения: ▲ 12%, ▼ 5% или «без изменений»
+func rateChange(delta float64) string {
+	switch d := math.Round(delta); {
+	case d > 0:
+		return fmt.Sprintf("▲ %.0f%%", d)
+	case d < 0:
+		return fmt.Sprintf("▼ %.0f%%", -d)
+	default:
+		return "без изменений"
+	}
+}
@@ -0,0 +1,11 @@
+-- Уведомления об окончании подписки (чтобы не отправлять одно и то же дважды)
+CREATE TABLE IF NOT EXISTS subscription_notices (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    subscription_end TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE(user_id, kind, subscription_end)
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_subscription_end ON users(subscription_end) WHERE subscription_end IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Привычки сверх бесплатного лимита блокируются после окончания Premium
+-- (история сохраняется, отмечать и редактировать нельзя)
+ALTER TABLE habits ADD COLUMN IF NOT EXISTS is_locked BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_habits_locked ON habits(user_id) WHERE is_locked = true;
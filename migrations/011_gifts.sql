@@ -0,0 +1,21 @@
+-- Назначение платежа: подписка себе или подарок
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS purpose VARCHAR(20) NOT NULL DEFAULT 'subscription';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS days INTEGER NOT NULL DEFAULT 30;
+
+-- Подарочные подписки
+CREATE TABLE IF NOT EXISTS gifts (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(32) UNIQUE NOT NULL,
+    buyer_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    plan_code VARCHAR(10) NOT NULL,
+    days INTEGER NOT NULL,
+    order_id VARCHAR(255) UNIQUE NOT NULL REFERENCES payments(order_id),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    paid_at TIMESTAMP,
+    redeemed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_gifts_buyer_id ON gifts(buyer_id);
+CREATE INDEX IF NOT EXISTS idx_gifts_recipient_id ON gifts(recipient_id);
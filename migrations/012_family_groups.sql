@@ -0,0 +1,15 @@
+-- Семейные группы: Premium владельца распространяется на участников
+CREATE TABLE IF NOT EXISTS family_groups (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id BIGINT UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    invite_code VARCHAR(32) UNIQUE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Пользователь может состоять только в одной группе
+CREATE TABLE IF NOT EXISTS family_members (
+    group_id BIGINT NOT NULL REFERENCES family_groups(id) ON DELETE CASCADE,
+    user_id BIGINT UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
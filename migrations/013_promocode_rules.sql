@@ -0,0 +1,7 @@
+-- Ограничения и виды промокодов
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS discount_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS free_days INT NOT NULL DEFAULT 0;
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS starts_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS ends_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS plan_codes TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS first_payment_only BOOLEAN NOT NULL DEFAULT false;
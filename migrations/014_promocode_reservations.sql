@@ -0,0 +1,22 @@
+-- Промокоды привязываются к внутренним ID пользователей, как и остальные таблицы
+ALTER TABLE promocode_usages DROP CONSTRAINT IF EXISTS promocode_usages_user_id_fkey;
+UPDATE promocode_usages pu SET user_id = u.id FROM users u WHERE u.telegram_id = pu.user_id;
+DELETE FROM promocode_usages WHERE user_id NOT IN (SELECT id FROM users);
+ALTER TABLE promocode_usages ADD CONSTRAINT promocode_usages_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE user_promo_status DROP CONSTRAINT IF EXISTS user_promo_status_user_id_fkey;
+UPDATE user_promo_status ps SET user_id = u.id FROM users u WHERE u.telegram_id = ps.user_id;
+DELETE FROM user_promo_status WHERE user_id NOT IN (SELECT id FROM users);
+ALTER TABLE user_promo_status ADD CONSTRAINT user_promo_status_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+-- Резерв промокода на время оплаты: reserved -> committed после CONFIRMED,
+-- удаляется при отмене или истечении платежа
+ALTER TABLE promocode_usages ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'committed';
+ALTER TABLE promocode_usages ADD COLUMN IF NOT EXISTS order_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_promocode_usages_order_id ON promocode_usages(order_id) WHERE order_id IS NOT NULL;
+
+-- used_count считает только подтверждённые оплаты
+UPDATE promocodes p SET used_count = (SELECT COUNT(*) FROM promocode_usages pu WHERE pu.promocode_id = p.id);
@@ -0,0 +1,19 @@
+-- Персональные промокоды автоматических кампаний
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS owner_user_id BIGINT REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE promocodes ADD COLUMN IF NOT EXISTS campaign VARCHAR(20) NOT NULL DEFAULT '';
+
+ALTER TABLE user_promo_status ADD COLUMN IF NOT EXISTS first_promo_sent_at TIMESTAMP DEFAULT NULL;
+
+-- Отправленные предложения и конверсия в оплату
+CREATE TABLE IF NOT EXISTS promo_campaign_sends (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    campaign VARCHAR(20) NOT NULL,
+    promocode_id INT NOT NULL REFERENCES promocodes(id) ON DELETE CASCADE,
+    sent_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    converted_at TIMESTAMP,
+    order_id VARCHAR(255)
+);
+
+CREATE INDEX IF NOT EXISTS idx_promo_campaign_sends_promocode ON promo_campaign_sends(promocode_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_promo_campaign_sends_campaign ON promo_campaign_sends(campaign, sent_at);
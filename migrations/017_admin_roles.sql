@@ -0,0 +1,8 @@
+-- Роли администраторов: owner, admin, support, marketer
+UPDATE admins SET role = 'admin' WHERE role IS NULL OR role NOT IN ('owner', 'admin', 'support', 'marketer');
+ALTER TABLE admins ALTER COLUMN role SET NOT NULL;
+ALTER TABLE admins ALTER COLUMN role SET DEFAULT 'admin';
+ALTER TABLE admins DROP CONSTRAINT IF EXISTS admins_role_check;
+ALTER TABLE admins ADD CONSTRAINT admins_role_check CHECK (role IN ('owner', 'admin', 'support', 'marketer'));
+
+CREATE INDEX IF NOT EXISTS idx_admin_audit_log_admin ON admin_audit_log(admin_telegram_id, created_at);
@@ -0,0 +1,5 @@
+-- Язык интерфейса Telegram для сегментации рассылок
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language_code VARCHAR(10) NOT NULL DEFAULT '';
+
+-- Сегмент аудитории рассылки; пустой объект — все подписанные пользователи
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS segment JSONB NOT NULL DEFAULT '{}';
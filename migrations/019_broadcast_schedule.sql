@@ -0,0 +1,10 @@
+-- Отложенный запуск рассылок
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS scheduled_at TIMESTAMPTZ;
+
+-- per_user_local: scheduled_at — показание часов (записано как UTC), рассылка уходит
+-- каждому пользователю в его часовом поясе. Пояса отправляются волнами.
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS per_user_local BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS wave_timezones TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS done_timezones TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_broadcasts_scheduled ON broadcasts(scheduled_at) WHERE status = 'scheduled';
@@ -0,0 +1,4 @@
+-- Аренда рассылки: её отправляет только экземпляр бота, продлевающий lease_until.
+-- Если экземпляр упал, аренда истекает и рассылку подхватывает другой (или он же после рестарта).
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ;
@@ -0,0 +1,19 @@
+-- Пользователь заблокировал бота или удалил аккаунт: не получает рассылок, напоминаний
+-- и не считается активным. Снимается, когда пользователь снова пишет /start.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_blocked BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked_at TIMESTAMPTZ;
+
+-- Журнал доставки рассылки по получателям
+CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    broadcast_id BIGINT NOT NULL REFERENCES broadcasts(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL, -- sent, failed, blocked
+    error TEXT NOT NULL DEFAULT '',
+    attempts INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (broadcast_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_broadcast_deliveries_status ON broadcast_deliveries(broadcast_id, status);
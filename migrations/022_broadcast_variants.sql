@@ -0,0 +1,27 @@
+-- Варианты рассылки для A/B-теста. Вариант A — сама рассылка, здесь хранятся B, C, ...
+CREATE TABLE IF NOT EXISTS broadcast_variants (
+    id BIGSERIAL PRIMARY KEY,
+    broadcast_id BIGINT NOT NULL REFERENCES broadcasts(id) ON DELETE CASCADE,
+    label VARCHAR(5) NOT NULL,
+    text TEXT NOT NULL,
+    image_url TEXT,
+    button_text VARCHAR(100),
+    button_url TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (broadcast_id, label)
+);
+
+-- Какой вариант получил пользователь
+ALTER TABLE broadcast_deliveries ADD COLUMN IF NOT EXISTS variant VARCHAR(5) NOT NULL DEFAULT 'A';
+
+-- Клики по кнопке рассылки через отслеживаемый редирект: одна строка на пользователя
+CREATE TABLE IF NOT EXISTS broadcast_clicks (
+    id BIGSERIAL PRIMARY KEY,
+    broadcast_id BIGINT NOT NULL REFERENCES broadcasts(id) ON DELETE CASCADE,
+    variant VARCHAR(5) NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    clicks INT NOT NULL DEFAULT 1,
+    first_clicked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_clicked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (broadcast_id, user_id)
+);
@@ -0,0 +1,8 @@
+-- Вложения (file_id загруженного в Telegram файла) и несколько рядов кнопок у рассылок
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS media_type VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS media_file_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE broadcasts ADD COLUMN IF NOT EXISTS buttons JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE broadcast_variants ADD COLUMN IF NOT EXISTS media_type VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE broadcast_variants ADD COLUMN IF NOT EXISTS media_file_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE broadcast_variants ADD COLUMN IF NOT EXISTS buttons JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,54 @@
+-- Сообщения перешли с Markdown на HTML: переводим разметку уже сохранённых текстов рекламы
+-- и рассылок. Экранируем &, < и >, затем *жирный*, _курсив_, `код` и [текст](url).
+-- Адреса ссылок и голые URL не размечаются: подчёркивания и звёздочки в них остаются как есть.
+-- Тексты, где уже есть HTML-теги или сущности, считаются переведёнными и пропускаются,
+-- поэтому повторный запуск ничего не меняет.
+CREATE OR REPLACE FUNCTION pg_temp.html_escape(src TEXT) RETURNS TEXT AS $$
+    SELECT replace(replace(replace(src, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- markdown_inline — разметка внутри фрагмента без ссылок. Курсив — только _слово_ целиком,
+-- чтобы не задеть snake_case
+CREATE OR REPLACE FUNCTION pg_temp.markdown_inline(src TEXT) RETURNS TEXT AS $$
+    SELECT regexp_replace(regexp_replace(regexp_replace(
+        pg_temp.html_escape(src),
+        '\*([^*\n]+)\*', '<b>\1</b>', 'g'),
+        '(?<![[:alnum:]])_([^_\n]+)_(?![[:alnum:]])', '<i>\1</i>', 'g'),
+        '`([^`\n]+)`', '<code>\1</code>', 'g')
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- markdown_to_html — режет текст на [текст](url), голые URL и всё остальное между ними
+CREATE OR REPLACE FUNCTION pg_temp.markdown_to_html(src TEXT) RETURNS TEXT AS $$
+DECLARE
+    token_re CONSTANT TEXT := '\[[^]\n]+\]\([^)[:space:]]+\)|https?://[^[:space:]]+';
+    result TEXT := '';
+    token TEXT;
+    link TEXT[];
+    pos INT;
+BEGIN
+    LOOP
+        pos := regexp_instr(src, token_re);
+        EXIT WHEN pos = 0;
+        token := regexp_substr(src, token_re);
+        result := result || pg_temp.markdown_inline(left(src, pos - 1));
+
+        link := regexp_match(token, '^\[([^]\n]+)\]\(([^)[:space:]]+)\)$');
+        IF link IS NULL THEN
+            result := result || pg_temp.html_escape(token);
+        ELSE
+            result := result || '<a href="' || replace(pg_temp.html_escape(link[2]), '"', '&quot;') || '">'
+                || pg_temp.markdown_inline(link[1]) || '</a>';
+        END IF;
+        src := substr(src, pos + length(token));
+    END LOOP;
+    RETURN result || pg_temp.markdown_inline(src);
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION pg_temp.is_html(src TEXT) RETURNS BOOLEAN AS $$
+    SELECT src ~ '</?(b|i|u|s|code|pre|a)( [^>]*)?>|&(amp|lt|gt|quot);'
+$$ LANGUAGE SQL IMMUTABLE;
+
+UPDATE ads SET text = pg_temp.markdown_to_html(text) WHERE NOT pg_temp.is_html(text);
+UPDATE broadcasts SET text = pg_temp.markdown_to_html(text) WHERE NOT pg_temp.is_html(text);
+UPDATE broadcast_variants SET text = pg_temp.markdown_to_html(text) WHERE NOT pg_temp.is_html(text);
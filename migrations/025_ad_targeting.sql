@@ -0,0 +1,18 @@
+-- Таргетинг рекламы: аудитория (JSON, как сегмент рассылки) и лимит показов одному пользователю
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS audience JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS max_per_user INTEGER NOT NULL DEFAULT 0;
+
+-- Эмодзи привычки используется в коде, но не было в схеме; по нему таргетируется реклама
+ALTER TABLE habits ADD COLUMN IF NOT EXISTS emoji VARCHAR(16) NOT NULL DEFAULT '';
+
+-- Показы и клики рекламы по пользователям: для лимитов и исключения уже кликнувших
+CREATE TABLE IF NOT EXISTS ad_impressions (
+    ad_id BIGINT NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    views INTEGER NOT NULL DEFAULT 0,
+    last_view_at TIMESTAMPTZ,
+    clicked_at TIMESTAMPTZ,
+    PRIMARY KEY (ad_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ad_impressions_user_id ON ad_impressions(user_id);
@@ -0,0 +1,8 @@
+-- Показы и клики рекламы по дням для отчёта CTR в /ads
+CREATE TABLE IF NOT EXISTS ad_daily_stats (
+    ad_id BIGINT NOT NULL REFERENCES ads(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    views INTEGER NOT NULL DEFAULT 0,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (ad_id, date)
+);
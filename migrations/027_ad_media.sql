@@ -0,0 +1,3 @@
+-- Вложение рекламы: file_id загруженного в Telegram фото, видео или GIF
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS media_type VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS media_file_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+-- Самостоятельное размещение рекламы: рекламодатель оплачивает пакет показов,
+-- админ одобряет объявление, и оно показывается, пока не закончатся показы
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS advertiser_id BIGINT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS impressions_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS order_id VARCHAR(255) UNIQUE REFERENCES payments(order_id);
+ALTER TABLE ads ADD COLUMN IF NOT EXISTS reject_reason TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_ads_advertiser_id ON ads(advertiser_id);
+CREATE INDEX IF NOT EXISTS idx_ads_status ON ads(status);
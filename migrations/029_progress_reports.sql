@@ -0,0 +1,11 @@
+-- Подписка на автоматические отчёты о прогрессе и начала периодов, за которые отчёт уже ушёл
+CREATE TABLE IF NOT EXISTS report_settings (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    weekly BOOLEAN NOT NULL DEFAULT false,
+    monthly BOOLEAN NOT NULL DEFAULT false,
+    last_weekly_from DATE,
+    last_monthly_from DATE,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_settings_enabled ON report_settings(user_id) WHERE weekly OR monthly;
@@ -0,0 +1,8 @@
+-- Резерв промокода привязан к платежу: у пользователя может быть несколько неоплаченных
+-- ссылок, и промокод списывается по той, которую он оплатил. Использовать промокод
+-- по-прежнему можно один раз.
+ALTER TABLE promocode_usages DROP CONSTRAINT IF EXISTS promocode_usages_promocode_id_user_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_promocode_usages_committed ON promocode_usages(promocode_id, user_id) WHERE status = 'committed';
+
+DROP INDEX IF EXISTS idx_promocode_usages_order_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_promocode_usages_order_id ON promocode_usages(order_id) WHERE order_id IS NOT NULL;
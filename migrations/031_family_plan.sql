@@ -0,0 +1,6 @@
+-- Семейный доступ теперь отдельный платный тариф: family_until — до какого момента он оплачен.
+-- Владельцы уже созданных групп сохраняют семейный доступ до конца текущей подписки.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS family_until TIMESTAMP;
+
+UPDATE users SET family_until = subscription_end
+WHERE family_until IS NULL AND id IN (SELECT owner_id FROM family_groups);